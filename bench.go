@@ -0,0 +1,421 @@
+package grpcurl
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/golang/protobuf/proto" //lint:ignore SA1019 we have to import this because it appears in exported API
+	"github.com/jhump/protoreflect/desc"
+	"github.com/jhump/protoreflect/dynamic"
+	"github.com/jhump/protoreflect/dynamic/grpcdynamic"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// maxBenchmarkErrors is the maximum number of distinct error messages that
+// are counted in a BenchmarkResult.
+const maxBenchmarkErrors = 10
+
+// benchmarkHistogramBuckets is the number of buckets in the latency histogram
+// of a BenchmarkResult.
+const benchmarkHistogramBuckets = 10
+
+// BenchmarkOptions control how Benchmark invokes a method.
+type BenchmarkOptions struct {
+	// Calls is the total number of calls to make. If zero, calls are made
+	// until Duration has elapsed. If both are set, the benchmark stops at
+	// whichever limit is reached first.
+	Calls int
+	// Duration is how long to start new calls for. Calls that are in progress
+	// when it elapses are allowed to complete.
+	Duration time.Duration
+	// Concurrency is the number of workers that make calls at the same time.
+	// If zero, one worker is used.
+	Concurrency int
+	// QPS limits the rate at which calls are started, in calls per second,
+	// across all workers. If zero, calls are started as fast as workers are
+	// available.
+	QPS float64
+	// Headers are sent with every call, each in 'name: value' format.
+	Headers []string
+	// Requests are the request messages sent by every call. Unary and
+	// server-streaming methods send only the first. If empty, an empty
+	// request message is sent.
+	Requests []proto.Message
+	// StreamMessages is the number of request messages that each call of a
+	// client-streaming or bidi-streaming method sends, repeating Requests as
+	// many times as needed. If zero, each of the Requests is sent once.
+	StreamMessages int
+}
+
+// BenchmarkResult summarizes the calls made by Benchmark. Times are in
+// milliseconds.
+type BenchmarkResult struct {
+	// Method is the fully-qualified name of the method, in 'service/method'
+	// format.
+	Method string `json:"method"`
+	// Calls is the number of calls that completed.
+	Calls int `json:"calls"`
+	// Responses is the total number of response messages received.
+	Responses int `json:"responses"`
+	// DurationMillis is how long the benchmark ran.
+	DurationMillis float64 `json:"durationMs"`
+	// CallsPerSecond is the throughput of the benchmark.
+	CallsPerSecond float64 `json:"callsPerSecond"`
+	// Codes are the number of calls that completed with each status code,
+	// keyed by the name of the code, such as "OK" or "Unavailable".
+	Codes map[string]int `json:"codes"`
+	// Errors are the number of failed calls with each status message, for up
+	// to 10 distinct messages.
+	Errors map[string]int `json:"errors,omitempty"`
+	// Latency summarizes the time taken by calls.
+	Latency LatencySummary `json:"latency"`
+	// Histogram is the distribution of the time taken by calls, in buckets
+	// of equal width between the minimum and maximum latency.
+	Histogram []LatencyBucket `json:"histogram"`
+}
+
+// LatencySummary describes the distribution of the time taken by calls, in
+// milliseconds.
+type LatencySummary struct {
+	Min  float64 `json:"min"`
+	Mean float64 `json:"mean"`
+	P50  float64 `json:"p50"`
+	P90  float64 `json:"p90"`
+	P99  float64 `json:"p99"`
+	Max  float64 `json:"max"`
+}
+
+// LatencyBucket is one bucket of a latency histogram.
+type LatencyBucket struct {
+	// UpperMillis is the inclusive upper bound of the bucket, in milliseconds.
+	// The lower bound is the upper bound of the previous bucket.
+	UpperMillis float64 `json:"upperMs"`
+	// Count is the number of calls whose latency is in the bucket.
+	Count int `json:"count"`
+}
+
+// Benchmark invokes the given method repeatedly, according to the given
+// options, and reports throughput and latency. Workers are spread evenly over
+// the given channels, so load can be put on several connections at once. The
+// given descriptor source is only queried once, before any calls are made.
+//
+// An error is returned if the options are invalid or the method cannot be
+// resolved. Calls that fail do not stop the benchmark; they are counted by
+// status code in the result. If the given context is cancelled, no more calls
+// are started and the result describes the calls made so far.
+func Benchmark(ctx context.Context, source DescriptorSource, channels []grpcdynamic.Channel, methodName string, opts BenchmarkOptions) (*BenchmarkResult, error) {
+	if len(channels) == 0 {
+		return nil, errors.New("no channels given")
+	}
+	if opts.Calls < 0 || opts.Duration < 0 || opts.Concurrency < 0 || opts.QPS < 0 || opts.StreamMessages < 0 {
+		return nil, errors.New("benchmark options must not be negative")
+	}
+	if opts.Calls == 0 && opts.Duration == 0 {
+		return nil, errors.New("either a number of calls or a duration is required")
+	}
+	source = &memoSource{DescriptorSource: source}
+	md, err := resolveMethod(source, methodName)
+	if err != nil {
+		return nil, err
+	}
+	// resolve extensions now, so that the source is not queried during calls
+	var ext dynamic.ExtensionRegistry
+	alreadyFetched := map[string]bool{}
+	if err := fetchAllExtensions(source, &ext, md.GetInputType(), alreadyFetched); err != nil {
+		return nil, fmt.Errorf("error resolving server extensions for message %s: %v", md.GetInputType().GetFullyQualifiedName(), err)
+	}
+	if err := fetchAllExtensions(source, &ext, md.GetOutputType(), alreadyFetched); err != nil {
+		return nil, fmt.Errorf("error resolving server extensions for message %s: %v", md.GetOutputType().GetFullyQualifiedName(), err)
+	}
+
+	reqs := make([][]byte, len(opts.Requests))
+	for i, req := range opts.Requests {
+		if reqs[i], err = proto.Marshal(req); err != nil {
+			return nil, fmt.Errorf("failed to serialize request message %d: %v", i+1, err)
+		}
+	}
+	numReqs := len(reqs)
+	if !md.IsClientStreaming() {
+		if numReqs > 1 {
+			numReqs = 1
+		}
+	} else if opts.StreamMessages > 0 {
+		numReqs = opts.StreamMessages
+	}
+
+	concurrency := opts.Concurrency
+	if concurrency == 0 {
+		concurrency = 1
+	}
+	if opts.Calls > 0 && concurrency > opts.Calls {
+		concurrency = opts.Calls
+	}
+	method := fmt.Sprintf("%s/%s", md.GetService().GetFullyQualifiedName(), md.GetName())
+	b := &benchmark{
+		ctx:      ctx,
+		source:   source,
+		method:   method,
+		headers:  opts.Headers,
+		reqs:     reqs,
+		numReqs:  numReqs,
+		maxCalls: opts.Calls,
+		qps:      opts.QPS,
+		result: BenchmarkResult{
+			Method: method,
+			Codes:  map[string]int{},
+		},
+	}
+	b.start = time.Now()
+	if opts.Duration > 0 {
+		b.deadline = b.start.Add(opts.Duration)
+	}
+
+	var wg sync.WaitGroup
+	latencies := make([][]time.Duration, concurrency)
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			latencies[i] = b.work(channels[i%len(channels)])
+		}(i)
+	}
+	wg.Wait()
+	elapsed := time.Since(b.start)
+
+	var all []time.Duration
+	for _, l := range latencies {
+		all = append(all, l...)
+	}
+	b.result.Calls = len(all)
+	b.result.DurationMillis = millis(elapsed)
+	if elapsed > 0 {
+		b.result.CallsPerSecond = float64(len(all)) / elapsed.Seconds()
+	}
+	b.result.Latency, b.result.Histogram = summarizeLatencies(all)
+	return &b.result, nil
+}
+
+// benchmark is the state shared by the workers of a call to Benchmark.
+type benchmark struct {
+	ctx      context.Context
+	source   DescriptorSource
+	method   string
+	headers  []string
+	reqs     [][]byte
+	numReqs  int
+	maxCalls int
+	qps      float64
+	start    time.Time
+	deadline time.Time
+
+	mu      sync.Mutex
+	started int
+	result  BenchmarkResult
+}
+
+// next waits until another call may be started. It returns false if the
+// benchmark is over.
+func (b *benchmark) next() bool {
+	b.mu.Lock()
+	if b.maxCalls > 0 && b.started >= b.maxCalls {
+		b.mu.Unlock()
+		return false
+	}
+	var wait time.Duration
+	if b.qps > 0 {
+		// calls are started at evenly spaced times
+		wait = time.Until(b.start.Add(time.Duration(float64(b.started) / b.qps * float64(time.Second))))
+	}
+	if !b.deadline.IsZero() && time.Now().Add(wait).After(b.deadline) {
+		b.mu.Unlock()
+		return false
+	}
+	b.started++
+	b.mu.Unlock()
+
+	if wait <= 0 {
+		return b.ctx.Err() == nil
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-b.ctx.Done():
+		return false
+	}
+}
+
+// work makes calls on the given channel until the benchmark is over, and
+// returns the latency of each call.
+func (b *benchmark) work(ch grpcdynamic.Channel) []time.Duration {
+	var latencies []time.Duration
+	for b.next() {
+		h := &benchmarkHandler{}
+		sent := 0
+		supplier := func(m proto.Message) error {
+			if sent >= b.numReqs || len(b.reqs) == 0 {
+				return io.EOF
+			}
+			data := b.reqs[sent%len(b.reqs)]
+			sent++
+			return proto.Unmarshal(data, m)
+		}
+		start := time.Now()
+		err := InvokeRPC(b.ctx, b.source, ch, b.method, b.headers, h, supplier)
+		latencies = append(latencies, time.Since(start))
+
+		stat := h.stat
+		if err != nil {
+			var ok bool
+			if stat, ok = status.FromError(err); !ok {
+				stat = status.New(codes.Unknown, err.Error())
+			}
+		}
+		b.record(stat, h.numResponses)
+	}
+	return latencies
+}
+
+func (b *benchmark) record(stat *status.Status, numResponses int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.result.Responses += numResponses
+	b.result.Codes[stat.Code().String()]++
+	if stat.Code() == codes.OK {
+		return
+	}
+	msg := stat.Message()
+	if _, ok := b.result.Errors[msg]; ok || len(b.result.Errors) < maxBenchmarkErrors {
+		if b.result.Errors == nil {
+			b.result.Errors = map[string]int{}
+		}
+		b.result.Errors[msg]++
+	}
+}
+
+func millis(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// summarizeLatencies computes percentiles and a histogram of the given
+// latencies. It sorts the given slice.
+func summarizeLatencies(latencies []time.Duration) (LatencySummary, []LatencyBucket) {
+	if len(latencies) == 0 {
+		return LatencySummary{}, nil
+	}
+	sort.Slice(latencies, func(i, j int) bool {
+		return latencies[i] < latencies[j]
+	})
+	percentile := func(p float64) float64 {
+		i := int(math.Ceil(p*float64(len(latencies)))) - 1
+		if i < 0 {
+			i = 0
+		}
+		return millis(latencies[i])
+	}
+	var total time.Duration
+	for _, l := range latencies {
+		total += l
+	}
+	summary := LatencySummary{
+		Min:  millis(latencies[0]),
+		Mean: millis(total / time.Duration(len(latencies))),
+		P50:  percentile(0.5),
+		P90:  percentile(0.9),
+		P99:  percentile(0.99),
+		Max:  millis(latencies[len(latencies)-1]),
+	}
+
+	min, max := latencies[0], latencies[len(latencies)-1]
+	width := (max - min) / benchmarkHistogramBuckets
+	if width == 0 {
+		return summary, []LatencyBucket{{UpperMillis: millis(max), Count: len(latencies)}}
+	}
+	buckets := make([]LatencyBucket, benchmarkHistogramBuckets)
+	for i := range buckets {
+		buckets[i].UpperMillis = millis(min + width*time.Duration(i+1))
+	}
+	// the last bucket's bound is not subject to rounding
+	buckets[len(buckets)-1].UpperMillis = millis(max)
+	for _, l := range latencies {
+		i := int((l - min) / width)
+		if i >= len(buckets) {
+			i = len(buckets) - 1
+		}
+		buckets[i].Count++
+	}
+	return summary, buckets
+}
+
+// benchmarkHandler records the outcome of a single call made by Benchmark.
+type benchmarkHandler struct {
+	numResponses int
+	stat         *status.Status
+}
+
+func (h *benchmarkHandler) OnResolveMethod(*desc.MethodDescriptor) {}
+
+func (h *benchmarkHandler) OnSendHeaders(metadata.MD) {}
+
+func (h *benchmarkHandler) OnReceiveHeaders(metadata.MD) {}
+
+func (h *benchmarkHandler) OnReceiveResponse(proto.Message) {
+	h.numResponses++
+}
+
+func (h *benchmarkHandler) OnReceiveTrailers(stat *status.Status, _ metadata.MD) {
+	h.stat = stat
+}
+
+// memoSource remembers the results of queries of a DescriptorSource, so that
+// each is only made once.
+type memoSource struct {
+	DescriptorSource
+
+	mu         sync.Mutex
+	symbols    map[string]desc.Descriptor
+	extensions map[string][]*desc.FieldDescriptor
+}
+
+func (s *memoSource) FindSymbol(fullyQualifiedName string) (desc.Descriptor, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if d, ok := s.symbols[fullyQualifiedName]; ok {
+		return d, nil
+	}
+	d, err := s.DescriptorSource.FindSymbol(fullyQualifiedName)
+	if err != nil {
+		return nil, err
+	}
+	if s.symbols == nil {
+		s.symbols = map[string]desc.Descriptor{}
+	}
+	s.symbols[fullyQualifiedName] = d
+	return d, nil
+}
+
+func (s *memoSource) AllExtensionsForType(typeName string) ([]*desc.FieldDescriptor, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if exts, ok := s.extensions[typeName]; ok {
+		return exts, nil
+	}
+	exts, err := s.DescriptorSource.AllExtensionsForType(typeName)
+	if err != nil {
+		return nil, err
+	}
+	if s.extensions == nil {
+		s.extensions = map[string][]*desc.FieldDescriptor{}
+	}
+	s.extensions[typeName] = exts
+	return exts, nil
+}
@@ -0,0 +1,171 @@
+package grpcurl_test
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto" //lint:ignore SA1019 we have to import this because it appears in exported API
+	"github.com/jhump/protoreflect/desc"
+	"github.com/jhump/protoreflect/dynamic"
+	"github.com/jhump/protoreflect/dynamic/grpcdynamic"
+
+	. "github.com/tetrateio/grpcurl"
+)
+
+func benchRequest(t *testing.T, msgType, data string) proto.Message {
+	d, err := sourceProtoset.FindSymbol(msgType)
+	if err != nil {
+		t.Fatalf("failed to find message type: %v", err)
+	}
+	msg := dynamic.NewMessage(d.(*desc.MessageDescriptor))
+	if err := msg.UnmarshalJSON([]byte(data)); err != nil {
+		t.Fatalf("failed to parse request: %v", err)
+	}
+	return msg
+}
+
+func TestBenchmark(t *testing.T) {
+	channels := []grpcdynamic.Channel{ccReflect, ccReflect}
+	testCases := []struct {
+		name              string
+		method            string
+		opts              BenchmarkOptions
+		expectedResponses int
+		expectedCodes     map[string]int
+	}{
+		{
+			name:   "unary",
+			method: "testing.TestService/UnaryCall",
+			opts: BenchmarkOptions{
+				Calls:       20,
+				Concurrency: 4,
+				Requests:    []proto.Message{benchRequest(t, "testing.SimpleRequest", `{"payload": {"body": "AQID"}}`)},
+			},
+			expectedResponses: 20,
+			expectedCodes:     map[string]int{"OK": 20},
+		},
+		{
+			name:   "client stream",
+			method: "testing.TestService/StreamingInputCall",
+			opts: BenchmarkOptions{
+				Calls:          5,
+				Concurrency:    2,
+				Requests:       []proto.Message{benchRequest(t, "testing.StreamingInputCallRequest", `{"payload": {"body": "AQID"}}`)},
+				StreamMessages: 3,
+			},
+			expectedResponses: 5,
+			expectedCodes:     map[string]int{"OK": 5},
+		},
+		{
+			name:   "server stream",
+			method: "testing.TestService/StreamingOutputCall",
+			opts: BenchmarkOptions{
+				Calls:    5,
+				Requests: []proto.Message{benchRequest(t, "testing.StreamingOutputCallRequest", `{"responseParameters": [{"size": 1}, {"size": 2}, {"size": 3}]}`)},
+			},
+			expectedResponses: 15,
+			expectedCodes:     map[string]int{"OK": 5},
+		},
+		{
+			name:   "bidi stream",
+			method: "testing.TestService/FullDuplexCall",
+			opts: BenchmarkOptions{
+				Calls:          4,
+				Concurrency:    4,
+				Requests:       []proto.Message{benchRequest(t, "testing.StreamingOutputCallRequest", `{"responseParameters": [{"size": 1}]}`)},
+				StreamMessages: 2,
+			},
+			expectedResponses: 8,
+			expectedCodes:     map[string]int{"OK": 4},
+		},
+		{
+			name:   "failures",
+			method: "testing.TestService/UnaryCall",
+			opts: BenchmarkOptions{
+				Calls:   3,
+				Headers: []string{"fail-early: 5"},
+			},
+			expectedCodes: map[string]int{"NotFound": 3},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			res, err := Benchmark(context.Background(), sourceProtoset, channels, tc.method, tc.opts)
+			if err != nil {
+				t.Fatalf("benchmark failed: %v", err)
+			}
+			if res.Calls != tc.opts.Calls {
+				t.Errorf("wrong number of calls: expected %d, got %d", tc.opts.Calls, res.Calls)
+			}
+			if res.Responses != tc.expectedResponses {
+				t.Errorf("wrong number of responses: expected %d, got %d", tc.expectedResponses, res.Responses)
+			}
+			if len(res.Codes) != len(tc.expectedCodes) {
+				t.Errorf("wrong codes: expected %v, got %v", tc.expectedCodes, res.Codes)
+			}
+			for code, n := range tc.expectedCodes {
+				if res.Codes[code] != n {
+					t.Errorf("wrong codes: expected %v, got %v", tc.expectedCodes, res.Codes)
+				}
+			}
+			l := res.Latency
+			if !(l.Min > 0 && l.Min <= l.P50 && l.P50 <= l.P90 && l.P90 <= l.P99 && l.P99 <= l.Max) {
+				t.Errorf("inconsistent latencies: %+v", l)
+			}
+			count := 0
+			for _, b := range res.Histogram {
+				count += b.Count
+			}
+			if count != res.Calls {
+				t.Errorf("histogram has %d calls, expected %d", count, res.Calls)
+			}
+			if _, err := json.Marshal(res); err != nil {
+				t.Errorf("failed to marshal result: %v", err)
+			}
+		})
+	}
+}
+
+func TestBenchmarkLimits(t *testing.T) {
+	channels := []grpcdynamic.Channel{ccReflect}
+
+	// calls are spread out to match the QPS
+	start := time.Now()
+	res, err := Benchmark(context.Background(), sourceProtoset, channels, "testing.TestService/EmptyCall", BenchmarkOptions{
+		Calls:       5,
+		Concurrency: 5,
+		QPS:         50,
+	})
+	if err != nil {
+		t.Fatalf("benchmark failed: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 80*time.Millisecond {
+		t.Errorf("5 calls at 50 QPS should take at least 80ms, took %v", elapsed)
+	}
+	if res.Calls != 5 {
+		t.Errorf("wrong number of calls: %d", res.Calls)
+	}
+
+	// calls are made until the duration elapses
+	res, err = Benchmark(context.Background(), sourceProtoset, channels, "testing.TestService/EmptyCall", BenchmarkOptions{
+		Duration: 100 * time.Millisecond,
+		QPS:      100,
+	})
+	if err != nil {
+		t.Fatalf("benchmark failed: %v", err)
+	}
+	if res.Calls < 5 || res.Calls > 11 {
+		t.Errorf("expected about 10 calls in 100ms at 100 QPS, got %d", res.Calls)
+	}
+
+	for _, opts := range []BenchmarkOptions{{}, {Calls: -1}} {
+		if _, err := Benchmark(context.Background(), sourceProtoset, channels, "testing.TestService/EmptyCall", opts); err == nil {
+			t.Errorf("%+v: expected error", opts)
+		}
+	}
+	if _, err := Benchmark(context.Background(), sourceProtoset, channels, "testing.TestService/NoSuchMethod", BenchmarkOptions{Calls: 1}); err == nil {
+		t.Errorf("expected error for unknown method")
+	}
+}
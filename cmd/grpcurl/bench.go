@@ -0,0 +1,101 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/golang/protobuf/proto" //lint:ignore SA1019 we have to import this because it appears in exported API
+	"github.com/jhump/protoreflect/desc"
+	"github.com/jhump/protoreflect/dynamic"
+
+	"github.com/tetrateio/grpcurl"
+)
+
+// defaultBenchCalls is the number of calls made by the 'bench' verb if neither
+// -calls nor -duration is given.
+const defaultBenchCalls = 200
+
+// histogramWidth is the width of the longest bar in a printed histogram.
+const histogramWidth = 40
+
+// readRequests reads all of the request messages for the given method from
+// the given parser.
+func readRequests(rf grpcurl.RequestParser, md *desc.MethodDescriptor) ([]proto.Message, error) {
+	var reqs []proto.Message
+	for {
+		msg := dynamic.NewMessage(md.GetInputType())
+		if err := rf.Next(msg); err == io.EOF {
+			return reqs, nil
+		} else if err != nil {
+			return nil, err
+		}
+		reqs = append(reqs, msg)
+	}
+}
+
+// printBenchmarkResult describes the given benchmark result for humans.
+func printBenchmarkResult(w io.Writer, res *grpcurl.BenchmarkResult) {
+	fmt.Fprintf(w, "Method:    %s\n", res.Method)
+	fmt.Fprintf(w, "Calls:     %d in %s (%.2f calls/s)\n", res.Calls, formatMillis(res.DurationMillis), res.CallsPerSecond)
+	fmt.Fprintf(w, "Responses: %d\n", res.Responses)
+
+	fmt.Fprintln(w, "\nStatus codes:")
+	for _, code := range sortedByCount(res.Codes) {
+		fmt.Fprintf(w, "  %-20s %d\n", code, res.Codes[code])
+	}
+	if len(res.Errors) > 0 {
+		fmt.Fprintln(w, "\nErrors:")
+		for _, msg := range sortedByCount(res.Errors) {
+			fmt.Fprintf(w, "  %6d  %s\n", res.Errors[msg], msg)
+		}
+	}
+	if res.Calls == 0 {
+		return
+	}
+
+	l := res.Latency
+	fmt.Fprintln(w, "\nLatency:")
+	for _, p := range []struct {
+		name  string
+		value float64
+	}{{"min", l.Min}, {"mean", l.Mean}, {"p50", l.P50}, {"p90", l.P90}, {"p99", l.P99}, {"max", l.Max}} {
+		fmt.Fprintf(w, "  %-4s  %s\n", p.name, formatMillis(p.value))
+	}
+
+	fmt.Fprintln(w, "\nHistogram:")
+	maxCount := 0
+	for _, b := range res.Histogram {
+		if b.Count > maxCount {
+			maxCount = b.Count
+		}
+	}
+	for _, b := range res.Histogram {
+		bar := strings.Repeat("#", (b.Count*histogramWidth+maxCount-1)/maxCount)
+		fmt.Fprintf(w, "  %10s  %-8s %s\n", formatMillis(b.UpperMillis), fmt.Sprintf("[%d]", b.Count), bar)
+	}
+}
+
+func formatMillis(ms float64) string {
+	if ms >= 1000 {
+		return fmt.Sprintf("%.2fs", ms/1000)
+	}
+	return fmt.Sprintf("%.2fms", ms)
+}
+
+// sortedByCount returns the keys of the given map, with the highest counts
+// first.
+func sortedByCount(counts map[string]int) []string {
+	keys := make([]string, 0, len(counts))
+	for k := range counts {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if counts[keys[i]] != counts[keys[j]] {
+			return counts[keys[i]] > counts[keys[j]]
+		}
+		return keys[i] < keys[j]
+	})
+	return keys
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/tetrateio/grpcurl"
+)
+
+func TestPrintBenchmarkResult(t *testing.T) {
+	res := &grpcurl.BenchmarkResult{
+		Method:         "testing.TestService/UnaryCall",
+		Calls:          4,
+		Responses:      3,
+		DurationMillis: 1500,
+		CallsPerSecond: 2.67,
+		Codes:          map[string]int{"OK": 3, "Unavailable": 1},
+		Errors:         map[string]int{"connection refused": 1},
+		Latency:        grpcurl.LatencySummary{Min: 1, Mean: 2, P50: 2, P90: 3, P99: 3, Max: 3},
+		Histogram: []grpcurl.LatencyBucket{
+			{UpperMillis: 2, Count: 2},
+			{UpperMillis: 3, Count: 1},
+		},
+	}
+	var out strings.Builder
+	printBenchmarkResult(&out, res)
+	expected := `Method:    testing.TestService/UnaryCall
+Calls:     4 in 1.50s (2.67 calls/s)
+Responses: 3
+
+Status codes:
+  OK                   3
+  Unavailable          1
+
+Errors:
+       1  connection refused
+
+Latency:
+  min   1.00ms
+  mean  2.00ms
+  p50   2.00ms
+  p90   3.00ms
+  p99   3.00ms
+  max   3.00ms
+
+Histogram:
+      2.00ms  [2]      ########################################
+      3.00ms  [1]      ####################
+`
+	if out.String() != expected {
+		t.Errorf("wrong output:\nexpected:\n%s\ngot:\n%s", expected, out.String())
+	}
+}
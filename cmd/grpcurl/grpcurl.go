@@ -5,23 +5,30 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
+	"encoding/json"
 	"flag"
 	"fmt"
 	"io"
+	"net"
+	"net/url"
 	"os"
+	"os/signal"
 	"path/filepath"
 	"strconv"
 	"strings"
 	"time"
 
 	"github.com/jhump/protoreflect/desc"
+	"github.com/jhump/protoreflect/dynamic/grpcdynamic"
 	"github.com/jhump/protoreflect/grpcreflect"
+	"golang.org/x/oauth2"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/credentials"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
 	"google.golang.org/grpc/keepalive"
 	"google.golang.org/grpc/metadata"
-	reflectpb "google.golang.org/grpc/reflection/grpc_reflection_v1alpha"
 	"google.golang.org/grpc/status"
 	"google.golang.org/protobuf/types/descriptorpb"
 
@@ -38,6 +45,10 @@ import (
 // the response status codes emitted use an offest of 64
 const statusCodeOffset = 64
 
+// The exit code used when the RPC completes but does not meet the
+// expectations given via -expect* flags.
+const expectationFailedCode = 3
+
 const noVersion = "dev build <no version set>"
 
 var version = noVersion
@@ -67,21 +78,50 @@ var (
 	key = flags.String("key", "", prettify(`
 		File containing client private key, to present to the server. Not valid
 		with -plaintext option. Must also provide -cert option.`))
-	protoset      multiString
-	protoFiles    multiString
-	importPaths   multiString
-	addlHeaders   multiString
-	rpcHeaders    multiString
-	reflHeaders   multiString
-	expandHeaders = flags.Bool("expand-headers", false, prettify(`
+	crl = flags.String("crl", "", prettify(`
+		File or directory containing certificate revocation lists (CRLs), in PEM
+		or DER format. If the server's certificate has been revoked by one of
+		them, the connection fails. With the 'serve' verb, client certificates
+		are checked instead. Ignored if -insecure is specified. Not valid with
+		-plaintext option.`))
+	tlsMinVersion = flags.String("tls-min-version", "", prettify(`
+		The minimum TLS version to accept: 1.0, 1.1, 1.2, or 1.3. Defaults to
+		1.2. Not valid with -plaintext option.`))
+	tlsMaxVersion = flags.String("tls-max-version", "", prettify(`
+		The maximum TLS version to accept: 1.0, 1.1, 1.2, or 1.3. Defaults to
+		1.3. If older than 1.2, the minimum defaults to 1.0, so this can be used
+		to check that a server rejects old versions. Not valid with -plaintext
+		option.`))
+	tlsCiphers = flags.String("tls-ciphers", "", prettify(`
+		A comma-separated list of the cipher suites to accept, by name, such as
+		'TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256'. Only applies to TLS 1.2 and
+		older; the cipher suites of TLS 1.3 cannot be configured. Not valid
+		with -plaintext option.`))
+	tlsCurves = flags.String("tls-curves", "", prettify(`
+		A comma-separated list of the elliptic curves to accept for key
+		exchange, in order of preference: X25519, P256, P384, or P521. Not
+		valid with -plaintext option.`))
+	protoset       multiString
+	protoFiles     multiString
+	importPaths    multiString
+	addlHeaders    multiString
+	rpcHeaders     multiString
+	reflHeaders    multiString
+	expectHeaders  multiString
+	expectTrailers multiString
+	expectFields   multiString
+	filters        multiString
+	templateVars   multiString
+	expandHeaders  = flags.Bool("expand-headers", false, prettify(`
 		If set, headers may use '${NAME}' syntax to reference environment
 		variables. These will be expanded to the actual environment variable
 		value before sending to the server. For example, if there is an
 		environment variable defined like FOO=bar, then a header of
 		'key: ${FOO}' would expand to 'key: bar'. This applies to -H,
-		-rpc-header, and -reflect-header options. No other expansion/escaping is
-		performed. This can be used to supply credentials/secrets without having
-		to put them in command-line arguments.`))
+		-rpc-header, and -reflect-header options. A literal '${' is written as
+		'$${'. No other expansion is performed. This can be used to supply
+		credentials/secrets without having to put them in command-line
+		arguments.`))
 	authority = flags.String("authority", "", prettify(`
 		The authoritative name of the remote server. This value is passed as the
 		value of the ":authority" pseudo-header in the HTTP/2 protocol. When TLS
@@ -92,28 +132,132 @@ var (
 		If set, the specified value will be added to the User-Agent header set
 		by the grpc-go library.
 		`))
+	tokenFile = flags.String("token-file", "", prettify(`
+		The name of a file containing a bearer token to send in the
+		"authorization" header. The file may contain just the token or a JSON
+		token response (see -token-exec). The file is read again whenever the
+		token expires, if its expiry is known from a JSON response or from the
+		"exp" claim of a JWT. See also -token-for.`))
+	tokenExec = flags.String("token-exec", "", prettify(`
+		A command, run with the system shell, that writes a JSON token response
+		to stdout. The response must have an "access_token" (or "token") and
+		may have a "token_type" and either an "expires_in" duration in seconds
+		or an "expiry" time in RFC 3339 format. The token is sent in the
+		"authorization" header, and the command is run again whenever the token
+		expires. See also -token-for.`))
+	oauthTokenURL = flags.String("oauth-token-url", "", prettify(`
+		The URL of an OAuth2 token endpoint, from which bearer tokens are
+		requested using the client credentials flow. Tokens are sent in the
+		"authorization" header, and new tokens are requested when they expire.
+		Requires -oauth-client-id. See also -token-for.`))
+	oauthClientID = flags.String("oauth-client-id", "", prettify(`
+		The client ID used to request tokens from -oauth-token-url.`))
+	oauthClientSecret = flags.String("oauth-client-secret", "", prettify(`
+		The client secret used to request tokens from -oauth-token-url. If not
+		given, the value of the GRPCURL_OAUTH_CLIENT_SECRET environment variable
+		is used, which avoids putting the secret in command-line arguments.`))
+	oauthScopes   multiString
+	oauthAudience = flags.String("oauth-audience", "", prettify(`
+		The audience to request tokens for from -oauth-token-url, which some
+		OpenID Connect providers require.`))
+	tokenFor = flags.String("token-for", "all", prettify(`
+		Which calls are sent the bearer token from -token-file, -token-exec, or
+		-oauth-token-url. The allowed values are 'all' (both reflection requests
+		and RPCs, like -H), 'rpc' (only RPCs, like -rpc-header) or 'reflection'
+		(only reflection requests, like -reflect-header).`))
 	data = flags.String("d", "", prettify(`
 		Data for request contents. If the value is '@' then the request contents
 		are read from stdin. For calls that accept a stream of requests, the
 		contents should include all such request messages concatenated together
 		(possibly delimited; see -format).`))
+	expandData = flags.Bool("expand-data", false, prettify(`
+		If set, request data may contain placeholders, which are expanded as
+		each message is read. '${NAME}' references a variable defined with -var
+		or, if there is none, an environment variable. Generators produce a new
+		value each time: '${uuid()}', '${now()}', '${randint(MIN, MAX)}',
+		'${randstr(LENGTH)}', '${seq()}', and '${file(PATH)}', which gives the
+		file contents in base64 for bytes fields. A literal '${' is written as
+		'$${'. This applies to -d, including data read from stdin, and to
+		-interactive input. In a quoted string in JSON or text data, values
+		are escaped; elsewhere, they are written as is, so they can stand for
+		numbers. YAML data is parsed first, so placeholders inside flow
+		collections, like '{id: "${uuid()}"}', must be quoted.`))
+	interactive = flags.Bool("interactive", false, prettify(`
+		When true, request messages for a client-streaming or bidi-streaming
+		method are read from stdin one at a time, and each is sent as soon as
+		it is complete, while response messages are printed as they arrive.
+		For 'json' format, a message may span several lines; for 'text' and
+		'yaml' formats, each line is one message. Enter '/close' (or press
+		Ctrl-D) to close the request stream, and '/cancel' (or press Ctrl-C
+		when stdin is a terminal) to cancel the RPC. Cannot be used with -d.`))
 	format = flags.String("format", "json", prettify(`
-		The format of request data. The allowed values are 'json' or 'text'. For
-		'json', the input data must be in JSON format. Multiple request values
-		may be concatenated (messages with a JSON representation other than
-		object must be separated by whitespace, such as a newline). For 'text',
-		the input data must be in the protobuf text format, in which case
-		multiple request values must be separated by the "record separator"
-		ASCII character: 0x1E. The stream should not end in a record separator.
-		If it does, it will be interpreted as a final, blank message after the
-		separator.`))
+		The format of request data. The allowed values are 'json', 'text',
+		'yaml' or 'binary'. For 'json', the input data must be in JSON format.
+		Multiple request values may be concatenated (messages with a JSON
+		representation other than object must be separated by whitespace, such
+		as a newline). For 'text', the input data must be in the protobuf text
+		format, in which case multiple request values must be separated by the
+		"record separator" ASCII character: 0x1E. The stream should not end in a
+		record separator. If it does, it will be interpreted as a final, blank
+		message after the separator. For 'yaml', the input data must be in YAML
+		format, using the same structure as JSON, and multiple request values
+		must be separate YAML documents (delimited by '---' lines). For
+		'binary', the input data must be in the protobuf binary format, with
+		each message preceded by its length encoded as a varint (which allows
+		multiple request values to simply be concatenated). Such data is
+		typically read from stdin, using '-d @'. Response messages are written
+		to stdout in the same format.`))
 	allowUnknownFields = flags.Bool("allow-unknown-fields", false, prettify(`
-		When true, the request contents, if 'json' format is used, allows
-		unknown fields to be present. They will be ignored when parsing
+		When true, the request contents, if 'json' or 'yaml' format is used,
+		allows unknown fields to be present. They will be ignored when parsing
 		the request.`))
 	connectTimeout = flags.Float64("connect-timeout", 0, prettify(`
 		The maximum time, in seconds, to wait for connection to be established.
 		Defaults to 10 seconds.`))
+	output = flags.String("output", "text", prettify(`
+		The form of the output when invoking a method or running a benchmark
+		with the 'bench' verb. The allowed values are
+		'text' and 'json'. With 'text' (the default), response messages are
+		written in the format given by -format, and errors are described on
+		stderr. With 'json', a JSON document describing the whole call is
+		written to stdout: the resolved method, request and response headers,
+		every response message, trailers, and the status with its details. For
+		server-streaming and bidi-streaming methods, each event is written as
+		a separate line of JSON as soon as it happens instead. Each document or
+		line has a "version" property that changes only if the structure of
+		the output changes incompatibly. With 'json', the -format flag only
+		applies to request data. For 'bench', 'json' writes the benchmark
+		results as a JSON object.`))
+	benchCalls = flags.Int("calls", 0, prettify(`
+		The total number of calls to make with the 'bench' verb. If neither
+		this nor -duration is given, 200 calls are made.`))
+	benchDuration = flags.Float64("duration", 0, prettify(`
+		How long, in seconds, to make calls for with the 'bench' verb. If
+		-calls is also given, the benchmark stops at whichever limit is
+		reached first.`))
+	benchConcurrency = flags.Int("concurrency", 1, prettify(`
+		The number of calls to make at the same time with the 'bench' verb.`))
+	benchConnections = flags.Int("connections", 1, prettify(`
+		The number of connections to spread calls over with the 'bench' verb.`))
+	benchQPS = flags.Float64("qps", 0, prettify(`
+		The maximum rate, in calls per second, at which to start calls with
+		the 'bench' verb. If not given, calls are made as fast as possible.`))
+	benchStreamMessages = flags.Int("stream-messages", 0, prettify(`
+		The number of request messages to send in each call of a
+		client-streaming or bidi-streaming method with the 'bench' verb. The
+		messages given with -d are repeated as many times as needed. If not
+		given, each message given with -d is sent once.`))
+	healthWatch = flags.Bool("watch", false, prettify(`
+		With the 'health' verb, watch the serving status instead of checking
+		it once, writing a line each time it changes. Runs until the server
+		ends the stream or until interrupted with Ctrl-C.`))
+	healthWaitFor = flags.Float64("wait-for", 0, prettify(`
+		With the 'health' verb, check the serving status every second, for up
+		to this many seconds, until it is SERVING. The server need not be
+		running yet. Exits with the code for the last status received.`))
+	listenAddr = flags.String("listen", "", prettify(`
+		The address, in "host:port" form, on which to accept connections with
+		the 'serve' and 'proxy' verbs. Required with those verbs.`))
 	formatError = flags.Bool("format-error", false, prettify(`
 		When a non-zero status is returned, format the response using the
 		value set by the -format flag .`))
@@ -126,11 +270,29 @@ var (
 		The maximum total time the operation can take, in seconds. This is
 		useful for preventing batch jobs that use grpcurl from hanging due to
 		slow or bad network links or due to incorrect stream method usage.`))
+	maxResponses = flags.Int("max-responses", 0, prettify(`
+		When invoking a server-streaming or bidi-streaming method, the number
+		of response messages after which to stop the stream. Stopping it this
+		way is not an error: the call is reported as completing with a status
+		of OK. This is useful with methods whose streams never end, such as
+		ones that watch for changes.`))
+	idleTimeout = flags.Float64("idle-timeout", 0, prettify(`
+		When invoking a server-streaming or bidi-streaming method, the maximum
+		time to wait for each response message, in seconds, after which the
+		stream is stopped. Like -max-responses, stopping it this way is not an
+		error, unlike reaching -max-time.`))
 	maxMsgSz = flags.Int("max-msg-sz", 0, prettify(`
 		The maximum encoded size of a response message, in bytes, that grpcurl
 		will accept. If not specified, defaults to 4,194,304 (4 megabytes).`))
 	emitDefaults = flags.Bool("emit-defaults", false, prettify(`
-		Emit default values for JSON-encoded responses.`))
+		Emit default values for JSON- or YAML-encoded responses.`))
+	fields = flags.String("fields", "", prettify(`
+		A comma-separated list of the fields of response messages to print,
+		like a google.protobuf.FieldMask. Each is a dot-separated path of field
+		names, like 'account.balance_cents'. A path through a repeated or map
+		field applies to each of its messages. All other fields are omitted.
+		Paths are checked against the method's response type before the method
+		is invoked.`))
 	protosetOut = flags.String("protoset-out", "", prettify(`
 		The name of a file to be written that will contain a FileDescriptorSet
 		proto. With the list and describe verbs, the listed or described
@@ -144,6 +306,24 @@ var (
 		Enable verbose output.`))
 	veryVerbose = flags.Bool("vv", false, prettify(`
 		Enable very verbose output.`))
+	timing = flags.Bool("timing", false, prettify(`
+		When invoking a method, write a report to stderr of how long each step
+		took: name resolution, TCP connect, TLS handshake, and getting the
+		response headers, the first response message, and the whole call, as
+		well as the time between response messages. The report is also
+		written when -v or -vv is given.`))
+	writeOut = flags.String("w", "", prettify(`
+		When invoking a method, write the given template to stdout after the
+		call completes, like curl's -w option. Each '%{name}' in the template
+		is replaced by the named value, and '\n', '\t', and '\r' stand for the
+		characters they do in Go. The names are time_namelookup, time_connect,
+		time_tls, time_dial (the whole connection setup), time_headers,
+		time_firstmsg, time_msggap_avg, time_msggap_max (time between
+		response messages), and time_total, which are in seconds, as well as
+		num_responses, status, status_code, status_message, and stop_reason
+		(max-responses or idle-timeout if the stream was stopped because of
+		-max-responses or -idle-timeout, or else empty). For example:
+		  -w 'connect: %{time_connect}s total: %{time_total}s\n'`))
 	serverName = flags.String("servername", "", prettify(`
 		Override server name when validating TLS certificate. This flag is
 		ignored if -plaintext or -insecure is used.
@@ -151,7 +331,47 @@ var (
 		an error to use both -authority and -servername (though this will be
 		permitted if they are both set to the same value, to increase backwards
 		compatibility with earlier releases that allowed both to be set).`))
-	reflection = optionalBoolFlag{val: true}
+	expectCode = flags.String("expect-code", "", prettify(`
+		The expected status code of the RPC, by name (e.g. 'NotFound' or
+		'NOT_FOUND') or number. If any -expect* flag is present, grpcurl acts
+		as a test: it checks the outcome of the RPC and exits with code 3 if
+		any expectation is not met, or zero if all are met. When other
+		expectations are given but not this one, the RPC is expected to
+		succeed (i.e. status code OK).`))
+	reflection        = optionalBoolFlag{val: true}
+	reflectionVersion = flags.String("reflection-version", "auto", prettify(`
+		The version of the server reflection service to use. The allowed values
+		are 'auto', 'v1' or 'v1alpha'. With 'auto', the stable v1 service is
+		tried first and, if the server does not implement it, grpcurl falls back
+		to the older v1alpha service.`))
+	noCache = flags.Bool("no-cache", false, prettify(`
+		When true, descriptors fetched using server reflection are neither
+		read from nor written to the on-disk descriptor cache. By default,
+		they are cached per target, authority, use of TLS, and headers sent
+		to the reflection service (see -cache-dir), so that later
+		invocations against the same server do not fetch them again.`))
+	refreshCache = flags.Bool("refresh-cache", false, prettify(`
+		When true, any cached descriptors for the server are discarded, and
+		descriptors are fetched again using server reflection and cached.`))
+	cacheTTL = flags.Float64("cache-ttl", 3600, prettify(`
+		The time, in seconds, for which descriptors fetched using server
+		reflection are cached. Once the cache for a server is older than this,
+		it is discarded and descriptors are fetched again. Zero means cached
+		descriptors never expire. Defaults to 3600 (one hour).`))
+	cacheDir = flags.String("cache-dir", "", prettify(`
+		The directory in which descriptors fetched using server reflection are
+		cached. Defaults to a "grpcurl/descriptors" directory in the user's
+		cache directory (for example, ~/.cache on Linux).`))
+	profileName = flags.String("profile", "", prettify(`
+		The name of a profile, defined in the config file, whose settings will
+		be used. A profile can provide the server address and the value of any
+		other flag, such as TLS settings, headers, and descriptor sources. Flags
+		given on the command line override the profile's values. With -v, the
+		profile values that were applied are printed.`))
+	configFile = flags.String("config", "", prettify(`
+		The config file that defines profiles for use with -profile. Defaults
+		to $XDG_CONFIG_HOME/grpcurl/config.yaml (or ~/.config/grpcurl/config.yaml
+		if XDG_CONFIG_HOME is not set).`))
 )
 
 func init() {
@@ -159,6 +379,11 @@ func init() {
 		Additional headers in 'name: value' format. May specify more than one
 		via multiple flags. These headers will also be included in reflection
 		requests to a server.`))
+	flags.Var(&templateVars, "var", prettify(`
+		A variable for -expand-data and the 'workflow' verb, in 'name=value'
+		format. May specify more than one via multiple flags. Variables take
+		precedence over environment variables, and over variables defined in a
+		workflow, with the same name.`))
 	flags.Var(&rpcHeaders, "rpc-header", prettify(`
 		Additional RPC headers in 'name: value' format. May specify more than
 		one via multiple flags. These headers will *only* be used when invoking
@@ -168,6 +393,36 @@ func init() {
 		than one via multiple flags. These headers will *only* be used during
 		reflection requests and will be excluded when invoking the requested RPC
 		method.`))
+	flags.Var(&expectHeaders, "expect-header", prettify(`
+		An expected response header in 'name: value' format. If only a name is
+		given, the header need only be present. May specify more than one via
+		multiple flags. See -expect-code.`))
+	flags.Var(&expectTrailers, "expect-trailer", prettify(`
+		An expected response trailer in 'name: value' format. If only a name is
+		given, the trailer need only be present. May specify more than one via
+		multiple flags. See -expect-code.`))
+	flags.Var(&expectFields, "expect", prettify(`
+		An expectation on the contents of response messages, in the form of a
+		field path optionally followed by an operator and value. The path is
+		made of dot-separated field names, with '[n]' to index repeated fields
+		and '[key]' to index maps. Operators are ==, !=, =~ (regex match), >,
+		>=, <, and <=. With no operator, the field must be present; with a
+		leading '!', the field must be absent. For example:
+		  -expect 'account.balance_cents >= 0'
+		  -expect 'account.status == OPEN'
+		The expectation must hold for every response message, unless the path
+		begins with a response index like '[0].'. May specify more than one via
+		multiple flags. See -expect-code.`))
+	flags.Var(&filters, "filter", prettify(`
+		A condition that response messages must meet to be printed, in the
+		same form as -expect but without a response index. For example:
+		  -filter 'status == OPEN' -filter 'balance_cents > 10000'
+		Messages that do not meet it are skipped, which is mostly useful with
+		streaming methods. May specify more than one via multiple flags, in
+		which case messages must meet all of them.`))
+	flags.Var(&oauthScopes, "oauth-scope", prettify(`
+		A scope to request tokens for from -oauth-token-url. May specify more
+		than one via multiple flags.`))
 	flags.Var(&protoset, "protoset", prettify(`
 		The name of a file containing an encoded FileDescriptorSet. This file's
 		contents will be used to determine the RPC schema instead of querying
@@ -268,6 +523,24 @@ func main() {
 		os.Exit(0)
 	}
 
+	var prof *profile
+	if *profileName != "" {
+		path := *configFile
+		if path == "" {
+			path = defaultConfigFile()
+		}
+		var err error
+		prof, err = loadProfile(flags, path, *profileName)
+		if err != nil {
+			fail(err, "Failed to load profile %q", *profileName)
+		}
+		if err := prof.apply(flags); err != nil {
+			fail(err, "Failed to apply profile %q", *profileName)
+		}
+	} else if *configFile != "" {
+		warn("The -config argument is only used with -profile.")
+	}
+
 	// Do extra validation on arguments and figure out what user asked us to do.
 	if *connectTimeout < 0 {
 		fail(nil, "The -connect-timeout argument must not be negative.")
@@ -278,6 +551,12 @@ func main() {
 	if *maxTime < 0 {
 		fail(nil, "The -max-time argument must not be negative.")
 	}
+	if *maxResponses < 0 {
+		fail(nil, "The -max-responses argument must not be negative.")
+	}
+	if *idleTimeout < 0 {
+		fail(nil, "The -idle-timeout argument must not be negative.")
+	}
 	if *maxMsgSz < 0 {
 		fail(nil, "The -max-msg-sz argument must not be negative.")
 	}
@@ -290,14 +569,84 @@ func main() {
 	if *plaintext && *key != "" {
 		fail(nil, "The -plaintext and -key arguments are mutually exclusive.")
 	}
+	if *plaintext && *crl != "" {
+		fail(nil, "The -plaintext and -crl arguments are mutually exclusive.")
+	}
+	if *plaintext && (*tlsMinVersion != "" || *tlsMaxVersion != "" || *tlsCiphers != "" || *tlsCurves != "") {
+		fail(nil, "The -plaintext argument is mutually exclusive with -tls-min-version, -tls-max-version, -tls-ciphers, and -tls-curves.")
+	}
+	tlsOpts := grpcurl.TLSOptions{CRLPath: *crl}
+	if *tlsMinVersion != "" {
+		v, err := grpcurl.ParseTLSVersion(*tlsMinVersion)
+		if err != nil {
+			fail(nil, "The -tls-min-version option is invalid: %v", err)
+		}
+		tlsOpts.MinVersion = v
+	}
+	if *tlsMaxVersion != "" {
+		v, err := grpcurl.ParseTLSVersion(*tlsMaxVersion)
+		if err != nil {
+			fail(nil, "The -tls-max-version option is invalid: %v", err)
+		}
+		tlsOpts.MaxVersion = v
+	}
+	if tlsOpts.MinVersion != 0 && tlsOpts.MaxVersion != 0 && tlsOpts.MinVersion > tlsOpts.MaxVersion {
+		fail(nil, "The -tls-min-version must not be newer than -tls-max-version.")
+	}
+	if *tlsCiphers != "" {
+		ids, err := grpcurl.ParseCipherSuites(*tlsCiphers)
+		if err != nil {
+			fail(nil, "The -tls-ciphers option is invalid: %v", err)
+		}
+		tlsOpts.CipherSuites = ids
+	}
+	if *tlsCurves != "" {
+		ids, err := grpcurl.ParseCurves(*tlsCurves)
+		if err != nil {
+			fail(nil, "The -tls-curves option is invalid: %v", err)
+		}
+		tlsOpts.CurvePreferences = ids
+	}
 	if (*key == "") != (*cert == "") {
 		fail(nil, "The -cert and -key arguments must be used together and both be present.")
 	}
-	if *format != "json" && *format != "text" {
-		fail(nil, "The -format option must be 'json' or 'text'.")
+	numTokenSources := 0
+	for _, src := range []string{*tokenFile, *tokenExec, *oauthTokenURL} {
+		if src != "" {
+			numTokenSources++
+		}
+	}
+	if numTokenSources > 1 {
+		fail(nil, "The -token-file, -token-exec, and -oauth-token-url arguments are mutually exclusive.")
+	}
+	if *oauthTokenURL != "" && *oauthClientID == "" {
+		fail(nil, "The -oauth-token-url argument requires -oauth-client-id.")
+	}
+	if *oauthTokenURL == "" && (*oauthClientID != "" || *oauthClientSecret != "" || len(oauthScopes) > 0 || *oauthAudience != "") {
+		fail(nil, "The -oauth-client-id, -oauth-client-secret, -oauth-scope, and -oauth-audience arguments require -oauth-token-url.")
+	}
+	switch grpcurl.TokenScope(*tokenFor) {
+	case grpcurl.TokenForAll, grpcurl.TokenForRPC, grpcurl.TokenForReflection:
+	default:
+		fail(nil, "The -token-for option must be 'all', 'rpc' or 'reflection'.")
+	}
+	switch grpcurl.Format(*format) {
+	case grpcurl.FormatJSON, grpcurl.FormatText, grpcurl.FormatYAML, grpcurl.FormatBinary:
+	default:
+		fail(nil, "The -format option must be 'json', 'text', 'yaml' or 'binary'.")
 	}
-	if *emitDefaults && *format != "json" {
-		warn("The -emit-defaults is only used when using json format.")
+	switch *output {
+	case "text", "json":
+	default:
+		fail(nil, "The -output option must be 'text' or 'json'.")
+	}
+	if *emitDefaults && *format != "json" && *format != "yaml" && *output != "json" && !prof.applied("emit-defaults") {
+		warn("The -emit-defaults is only used when using json or yaml format.")
+	}
+	switch grpcurl.ReflectionVersion(*reflectionVersion) {
+	case grpcurl.ReflectionAuto, grpcurl.ReflectionV1, grpcurl.ReflectionV1Alpha:
+	default:
+		fail(nil, "The -reflection-version option must be 'auto', 'v1' or 'v1alpha'.")
 	}
 
 	args := flags.Args()
@@ -306,24 +655,27 @@ func main() {
 		fail(nil, "Too few arguments.")
 	}
 	var target string
-	if args[0] != "list" && args[0] != "describe" {
-		target = args[0]
-		args = args[1:]
+	if !verbs[args[0]] {
+		// if the profile has an address, it is omitted when the arguments
+		// start with a verb or are just the method
+		_, isVerb := verbs[args[0]]
+		if prof == nil || prof.address == "" || (len(args) > 1 && !isVerb) {
+			target = args[0]
+			args = args[1:]
+		}
 	}
 
 	if len(args) == 0 {
 		fail(nil, "Too few arguments.")
 	}
-	var list, describe, invoke bool
-	if args[0] == "list" {
-		list = true
-		args = args[1:]
-	} else if args[0] == "describe" {
-		describe = true
+	var verb string
+	if _, ok := verbs[args[0]]; ok {
+		verb = args[0]
 		args = args[1:]
-	} else {
-		invoke = true
 	}
+	list, describe, replay, workflow := verb == "list", verb == "describe", verb == "replay", verb == "workflow"
+	shell, bench, serve, proxy := verb == "shell", verb == "bench", verb == "serve", verb == "proxy"
+	health, tlsInfo, invoke := verb == "health", verb == "tls-info", verb == ""
 
 	verbosityLevel := 0
 	if *verbose {
@@ -333,18 +685,131 @@ func main() {
 		verbosityLevel = 2
 	}
 
-	var symbol string
+	var symbol, callLog, workflowFile, stubFile string
 	if invoke {
 		if len(args) == 0 {
 			fail(nil, "Too few arguments.")
 		}
 		symbol = args[0]
 		args = args[1:]
+		if *interactive {
+			if *data != "" {
+				fail(nil, "The -d argument cannot be used with -interactive.")
+			}
+			if *format == "binary" {
+				fail(nil, "The -interactive argument does not support the 'binary' format.")
+			}
+		}
+	} else if replay {
+		if len(args) == 0 {
+			fail(nil, "No call log file specified.")
+		}
+		callLog = args[0]
+		args = args[1:]
+		if *data != "" {
+			warn("The -d argument is not used with 'replay' verb.")
+		}
+		if *format != "json" && !prof.applied("format") {
+			warn("The -format argument is not used with 'replay' verb; call logs are always JSON.")
+		}
+	} else if workflow {
+		if len(args) == 0 {
+			fail(nil, "No workflow file specified.")
+		}
+		workflowFile = args[0]
+		args = args[1:]
+		if *data != "" {
+			warn("The -d argument is not used with 'workflow' verb; request data is given in each step.")
+		}
+		if *format != "json" && !prof.applied("format") {
+			warn("The -format argument is not used with 'workflow' verb; request data is given as JSON or YAML.")
+		}
+	} else if bench {
+		if len(args) == 0 {
+			fail(nil, "No method specified.")
+		}
+		symbol = args[0]
+		args = args[1:]
+		if *benchCalls < 0 || *benchDuration < 0 || *benchQPS < 0 || *benchStreamMessages < 0 {
+			fail(nil, "The -calls, -duration, -qps, and -stream-messages arguments must not be negative.")
+		}
+		if *benchConcurrency < 1 || *benchConnections < 1 {
+			fail(nil, "The -concurrency and -connections arguments must be at least 1.")
+		}
+		if *interactive {
+			fail(nil, "The -interactive argument cannot be used with 'bench' verb.")
+		}
+	} else if serve {
+		if len(args) == 0 {
+			fail(nil, "No stub file specified.")
+		}
+		stubFile = args[0]
+		args = args[1:]
+		if target != "" {
+			fail(nil, "The 'serve' verb does not take an address; use -listen instead.")
+		}
+		if *listenAddr == "" {
+			fail(nil, "The 'serve' verb requires a -listen address.")
+		}
+		if len(protoset) == 0 && len(protoFiles) == 0 {
+			fail(nil, "The 'serve' verb requires -protoset or -proto files.")
+		}
+		if reflection.set && reflection.val {
+			fail(nil, "The -use-reflection argument cannot be used with 'serve' verb.")
+		}
+		if *data != "" {
+			warn("The -d argument is not used with 'serve' verb; responses are given in the stub file.")
+		}
+	} else if health {
+		if len(args) > 0 {
+			symbol = args[0]
+			args = args[1:]
+		}
+		if *healthWaitFor < 0 {
+			fail(nil, "The -wait-for argument must not be negative.")
+		}
+		if *healthWatch && *healthWaitFor > 0 {
+			fail(nil, "The -watch and -wait-for arguments are mutually exclusive.")
+		}
+		if *data != "" {
+			warn("The -d argument is not used with 'health' verb.")
+		}
+	} else if tlsInfo {
+		if *plaintext {
+			fail(nil, "The 'tls-info' verb cannot be used with -plaintext.")
+		}
+		if *data != "" {
+			warn("The -d argument is not used with 'tls-info' verb.")
+		}
+	} else if proxy {
+		// the address may also come after the verb
+		if target == "" && len(args) > 0 {
+			target = args[0]
+			args = args[1:]
+		}
+		if *listenAddr == "" {
+			fail(nil, "The 'proxy' verb requires a -listen address.")
+		}
+		if *data != "" {
+			warn("The -d argument is not used with 'proxy' verb.")
+		}
+	} else if shell {
+		// the address may also come after the verb
+		if target == "" && len(args) > 0 {
+			target = args[0]
+			args = args[1:]
+		}
+		if *data != "" {
+			warn("The -d argument is not used with 'shell' verb; request data is given with each 'call' command.")
+		}
+		if *format == "binary" {
+			fail(nil, "The 'shell' verb does not support the 'binary' format.")
+		}
 	} else {
 		if *data != "" {
 			warn("The -d argument is not used with 'list' or 'describe' verb.")
 		}
-		if len(rpcHeaders) > 0 {
+		if len(rpcHeaders) > 0 && !prof.applied("rpc-header") {
 			warn("The -rpc-header argument is not used with 'list' or 'describe' verb.")
 		}
 		if len(args) > 0 {
@@ -356,22 +821,116 @@ func main() {
 	if len(args) > 0 {
 		fail(nil, "Too many arguments.")
 	}
-	if invoke && target == "" {
+	if prof != nil {
+		if verbosityLevel > 0 {
+			prof.print(os.Stderr, target != "")
+		}
+		if target == "" {
+			target = prof.address
+		}
+	}
+	if *listenAddr != "" && !serve && !proxy && !prof.applied("listen") {
+		warn("The -listen argument is only used with 'serve' and 'proxy' verbs.")
+	}
+	if !health && (*healthWatch || *healthWaitFor != 0) && !prof.applied("watch") && !prof.applied("wait-for") {
+		warn("The -watch and -wait-for arguments are only used with 'health' verb.")
+	}
+	if (*timing || *writeOut != "") && !invoke && !prof.applied("timing") && !prof.applied("w") {
+		warn("The -timing and -w arguments are only used when invoking a method.")
+	}
+	if *writeOut != "" {
+		vars := writeOutVars(&grpcurl.DialTiming{}, &grpcurl.CallTiming{}, nil, 0, "")
+		if _, err := expandWriteOut(*writeOut, vars); err != nil {
+			fail(nil, "The -w template is malformed: %v", err)
+		}
+	}
+	if (*maxResponses > 0 || *idleTimeout > 0) && !invoke && !prof.applied("max-responses") && !prof.applied("idle-timeout") {
+		warn("The -max-responses and -idle-timeout arguments are only used when invoking a method.")
+	}
+	if *interactive && !invoke {
+		warn("The -interactive argument is only used when invoking a method.")
+	}
+	if *output != "text" && !invoke && !bench && !health && !workflow && !prof.applied("output") {
+		warn("The -output argument is only used when invoking a method or with 'bench', 'health', or 'workflow' verb.")
+	}
+	if !bench && (*benchCalls != 0 || *benchDuration != 0 || *benchConcurrency != 1 || *benchConnections != 1 || *benchQPS != 0 || *benchStreamMessages != 0) {
+		warn("The -calls, -duration, -concurrency, -connections, -qps, and -stream-messages arguments are only used with 'bench' verb.")
+	}
+	if *output == "json" && invoke {
+		if verbosityLevel > 0 {
+			warn("The -v and -vv arguments are not used with '-output json'; the output always describes the whole call.")
+			verbosityLevel = 0
+		}
+		if *formatError {
+			warn("The -format-error argument is not used with '-output json'; the status is always part of the output.")
+		}
+	}
+	if (invoke || replay || workflow || bench || proxy || health || tlsInfo) && target == "" {
 		fail(nil, "No host:port specified.")
 	}
 	if len(protoset) == 0 && len(protoFiles) == 0 && target == "" {
 		fail(nil, "No host:port specified, no protoset specified, and no proto sources specified.")
 	}
-	if len(protoset) > 0 && len(reflHeaders) > 0 {
+	if len(protoset) > 0 && len(reflHeaders) > 0 && !prof.applied("reflect-header") {
 		warn("The -reflect-header argument is not used when -protoset files are used.")
 	}
 	if len(protoset) > 0 && len(protoFiles) > 0 {
 		fail(nil, "Use either -protoset files or -proto files, but not both.")
 	}
-	if len(importPaths) > 0 && len(protoFiles) == 0 {
+	if len(importPaths) > 0 && len(protoFiles) == 0 && !prof.applied("import-path") {
 		warn("The -import-path argument is not used unless -proto files are used.")
 	}
-	if !reflection.val && len(protoset) == 0 && len(protoFiles) == 0 {
+	vars := map[string]string{}
+	for _, v := range templateVars {
+		name, value, ok := strings.Cut(v, "=")
+		if !ok || !grpcurl.IsValidVarName(name) {
+			fail(nil, "The -var argument must be in 'name=value' format, where name has only letters, digits, and underscores: %q", v)
+		}
+		vars[name] = value
+	}
+	var tmpl *grpcurl.RequestTemplate
+	if *expandData {
+		if !invoke && !bench && !prof.applied("expand-data") {
+			warn("The -expand-data argument is only used when invoking a method or with 'bench' verb.")
+		}
+		if *format == "binary" {
+			fail(nil, "The -expand-data argument does not support the 'binary' format.")
+		}
+		tmpl = grpcurl.NewRequestTemplate(vars)
+	} else if len(templateVars) > 0 && !workflow && !prof.applied("var") {
+		warn("The -var argument is not used unless -expand-data is set or with 'workflow' verb.")
+	}
+	var expectations *grpcurl.Expectations
+	if *expectCode != "" || len(expectHeaders) > 0 || len(expectTrailers) > 0 || len(expectFields) > 0 {
+		if !invoke {
+			warn("The -expect* arguments are only used when invoking an RPC; use \"expect\" in call log records with 'replay' verb.")
+		}
+		if *expectCode != "" {
+			if _, err := grpcurl.ParseCode(*expectCode); err != nil {
+				fail(nil, "The -expect-code option must be a status code name or number: %v", err)
+			}
+		}
+		for _, f := range expectFields {
+			if _, err := grpcurl.ParseFieldExpectation(f); err != nil {
+				fail(nil, "The -expect option is malformed: %v", err)
+			}
+		}
+		expectations = &grpcurl.Expectations{
+			Code:     *expectCode,
+			Headers:  expectHeaders,
+			Trailers: expectTrailers,
+			Fields:   expectFields,
+		}
+	}
+	if (*fields != "" || len(filters) > 0) && !invoke && !prof.applied("fields") && !prof.applied("filter") {
+		warn("The -fields and -filter arguments are only used when invoking an RPC.")
+	}
+	for _, f := range filters {
+		if _, err := grpcurl.ParseFieldExpectation(f); err != nil {
+			fail(nil, "The -filter option is malformed: %v", err)
+		}
+	}
+	if !reflection.val && len(protoset) == 0 && len(protoFiles) == 0 && !health && !tlsInfo {
 		fail(nil, "No protoset files or proto files specified and -use-reflection set to false.")
 	}
 
@@ -379,16 +938,67 @@ func main() {
 	if !reflection.set && (len(protoset) > 0 || len(protoFiles) > 0) {
 		reflection.val = false
 	}
+	if *cacheTTL < 0 {
+		fail(nil, "The -cache-ttl argument must not be negative.")
+	}
+	if *noCache && *refreshCache {
+		fail(nil, "The -no-cache and -refresh-cache arguments are mutually exclusive.")
+	}
+	if !reflection.val && (*noCache || *refreshCache) && !prof.applied("no-cache") && !prof.applied("refresh-cache") {
+		warn("The -no-cache and -refresh-cache arguments are only used with server reflection.")
+	}
 
 	ctx := context.Background()
-	if *maxTime > 0 {
+	if *maxTime > 0 && !shell {
+		// for shell, the maximum time applies to each call instead
 		timeout := time.Duration(*maxTime * float64(time.Second))
 		var cancel context.CancelFunc
 		ctx, cancel = context.WithTimeout(ctx, timeout)
 		defer cancel()
 	}
 
-	dial := func() *grpc.ClientConn {
+	// clientTLSConfig returns the TLS config for connecting to the target, and
+	// the name, if any, to use instead of the target's host name to verify
+	// the server's certificate
+	clientTLSConfig := func() (*tls.Config, string) {
+		tlsConf, err := grpcurl.ClientTLSConfigWithOptions(*insecure, *cacert, *cert, *key, tlsOpts)
+		if err != nil {
+			fail(err, "Failed to create TLS config")
+		}
+
+		sslKeylogFile := os.Getenv("SSLKEYLOGFILE")
+		if sslKeylogFile != "" {
+			w, err := os.OpenFile(sslKeylogFile, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+			if err != nil {
+				fail(err, "Could not open SSLKEYLOGFILE %s", sslKeylogFile)
+			}
+			tlsConf.KeyLogWriter = w
+		}
+
+		// can use either -servername or -authority; but not both
+		if *serverName != "" && *authority != "" {
+			if *serverName == *authority {
+				warn("Both -servername and -authority are present; prefer only -authority.")
+			} else {
+				fail(nil, "Cannot specify different values for -servername and -authority.")
+			}
+		}
+		overrideName := *serverName
+		if overrideName == "" {
+			overrideName = *authority
+		}
+		return tlsConf, overrideName
+	}
+
+	// the timing of the connection and of the invoked RPC
+	var dialTiming grpcurl.DialTiming
+	var callTiming grpcurl.CallTiming
+	// when to stop the response stream of the invoked RPC, and why it was
+	streamLimits := grpcurl.StreamLimits{
+		MaxResponses: *maxResponses,
+		IdleTimeout:  time.Duration(*idleTimeout * float64(time.Second)),
+	}
+	tryDial := func(ctx context.Context) (*grpc.ClientConn, error) {
 		dialTime := 10 * time.Second
 		if *connectTimeout > 0 {
 			dialTime = time.Duration(*connectTimeout * float64(time.Second))
@@ -408,35 +1018,8 @@ func main() {
 		}
 		var creds credentials.TransportCredentials
 		if !*plaintext {
-			tlsConf, err := grpcurl.ClientTLSConfig(*insecure, *cacert, *cert, *key)
-			if err != nil {
-				fail(err, "Failed to create TLS config")
-			}
-
-			sslKeylogFile := os.Getenv("SSLKEYLOGFILE")
-			if sslKeylogFile != "" {
-				w, err := os.OpenFile(sslKeylogFile, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
-				if err != nil {
-					fail(err, "Could not open SSLKEYLOGFILE %s", sslKeylogFile)
-				}
-				tlsConf.KeyLogWriter = w
-			}
-
+			tlsConf, overrideName := clientTLSConfig()
 			creds = credentials.NewTLS(tlsConf)
-
-			// can use either -servername or -authority; but not both
-			if *serverName != "" && *authority != "" {
-				if *serverName == *authority {
-					warn("Both -servername and -authority are present; prefer only -authority.")
-				} else {
-					fail(nil, "Cannot specify different values for -servername and -authority.")
-				}
-			}
-			overrideName := *serverName
-			if overrideName == "" {
-				overrideName = *authority
-			}
-
 			if overrideName != "" {
 				opts = append(opts, grpc.WithAuthority(overrideName))
 			}
@@ -453,11 +1036,22 @@ func main() {
 		}
 		opts = append(opts, grpc.WithUserAgent(grpcurlUA))
 
+		if ts := tokenSource(); ts != nil {
+			opts = append(opts, grpc.WithPerRPCCredentials(grpcurl.TokenCredentials(ts, grpcurl.TokenScope(*tokenFor), !*plaintext)))
+		}
+
 		network := "tcp"
 		if isUnixSocket != nil && isUnixSocket() {
 			network = "unix"
 		}
-		cc, err := grpcurl.BlockingDial(ctx, network, target, creds, opts...)
+		if invoke {
+			opts = append(opts, grpc.WithStatsHandler(grpcurl.TimingStatsHandler{}))
+			ctx = grpcurl.WithDialTiming(ctx, &dialTiming)
+		}
+		return grpcurl.BlockingDial(ctx, network, target, creds, opts...)
+	}
+	dial := func() *grpc.ClientConn {
+		cc, err := tryDial(ctx)
 		if err != nil {
 			fail(err, "Failed to dial target host %q", target)
 		}
@@ -504,12 +1098,21 @@ func main() {
 			fail(err, "Failed to process proto source files.")
 		}
 	}
-	if reflection.val {
+	if reflection.val && !health && !tlsInfo {
+		// the 'health' verb uses the compiled-in health client instead, and
+		// the 'tls-info' verb does not make any calls
 		md := grpcurl.MetadataFromHeaders(append(addlHeaders, reflHeaders...))
 		refCtx := metadata.NewOutgoingContext(ctx, md)
 		cc = dial()
-		refClient = grpcreflect.NewClientV1Alpha(refCtx, reflectpb.NewServerReflectionClient(cc))
+		var err error
+		refClient, err = grpcurl.NewReflectionClient(refCtx, cc, grpcurl.ReflectionVersion(*reflectionVersion))
+		if err != nil {
+			fail(err, "Failed to create reflection client")
+		}
 		reflSource := grpcurl.DescriptorSourceFromServer(ctx, refClient)
+		if !*noCache {
+			reflSource = cachedDescriptorSource(reflSource, target, append(addlHeaders, reflHeaders...))
+		}
 		if fileSource != nil {
 			descSource = compositeSource{reflSource, fileSource}
 		} else {
@@ -596,52 +1199,8 @@ func main() {
 			}
 
 			fqn := dsc.GetFullyQualifiedName()
-			var elementType string
-			switch d := dsc.(type) {
-			case *desc.MessageDescriptor:
-				elementType = "a message"
-				parent, ok := d.GetParent().(*desc.MessageDescriptor)
-				if ok {
-					if d.IsMapEntry() {
-						for _, f := range parent.GetFields() {
-							if f.IsMap() && f.GetMessageType() == d {
-								// found it: describe the map field instead
-								elementType = "the entry type for a map field"
-								dsc = f
-								break
-							}
-						}
-					} else {
-						// see if it's a group
-						for _, f := range parent.GetFields() {
-							if f.GetType() == descriptorpb.FieldDescriptorProto_TYPE_GROUP && f.GetMessageType() == d {
-								// found it: describe the map field instead
-								elementType = "the type of a group field"
-								dsc = f
-								break
-							}
-						}
-					}
-				}
-			case *desc.FieldDescriptor:
-				elementType = "a field"
-				if d.GetType() == descriptorpb.FieldDescriptorProto_TYPE_GROUP {
-					elementType = "a group field"
-				} else if d.IsExtension() {
-					elementType = "an extension"
-				}
-			case *desc.OneOfDescriptor:
-				elementType = "a one-of"
-			case *desc.EnumDescriptor:
-				elementType = "an enum"
-			case *desc.EnumValueDescriptor:
-				elementType = "an enum value"
-			case *desc.ServiceDescriptor:
-				elementType = "a service"
-			case *desc.MethodDescriptor:
-				elementType = "a method"
-			default:
-				err = fmt.Errorf("descriptor has unrecognized type %T", dsc)
+			dsc, elementType, err := descriptorToDescribe(dsc)
+			if err != nil {
 				fail(err, "Failed to describe symbol %q", s)
 			}
 
@@ -657,9 +1216,14 @@ func main() {
 				// create a request to invoke an RPC
 				tmpl := grpcurl.MakeTemplate(dsc)
 				options := grpcurl.FormatOptions{EmitJSONDefaultFields: true}
-				_, formatter, err := grpcurl.RequestParserAndFormatter(grpcurl.Format(*format), descSource, nil, options)
+				tmplFormat := grpcurl.Format(*format)
+				if tmplFormat == grpcurl.FormatBinary {
+					// a binary template isn't useful to show in a terminal
+					tmplFormat = grpcurl.FormatJSON
+				}
+				_, formatter, err := grpcurl.RequestParserAndFormatter(tmplFormat, descSource, nil, options)
 				if err != nil {
-					fail(err, "Failed to construct formatter for %q", *format)
+					fail(err, "Failed to construct formatter for %q", tmplFormat)
 				}
 				str, err := formatter(tmpl)
 				if err != nil {
@@ -673,6 +1237,337 @@ func main() {
 			fail(err, "Failed to write protoset to %s", *protosetOut)
 		}
 
+	} else if shell {
+		// Start an interactive session
+		if cc == nil && target != "" {
+			cc = dial()
+		}
+		sh := &shellSession{
+			descSource:     descSource,
+			cc:             cc,
+			headers:        append(addlHeaders, rpcHeaders...),
+			format:         grpcurl.Format(*format),
+			emitDefaults:   *emitDefaults,
+			allowUnknown:   *allowUnknownFields,
+			verbosityLevel: verbosityLevel,
+			maxTime:        time.Duration(*maxTime * float64(time.Second)),
+			out:            os.Stdout,
+			errOut:         os.Stderr,
+		}
+		if err := sh.run(os.Stdin); err != nil {
+			fail(err, "Failed to read commands")
+		}
+
+	} else if bench {
+		// Benchmark an RPC
+		if cc == nil {
+			cc = dial()
+		}
+		channels := []grpcdynamic.Channel{cc}
+		for i := 1; i < *benchConnections; i++ {
+			extra := dial()
+			defer extra.Close()
+			channels = append(channels, extra)
+		}
+		var in io.Reader
+		if *data == "@" {
+			in = os.Stdin
+		} else {
+			in = strings.NewReader(*data)
+		}
+		if tmpl != nil {
+			in = tmpl.NewReader(in, grpcurl.Format(*format))
+		}
+		options := grpcurl.FormatOptions{
+			EmitJSONDefaultFields: *emitDefaults,
+			AllowUnknownFields:    *allowUnknownFields,
+		}
+		rf, _, err := grpcurl.RequestParserAndFormatter(grpcurl.Format(*format), descSource, in, options)
+		if err != nil {
+			fail(err, "Failed to construct request parser for %q", *format)
+		}
+		md, err := findMethod(descSource, symbol)
+		if err != nil {
+			fail(err, "Failed to resolve method %q", symbol)
+		}
+		reqs, err := readRequests(rf, md)
+		if err != nil {
+			fail(err, "Failed to parse request data")
+		}
+		opts := grpcurl.BenchmarkOptions{
+			Calls:          *benchCalls,
+			Duration:       time.Duration(*benchDuration * float64(time.Second)),
+			Concurrency:    *benchConcurrency,
+			QPS:            *benchQPS,
+			Headers:        append(addlHeaders, rpcHeaders...),
+			Requests:       reqs,
+			StreamMessages: *benchStreamMessages,
+		}
+		if opts.Calls == 0 && opts.Duration == 0 {
+			opts.Calls = defaultBenchCalls
+		}
+		if verbosityLevel > 0 {
+			fmt.Fprintf(os.Stderr, "Benchmarking %s with %d worker(s) over %d connection(s)...\n", md.GetFullyQualifiedName(), opts.Concurrency, len(channels))
+		}
+		// stop on Ctrl-C, but still report on the calls made so far
+		benchCtx, stop := signal.NotifyContext(ctx, os.Interrupt)
+		defer stop()
+		res, err := grpcurl.Benchmark(benchCtx, descSource, channels, symbol, opts)
+		if err != nil {
+			fail(err, "Failed to benchmark method %q", symbol)
+		}
+		if *output == "json" {
+			b, err := json.MarshalIndent(res, "", "  ")
+			if err != nil {
+				fail(err, "Failed to format benchmark result")
+			}
+			fmt.Println(string(b))
+		} else {
+			printBenchmarkResult(os.Stdout, res)
+		}
+
+	} else if serve {
+		// Serve the services in the descriptor source, answering with stubs
+		var in io.Reader
+		if stubFile == "@" {
+			in = os.Stdin
+		} else {
+			f, err := os.Open(stubFile)
+			if err != nil {
+				fail(err, "Failed to open stub file")
+			}
+			defer f.Close()
+			in = f
+		}
+		sf, err := grpcurl.ReadStubFile(in)
+		if err != nil {
+			fail(err, "Failed to read stub file")
+		}
+		var opts []grpc.ServerOption
+		if *cert != "" {
+			creds, err := grpcurl.ServerTransportCredentialsWithOptions(*cacert, *cert, *key, false, tlsOpts)
+			if err != nil {
+				fail(err, "Failed to create TLS config")
+			}
+			opts = append(opts, grpc.Creds(creds))
+		}
+		if *maxMsgSz > 0 {
+			opts = append(opts, grpc.MaxRecvMsgSize(*maxMsgSz))
+		}
+		if verbosityLevel > 0 {
+			opts = append(opts, grpc.StreamInterceptor(logCalls))
+		}
+		svr, err := grpcurl.NewStubServer(descSource, sf.Stubs, opts...)
+		if err != nil {
+			fail(err, "Failed to create server")
+		}
+		l, err := net.Listen("tcp", *listenAddr)
+		if err != nil {
+			fail(err, "Failed to listen on %s", *listenAddr)
+		}
+		fmt.Fprintf(os.Stderr, "Serving %d stub(s) on %s\n", len(sf.Stubs), l.Addr())
+		go func() {
+			sigCtx, stop := signal.NotifyContext(ctx, os.Interrupt)
+			defer stop()
+			<-sigCtx.Done()
+			svr.GracefulStop()
+		}()
+		if err := svr.Serve(l); err != nil {
+			fail(err, "Failed to serve")
+		}
+
+	} else if health {
+		// Check the server's health with the standard health service
+		md := grpcurl.MetadataFromHeaders(append(addlHeaders, rpcHeaders...))
+		healthCtx := metadata.NewOutgoingContext(ctx, md)
+		printStatus := func(st healthpb.HealthCheckResponse_ServingStatus) {
+			printHealth(os.Stdout, symbol, st, *healthWatch, *output == "json")
+		}
+		var st healthpb.HealthCheckResponse_ServingStatus
+		var err error
+		if *healthWaitFor > 0 {
+			waitCtx, cancel := context.WithTimeout(healthCtx, time.Duration(*healthWaitFor*float64(time.Second)))
+			defer cancel()
+			st, err = waitForServing(waitCtx, tryDial, symbol, healthPollInterval, func(st healthpb.HealthCheckResponse_ServingStatus, err error) {
+				if verbosityLevel == 0 {
+					return
+				}
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "Not ready: %v\n", err)
+				} else {
+					fmt.Fprintf(os.Stderr, "Status: %s\n", st)
+				}
+			})
+			if _, ok := status.FromError(err); !ok {
+				// never connected, so there is no status to report
+				fail(err, "Failed to check health of %q", target)
+			}
+		} else {
+			if cc == nil {
+				cc = dial()
+			}
+			if *healthWatch {
+				// stop on Ctrl-C, reporting the last status
+				watchCtx, stop := signal.NotifyContext(healthCtx, os.Interrupt)
+				defer stop()
+				st, err = watchHealth(watchCtx, cc, symbol, printStatus)
+				if watchCtx.Err() != nil && healthCtx.Err() == nil {
+					err = nil
+				}
+			} else {
+				st, err = checkHealth(healthCtx, cc, symbol)
+			}
+		}
+		if err != nil {
+			errStatus, _ := status.FromError(err)
+			grpcurl.PrintStatus(os.Stderr, errStatus, grpcurl.NewJSONFormatter(false, nil))
+			exit(statusCodeOffset + int(errStatus.Code()))
+		}
+		if !*healthWatch {
+			printStatus(st)
+		}
+		exit(healthExitCode(st))
+
+	} else if tlsInfo {
+		// Only do a TLS handshake, and describe it
+		tlsConf, overrideName := clientTLSConfig()
+		if overrideName != "" {
+			tlsConf.ServerName = overrideName
+		}
+		network := "tcp"
+		if isUnixSocket != nil && isUnixSocket() {
+			network = "unix"
+		}
+		dialTime := 10 * time.Second
+		if *connectTimeout > 0 {
+			dialTime = time.Duration(*connectTimeout * float64(time.Second))
+		}
+		checkCtx, cancel := context.WithTimeout(ctx, dialTime)
+		defer cancel()
+		report, err := grpcurl.CheckTLS(checkCtx, network, target, tlsConf)
+		if err != nil {
+			fail(err, "Failed to complete TLS handshake with %q", target)
+		}
+		fmt.Println(report)
+		if report.VerifyError != nil || report.ClientCertError != nil {
+			exit(1)
+		}
+
+	} else if proxy {
+		// Forward all calls to the target, logging them to stdout
+		if cc == nil {
+			cc = dial()
+		}
+		var opts []grpc.ServerOption
+		if *maxMsgSz > 0 {
+			opts = append(opts, grpc.MaxRecvMsgSize(*maxMsgSz))
+		}
+		if verbosityLevel > 0 {
+			opts = append(opts, grpc.StreamInterceptor(logCalls))
+		}
+		svr := grpcurl.NewProxyServer(cc, descSource, os.Stdout, opts...)
+		l, err := net.Listen("tcp", *listenAddr)
+		if err != nil {
+			fail(err, "Failed to listen on %s", *listenAddr)
+		}
+		fmt.Fprintf(os.Stderr, "Proxying calls from %s to %s\n", l.Addr(), target)
+		go func() {
+			sigCtx, stop := signal.NotifyContext(ctx, os.Interrupt)
+			defer stop()
+			<-sigCtx.Done()
+			svr.GracefulStop()
+		}()
+		if err := svr.Serve(l); err != nil {
+			fail(err, "Failed to serve")
+		}
+
+	} else if replay {
+		// Replay all RPCs in a call log
+		if cc == nil {
+			cc = dial()
+		}
+		var in io.Reader
+		if callLog == "@" {
+			in = os.Stdin
+		} else {
+			f, err := os.Open(callLog)
+			if err != nil {
+				fail(err, "Failed to open call log")
+			}
+			defer f.Close()
+			in = f
+		}
+		results, err := grpcurl.ReplayCalls(ctx, descSource, cc, append(addlHeaders, rpcHeaders...), in, os.Stdout)
+		if err != nil {
+			fail(err, "Failed to replay calls from %s", callLog)
+		}
+		numFailed, numUnmet := 0, 0
+		firstFailure := codes.OK
+		for _, res := range results {
+			if res.Passed != nil {
+				if !*res.Passed {
+					numUnmet++
+				}
+			} else if code := res.StatusCode(); code != codes.OK {
+				if numFailed == 0 {
+					firstFailure = code
+				}
+				numFailed++
+			}
+		}
+		if verbosityLevel > 0 {
+			fmt.Fprintf(os.Stderr, "Replayed %d call(s); %d did not complete successfully and %d did not meet expectations\n", len(results), numFailed, numUnmet)
+		}
+		if numUnmet > 0 {
+			exit(expectationFailedCode)
+		}
+		if numFailed > 0 {
+			exit(statusCodeOffset + int(firstFailure))
+		}
+
+	} else if workflow {
+		// Run the steps of a workflow
+		if cc == nil {
+			cc = dial()
+		}
+		var in io.Reader
+		if workflowFile == "@" {
+			in = os.Stdin
+		} else {
+			f, err := os.Open(workflowFile)
+			if err != nil {
+				fail(err, "Failed to open workflow file")
+			}
+			defer f.Close()
+			in = f
+		}
+		wf, err := grpcurl.ReadWorkflow(in)
+		if err != nil {
+			fail(err, "Failed to read workflow from %s", workflowFile)
+		}
+		enc := json.NewEncoder(os.Stdout)
+		results, err := grpcurl.RunWorkflow(ctx, descSource, cc, append(addlHeaders, rpcHeaders...), wf, vars, func(res *grpcurl.WorkflowStepResult) {
+			if *output == "json" {
+				if err := enc.Encode(res); err != nil {
+					fail(err, "Failed to write result")
+				}
+				return
+			}
+			if res.Step > 1 {
+				fmt.Println()
+			}
+			printWorkflowStep(os.Stdout, res, verbosityLevel)
+		})
+		if err != nil {
+			fail(err, "Failed to run workflow from %s", workflowFile)
+		}
+		if last := results[len(results)-1]; !*last.Passed {
+			if verbosityLevel > 0 || *output != "json" {
+				fmt.Fprintf(os.Stderr, "Workflow stopped at step %d of %d\n", last.Step, len(wf.Steps))
+			}
+			exit(workflowExitCode(wf.Steps[last.Step-1], last))
+		}
+
 	} else {
 		// Invoke an RPC
 		if cc == nil {
@@ -684,6 +1579,9 @@ func main() {
 		} else {
 			in = strings.NewReader(*data)
 		}
+		if tmpl != nil {
+			in = tmpl.NewReader(in, grpcurl.Format(*format))
+		}
 
 		// if not verbose output, then also include record delimiters
 		// between each message, so output could potentially be piped
@@ -698,23 +1596,187 @@ func main() {
 		if err != nil {
 			fail(err, "Failed to construct request parser and formatter for %q", *format)
 		}
+		// requests have their own formatter, so they don't change how
+		// responses are separated
+		_, reqFormatter, err := grpcurl.RequestParserAndFormatter(grpcurl.Format(*format), descSource, strings.NewReader(""), grpcurl.FormatOptions{EmitJSONDefaultFields: *emitDefaults})
+		if err != nil {
+			fail(err, "Failed to construct request parser and formatter for %q", *format)
+		}
 		h := &grpcurl.DefaultEventHandler{
-			Out:            os.Stdout,
-			Formatter:      formatter,
-			VerbosityLevel: verbosityLevel,
+			Out:              os.Stdout,
+			Formatter:        formatter,
+			RequestFormatter: reqFormatter,
+			VerbosityLevel:   verbosityLevel,
+			RawOutput:        *format == "binary",
+		}
+		// errors, including with -format-error, are printed for humans, so
+		// don't print them in binary
+		statusFormatter := formatter
+		if *format == "binary" {
+			statusFormatter = grpcurl.NewJSONFormatter(false, grpcurl.AnyResolverFromDescriptorSourceWithFallback(descSource))
+		}
+
+		var handler grpcurl.InvocationEventHandler = h
+		var env *grpcurl.JSONEnvelopeHandler
+		if *output == "json" {
+			env = grpcurl.NewJSONEnvelopeHandler(os.Stdout, descSource, *emitDefaults)
+			handler = env
+		}
+		var fh *grpcurl.ResponseFilterHandler
+		if *fields != "" || len(filters) > 0 {
+			md, err := findMethod(descSource, symbol)
+			if err != nil {
+				fail(err, "Failed to resolve method %q", symbol)
+			}
+			var mask *grpcurl.FieldMask
+			if *fields != "" {
+				if mask, err = grpcurl.NewFieldMask(md.GetOutputType(), strings.Split(*fields, ",")); err != nil {
+					fail(err, "Invalid -fields")
+				}
+			}
+			var filter *grpcurl.ResponseFilter
+			if len(filters) > 0 {
+				if filter, err = grpcurl.NewResponseFilter(md.GetOutputType(), descSource, filters); err != nil {
+					fail(err, "Invalid -filter")
+				}
+			}
+			// expectations apply to all responses, so they see them unfiltered
+			fh = grpcurl.NewResponseFilterHandler(handler, filter, mask)
+			handler = fh
+		}
+		var eh *grpcurl.ExpectationHandler
+		if expectations != nil {
+			// check field expectations before the RPC is invoked
+			var md *desc.MethodDescriptor
+			if len(expectations.Fields) > 0 {
+				if md, err = findMethod(descSource, symbol); err != nil {
+					fail(err, "Failed to resolve method %q", symbol)
+				}
+			}
+			eh, err = grpcurl.NewExpectationHandler(handler, descSource, md, expectations)
+			if err != nil {
+				fail(nil, "The expectations are invalid: %v", err)
+			}
+			handler = eh
+		}
+
+		requests := rf
+		var ir *interactiveRequests
+		var lines *lineReader
+		restore := func() {}
+		if *interactive {
+			md, err := findMethod(descSource, symbol)
+			if err != nil {
+				fail(err, "Failed to resolve method %q", symbol)
+			}
+			if !md.IsClientStreaming() {
+				fail(nil, "The -interactive argument can only be used with client-streaming or bidi-streaming methods.")
+			}
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithCancel(ctx)
+			defer cancel()
+			lines = newLineReader(os.Stdin, os.Stdout, streamPrompt)
+			ir = newInteractiveRequests(lines, lines.output(os.Stderr), grpcurl.Format(*format), descSource, options, cancel)
+			if tmpl != nil {
+				ir.expand = func(line string) (string, error) {
+					return tmpl.ExpandData(line, grpcurl.Format(*format))
+				}
+			}
+			h.Out = lines.output(os.Stdout)
+			if env != nil {
+				env.Out = h.Out
+			}
+			handler = ir.handler(handler)
+			requests = ir
+			if restore, err = ir.begin(); err != nil {
+				fail(err, "Failed to read from terminal")
+			}
 		}
 
-		err = grpcurl.InvokeRPC(ctx, descSource, cc, symbol, append(addlHeaders, rpcHeaders...), h, rf.Next)
+		invokeCtx := grpcurl.WithStreamLimits(grpcurl.WithCallTiming(ctx, &callTiming), &streamLimits)
+		err = grpcurl.InvokeRPC(invokeCtx, descSource, cc, symbol, append(addlHeaders, rpcHeaders...), handler, requests.Next)
+		if ir != nil {
+			ir.finish()
+			// clear the prompt, since nothing more will be read
+			lines.setPrompt("")
+			restore()
+		}
+		if *timing || verbosityLevel > 0 || *writeOut != "" {
+			// report the timing after everything else, however the call ends
+			invokeErr := err
+			reported := false
+			report := func() {
+				if reported {
+					return
+				}
+				reported = true
+				if *timing || verbosityLevel > 0 {
+					printTiming(os.Stderr, &dialTiming, &callTiming)
+				}
+				if *writeOut != "" {
+					stat, numResponses := h.Status, h.NumResponses
+					if env != nil {
+						stat, numResponses = env.Status, env.NumResponses
+					}
+					if fh != nil {
+						numResponses += fh.NumFiltered
+					}
+					if stat == nil {
+						stat = status.Convert(invokeErr)
+					}
+					out, _ := expandWriteOut(*writeOut, writeOutVars(&dialTiming, &callTiming, stat, numResponses, streamLimits.Stopped))
+					fmt.Print(out)
+				}
+			}
+			defer report()
+			prevExit := exit
+			exit = func(code int) {
+				report()
+				prevExit(code)
+			}
+		}
+		if env != nil {
+			// the status is part of the output, so it is not also printed
+			errStatus, isStatus := status.FromError(err)
+			if err != nil && isStatus && eh != nil {
+				// let the expectations see the status, too
+				eh.OnReceiveTrailers(errStatus, nil)
+			}
+			if werr := env.Finish(err); werr != nil {
+				fail(werr, "Failed to write output")
+			}
+			if verbosityLevel > 0 {
+				printStreamStopped(os.Stderr, &streamLimits)
+			}
+			if err != nil && !isStatus {
+				fail(err, "Error invoking method %q", symbol)
+			}
+			if eh != nil {
+				if failures := eh.Failures(); len(failures) > 0 {
+					printExpectationFailures(os.Stderr, failures)
+					exit(expectationFailedCode)
+				}
+			}
+			if env.Status.Code() != codes.OK {
+				exit(statusCodeOffset + int(env.Status.Code()))
+			}
+			return
+		}
 		if err != nil {
 			if errStatus, ok := status.FromError(err); ok && *formatError {
-				h.Status = errStatus
+				if eh != nil {
+					// let the expectations see the status, too
+					eh.OnReceiveTrailers(errStatus, nil)
+				} else {
+					h.Status = errStatus
+				}
 			} else {
 				fail(err, "Error invoking method %q", symbol)
 			}
 		}
 		reqSuffix := ""
 		respSuffix := ""
-		reqCount := rf.NumRequests()
+		reqCount := requests.NumRequests()
 		if reqCount != 1 {
 			reqSuffix = "s"
 		}
@@ -722,25 +1784,149 @@ func main() {
 			respSuffix = "s"
 		}
 		if verbosityLevel > 0 {
-			fmt.Printf("Sent %d request%s and received %d response%s\n", reqCount, reqSuffix, h.NumResponses, respSuffix)
+			if fh != nil && fh.NumFiltered > 0 {
+				numReceived := h.NumResponses + fh.NumFiltered
+				respSuffix = ""
+				if numReceived != 1 {
+					respSuffix = "s"
+				}
+				fmt.Printf("Sent %d request%s and received %d response%s (%d filtered out)\n", reqCount, reqSuffix, numReceived, respSuffix, fh.NumFiltered)
+			} else {
+				fmt.Printf("Sent %d request%s and received %d response%s\n", reqCount, reqSuffix, h.NumResponses, respSuffix)
+			}
+			printStreamStopped(os.Stdout, &streamLimits)
+		}
+		if eh != nil {
+			failures := eh.Failures()
+			if len(failures) == 0 {
+				if verbosityLevel > 0 {
+					fmt.Println("All expectations met")
+				}
+				return
+			}
+			if h.Status.Code() != codes.OK {
+				grpcurl.PrintStatus(os.Stderr, h.Status, statusFormatter)
+			}
+			printExpectationFailures(os.Stderr, failures)
+			exit(expectationFailedCode)
 		}
 		if h.Status.Code() != codes.OK {
 			if *formatError {
-				printFormattedStatus(os.Stderr, h.Status, formatter)
+				printFormattedStatus(os.Stderr, h.Status, statusFormatter)
 			} else {
-				grpcurl.PrintStatus(os.Stderr, h.Status, formatter)
+				grpcurl.PrintStatus(os.Stderr, h.Status, statusFormatter)
 			}
 			exit(statusCodeOffset + int(h.Status.Code()))
 		}
 	}
 }
 
+// descriptorToDescribe returns the descriptor that should be shown when the
+// given descriptor is described, along with a phrase describing what kind of
+// element it is. For synthetic messages, like map entries and the types of
+// group fields, the corresponding field is described instead.
+func descriptorToDescribe(dsc desc.Descriptor) (desc.Descriptor, string, error) {
+	var elementType string
+	switch d := dsc.(type) {
+	case *desc.MessageDescriptor:
+		elementType = "a message"
+		parent, ok := d.GetParent().(*desc.MessageDescriptor)
+		if ok {
+			if d.IsMapEntry() {
+				for _, f := range parent.GetFields() {
+					if f.IsMap() && f.GetMessageType() == d {
+						// found it: describe the map field instead
+						elementType = "the entry type for a map field"
+						dsc = f
+						break
+					}
+				}
+			} else {
+				// see if it's a group
+				for _, f := range parent.GetFields() {
+					if f.GetType() == descriptorpb.FieldDescriptorProto_TYPE_GROUP && f.GetMessageType() == d {
+						// found it: describe the map field instead
+						elementType = "the type of a group field"
+						dsc = f
+						break
+					}
+				}
+			}
+		}
+	case *desc.FieldDescriptor:
+		elementType = "a field"
+		if d.GetType() == descriptorpb.FieldDescriptorProto_TYPE_GROUP {
+			elementType = "a group field"
+		} else if d.IsExtension() {
+			elementType = "an extension"
+		}
+	case *desc.OneOfDescriptor:
+		elementType = "a one-of"
+	case *desc.EnumDescriptor:
+		elementType = "an enum"
+	case *desc.EnumValueDescriptor:
+		elementType = "an enum value"
+	case *desc.ServiceDescriptor:
+		elementType = "a service"
+	case *desc.MethodDescriptor:
+		elementType = "a method"
+	default:
+		return nil, "", fmt.Errorf("descriptor has unrecognized type %T", dsc)
+	}
+	return dsc, elementType, nil
+}
+
+func printExpectationFailures(w io.Writer, failures []grpcurl.ExpectationFailure) {
+	fmt.Fprintf(w, "Expectations not met: %d\n", len(failures))
+	for _, f := range failures {
+		fmt.Fprintf(w, "  %s\n", f.Subject)
+		fmt.Fprintf(w, "    - expected: %s\n", f.Expected)
+		fmt.Fprintf(w, "    + actual:   %s\n", f.Actual)
+	}
+}
+
+// printStreamStopped says why the response stream was stopped, if it was
+// stopped because of -max-responses or -idle-timeout.
+func printStreamStopped(w io.Writer, limits *grpcurl.StreamLimits) {
+	switch limits.Stopped {
+	case grpcurl.StreamStoppedAtMaxResponses:
+		fmt.Fprintf(w, "Stopped response stream after %d response(s) (-max-responses)\n", limits.MaxResponses)
+	case grpcurl.StreamStoppedWhenIdle:
+		fmt.Fprintf(w, "Stopped response stream after no response for %v (-idle-timeout)\n", limits.IdleTimeout)
+	}
+}
+
+// verbs are the names of the verbs, which select what to do instead of
+// invoking a method. The value is true for the verbs that may be used without
+// an address, so they may come first; the rest follow the address.
+var verbs = map[string]bool{
+	"list":     true,
+	"describe": true,
+	"shell":    true,
+	"serve":    true,
+	"proxy":    true,
+	"replay":   false,
+	"workflow": false,
+	"bench":    false,
+	"health":   false,
+	"tls-info": false,
+}
+
 func usage() {
 	fmt.Fprintf(os.Stderr, `Usage:
 	%s [flags] [address] [list|describe] [symbol]
-
-The 'address' is only optional when used with 'list' or 'describe' and a
-protoset or proto flag is provided.
+	%s [flags] address replay call-log
+	%s [flags] address workflow workflow-file
+	%s [flags] address bench method
+	%s [flags] serve stub-file
+	%s [flags] proxy address
+	%s [flags] address health [service]
+	%s [flags] address tls-info
+	%s [flags] shell [address]
+
+The 'address' is only optional when used with 'list', 'describe', or 'shell'
+and a protoset or proto flag is provided, or when it is provided by the profile
+named with -profile.
 
 If 'list' is indicated, the symbol (if present) should be a fully-qualified
 service name. If present, all methods of that service are listed. If not
@@ -750,12 +1936,90 @@ If 'describe' is indicated, the descriptor for the given symbol is shown. The
 symbol should be a fully-qualified service, enum, or message name. If no symbol
 is given then the descriptors for all exposed or known services are shown.
 
-If neither verb is present, the symbol must be a fully-qualified method name in
+If no verb is present, the symbol must be a fully-qualified method name in
 'service/method' or 'service.method' format. In this case, the request body will
 be used to invoke the named method. If no body is given but one is required
 (i.e. the method is unary or server-streaming), an empty instance of the
 method's request type will be sent.
 
+If 'replay' is indicated, the named file (or stdin, if the name is '@') is a
+call log in JSONL format: each line is a JSON object with a "method", optional
+"headers" (in 'name: value' format), and a "request" body or a "requests" array
+of bodies for streaming calls. All calls are made over a single connection, in
+order, and one JSON result per call (with status code, message, headers,
+trailers, responses, and duration) is written to stdout.
+
+If 'workflow' is indicated, the named file (or stdin, if the name is '@') is a
+workflow in JSON or YAML format: optional "vars" and a list of "steps", each
+with a "method", optional "headers", a "request" body or a "requests" array,
+optional "expect" (with "code", "headers", "trailers", and "fields" in the
+same syntax as -expect), and optional "capture", which maps variable names to
+a field path in the responses (in the same syntax as -expect) or to
+'header:NAME' or 'trailer:NAME'. Placeholders in headers and in request
+strings are expanded like with -expand-data, and may also refer to captured
+values and to variables defined with -var. Steps are run in order over a
+single connection, and each step's status, responses, and captured values are
+written to stdout (or a JSON result per step, with -output json). The
+workflow stops at the first step that fails: one that does not meet its
+expectations (by default, that it succeeds) or whose values cannot be
+captured.
+
+If 'bench' is indicated, the named method is invoked repeatedly, with the
+request body given by -d, to measure its throughput and latency. Calls are made
+according to the -calls, -duration, -concurrency, -connections, -qps, and
+-stream-messages flags. When done (or when interrupted with Ctrl-C), the number
+of calls, calls per second, count of each status code, latency percentiles, and
+a latency histogram are written to stdout.
+
+If 'serve' is indicated, a mock server is started on the -listen address. It
+implements every service in the -protoset or -proto files, along with server
+reflection for them, and answers calls using the named stub file (or stdin, if
+the name is '@'). The stub file is JSON or YAML with a "stubs" list. Each stub
+has a "method", optional "match" expressions on the request (in the same
+syntax as -expect), "headers" and "trailers" (in 'name: value' format), a
+"response" body or a "responses" array of bodies for server-streaming methods,
+and an optional "status" with a "code", "message", and "details". Each call is
+answered by the first stub for its method whose expressions all match. If
+-cert and -key are given, the server uses TLS. The server runs until
+interrupted with Ctrl-C.
+
+If 'health' is indicated, the serving status of the named service (or of the
+server as a whole, if no service is named) is checked with the standard
+grpc.health.v1.Health service, which needs no reflection or proto files. The
+status is written to stdout, and the exit code is 0 for SERVING, 4 for
+NOT_SERVING, 5 for UNKNOWN, and 6 for SERVICE_UNKNOWN. Use -watch to write
+each change of status as it happens, or -wait-for to wait until the status is
+SERVING.
+
+If 'tls-info' is indicated, only a TLS handshake is done with the server, using
+the same TLS flags as other verbs, and a description of it is written to
+stdout: the negotiated TLS version and cipher suite, the certificate chain that
+the server presented (with days until each certificate expires), whether the
+chain could be verified against the -cacert roots and the -authority (or the
+host name of the address), whether the server requested a client certificate
+and which certificate authorities it accepts, and whether it accepted the
+-cert given. The exit code is 1 if the chain could not be verified or the
+client certificate was rejected.
+
+If 'proxy' is indicated, a proxy is started on the -listen address, which
+forwards every call it receives to the given address, using the same flags
+as other verbs to connect to it. Calls to any method are forwarded, even if
+the method is unknown. When a call completes, it is written to stdout as a
+line of JSON, in the call log format used by 'replay', with its response
+headers, responses, trailers, and status also included. Messages are decoded
+to JSON using server reflection or the -protoset or -proto files; messages
+that cannot be decoded are written in base64 as "rawRequests" and
+"rawResponses" instead. The proxy accepts plain-text connections and runs
+until interrupted with Ctrl-C.
+
+If 'shell' is indicated, an interactive session is started, in which commands
+to list and describe symbols and to invoke methods can be entered, with tab
+completion of symbol names. The connection and schema are re-used for all
+commands in the session. Headers set during the session are sent with every
+call. The address may be given before or after the verb; without an address,
+only commands that do not invoke methods can be used. Type 'help' in the
+session for a list of commands.
+
 The address will typically be in the form "host:port" where host can be an IP
 address or a hostname and port is a numeric port or service name. If an IPv6
 address is given, it must be surrounded by brackets, like "[2001:db8::1]". For
@@ -763,7 +2027,7 @@ Unix variants, if a -unix=true flag is present, then the address must be the
 path to the domain socket.
 
 Available flags:
-`, os.Args[0])
+`, os.Args[0], os.Args[0], os.Args[0], os.Args[0], os.Args[0], os.Args[0], os.Args[0], os.Args[0], os.Args[0])
 	flags.PrintDefaults()
 }
 
@@ -806,6 +2070,49 @@ func fail(err error, msg string, args ...interface{}) {
 	}
 }
 
+// tokenSource returns the source of bearer tokens given by the -token-file,
+// -token-exec, or -oauth-* flags, or nil if none was given.
+func tokenSource() oauth2.TokenSource {
+	switch {
+	case *tokenFile != "":
+		return grpcurl.TokenSourceFromFile(*tokenFile)
+	case *tokenExec != "":
+		return grpcurl.TokenSourceFromCommand(*tokenExec)
+	case *oauthTokenURL != "":
+		secret := *oauthClientSecret
+		if secret == "" {
+			secret = os.Getenv("GRPCURL_OAUTH_CLIENT_SECRET")
+		}
+		var params url.Values
+		if *oauthAudience != "" {
+			params = url.Values{"audience": []string{*oauthAudience}}
+		}
+		return grpcurl.ClientCredentialsTokenSource(context.Background(), *oauthTokenURL, *oauthClientID, secret, oauthScopes, params)
+	}
+	return nil
+}
+
+// cachedDescriptorSource wraps the given reflection source so that its
+// descriptors are cached on disk, according to the -cache-dir, -cache-ttl and
+// -refresh-cache flags. The given headers are those sent to the reflection
+// service. If there is no cache directory, the source is returned as is.
+func cachedDescriptorSource(source grpcurl.DescriptorSource, target string, headers []string) grpcurl.DescriptorSource {
+	dir := *cacheDir
+	if dir == "" {
+		var err error
+		if dir, err = grpcurl.DefaultDescriptorCacheDir(); err != nil {
+			return source
+		}
+	}
+	authority := *authority
+	if authority == "" {
+		authority = *serverName
+	}
+	path := grpcurl.DescriptorCacheFile(dir, target, authority, *plaintext, headers)
+	ttl := time.Duration(*cacheTTL * float64(time.Second))
+	return grpcurl.CachedDescriptorSource(source, path, ttl, *refreshCache)
+}
+
 func writeProtoset(descSource grpcurl.DescriptorSource, symbols ...string) error {
 	if *protosetOut == "" {
 		return nil
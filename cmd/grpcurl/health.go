@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+)
+
+// The exit codes used by the 'health' verb when the server reports a status
+// other than SERVING.
+const (
+	healthNotServingCode     = 4
+	healthUnknownCode        = 5
+	healthServiceUnknownCode = 6
+)
+
+// healthPollInterval is how often the server is checked with -wait-for.
+const healthPollInterval = time.Second
+
+// healthExitCode returns the exit code for the given serving status.
+func healthExitCode(st healthpb.HealthCheckResponse_ServingStatus) int {
+	switch st {
+	case healthpb.HealthCheckResponse_SERVING:
+		return 0
+	case healthpb.HealthCheckResponse_NOT_SERVING:
+		return healthNotServingCode
+	case healthpb.HealthCheckResponse_SERVICE_UNKNOWN:
+		return healthServiceUnknownCode
+	default:
+		return healthUnknownCode
+	}
+}
+
+// checkHealth returns the serving status of the given service. A NotFound
+// error, which servers return for services they do not know, is reported as
+// SERVICE_UNKNOWN, like the Watch method does.
+func checkHealth(ctx context.Context, cc grpc.ClientConnInterface, service string) (healthpb.HealthCheckResponse_ServingStatus, error) {
+	resp, err := healthpb.NewHealthClient(cc).Check(ctx, &healthpb.HealthCheckRequest{Service: service})
+	if status.Code(err) == codes.NotFound {
+		return healthpb.HealthCheckResponse_SERVICE_UNKNOWN, nil
+	} else if err != nil {
+		return healthpb.HealthCheckResponse_UNKNOWN, err
+	}
+	return resp.GetStatus(), nil
+}
+
+// watchHealth calls onStatus with each serving status of the given service
+// that the server reports, until the stream ends. It returns the last status
+// reported, along with the error that ended the stream.
+func watchHealth(ctx context.Context, cc grpc.ClientConnInterface, service string, onStatus func(healthpb.HealthCheckResponse_ServingStatus)) (healthpb.HealthCheckResponse_ServingStatus, error) {
+	last := healthpb.HealthCheckResponse_UNKNOWN
+	stream, err := healthpb.NewHealthClient(cc).Watch(ctx, &healthpb.HealthCheckRequest{Service: service})
+	if err != nil {
+		return last, err
+	}
+	for {
+		resp, err := stream.Recv()
+		if err == io.EOF {
+			return last, nil
+		} else if err != nil {
+			return last, err
+		}
+		last = resp.GetStatus()
+		onStatus(last)
+	}
+}
+
+// waitForServing checks the serving status of the given service every
+// interval, until it is SERVING or the context is done. The dial function is
+// used to connect to the server, and is retried until it succeeds, so that the
+// server need not be running yet. It returns the last status reported and, if
+// the last attempt failed, the error.
+func waitForServing(ctx context.Context, dial func(context.Context) (*grpc.ClientConn, error), service string, interval time.Duration, onAttempt func(healthpb.HealthCheckResponse_ServingStatus, error)) (healthpb.HealthCheckResponse_ServingStatus, error) {
+	var cc *grpc.ClientConn
+	defer func() {
+		if cc != nil {
+			cc.Close()
+		}
+	}()
+	lastStatus := healthpb.HealthCheckResponse_UNKNOWN
+	var lastErr error
+	for attempts := 0; ; attempts++ {
+		var st healthpb.HealthCheckResponse_ServingStatus
+		var err error
+		if cc == nil {
+			cc, err = dial(ctx)
+		}
+		if err == nil {
+			st, err = checkHealth(ctx, cc, service)
+		}
+		if ctx.Err() != nil && attempts > 0 {
+			// the attempt was cut short, so report the one before it
+			return lastStatus, lastErr
+		}
+		lastStatus, lastErr = st, err
+		if onAttempt != nil {
+			onAttempt(st, err)
+		}
+		if err == nil && st == healthpb.HealthCheckResponse_SERVING {
+			return st, nil
+		}
+		select {
+		case <-ctx.Done():
+			return lastStatus, lastErr
+		case <-time.After(interval):
+		}
+	}
+}
+
+// printHealth writes the given serving status to w. In watch mode, each line
+// includes the time it was received.
+func printHealth(w io.Writer, service string, st healthpb.HealthCheckResponse_ServingStatus, watch, asJSON bool) {
+	now := time.Now().Format(time.RFC3339Nano)
+	if asJSON {
+		out := struct {
+			Time    string `json:"time,omitempty"`
+			Service string `json:"service"`
+			Status  string `json:"status"`
+		}{Service: service, Status: st.String()}
+		if watch {
+			out.Time = now
+		}
+		b, _ := json.Marshal(out)
+		fmt.Fprintln(w, string(b))
+	} else if watch {
+		fmt.Fprintf(w, "%s %s\n", now, st)
+	} else {
+		fmt.Fprintln(w, st)
+	}
+}
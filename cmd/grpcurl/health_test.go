@@ -0,0 +1,157 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	insecurecreds "google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+)
+
+func startHealthServer(t *testing.T) (*health.Server, string) {
+	hs := health.NewServer()
+	svr := grpc.NewServer()
+	healthpb.RegisterHealthServer(svr, hs)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	go svr.Serve(l)
+	t.Cleanup(svr.Stop)
+	return hs, l.Addr().String()
+}
+
+func dialHealth(ctx context.Context, addr string) (*grpc.ClientConn, error) {
+	return grpc.DialContext(ctx, addr, grpc.WithTransportCredentials(insecurecreds.NewCredentials()), grpc.WithBlock())
+}
+
+func TestCheckHealth(t *testing.T) {
+	hs, addr := startHealthServer(t)
+	hs.SetServingStatus("down", healthpb.HealthCheckResponse_NOT_SERVING)
+	cc, err := dialHealth(context.Background(), addr)
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+	defer cc.Close()
+
+	testCases := []struct {
+		service      string
+		expected     healthpb.HealthCheckResponse_ServingStatus
+		expectedCode int
+	}{
+		{"", healthpb.HealthCheckResponse_SERVING, 0},
+		{"down", healthpb.HealthCheckResponse_NOT_SERVING, healthNotServingCode},
+		{"unknown", healthpb.HealthCheckResponse_SERVICE_UNKNOWN, healthServiceUnknownCode},
+	}
+	for _, tc := range testCases {
+		st, err := checkHealth(context.Background(), cc, tc.service)
+		if err != nil {
+			t.Errorf("%q: failed to check health: %v", tc.service, err)
+			continue
+		}
+		if st != tc.expected {
+			t.Errorf("%q: wrong status: expected %v, got %v", tc.service, tc.expected, st)
+		}
+		if code := healthExitCode(st); code != tc.expectedCode {
+			t.Errorf("%q: wrong exit code: expected %d, got %d", tc.service, tc.expectedCode, code)
+		}
+	}
+	if code := healthExitCode(healthpb.HealthCheckResponse_UNKNOWN); code != healthUnknownCode {
+		t.Errorf("wrong exit code for UNKNOWN: %d", code)
+	}
+}
+
+func TestWatchHealth(t *testing.T) {
+	hs, addr := startHealthServer(t)
+	hs.SetServingStatus("svc", healthpb.HealthCheckResponse_NOT_SERVING)
+	cc, err := dialHealth(context.Background(), addr)
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+	defer cc.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var seen []healthpb.HealthCheckResponse_ServingStatus
+	st, err := watchHealth(ctx, cc, "svc", func(st healthpb.HealthCheckResponse_ServingStatus) {
+		seen = append(seen, st)
+		switch len(seen) {
+		case 1:
+			hs.SetServingStatus("svc", healthpb.HealthCheckResponse_SERVING)
+		case 2:
+			cancel()
+		}
+	})
+	if status.Code(err) != codes.Canceled {
+		t.Errorf("expected watch to be cancelled, got %v", err)
+	}
+	if st != healthpb.HealthCheckResponse_SERVING {
+		t.Errorf("wrong last status: %v", st)
+	}
+	if len(seen) != 2 || seen[0] != healthpb.HealthCheckResponse_NOT_SERVING {
+		t.Errorf("wrong statuses: %v", seen)
+	}
+}
+
+func TestWaitForServing(t *testing.T) {
+	hs, addr := startHealthServer(t)
+	hs.SetServingStatus("svc", healthpb.HealthCheckResponse_NOT_SERVING)
+
+	// the first attempt fails to connect, and the second finds the service
+	// not serving yet
+	dials := 0
+	dial := func(ctx context.Context) (*grpc.ClientConn, error) {
+		dials++
+		if dials == 1 {
+			return nil, errors.New("connection refused")
+		}
+		return dialHealth(ctx, addr)
+	}
+	attempts := 0
+	st, err := waitForServing(context.Background(), dial, "svc", 10*time.Millisecond, func(st healthpb.HealthCheckResponse_ServingStatus, err error) {
+		attempts++
+		if attempts == 2 {
+			hs.SetServingStatus("svc", healthpb.HealthCheckResponse_SERVING)
+		}
+	})
+	if err != nil || st != healthpb.HealthCheckResponse_SERVING {
+		t.Errorf("expected SERVING, got %v, %v", st, err)
+	}
+	if attempts != 3 || dials != 2 {
+		t.Errorf("wrong number of attempts or dials: %d, %d", attempts, dials)
+	}
+
+	// if it never becomes ready, the last status is reported
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	hs.SetServingStatus("svc", healthpb.HealthCheckResponse_NOT_SERVING)
+	st, err = waitForServing(ctx, dial, "svc", 10*time.Millisecond, nil)
+	if err != nil || st != healthpb.HealthCheckResponse_NOT_SERVING {
+		t.Errorf("expected NOT_SERVING, got %v, %v", st, err)
+	}
+}
+
+func TestPrintHealth(t *testing.T) {
+	var out bytes.Buffer
+	printHealth(&out, "svc", healthpb.HealthCheckResponse_SERVING, false, false)
+	printHealth(&out, "svc", healthpb.HealthCheckResponse_NOT_SERVING, false, true)
+	expected := "SERVING\n" + `{"service":"svc","status":"NOT_SERVING"}` + "\n"
+	if out.String() != expected {
+		t.Errorf("wrong output: expected %q, got %q", expected, out.String())
+	}
+
+	out.Reset()
+	printHealth(&out, "svc", healthpb.HealthCheckResponse_SERVING, true, false)
+	if !strings.HasSuffix(out.String(), " SERVING\n") {
+		t.Errorf("wrong output for watch: %q", out.String())
+	}
+}
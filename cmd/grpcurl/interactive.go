@@ -0,0 +1,427 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/golang/protobuf/proto" //lint:ignore SA1019 we have to import this because it appears in exported API
+	"github.com/jhump/protoreflect/desc"
+	"golang.org/x/term"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+
+	"github.com/tetrateio/grpcurl"
+)
+
+const (
+	streamPrompt         = "> "
+	streamContinuePrompt = "... "
+	streamClosedPrompt   = "(closed)> "
+)
+
+// errStopped is returned by lineReader.next when the caller stops waiting.
+var errStopped = errors.New("stopped waiting for input")
+
+// errInterrupted is returned by lineReader.next when the user presses Ctrl-C
+// at a terminal.
+var errInterrupted = errors.New("interrupted")
+
+type lineResult struct {
+	line string
+	err  error
+}
+
+// lineReader reads lines of input, from a terminal or otherwise, in a
+// background goroutine. This allows a caller to stop waiting for a line, such
+// as when a stream ends, without losing input: the line is then returned to
+// the next caller instead.
+type lineReader struct {
+	// terminal is nil if input is not a terminal
+	terminal *term.Terminal
+	fd       int
+	read     func() (string, error)
+	results  chan lineResult
+
+	mu      sync.Mutex
+	pending bool
+}
+
+func newLineReader(in *os.File, out io.Writer, prompt string) *lineReader {
+	r := &lineReader{
+		fd:      int(in.Fd()),
+		results: make(chan lineResult, 1),
+	}
+	if !term.IsTerminal(r.fd) {
+		scanner := bufio.NewScanner(in)
+		r.read = func() (string, error) {
+			if scanner.Scan() {
+				return scanner.Text(), nil
+			}
+			if err := scanner.Err(); err != nil {
+				return "", err
+			}
+			return "", io.EOF
+		}
+		return r
+	}
+
+	ir := &interruptDetector{r: in}
+	r.terminal = term.NewTerminal(struct {
+		io.Reader
+		io.Writer
+	}{ir, out}, prompt)
+	r.read = func() (string, error) {
+		ir.key.Store(0)
+		line, err := r.terminal.ReadLine()
+		switch ir.key.Load() {
+		case keyCtrlC:
+			return "", errInterrupted
+		case keyCtrlD:
+			return "", io.EOF
+		}
+		return line, err
+	}
+	return r
+}
+
+// next returns the next line of input. If the given channel is closed before
+// a line is available, it returns errStopped.
+func (r *lineReader) next(stop <-chan struct{}) (string, error) {
+	r.mu.Lock()
+	if !r.pending {
+		r.pending = true
+		go func() {
+			line, err := r.read()
+			r.results <- lineResult{line: line, err: err}
+		}()
+	}
+	r.mu.Unlock()
+
+	select {
+	case res := <-r.results:
+		r.mu.Lock()
+		r.pending = false
+		r.mu.Unlock()
+		return res.line, res.err
+	case <-stop:
+		return "", errStopped
+	}
+}
+
+// makeRaw puts the terminal, if input is a terminal, into raw mode so that
+// lines can be edited. It returns a function that restores the prior mode.
+func (r *lineReader) makeRaw() (func(), error) {
+	if r.terminal == nil {
+		return func() {}, nil
+	}
+	state, err := term.MakeRaw(r.fd)
+	if err != nil {
+		return nil, err
+	}
+	if w, _, err := term.GetSize(r.fd); err == nil && w > 0 {
+		_ = r.terminal.SetSize(w, 0)
+	}
+	return func() {
+		_ = term.Restore(r.fd, state)
+	}, nil
+}
+
+// setPrompt changes the prompt. If a line is being read, it is redrawn with the
+// new prompt.
+func (r *lineReader) setPrompt(prompt string) {
+	if r.terminal != nil {
+		r.terminal.SetPrompt(prompt)
+		_, _ = r.terminal.Write(nil)
+	}
+}
+
+// output returns a writer for output that is interleaved with input. If input
+// is a terminal, the prompt and partially entered line are redrawn after each
+// write. Otherwise, the given writer is returned.
+func (r *lineReader) output(w io.Writer) io.Writer {
+	if r.terminal != nil {
+		return r.terminal
+	}
+	return w
+}
+
+const (
+	keyCtrlC = 3
+	keyCtrlD = 4
+)
+
+// endLineKeys clear the line being edited and then end it.
+var endLineKeys = []byte{11, 21, '\r'} // Ctrl-K, Ctrl-U, Enter
+
+// interruptDetector reads keys from a terminal and records whether Ctrl-C or
+// Ctrl-D was pressed, replacing it with keys that end the line. This is
+// needed because the terminal reports both keys as io.EOF, and then reports
+// io.EOF again the next time a line is read.
+type interruptDetector struct {
+	r   io.Reader
+	buf []byte
+	key atomic.Int32
+}
+
+func (d *interruptDetector) Read(p []byte) (int, error) {
+	if len(d.buf) == 0 {
+		n, err := d.r.Read(p)
+		if n == 0 {
+			return 0, err
+		}
+		d.buf = append(d.buf[:0], p[:n]...)
+	}
+	if d.buf[0] == keyCtrlC || d.buf[0] == keyCtrlD {
+		d.key.Store(int32(d.buf[0]))
+		d.buf = d.buf[1:]
+		return copy(p, endLineKeys), nil
+	}
+	// stop before the next Ctrl-C or Ctrl-D, so it only ends its own line
+	n := len(d.buf)
+	if i := bytes.IndexAny(d.buf, "\x03\x04"); i > 0 {
+		n = i
+	}
+	n = copy(p, d.buf[:n])
+	d.buf = d.buf[n:]
+	return n, nil
+}
+
+// interactiveRequests is a grpcurl.RequestParser that reads request messages
+// as they are entered, so each one is sent as soon as it is complete. Typing
+// '/close' (or pressing Ctrl-D) half-closes the request stream, and '/cancel'
+// (or pressing Ctrl-C) cancels the RPC.
+//
+// With JSON format, a message may span multiple lines, and several messages
+// may be entered on one line. With other formats, each line is one message.
+type interactiveRequests struct {
+	lines     *lineReader
+	out       io.Writer
+	newParser func(io.Reader) grpcurl.RequestParser
+	isJSON    bool
+	cancel    context.CancelFunc
+	// if not nil, applied to each line of input before it is parsed, such as
+	// to expand placeholders
+	expand func(string) (string, error)
+
+	done     chan struct{}
+	doneOnce sync.Once
+	wg       sync.WaitGroup
+
+	// complete messages entered but not yet sent
+	queued []string
+	// the start of a JSON message that spans lines
+	partial      string
+	requestCount int
+}
+
+// newInteractiveRequests creates a request parser that reads messages from the
+// given lines. Error messages are written to out. The given cancel function is
+// used to cancel the RPC.
+func newInteractiveRequests(lines *lineReader, out io.Writer, format grpcurl.Format, descSource grpcurl.DescriptorSource, options grpcurl.FormatOptions, cancel context.CancelFunc) *interactiveRequests {
+	return &interactiveRequests{
+		lines: lines,
+		out:   out,
+		newParser: func(in io.Reader) grpcurl.RequestParser {
+			// The format was already validated, so this can't fail.
+			rf, _, _ := grpcurl.RequestParserAndFormatter(format, descSource, in, options)
+			return rf
+		},
+		isJSON: format == grpcurl.FormatJSON,
+		cancel: cancel,
+		done:   make(chan struct{}),
+	}
+}
+
+// handler wraps the given handler so that waiting for input stops when the
+// RPC completes.
+func (r *interactiveRequests) handler(h grpcurl.InvocationEventHandler) grpcurl.InvocationEventHandler {
+	return &streamEndHandler{InvocationEventHandler: h, onEnd: r.finish}
+}
+
+// begin prepares for reading request messages. If input is a terminal, it
+// prints a hint and puts the terminal into raw mode, returning a function that
+// restores the prior mode.
+func (r *interactiveRequests) begin() (func(), error) {
+	if r.lines.terminal != nil {
+		fmt.Fprintln(r.out, "Enter request messages; type '/close' to close the request stream or '/cancel' to cancel the RPC.")
+	}
+	r.lines.setPrompt(streamPrompt)
+	return r.lines.makeRaw()
+}
+
+// finish stops waiting for input and waits for any background reading to
+// stop. It must be called after the RPC completes. The caller should then
+// set the prompt, since a line may still be being read.
+func (r *interactiveRequests) finish() {
+	r.doneOnce.Do(func() {
+		close(r.done)
+	})
+	r.wg.Wait()
+}
+
+func (r *interactiveRequests) Next(m proto.Message) error {
+	for {
+		for len(r.queued) > 0 {
+			text := r.queued[0]
+			r.queued = r.queued[1:]
+			if err := r.newParser(strings.NewReader(text)).Next(m); err != nil {
+				fmt.Fprintf(r.out, "Error: invalid request message: %v\n", err)
+				m.Reset()
+				continue
+			}
+			r.requestCount++
+			return nil
+		}
+
+		line, err := r.lines.next(r.done)
+		switch err {
+		case nil:
+		case errStopped:
+			return io.EOF
+		case errInterrupted:
+			r.cancel()
+			return io.EOF
+		case io.EOF:
+			r.closeSend()
+			return io.EOF
+		default:
+			return err
+		}
+		switch strings.TrimSpace(line) {
+		case "/close":
+			r.closeSend()
+			return io.EOF
+		case "/cancel":
+			r.cancel()
+			return io.EOF
+		}
+		if r.expand != nil {
+			if line, err = r.expand(line); err != nil {
+				fmt.Fprintf(r.out, "Error: %v\n", err)
+				continue
+			}
+		}
+		r.addInput(line)
+	}
+}
+
+func (r *interactiveRequests) NumRequests() int {
+	return r.requestCount
+}
+
+// addInput queues the messages in the given line of input.
+func (r *interactiveRequests) addInput(line string) {
+	if !r.isJSON {
+		if strings.TrimSpace(line) != "" {
+			r.queued = append(r.queued, line)
+		}
+		return
+	}
+
+	input := r.partial + line + "\n"
+	r.partial = ""
+	dec := json.NewDecoder(strings.NewReader(input))
+	for {
+		offset := dec.InputOffset()
+		var msg json.RawMessage
+		err := dec.Decode(&msg)
+		if err == io.EOF {
+			break
+		} else if err == io.ErrUnexpectedEOF {
+			// wait for the rest of the message
+			r.partial = input[offset:]
+			break
+		} else if err != nil {
+			fmt.Fprintf(r.out, "Error: invalid request message: %v\n", err)
+			break
+		}
+		r.queued = append(r.queued, string(msg))
+	}
+	if r.partial != "" {
+		r.lines.setPrompt(streamContinuePrompt)
+	} else {
+		r.lines.setPrompt(streamPrompt)
+	}
+}
+
+// closeSend is called when the request stream is half-closed. Since responses
+// may still arrive, input is still read in the background, so the RPC can
+// be cancelled.
+func (r *interactiveRequests) closeSend() {
+	r.lines.setPrompt(streamClosedPrompt)
+	r.wg.Add(1)
+	go func() {
+		defer r.wg.Done()
+		for {
+			line, err := r.lines.next(r.done)
+			if err == errInterrupted || (err == nil && strings.TrimSpace(line) == "/cancel") {
+				r.cancel()
+				return
+			} else if err != nil {
+				// input exhausted or we're done waiting for it
+				return
+			}
+			if strings.TrimSpace(line) != "" {
+				fmt.Fprintln(r.out, "Request stream is closed; enter '/cancel' to cancel the RPC.")
+			}
+		}
+	}()
+}
+
+// streamEndHandler is an event handler that calls a function when the RPC
+// completes and then delegates to another handler.
+type streamEndHandler struct {
+	grpcurl.InvocationEventHandler
+	onEnd func()
+}
+
+func (h *streamEndHandler) OnSendRequest(req proto.Message, sentAt time.Time) {
+	if rh, ok := h.InvocationEventHandler.(grpcurl.RequestEventHandler); ok {
+		rh.OnSendRequest(req, sentAt)
+	}
+}
+
+func (h *streamEndHandler) OnCloseSend(closedAt time.Time) {
+	if rh, ok := h.InvocationEventHandler.(grpcurl.RequestEventHandler); ok {
+		rh.OnCloseSend(closedAt)
+	}
+}
+
+func (h *streamEndHandler) OnReceivePeer(p *peer.Peer) {
+	if ph, ok := h.InvocationEventHandler.(grpcurl.PeerEventHandler); ok {
+		ph.OnReceivePeer(p)
+	}
+}
+
+func (h *streamEndHandler) OnReceiveTrailers(stat *status.Status, md metadata.MD) {
+	h.InvocationEventHandler.OnReceiveTrailers(stat, md)
+	h.onEnd()
+}
+
+// findMethod returns the descriptor for the given method, which may be in
+// 'service/method' or 'service.method' format.
+func findMethod(descSource grpcurl.DescriptorSource, name string) (*desc.MethodDescriptor, error) {
+	if pos := strings.LastIndex(name, "/"); pos >= 0 {
+		name = name[:pos] + "." + name[pos+1:]
+	}
+	d, err := descSource.FindSymbol(strings.TrimPrefix(name, "."))
+	if err != nil {
+		return nil, err
+	}
+	md, ok := d.(*desc.MethodDescriptor)
+	if !ok {
+		return nil, fmt.Errorf("%s is not a method", name)
+	}
+	return md, nil
+}
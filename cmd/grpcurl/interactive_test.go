@@ -0,0 +1,168 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"testing"
+
+	"github.com/jhump/protoreflect/dynamic"
+
+	"github.com/tetrateio/grpcurl"
+)
+
+func TestInteractiveRequests(t *testing.T) {
+	source, err := grpcurl.DescriptorSourceFromProtoSets("../../internal/testing/test.protoset")
+	if err != nil {
+		t.Fatalf("failed to create descriptor source: %v", err)
+	}
+	md, err := findMethod(source, "testing.TestService/FullDuplexCall")
+	if err != nil {
+		t.Fatalf("failed to find method: %v", err)
+	}
+	if !md.IsClientStreaming() {
+		t.Fatalf("method should be client-streaming")
+	}
+	if _, err := findMethod(source, "testing.Payload"); err == nil {
+		t.Errorf("expected error finding method that is a message")
+	}
+
+	testCases := []struct {
+		name     string
+		format   grpcurl.Format
+		input    string
+		expected []string
+		errs     string
+		canceled bool
+		expand   bool
+	}{
+		{
+			name:   "json",
+			format: grpcurl.FormatJSON,
+			input: `{"responseParameters": [{"size": 1}]}
+{"responseParameters":
+  [{"size": 2}]} {"responseParameters": [{"size": 3}]}
+nope
+{"nope": 1}
+{"responseParameters": [{"size": 4}]}
+/close
+`,
+			expected: []string{
+				`response_parameters:<size:1>`,
+				`response_parameters:<size:2>`,
+				`response_parameters:<size:3>`,
+				`response_parameters:<size:4>`,
+			},
+			errs: "Error: invalid request message: invalid character 'o' in literal null (expecting 'u')\n" +
+				"Error: invalid request message: message type testing.StreamingOutputCallRequest has no known field named nope\n",
+		},
+		{
+			name:   "text",
+			format: grpcurl.FormatText,
+			input: `response_parameters: {size: 1}
+
+nope: 1
+response_parameters: {size: 2}
+`,
+			expected: []string{
+				`response_parameters:<size:1>`,
+				`response_parameters:<size:2>`,
+			},
+			errs: "Error: invalid request message: line 1, col 1: \"nope\" is not a recognized field name of \"testing.StreamingOutputCallRequest\"\n",
+		},
+		{
+			name:   "expand",
+			format: grpcurl.FormatJSON,
+			input: `{"responseParameters": [{"size": ${seq()}}]}
+{"responseParameters": [{"size": ${size}}]} {"responseParameters": [{"size": ${seq()}}]}
+{"responseParameters": [{"size": ${undefined_size}}]}
+/close
+`,
+			expected: []string{
+				`response_parameters:<size:1>`,
+				`response_parameters:<size:5>`,
+				`response_parameters:<size:2>`,
+			},
+			errs:   "Error: variable \"undefined_size\" is not defined and there is no environment variable with that name\n",
+			expand: true,
+		},
+		{
+			name:     "expand yaml",
+			format:   grpcurl.FormatYAML,
+			input:    "{responseParameters: [{size: \"${size}\"}]}\nresponseParameters: [{size: \"${seq()}\"}]\n/close\n",
+			expected: []string{`response_parameters:<size:5>`, `response_parameters:<size:1>`},
+			expand:   true,
+		},
+		{
+			name:     "cancel",
+			format:   grpcurl.FormatJSON,
+			input:    "{}\n/cancel\n",
+			expected: []string{``},
+			canceled: true,
+		},
+		{
+			name:     "cancel after close",
+			format:   grpcurl.FormatYAML,
+			input:    "{responseParameters: [{size: 1}]}\n/close\noops\n/cancel\n",
+			expected: []string{`response_parameters:<size:1>`},
+			errs:     "Request stream is closed; enter '/cancel' to cancel the RPC.\n",
+			canceled: true,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			r, w, err := os.Pipe()
+			if err != nil {
+				t.Fatalf("failed to create pipe: %v", err)
+			}
+			defer r.Close()
+			go func() {
+				_, _ = io.WriteString(w, tc.input)
+				_ = w.Close()
+			}()
+
+			var errOut bytes.Buffer
+			canceled := make(chan struct{})
+			lines := newLineReader(r, io.Discard, streamPrompt)
+			ir := newInteractiveRequests(lines, &errOut, tc.format, source, grpcurl.FormatOptions{}, func() {
+				close(canceled)
+			})
+			if tc.expand {
+				tmpl := grpcurl.NewRequestTemplate(map[string]string{"size": "5"})
+				ir.expand = func(line string) (string, error) {
+					return tmpl.ExpandData(line, tc.format)
+				}
+			}
+
+			var actual []string
+			for {
+				m := dynamic.NewMessage(md.GetInputType())
+				if err := ir.Next(m); err == io.EOF {
+					break
+				} else if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				actual = append(actual, m.String())
+			}
+			if tc.canceled {
+				<-canceled
+			}
+			ir.finish()
+
+			if len(actual) != len(tc.expected) {
+				t.Fatalf("wrong requests: expected %q, got %q", tc.expected, actual)
+			}
+			for i := range actual {
+				if actual[i] != tc.expected[i] {
+					t.Errorf("wrong request %d: expected %q, got %q", i, tc.expected[i], actual[i])
+				}
+			}
+			if ir.NumRequests() != len(tc.expected) {
+				t.Errorf("wrong request count: expected %d, got %d", len(tc.expected), ir.NumRequests())
+			}
+			if errOut.String() != tc.errs {
+				t.Errorf("wrong error output:\nexpected:\n%s\ngot:\n%s", tc.errs, errOut.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"gopkg.in/yaml.v3"
+)
+
+// profileAddressKey is the profile setting for the server address. All
+// other settings in a profile are named after command-line flags.
+const profileAddressKey = "address"
+
+// nonProfileFlags are flags that cannot be set in a profile.
+var nonProfileFlags = map[string]bool{
+	"help":    true,
+	"version": true,
+	"profile": true,
+	"config":  true,
+}
+
+// profileFlagGroups are sets of related flags. If any flag in a group is set on
+// the command line, the profile's settings for all flags in the group are
+// overridden, so that the command line can, for example, use -protoset files
+// even if the profile uses -proto files.
+var profileFlagGroups = [][]string{
+	{"proto", "protoset", "import-path", "use-reflection"},
+	{"plaintext", "insecure", "cacert", "cert", "key", "servername", "authority"},
+}
+
+// profileConfig is the contents of a config file, which defines named
+// profiles. For example:
+//
+//	profiles:
+//	  staging:
+//	    address: api.staging.example.com:443
+//	    cacert: /etc/certs/staging-ca.pem
+//	    H:
+//	      - "x-env: staging"
+//	    import-path: [./protos]
+//	    proto: [service.proto]
+type profileConfig struct {
+	Profiles map[string]map[string]interface{} `yaml:"profiles"`
+}
+
+// profileSetting is a single setting from a profile.
+type profileSetting struct {
+	name   string
+	values []string
+	// overridden is true if the setting was not applied because its flag was
+	// set on the command line.
+	overridden bool
+}
+
+// profile is a named collection of settings, read from a config file.
+type profile struct {
+	name, path string
+	address    string
+	settings   []profileSetting
+}
+
+// defaultConfigFile returns the path of the config file that is used when
+// no -config flag is given.
+func defaultConfigFile() string {
+	dir := os.Getenv("XDG_CONFIG_HOME")
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return ""
+		}
+		dir = filepath.Join(home, ".config")
+	}
+	return filepath.Join(dir, "grpcurl", "config.yaml")
+}
+
+// loadProfile reads the named profile from the given config file. Settings
+// are validated against the flags in the given flag set.
+func loadProfile(fs *flag.FlagSet, path, name string) (*profile, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var config profileConfig
+	if err := yaml.Unmarshal(data, &config); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %v", path, err)
+	}
+	values, ok := config.Profiles[name]
+	if !ok {
+		return nil, fmt.Errorf("no profile named %q in %s", name, path)
+	}
+
+	p := &profile{name: name, path: path}
+	keys := make([]string, 0, len(values))
+	for k := range values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		if k == profileAddressKey {
+			addr, ok := values[k].(string)
+			if !ok {
+				return nil, fmt.Errorf("profile %q: %s must be a string", name, k)
+			}
+			p.address = addr
+			continue
+		}
+		if fs.Lookup(k) == nil || nonProfileFlags[k] {
+			return nil, fmt.Errorf("profile %q: unknown setting %q", name, k)
+		}
+		var vals []string
+		switch v := values[k].(type) {
+		case []interface{}:
+			for _, e := range v {
+				vals = append(vals, fmt.Sprint(e))
+			}
+		case map[string]interface{}:
+			return nil, fmt.Errorf("profile %q: %s must be a value or a list of values", name, k)
+		case nil:
+			return nil, fmt.Errorf("profile %q: %s has no value", name, k)
+		default:
+			vals = []string{fmt.Sprint(v)}
+		}
+		p.settings = append(p.settings, profileSetting{name: k, values: vals})
+	}
+	return p, nil
+}
+
+// apply sets the flags in the given flag set from the profile's settings,
+// except for flags that were already set on the command line.
+func (p *profile) apply(fs *flag.FlagSet) error {
+	explicit := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) {
+		explicit[f.Name] = true
+	})
+	for _, group := range profileFlagGroups {
+		for _, name := range group {
+			if explicit[name] {
+				for _, n := range group {
+					explicit[n] = true
+				}
+				break
+			}
+		}
+	}
+	for i := range p.settings {
+		s := &p.settings[i]
+		if explicit[s.name] {
+			s.overridden = true
+			continue
+		}
+		for _, v := range s.values {
+			if err := fs.Set(s.name, v); err != nil {
+				return fmt.Errorf("profile %q: invalid value %q for %s: %v", p.name, v, s.name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// applied returns true if the given flag was set from the profile. It returns
+// false if p is nil.
+func (p *profile) applied(name string) bool {
+	if p == nil {
+		return false
+	}
+	for _, s := range p.settings {
+		if s.name == name {
+			return !s.overridden
+		}
+	}
+	return false
+}
+
+// print describes the profile's settings, including which were overridden.
+// The address is shown as overridden if addressGiven is true.
+func (p *profile) print(w io.Writer, addressGiven bool) {
+	fmt.Fprintf(w, "Using profile %q from %s:\n", p.name, p.path)
+	if p.address != "" {
+		fmt.Fprintf(w, "  %s: %s%s\n", profileAddressKey, p.address, overriddenSuffix(addressGiven))
+	}
+	for _, s := range p.settings {
+		for _, v := range s.values {
+			fmt.Fprintf(w, "  %s: %s%s\n", s.name, v, overriddenSuffix(s.overridden))
+		}
+	}
+}
+
+func overriddenSuffix(overridden bool) string {
+	if overridden {
+		return " (overridden by command-line argument)"
+	}
+	return ""
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const testConfig = `
+profiles:
+  staging:
+    address: staging.example.com:443
+    cacert: /etc/certs/ca.pem
+    H:
+      - "x-env: staging"
+      - "x-team: payments"
+    format: yaml
+    max-time: 2.5
+    proto: [service.proto]
+    import-path: [./protos]
+  bad-setting:
+    nope: 1
+  bad-value:
+    max-time: abc
+  bad-address:
+    address: [a, b]
+`
+
+func newTestFlags() (*flag.FlagSet, map[string]interface{}) {
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	var headers, protos, importPaths, protosets multiString
+	fs.Var(&headers, "H", "")
+	fs.Var(&protos, "proto", "")
+	fs.Var(&importPaths, "import-path", "")
+	fs.Var(&protosets, "protoset", "")
+	vals := map[string]interface{}{
+		"H":           &headers,
+		"proto":       &protos,
+		"import-path": &importPaths,
+		"protoset":    &protosets,
+		"cacert":      fs.String("cacert", "", ""),
+		"format":      fs.String("format", "json", ""),
+		"max-time":    fs.Float64("max-time", 0, ""),
+		"plaintext":   fs.Bool("plaintext", false, ""),
+		"profile":     fs.String("profile", "", ""),
+	}
+	return fs, vals
+}
+
+func TestProfile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(testConfig), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	fs, vals := newTestFlags()
+	if err := fs.Parse([]string{"-profile", "staging", "-format", "text", "-protoset", "x.protoset", "-plaintext", "list"}); err != nil {
+		t.Fatal(err)
+	}
+	p, err := loadProfile(fs, path, "staging")
+	if err != nil {
+		t.Fatalf("failed to load profile: %v", err)
+	}
+	if err := p.apply(fs); err != nil {
+		t.Fatalf("failed to apply profile: %v", err)
+	}
+
+	if p.address != "staging.example.com:443" {
+		t.Errorf("wrong address: %q", p.address)
+	}
+	// headers are applied
+	if h := *vals["H"].(*multiString); !reflect.DeepEqual([]string(h), []string{"x-env: staging", "x-team: payments"}) {
+		t.Errorf("wrong headers: %v", h)
+	}
+	if mt := *vals["max-time"].(*float64); mt != 2.5 {
+		t.Errorf("wrong max time: %v", mt)
+	}
+	// -plaintext on the command line overrides all TLS settings
+	if cacert := *vals["cacert"].(*string); cacert != "" {
+		t.Errorf("cacert should not have been applied: %q", cacert)
+	}
+	// command-line value overrides the profile value
+	if f := *vals["format"].(*string); f != "text" {
+		t.Errorf("wrong format: %q", f)
+	}
+	// -protoset on the command line overrides all descriptor source settings
+	if protos := *vals["proto"].(*multiString); len(protos) != 0 {
+		t.Errorf("proto should not have been applied: %v", protos)
+	}
+	if p.applied("format") || !p.applied("H") || p.applied("nope") {
+		t.Errorf("wrong applied settings: %+v", p.settings)
+	}
+
+	var out strings.Builder
+	p.print(&out, false)
+	expected := `Using profile "staging" from ` + path + `:
+  address: staging.example.com:443
+  H: x-env: staging
+  H: x-team: payments
+  cacert: /etc/certs/ca.pem (overridden by command-line argument)
+  format: yaml (overridden by command-line argument)
+  import-path: ./protos (overridden by command-line argument)
+  max-time: 2.5
+  proto: service.proto (overridden by command-line argument)
+`
+	if out.String() != expected {
+		t.Errorf("wrong output:\nexpected:\n%s\ngot:\n%s", expected, out.String())
+	}
+
+	testCases := map[string]string{
+		"nope":        `no profile named "nope"`,
+		"bad-setting": `unknown setting "nope"`,
+		"bad-address": `address must be a string`,
+	}
+	for name, expectedErr := range testCases {
+		fs, _ := newTestFlags()
+		_, err := loadProfile(fs, path, name)
+		if err == nil || !strings.Contains(err.Error(), expectedErr) {
+			t.Errorf("%s: expected error containing %q, got %v", name, expectedErr, err)
+		}
+	}
+
+	fs, _ = newTestFlags()
+	p, err = loadProfile(fs, path, "bad-value")
+	if err != nil {
+		t.Fatalf("failed to load profile: %v", err)
+	}
+	if err := p.apply(fs); err == nil || !strings.Contains(err.Error(), `invalid value "abc" for max-time`) {
+		t.Errorf("expected error for invalid value, got %v", err)
+	}
+	fs, _ = newTestFlags()
+	if _, err := loadProfile(fs, filepath.Join(t.TempDir(), "missing.yaml"), "staging"); err == nil {
+		t.Errorf("expected error for missing config file")
+	}
+}
@@ -0,0 +1,25 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// logCalls is a stream interceptor, used by the 'serve' verb in verbose mode,
+// that writes a line to stderr for every call that the server handles.
+func logCalls(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	start := time.Now()
+	err := handler(srv, ss)
+	client := "unknown"
+	if p, ok := peer.FromContext(ss.Context()); ok {
+		client = p.Addr.String()
+	}
+	stat, _ := status.FromError(err)
+	fmt.Fprintf(os.Stderr, "%s %s from %s: %s (%v)\n", time.Now().Format(time.RFC3339), info.FullMethod, client, stat.Code(), time.Since(start).Round(time.Microsecond))
+	return err
+}
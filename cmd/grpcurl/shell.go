@@ -0,0 +1,513 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jhump/protoreflect/desc"
+	"golang.org/x/term"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/tetrateio/grpcurl"
+)
+
+const shellPrompt = "grpcurl> "
+
+var shellCommands = []string{"call", "describe", "exit", "headers", "help", "history", "ls", "set", "unset"}
+
+const shellHelp = `Commands:
+  ls [service]              List services, or the methods of the given service.
+  describe symbol           Describe the given service, method, message, field,
+                            or enum, and show a template for messages.
+  call method [data]        Invoke the given method. The data is the request
+                            body, in the session's format. If no data is given,
+                            an empty request is sent, except for client and
+                            bidi streams, whose requests are then entered one
+                            at a time and sent as soon as each is complete.
+  set header name: value    Set a header that is sent with every call, replacing
+                            any other values for the same name.
+  unset header name         Remove a header.
+  headers                   Show the headers that are sent with every call.
+  set format json|text|yaml Set the format of request data and responses.
+  history                   Show the commands entered in this session.
+  help                      Show this help.
+  exit                      End the session (as does Ctrl-D).
+
+While entering requests for a stream, type '/close' (or press Ctrl-D) to close
+the request stream or '/cancel' (or press Ctrl-C) to cancel the call.
+
+Press Tab to complete commands and the names of services, methods, messages,
+and fields.
+`
+
+// shellSession is an interactive session, started with the 'shell' verb. It
+// keeps a single connection and descriptor source open across all commands,
+// so that the server is only dialed and queried for its schema once.
+type shellSession struct {
+	descSource grpcurl.DescriptorSource
+	// cc is nil when no address was given, in which case only commands that
+	// explore the descriptor source can be used
+	cc             *grpc.ClientConn
+	headers        []string
+	format         grpcurl.Format
+	emitDefaults   bool
+	allowUnknown   bool
+	verbosityLevel int
+	maxTime        time.Duration
+
+	out, errOut io.Writer
+	history     []string
+	// lines is nil until the session is run
+	lines *lineReader
+
+	// lazily computed candidates for tab completion
+	services []string
+	methods  []string
+	symbols  []string
+}
+
+// run reads and executes commands until input is exhausted or the user exits.
+// If in is a terminal, it is put into raw mode while reading each command so
+// that the command line can be edited and completed.
+func (sh *shellSession) run(in *os.File) error {
+	sh.lines = newLineReader(in, sh.out, shellPrompt)
+	if t := sh.lines.terminal; t != nil {
+		t.AutoCompleteCallback = func(line string, pos int, key rune) (string, int, bool) {
+			if key != '\t' {
+				return "", 0, false
+			}
+			return sh.complete(t, line, pos)
+		}
+		fmt.Fprintln(sh.out, "Type 'help' for a list of commands.")
+	}
+	for {
+		restore, err := sh.lines.makeRaw()
+		if err != nil {
+			return err
+		}
+		line, err := sh.lines.next(nil)
+		restore()
+		if err == io.EOF || err == errInterrupted {
+			if sh.lines.terminal != nil {
+				fmt.Fprintln(sh.out)
+			}
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if !sh.execute(line) {
+			return nil
+		}
+	}
+}
+
+// execute runs a single command. It returns false if the session should end.
+func (sh *shellSession) execute(line string) bool {
+	line = strings.TrimSpace(line)
+	if line == "" || strings.HasPrefix(line, "#") {
+		return true
+	}
+	sh.history = append(sh.history, line)
+
+	cmd, rest := splitWord(line)
+	var err error
+	switch cmd {
+	case "exit", "quit":
+		return false
+	case "help":
+		fmt.Fprint(sh.out, shellHelp)
+	case "history":
+		for i, h := range sh.history {
+			fmt.Fprintf(sh.out, "%4d  %s\n", i+1, h)
+		}
+	case "ls", "list":
+		err = sh.list(rest)
+	case "describe", "desc":
+		err = sh.describe(rest)
+	case "call":
+		err = sh.call(rest)
+	case "headers":
+		for _, h := range sh.headers {
+			fmt.Fprintln(sh.out, h)
+		}
+	case "set":
+		err = sh.set(rest)
+	case "unset":
+		err = sh.unset(rest)
+	default:
+		err = fmt.Errorf("unknown command %q; type 'help' for a list of commands", cmd)
+	}
+	if err != nil {
+		fmt.Fprintf(sh.errOut, "Error: %v\n", err)
+	}
+	return true
+}
+
+func (sh *shellSession) list(args string) error {
+	if args == "" {
+		svcs, err := grpcurl.ListServices(sh.descSource)
+		if err != nil {
+			return err
+		}
+		if len(svcs) == 0 {
+			fmt.Fprintln(sh.out, "(No services)")
+		}
+		for _, svc := range svcs {
+			fmt.Fprintln(sh.out, svc)
+		}
+		return nil
+	}
+	methods, err := grpcurl.ListMethods(sh.descSource, strings.TrimPrefix(args, "."))
+	if err != nil {
+		return err
+	}
+	if len(methods) == 0 {
+		fmt.Fprintln(sh.out, "(No methods)")
+	}
+	for _, m := range methods {
+		fmt.Fprintln(sh.out, m)
+	}
+	return nil
+}
+
+func (sh *shellSession) describe(args string) error {
+	if args == "" {
+		return errors.New("describe requires a symbol")
+	}
+	dsc, err := sh.descSource.FindSymbol(strings.TrimPrefix(args, "."))
+	if err != nil {
+		return err
+	}
+	fqn := dsc.GetFullyQualifiedName()
+	dsc, elementType, err := descriptorToDescribe(dsc)
+	if err != nil {
+		return err
+	}
+	txt, err := grpcurl.GetDescriptorText(dsc, sh.descSource)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(sh.out, "%s is %s:\n", fqn, elementType)
+	fmt.Fprintln(sh.out, txt)
+
+	if md, ok := dsc.(*desc.MessageDescriptor); ok {
+		options := grpcurl.FormatOptions{EmitJSONDefaultFields: true}
+		_, formatter, err := grpcurl.RequestParserAndFormatter(sh.format, sh.descSource, strings.NewReader(""), options)
+		if err != nil {
+			return err
+		}
+		str, err := formatter(grpcurl.MakeTemplate(md))
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(sh.out, "\nMessage template:")
+		fmt.Fprintln(sh.out, str)
+	}
+	return nil
+}
+
+func (sh *shellSession) call(args string) error {
+	if sh.cc == nil {
+		return errors.New("cannot invoke methods without a server address")
+	}
+	method, data := splitWord(args)
+	if method == "" {
+		return errors.New("call requires a method name")
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer cancel()
+	if sh.maxTime > 0 {
+		var cancelTimeout context.CancelFunc
+		ctx, cancelTimeout = context.WithTimeout(ctx, sh.maxTime)
+		defer cancelTimeout()
+	}
+
+	options := grpcurl.FormatOptions{
+		EmitJSONDefaultFields: sh.emitDefaults,
+		IncludeTextSeparator:  sh.verbosityLevel == 0,
+		AllowUnknownFields:    sh.allowUnknown,
+	}
+	rf, formatter, err := grpcurl.RequestParserAndFormatter(sh.format, sh.descSource, strings.NewReader(data), options)
+	if err != nil {
+		return err
+	}
+	// requests have their own formatter, so they don't change how responses
+	// are separated
+	_, reqFormatter, err := grpcurl.RequestParserAndFormatter(sh.format, sh.descSource, strings.NewReader(""), grpcurl.FormatOptions{EmitJSONDefaultFields: sh.emitDefaults})
+	if err != nil {
+		return err
+	}
+	h := &grpcurl.DefaultEventHandler{
+		Out:              sh.out,
+		Formatter:        formatter,
+		RequestFormatter: reqFormatter,
+		VerbosityLevel:   sh.verbosityLevel,
+	}
+	var handler grpcurl.InvocationEventHandler = h
+	requestData := rf.Next
+
+	// With no data, streaming requests are entered interactively.
+	var ir *interactiveRequests
+	restore := func() {}
+	if data == "" && sh.lines != nil {
+		if md, err := findMethod(sh.descSource, method); err == nil && md.IsClientStreaming() {
+			ir = newInteractiveRequests(sh.lines, sh.lines.output(sh.errOut), sh.format, sh.descSource, options, cancel)
+			h.Out = sh.lines.output(sh.out)
+			handler = ir.handler(h)
+			requestData = ir.Next
+			if restore, err = ir.begin(); err != nil {
+				return err
+			}
+		}
+	}
+
+	err = grpcurl.InvokeRPC(ctx, sh.descSource, sh.cc, method, sh.headers, handler, requestData)
+	if ir != nil {
+		ir.finish()
+		restore()
+		sh.lines.setPrompt(shellPrompt)
+	}
+	if err != nil {
+		if errStatus, ok := status.FromError(err); ok {
+			h.Status = errStatus
+		} else {
+			return err
+		}
+	}
+	if h.Status.Code() != codes.OK {
+		grpcurl.PrintStatus(sh.errOut, h.Status, formatter)
+	}
+	return nil
+}
+
+func (sh *shellSession) set(args string) error {
+	what, val := splitWord(args)
+	switch what {
+	case "header":
+		name, _ := splitHeader(val)
+		if name == "" {
+			return errors.New("set header requires a header in 'name: value' format")
+		}
+		sh.removeHeader(name)
+		sh.headers = append(sh.headers, val)
+	case "format":
+		switch grpcurl.Format(val) {
+		case grpcurl.FormatJSON, grpcurl.FormatText, grpcurl.FormatYAML:
+			sh.format = grpcurl.Format(val)
+		default:
+			return errors.New("format must be 'json', 'text' or 'yaml'")
+		}
+	default:
+		return fmt.Errorf("cannot set %q; can only set 'header' or 'format'", what)
+	}
+	return nil
+}
+
+func (sh *shellSession) unset(args string) error {
+	what, name := splitWord(args)
+	if what != "header" {
+		return fmt.Errorf("cannot unset %q; can only unset 'header'", what)
+	}
+	if name == "" {
+		return errors.New("unset header requires a header name")
+	}
+	if !sh.removeHeader(name) {
+		return fmt.Errorf("no header named %q", name)
+	}
+	return nil
+}
+
+// removeHeader removes all headers with the given name, returning true if
+// any were removed.
+func (sh *shellSession) removeHeader(name string) bool {
+	found := false
+	headers := sh.headers[:0]
+	for _, h := range sh.headers {
+		if n, _ := splitHeader(h); strings.EqualFold(n, name) {
+			found = true
+			continue
+		}
+		headers = append(headers, h)
+	}
+	sh.headers = headers
+	return found
+}
+
+// complete is the tab completion callback. If there is only one candidate for
+// the word before the cursor, it is filled in. If there are several, their
+// common prefix is filled in and, if that doesn't change the line, all of
+// the candidates are printed.
+func (sh *shellSession) complete(t *term.Terminal, line string, pos int) (string, int, bool) {
+	start, candidates := sh.completions(line[:pos])
+	if len(candidates) == 0 {
+		return "", 0, false
+	}
+	word := line[start:pos]
+	completion := candidates[0]
+	if len(candidates) == 1 {
+		completion += " "
+	} else {
+		for _, c := range candidates[1:] {
+			completion = commonPrefix(completion, c)
+		}
+		if completion == word {
+			fmt.Fprintln(t, strings.Join(candidates, "  "))
+			return "", 0, false
+		}
+	}
+	return line[:start] + completion + line[pos:], start + len(completion), true
+}
+
+// completions returns the candidates for completing the last word in the
+// given line, along with the position in the line where that word starts.
+func (sh *shellSession) completions(line string) (int, []string) {
+	start := strings.LastIndexAny(line, " \t") + 1
+	word := line[start:]
+	fields := strings.Fields(line[:start])
+
+	var options []string
+	switch {
+	case len(fields) == 0:
+		options = shellCommands
+	case len(fields) == 1:
+		switch fields[0] {
+		case "ls", "list":
+			options = sh.completeServices()
+		case "describe", "desc":
+			options = sh.completeSymbols()
+		case "call":
+			options = sh.completeMethods()
+		case "set":
+			options = []string{"format", "header"}
+		case "unset":
+			options = []string{"header"}
+		}
+	case len(fields) == 2:
+		switch {
+		case fields[0] == "set" && fields[1] == "format":
+			options = []string{"json", "text", "yaml"}
+		case fields[0] == "unset" && fields[1] == "header":
+			for _, h := range sh.headers {
+				if name, _ := splitHeader(h); name != "" {
+					options = append(options, name)
+				}
+			}
+		}
+	}
+
+	var candidates []string
+	for _, o := range options {
+		if strings.HasPrefix(o, word) {
+			candidates = append(candidates, o)
+		}
+	}
+	return start, candidates
+}
+
+func (sh *shellSession) completeServices() []string {
+	if sh.services == nil {
+		sh.loadSymbols()
+	}
+	return sh.services
+}
+
+func (sh *shellSession) completeMethods() []string {
+	if sh.services == nil {
+		sh.loadSymbols()
+	}
+	return sh.methods
+}
+
+func (sh *shellSession) completeSymbols() []string {
+	if sh.services == nil {
+		sh.loadSymbols()
+	}
+	return sh.symbols
+}
+
+// loadSymbols computes the names that can be completed: all services and
+// their methods, and all messages, enums, and fields that are reachable from
+// the methods' request and response types.
+func (sh *shellSession) loadSymbols() {
+	sh.services = []string{}
+	sh.methods = nil
+	sh.symbols = nil
+	svcs, err := sh.descSource.ListServices()
+	if err != nil {
+		return
+	}
+	seen := map[string]bool{}
+	var addMessage func(md *desc.MessageDescriptor)
+	addMessage = func(md *desc.MessageDescriptor) {
+		if seen[md.GetFullyQualifiedName()] {
+			return
+		}
+		seen[md.GetFullyQualifiedName()] = true
+		if !md.IsMapEntry() {
+			sh.symbols = append(sh.symbols, md.GetFullyQualifiedName())
+		}
+		for _, fd := range md.GetFields() {
+			sh.symbols = append(sh.symbols, fd.GetFullyQualifiedName())
+			if fmd := fd.GetMessageType(); fmd != nil {
+				addMessage(fmd)
+			} else if ed := fd.GetEnumType(); ed != nil && !seen[ed.GetFullyQualifiedName()] {
+				seen[ed.GetFullyQualifiedName()] = true
+				sh.symbols = append(sh.symbols, ed.GetFullyQualifiedName())
+			}
+		}
+	}
+	for _, svc := range svcs {
+		sh.services = append(sh.services, svc)
+		sh.symbols = append(sh.symbols, svc)
+		d, err := sh.descSource.FindSymbol(svc)
+		if err != nil {
+			continue
+		}
+		sd, ok := d.(*desc.ServiceDescriptor)
+		if !ok {
+			continue
+		}
+		for _, md := range sd.GetMethods() {
+			sh.methods = append(sh.methods, svc+"/"+md.GetName())
+			sh.symbols = append(sh.symbols, md.GetFullyQualifiedName())
+			addMessage(md.GetInputType())
+			addMessage(md.GetOutputType())
+		}
+	}
+	sort.Strings(sh.services)
+	sort.Strings(sh.methods)
+	sort.Strings(sh.symbols)
+}
+
+// splitWord splits the given string into its first word and the rest.
+func splitWord(s string) (string, string) {
+	s = strings.TrimSpace(s)
+	if i := strings.IndexAny(s, " \t"); i >= 0 {
+		return s[:i], strings.TrimSpace(s[i+1:])
+	}
+	return s, ""
+}
+
+// splitHeader returns the name and value of a header in 'name: value' format.
+func splitHeader(h string) (string, string) {
+	name, val, _ := strings.Cut(h, ":")
+	return strings.TrimSpace(name), strings.TrimSpace(val)
+}
+
+func commonPrefix(a, b string) string {
+	i := 0
+	for i < len(a) && i < len(b) && a[i] == b[i] {
+		i++
+	}
+	return a[:i]
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/tetrateio/grpcurl"
+)
+
+func newTestShell(t *testing.T) (*shellSession, *bytes.Buffer, *bytes.Buffer) {
+	source, err := grpcurl.DescriptorSourceFromProtoSets("../../internal/testing/test.protoset")
+	if err != nil {
+		t.Fatalf("failed to create descriptor source: %v", err)
+	}
+	var out, errOut bytes.Buffer
+	sh := &shellSession{
+		descSource: source,
+		headers:    []string{"foo: bar"},
+		format:     grpcurl.FormatJSON,
+		out:        &out,
+		errOut:     &errOut,
+	}
+	return sh, &out, &errOut
+}
+
+func TestShellCompletions(t *testing.T) {
+	sh, _, _ := newTestShell(t)
+	testCases := []struct {
+		line       string
+		start      int
+		candidates []string
+	}{
+		{"", 0, shellCommands},
+		{"h", 0, []string{"headers", "help", "history"}},
+		{"ls ", 3, []string{"testing.TestService", "testing.UnimplementedService"}},
+		{"call testing.TestService/S", 5, []string{"testing.TestService/StreamingInputCall", "testing.TestService/StreamingOutputCall"}},
+		{"describe testing.Payload.", 9, []string{"testing.Payload.body", "testing.Payload.type"}},
+		{"describe testing.PayloadT", 9, []string{"testing.PayloadType"}},
+		{"set f", 4, []string{"format"}},
+		{"set format ", 11, []string{"json", "text", "yaml"}},
+		{"unset header ", 13, []string{"foo"}},
+		{"call testing.TestService/UnaryCall {", 35, nil},
+	}
+	for _, tc := range testCases {
+		start, candidates := sh.completions(tc.line)
+		if start != tc.start || !reflect.DeepEqual(candidates, tc.candidates) {
+			t.Errorf("%q: expected %d, %v; got %d, %v", tc.line, tc.start, tc.candidates, start, candidates)
+		}
+	}
+}
+
+func TestShellCommands(t *testing.T) {
+	sh, out, errOut := newTestShell(t)
+	commands := []string{
+		"ls",
+		"describe testing.Payload",
+		"set header baz: buzz",
+		"set header foo: abc",
+		"unset header baz",
+		"unset header nope",
+		"headers",
+		"set format yaml",
+		"call testing.TestService/EmptyCall",
+		"bogus",
+	}
+	for _, cmd := range commands {
+		if !sh.execute(cmd) {
+			t.Fatalf("%q: unexpectedly ended session", cmd)
+		}
+	}
+	if sh.execute("exit") {
+		t.Errorf("'exit' should end session")
+	}
+
+	expectedOut := `testing.TestService
+testing.UnimplementedService
+testing.Payload is a message:
+message Payload {
+  .testing.PayloadType type = 1;
+  bytes body = 2;
+}
+
+Message template:
+{
+  "type": "COMPRESSABLE",
+  "body": ""
+}
+foo: abc
+`
+	if out.String() != expectedOut {
+		t.Errorf("wrong output:\nexpected:\n%s\ngot:\n%s", expectedOut, out.String())
+	}
+	expectedErrs := `Error: no header named "nope"
+Error: cannot invoke methods without a server address
+Error: unknown command "bogus"; type 'help' for a list of commands
+`
+	if errOut.String() != expectedErrs {
+		t.Errorf("wrong error output:\nexpected:\n%s\ngot:\n%s", expectedErrs, errOut.String())
+	}
+	if sh.format != grpcurl.FormatYAML {
+		t.Errorf("wrong format: expected yaml, got %s", sh.format)
+	}
+	if len(sh.history) != len(commands)+1 || !strings.HasPrefix(sh.history[0], "ls") {
+		t.Errorf("wrong history: %v", sh.history)
+	}
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc/status"
+
+	"github.com/tetrateio/grpcurl"
+)
+
+// printTiming describes the given timing of a connection and an RPC for
+// humans.
+func printTiming(w io.Writer, dt *grpcurl.DialTiming, ct *grpcurl.CallTiming) {
+	fmt.Fprintln(w, "\nTiming:")
+	for _, t := range []struct {
+		name  string
+		value time.Duration
+	}{
+		{"Name lookup", dt.NameLookup},
+		{"TCP connect", dt.Connect},
+		{"TLS handshake", dt.TLSHandshake},
+		{"Connection ready", dt.Total},
+		{"Response headers", ct.Headers},
+		{"First response", ct.FirstResponse},
+	} {
+		fmt.Fprintf(w, "  %-20s %s\n", t.name+":", formatDuration(t.value))
+	}
+	if len(ct.ResponseGaps) > 0 {
+		minGap, avgGap, maxGap := gapStats(ct.ResponseGaps)
+		fmt.Fprintf(w, "  %-20s min %s, avg %s, max %s\n", "Between responses:", formatDuration(minGap), formatDuration(avgGap), formatDuration(maxGap))
+	}
+	fmt.Fprintf(w, "  %-20s %s\n", "Total:", formatDuration(ct.Total))
+}
+
+func formatDuration(d time.Duration) string {
+	return formatMillis(float64(d) / float64(time.Millisecond))
+}
+
+// gapStats returns the minimum, mean, and maximum of the given durations,
+// which must not be empty.
+func gapStats(gaps []time.Duration) (min, avg, max time.Duration) {
+	min, max = gaps[0], gaps[0]
+	var sum time.Duration
+	for _, g := range gaps {
+		sum += g
+		if g < min {
+			min = g
+		}
+		if g > max {
+			max = g
+		}
+	}
+	return min, sum / time.Duration(len(gaps)), max
+}
+
+// writeOutVars returns the values of the variables that can be used in a -w
+// template. Times are in seconds, like curl's.
+func writeOutVars(dt *grpcurl.DialTiming, ct *grpcurl.CallTiming, stat *status.Status, numResponses int, stopped grpcurl.StreamStopReason) map[string]string {
+	seconds := func(d time.Duration) string {
+		return strconv.FormatFloat(d.Seconds(), 'f', 6, 64)
+	}
+	var avgGap, maxGap time.Duration
+	if len(ct.ResponseGaps) > 0 {
+		_, avgGap, maxGap = gapStats(ct.ResponseGaps)
+	}
+	return map[string]string{
+		"time_namelookup": seconds(dt.NameLookup),
+		"time_connect":    seconds(dt.Connect),
+		"time_tls":        seconds(dt.TLSHandshake),
+		"time_dial":       seconds(dt.Total),
+		"time_headers":    seconds(ct.Headers),
+		"time_firstmsg":   seconds(ct.FirstResponse),
+		"time_msggap_avg": seconds(avgGap),
+		"time_msggap_max": seconds(maxGap),
+		"time_total":      seconds(ct.Total),
+		"num_responses":   strconv.Itoa(numResponses),
+		"status":          stat.Code().String(),
+		"status_code":     strconv.Itoa(int(stat.Code())),
+		"status_message":  stat.Message(),
+		"stop_reason":     string(stopped),
+	}
+}
+
+// expandWriteOut expands the given -w template: each '%{name}' is replaced
+// with the value of the named variable, '%%' with '%', and the escapes '\n',
+// '\r', '\t', and '\\' with the characters they stand for.
+func expandWriteOut(tmpl string, vars map[string]string) (string, error) {
+	var sb strings.Builder
+	for i := 0; i < len(tmpl); i++ {
+		c := tmpl[i]
+		switch {
+		case c == '%' && strings.HasPrefix(tmpl[i:], "%%"):
+			sb.WriteByte('%')
+			i++
+		case c == '%' && strings.HasPrefix(tmpl[i:], "%{"):
+			end := strings.IndexByte(tmpl[i:], '}')
+			if end < 0 {
+				return "", fmt.Errorf("unterminated variable at offset %d", i)
+			}
+			name := tmpl[i+2 : i+end]
+			val, ok := vars[name]
+			if !ok {
+				return "", fmt.Errorf("unknown variable %q; known variables are %s", name, strings.Join(sortedKeys(vars), ", "))
+			}
+			sb.WriteString(val)
+			i += end
+		case c == '\\' && i+1 < len(tmpl):
+			switch tmpl[i+1] {
+			case 'n':
+				sb.WriteByte('\n')
+			case 'r':
+				sb.WriteByte('\r')
+			case 't':
+				sb.WriteByte('\t')
+			case '\\':
+				sb.WriteByte('\\')
+			default:
+				sb.WriteByte(c)
+				continue
+			}
+			i++
+		default:
+			sb.WriteByte(c)
+		}
+	}
+	return sb.String(), nil
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
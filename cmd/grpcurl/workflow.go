@@ -0,0 +1,97 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/tetrateio/grpcurl"
+	"google.golang.org/grpc/codes"
+)
+
+// printWorkflowStep describes the result of a workflow step for humans.
+func printWorkflowStep(w io.Writer, res *grpcurl.WorkflowStepResult, verbosityLevel int) {
+	fmt.Fprintf(w, "Step %d", res.Step)
+	if res.Name != "" {
+		fmt.Fprintf(w, " (%s)", res.Name)
+	}
+	fmt.Fprintf(w, ": %s\n", res.Method)
+	fmt.Fprintf(w, "  Status: %s", res.Code)
+	if res.Message != "" {
+		fmt.Fprintf(w, ": %s", res.Message)
+	}
+	fmt.Fprintf(w, " (%.2fms)\n", res.DurationMillis)
+	if res.Error != "" {
+		fmt.Fprintf(w, "  Error: %s\n", res.Error)
+	}
+	if verbosityLevel > 0 {
+		printWorkflowMetadata(w, "Response headers", res.Headers)
+	}
+	for _, resp := range res.Responses {
+		var buf bytes.Buffer
+		if err := json.Indent(&buf, resp, "  ", "  "); err != nil {
+			buf.Reset()
+			buf.Write(resp)
+		}
+		fmt.Fprintf(w, "  %s\n", buf.String())
+	}
+	if verbosityLevel > 0 {
+		printWorkflowMetadata(w, "Response trailers", res.Trailers)
+	}
+	if len(res.Failures) > 0 {
+		fmt.Fprintf(w, "  Expectations not met: %d\n", len(res.Failures))
+		for _, f := range res.Failures {
+			fmt.Fprintf(w, "    %s\n", f.Subject)
+			fmt.Fprintf(w, "      - expected: %s\n", f.Expected)
+			fmt.Fprintf(w, "      + actual:   %s\n", f.Actual)
+		}
+	}
+	if len(res.Captured) > 0 {
+		fmt.Fprintf(w, "  Captured:\n")
+		names := make([]string, 0, len(res.Captured))
+		for name := range res.Captured {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			fmt.Fprintf(w, "    %s = %s\n", name, res.Captured[name])
+		}
+	}
+}
+
+func printWorkflowMetadata(w io.Writer, title string, md map[string][]string) {
+	if len(md) == 0 {
+		return
+	}
+	fmt.Fprintf(w, "  %s:\n", title)
+	names := make([]string, 0, len(md))
+	for name := range md {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		for _, val := range md[name] {
+			fmt.Fprintf(w, "    %s: %s\n", name, val)
+		}
+	}
+}
+
+// workflowExitCode returns the exit code for a workflow that stopped at the
+// given step, which failed. It is 1 if the step could not be run or its values
+// could not be captured. Otherwise, if the step had no expectations, it is
+// based on the status code, like when invoking a method, or else it is the
+// code for unmet expectations.
+func workflowExitCode(step *grpcurl.WorkflowStep, res *grpcurl.WorkflowStepResult) int {
+	if res.Error != "" {
+		return 1
+	}
+	if step.Expect == nil && res.StatusCode() != codes.OK {
+		return statusCodeOffset + int(res.StatusCode())
+	}
+	if len(res.Failures) > 0 {
+		return expectationFailedCode
+	}
+	return 1
+}
@@ -0,0 +1,140 @@
+package grpcurl
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// LoadCRLs reads certificate revocation lists from the given path, which may
+// be a file or a directory. Each file may contain any number of PEM-encoded
+// CRLs or a single DER-encoded one. For a directory, every file in it (but not
+// in its sub-directories) is read, and files that contain no CRLs are ignored.
+func LoadCRLs(path string) ([]*x509.RevocationList, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not read CRLs: %v", err)
+	}
+	if !info.IsDir() {
+		crls, err := loadCRLFile(path)
+		if err != nil {
+			return nil, err
+		}
+		if len(crls) == 0 {
+			return nil, fmt.Errorf("no CRLs found in %s", path)
+		}
+		return crls, nil
+	}
+
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not read CRLs: %v", err)
+	}
+	var crls []*x509.RevocationList
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() {
+			continue
+		}
+		fileCRLs, err := loadCRLFile(filepath.Join(path, entry.Name()))
+		if err != nil {
+			var parseErr crlParseError
+			if errors.As(err, &parseErr) && !parseErr.pem {
+				// not a CRL at all, so skip it
+				continue
+			}
+			return nil, err
+		}
+		crls = append(crls, fileCRLs...)
+	}
+	if len(crls) == 0 {
+		return nil, fmt.Errorf("no CRLs found in directory %s", path)
+	}
+	return crls, nil
+}
+
+// crlParseError is returned when a file cannot be parsed as a CRL.
+type crlParseError struct {
+	file string
+	pem  bool
+	err  error
+}
+
+func (e crlParseError) Error() string {
+	return fmt.Sprintf("could not parse CRL in %s: %v", e.file, e.err)
+}
+
+func loadCRLFile(file string) ([]*x509.RevocationList, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("could not read CRL: %v", err)
+	}
+	if !bytes.Contains(data, []byte("-----BEGIN")) {
+		crl, err := x509.ParseRevocationList(data)
+		if err != nil {
+			return nil, crlParseError{file: file, err: err}
+		}
+		return []*x509.RevocationList{crl}, nil
+	}
+	var crls []*x509.RevocationList
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			return crls, nil
+		}
+		if block.Type != "X509 CRL" {
+			continue
+		}
+		crl, err := x509.ParseRevocationList(block.Bytes)
+		if err != nil {
+			return nil, crlParseError{file: file, pem: true, err: err}
+		}
+		crls = append(crls, crl)
+	}
+}
+
+// verifyNotRevoked returns a function, for use as a tls.Config's
+// VerifyPeerCertificate, that rejects a peer unless one of its verified chains
+// has no certificates that are revoked by the given CRLs. A certificate is
+// only checked against CRLs signed by its issuer. Certificates whose issuer
+// has no CRL are not considered revoked.
+func verifyNotRevoked(crls []*x509.RevocationList) func([][]byte, [][]*x509.Certificate) error {
+	return func(_ [][]byte, verifiedChains [][]*x509.Certificate) error {
+		var firstErr error
+		for _, chain := range verifiedChains {
+			err := checkChainRevocation(chain, crls)
+			if err == nil {
+				return nil
+			}
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+		return firstErr
+	}
+}
+
+func checkChainRevocation(chain []*x509.Certificate, crls []*x509.RevocationList) error {
+	// the last certificate in the chain is the root, which has no issuer
+	for i := 0; i < len(chain)-1; i++ {
+		cert, issuer := chain[i], chain[i+1]
+		for _, crl := range crls {
+			if !bytes.Equal(crl.RawIssuer, cert.RawIssuer) || crl.CheckSignatureFrom(issuer) != nil {
+				continue
+			}
+			//lint:ignore SA1019 RevokedCertificateEntries requires Go 1.21
+			for _, revoked := range crl.RevokedCertificates {
+				if revoked.SerialNumber.Cmp(cert.SerialNumber) == 0 {
+					return fmt.Errorf("certificate %q (serial number %X) was revoked by %q at %s",
+						cert.Subject, cert.SerialNumber, cert.Issuer, revoked.RevocationTime.UTC().Format(time.RFC3339))
+				}
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,185 @@
+package grpcurl_test
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/credentials"
+
+	. "github.com/tetrateio/grpcurl"
+)
+
+func TestLoadCRLs(t *testing.T) {
+	crls, err := LoadCRLs("internal/testing/tls/ca.crl")
+	if err != nil {
+		t.Fatalf("failed to load CRL file: %v", err)
+	}
+	if len(crls) != 1 || crls[0].Issuer.CommonName != "ca" {
+		t.Errorf("wrong CRLs loaded from file: %v", crls)
+	}
+
+	// the directory has other files, which are ignored
+	crls, err = LoadCRLs("internal/testing/tls")
+	if err != nil {
+		t.Fatalf("failed to load CRL directory: %v", err)
+	}
+	var issuers []string
+	for _, crl := range crls {
+		issuers = append(issuers, crl.Issuer.CommonName)
+	}
+	if strings.Join(issuers, ",") != "ca,wrong-ca" {
+		t.Errorf("wrong CRLs loaded from directory: %v", issuers)
+	}
+
+	// DER is also accepted
+	derFile := filepath.Join(t.TempDir(), "ca.der")
+	if err := os.WriteFile(derFile, crls[0].Raw, 0644); err != nil {
+		t.Fatalf("failed to write DER file: %v", err)
+	}
+	if crls, err := LoadCRLs(derFile); err != nil || len(crls) != 1 {
+		t.Errorf("failed to load DER file: %v, %v", crls, err)
+	}
+
+	if _, err := LoadCRLs("internal/testing/tls/ca.crt"); err == nil || !strings.Contains(err.Error(), "no CRLs found") {
+		t.Errorf("expecting error for file without CRLs, got %v", err)
+	}
+	if _, err := LoadCRLs("internal/testing/tls/nonexistent.crl"); err == nil {
+		t.Error("expecting error for nonexistent file")
+	}
+}
+
+func TestCRL_NoneRevoked(t *testing.T) {
+	serverCreds, err := ServerTransportCredentialsWithOptions("internal/testing/tls/ca.crt", "internal/testing/tls/server.crt", "internal/testing/tls/server.key", true, TLSOptions{CRLPath: "internal/testing/tls/ca.crl"})
+	if err != nil {
+		t.Fatalf("failed to create server creds: %v", err)
+	}
+	clientCreds := clientCredsWithCRL(t, "internal/testing/tls/client.crt", "internal/testing/tls/client.key", "internal/testing/tls/ca.crl")
+
+	e, err := createTestServerAndClient(serverCreds, clientCreds)
+	if err != nil {
+		t.Fatalf("failed to setup server and client: %v", err)
+	}
+	defer e.Close()
+
+	simpleTest(t, e.cc)
+}
+
+func TestCRL_ServerRevoked(t *testing.T) {
+	serverCreds, err := ServerTransportCredentials("", "internal/testing/tls/server.crt", "internal/testing/tls/server.key", false)
+	if err != nil {
+		t.Fatalf("failed to create server creds: %v", err)
+	}
+	clientCreds := clientCredsWithCRL(t, "", "", writeCRL(t, "internal/testing/tls/server.crt"))
+
+	e, err := createTestServerAndClient(serverCreds, clientCreds)
+	if err == nil {
+		e.Close()
+		t.Fatal("expecting TLS failure setting up server and client")
+	}
+	if !strings.Contains(err.Error(), `certificate "CN=server" (serial number 48276F848637299F70F5845C0C7D6847) was revoked by "CN=ca"`) {
+		t.Fatalf("expecting revoked certificate error, got: %v", err)
+	}
+}
+
+func TestCRL_ClientRevoked(t *testing.T) {
+	serverCreds, err := ServerTransportCredentialsWithOptions("internal/testing/tls/ca.crt", "internal/testing/tls/server.crt", "internal/testing/tls/server.key", true, TLSOptions{CRLPath: writeCRL(t, "internal/testing/tls/client.crt")})
+	if err != nil {
+		t.Fatalf("failed to create server creds: %v", err)
+	}
+	clientCreds := clientCredsWithCRL(t, "internal/testing/tls/client.crt", "internal/testing/tls/client.key", "")
+
+	e, err := createTestServerAndClient(serverCreds, clientCreds)
+	if err == nil {
+		e.Close()
+		t.Fatal("expecting TLS failure setting up server and client")
+	}
+	if !strings.Contains(err.Error(), "bad certificate") {
+		t.Fatalf("expecting TLS certificate error, got: %v", err)
+	}
+}
+
+func TestCRL_CheckTLS(t *testing.T) {
+	serverCreds, err := ServerTransportCredentials("", "internal/testing/tls/server.crt", "internal/testing/tls/server.key", false)
+	if err != nil {
+		t.Fatalf("failed to create server creds: %v", err)
+	}
+	e, err := createTestServerAndClient(serverCreds, clientCredsWithCRL(t, "", "", ""))
+	if err != nil {
+		t.Fatalf("failed to setup server and client: %v", err)
+	}
+	defer e.Close()
+
+	conf, err := ClientTLSConfigWithOptions(false, "internal/testing/tls/ca.crt", "", "", TLSOptions{CRLPath: writeCRL(t, "internal/testing/tls/server.crt")})
+	if err != nil {
+		t.Fatalf("failed to create TLS config: %v", err)
+	}
+	report, err := CheckTLS(context.Background(), "tcp", e.cc.Target(), conf)
+	if err != nil {
+		t.Fatalf("failed to check TLS: %v", err)
+	}
+	if report.VerifyError == nil || !strings.Contains(report.VerifyError.Error(), "was revoked") {
+		t.Errorf("expecting revoked certificate error, got: %v", report.VerifyError)
+	}
+}
+
+func clientCredsWithCRL(t *testing.T, certFile, keyFile, crlPath string) credentials.TransportCredentials {
+	conf, err := ClientTLSConfigWithOptions(false, "internal/testing/tls/ca.crt", certFile, keyFile, TLSOptions{CRLPath: crlPath})
+	if err != nil {
+		t.Fatalf("failed to create client TLS config: %v", err)
+	}
+	return credentials.NewTLS(conf)
+}
+
+// writeCRL writes a CRL, signed by the test CA, that revokes the given
+// certificates, and returns the name of the file.
+func writeCRL(t *testing.T, certFiles ...string) string {
+	ca, err := tls.LoadX509KeyPair("internal/testing/tls/ca.crt", "internal/testing/tls/ca.key")
+	if err != nil {
+		t.Fatalf("failed to load CA: %v", err)
+	}
+	caCert, err := x509.ParseCertificate(ca.Certificate[0])
+	if err != nil {
+		t.Fatalf("failed to parse CA certificate: %v", err)
+	}
+	now := time.Now()
+	tmpl := x509.RevocationList{
+		Number:     big.NewInt(1),
+		ThisUpdate: now,
+		NextUpdate: now.Add(time.Hour),
+	}
+	for _, f := range certFiles {
+		data, err := os.ReadFile(f)
+		if err != nil {
+			t.Fatalf("failed to read certificate: %v", err)
+		}
+		block, _ := pem.Decode(data)
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			t.Fatalf("failed to parse certificate: %v", err)
+		}
+		tmpl.RevokedCertificates = append(tmpl.RevokedCertificates, pkix.RevokedCertificate{
+			SerialNumber:   cert.SerialNumber,
+			RevocationTime: now,
+		})
+	}
+	der, err := x509.CreateRevocationList(rand.Reader, &tmpl, caCert, ca.PrivateKey.(crypto.Signer))
+	if err != nil {
+		t.Fatalf("failed to create CRL: %v", err)
+	}
+	file := filepath.Join(t.TempDir(), "revoked.crl")
+	if err := os.WriteFile(file, pem.EncodeToMemory(&pem.Block{Type: "X509 CRL", Bytes: der}), 0644); err != nil {
+		t.Fatalf("failed to write CRL: %v", err)
+	}
+	return file
+}
@@ -0,0 +1,304 @@
+package grpcurl
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/protobuf/proto" //lint:ignore SA1019 we have to import this because it appears in exported API
+	"github.com/jhump/protoreflect/desc"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+// descriptorCacheVersion is the version of the format of cache files. Files
+// with a different version are ignored.
+const descriptorCacheVersion = 1
+
+// DefaultDescriptorCacheDir returns the directory in which descriptor caches
+// are stored by default: a "grpcurl/descriptors" directory in the user's cache
+// directory.
+func DefaultDescriptorCacheDir() (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "grpcurl", "descriptors"), nil
+}
+
+// DescriptorCacheFile returns the path of the file, in the given directory, in
+// which descriptors for the server at the given target and authority are
+// cached. The authority may be empty if it is not overridden. Since a server
+// may expose different services depending on how it is asked, the path also
+// depends on whether the connection is in plaintext and on the headers, in
+// 'name: value' format, that are sent to the reflection service.
+func DescriptorCacheFile(dir, target, authority string, plaintext bool, headers []string) string {
+	security := "tls"
+	if plaintext {
+		security = "plaintext"
+	}
+	// the order of the headers doesn't change which services are exposed
+	sorted := append([]string(nil), headers...)
+	sort.Strings(sorted)
+	key := strings.Join(append([]string{target, authority, security}, sorted...), "\x00")
+	sum := sha256.Sum256([]byte(key))
+	return filepath.Join(dir, hex.EncodeToString(sum[:16])+".json")
+}
+
+// descriptorCache is the contents of a cache file.
+type descriptorCache struct {
+	Version int       `json:"version"`
+	Fetched time.Time `json:"fetched"`
+	// Services is nil if services have not been listed.
+	Services []string `json:"services,omitempty"`
+	// Extensions are the fully-qualified names of the extensions of each
+	// message type whose extensions have been fetched.
+	Extensions map[string][]string `json:"extensions,omitempty"`
+	// Files are serialized FileDescriptorProtos, with every file appearing
+	// after its dependencies.
+	Files [][]byte `json:"files"`
+}
+
+// CachedDescriptorSource returns a DescriptorSource that answers queries using
+// descriptors cached in the given file, which is usually created with
+// DescriptorCacheFile. Queries that cannot be answered from the cache are
+// passed to the given source, typically one created with
+// DescriptorSourceFromServer, and the results are added to the cache. This
+// avoids fetching the same descriptors from a server's reflection service on
+// every invocation.
+//
+// Changes on the server are noticed as soon as they affect a query: the list
+// of services is always fetched from the given source, and if it has changed,
+// or if a symbol that is not cached is found in a file that is, the cached
+// descriptors are out of date, so they are discarded and fetched again. The
+// cached descriptors are also discarded once they are older than the given
+// TTL, so that other changes on the server are eventually seen. A TTL of zero
+// means the descriptors never expire. If refresh is true, any cached
+// descriptors are discarded immediately.
+//
+// The cache is best effort: if the file cannot be read or written, the source
+// behaves as if the cache were empty.
+func CachedDescriptorSource(source DescriptorSource, path string, ttl time.Duration, refresh bool) DescriptorSource {
+	cs := &cachedSource{source: source, path: path}
+	if !refresh {
+		cs.load(ttl)
+	}
+	if cs.files == nil {
+		cs.reset()
+	}
+	return cs
+}
+
+type cachedSource struct {
+	source DescriptorSource
+	path   string
+
+	mu         sync.Mutex
+	fetched    time.Time
+	services   []string
+	extensions map[string][]string
+	files      map[string]*desc.FileDescriptor
+	// order is the names of files in files, in the order they are written
+	order []string
+}
+
+func (cs *cachedSource) reset() {
+	cs.fetched = time.Now()
+	cs.services = nil
+	cs.extensions = map[string][]string{}
+	cs.files = map[string]*desc.FileDescriptor{}
+	cs.order = nil
+}
+
+func (cs *cachedSource) load(ttl time.Duration) {
+	data, err := os.ReadFile(cs.path)
+	if err != nil {
+		return
+	}
+	var c descriptorCache
+	if err := json.Unmarshal(data, &c); err != nil || c.Version != descriptorCacheVersion {
+		return
+	}
+	if ttl > 0 && time.Since(c.Fetched) > ttl {
+		return
+	}
+	unresolved := map[string]*descriptorpb.FileDescriptorProto{}
+	var order []string
+	for _, b := range c.Files {
+		var fd descriptorpb.FileDescriptorProto
+		if err := proto.Unmarshal(b, &fd); err != nil {
+			return
+		}
+		unresolved[fd.GetName()] = &fd
+		order = append(order, fd.GetName())
+	}
+	resolved := map[string]*desc.FileDescriptor{}
+	for _, name := range order {
+		if _, err := resolveFileDescriptor(unresolved, resolved, name); err != nil {
+			return
+		}
+	}
+	cs.fetched = c.Fetched
+	cs.services = c.Services
+	cs.extensions = c.Extensions
+	if cs.extensions == nil {
+		cs.extensions = map[string][]string{}
+	}
+	cs.files = resolved
+	cs.order = order
+}
+
+// save writes the cache file. Errors are ignored, since the cache is only an
+// optimization.
+func (cs *cachedSource) save() {
+	c := descriptorCache{
+		Version:    descriptorCacheVersion,
+		Fetched:    cs.fetched,
+		Services:   cs.services,
+		Extensions: cs.extensions,
+	}
+	for _, name := range cs.order {
+		b, err := proto.Marshal(cs.files[name].AsFileDescriptorProto())
+		if err != nil {
+			return
+		}
+		c.Files = append(c.Files, b)
+	}
+	data, err := json.Marshal(&c)
+	if err != nil {
+		return
+	}
+	if err := os.MkdirAll(filepath.Dir(cs.path), 0700); err != nil {
+		return
+	}
+	// write to a temporary file first, so concurrent invocations never see
+	// a partially written cache
+	f, err := os.CreateTemp(filepath.Dir(cs.path), filepath.Base(cs.path)+".*.tmp")
+	if err != nil {
+		return
+	}
+	_, err = f.Write(data)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), cs.path)
+	}
+	if err != nil {
+		_ = os.Remove(f.Name())
+	}
+}
+
+// addFile adds the given file and its dependencies to the cache, returning
+// true if any were not already cached.
+func (cs *cachedSource) addFile(fd *desc.FileDescriptor) bool {
+	if _, ok := cs.files[fd.GetName()]; ok {
+		return false
+	}
+	for _, dep := range fd.GetDependencies() {
+		cs.addFile(dep)
+	}
+	cs.files[fd.GetName()] = fd
+	cs.order = append(cs.order, fd.GetName())
+	return true
+}
+
+func (cs *cachedSource) findSymbol(fullyQualifiedName string) desc.Descriptor {
+	for _, name := range cs.order {
+		if d := cs.files[name].FindSymbol(fullyQualifiedName); d != nil {
+			return d
+		}
+	}
+	return nil
+}
+
+func (cs *cachedSource) ListServices() ([]string, error) {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	// listing services is a single query, so it is always made, to notice
+	// when the server's services change
+	svcs, err := cs.source.ListServices()
+	if err != nil {
+		return nil, err
+	}
+	svcs = append([]string{}, svcs...)
+	sort.Strings(svcs)
+	if cs.services == nil || !equalStrings(svcs, cs.services) {
+		if cs.services != nil {
+			cs.reset()
+		}
+		cs.services = svcs
+		cs.save()
+	}
+	return append([]string(nil), cs.services...), nil
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func (cs *cachedSource) FindSymbol(fullyQualifiedName string) (desc.Descriptor, error) {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	if d := cs.findSymbol(fullyQualifiedName); d != nil {
+		return d, nil
+	}
+	d, err := cs.source.FindSymbol(fullyQualifiedName)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := cs.files[d.GetFile().GetName()]; ok {
+		// the cached version of the file doesn't have the symbol, so the
+		// server's schema has changed since it was cached
+		cs.reset()
+	}
+	if cs.addFile(d.GetFile()) {
+		cs.save()
+	}
+	return d, nil
+}
+
+func (cs *cachedSource) AllExtensionsForType(typeName string) ([]*desc.FieldDescriptor, error) {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	if names, ok := cs.extensions[typeName]; ok {
+		exts := make([]*desc.FieldDescriptor, 0, len(names))
+		for _, name := range names {
+			ext, ok := cs.findSymbol(name).(*desc.FieldDescriptor)
+			if !ok {
+				// should not happen, but fetch them again if it does
+				exts = nil
+				break
+			}
+			exts = append(exts, ext)
+		}
+		if exts != nil {
+			return exts, nil
+		}
+	}
+	exts, err := cs.source.AllExtensionsForType(typeName)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, len(exts))
+	for i, ext := range exts {
+		names[i] = ext.GetFullyQualifiedName()
+		cs.addFile(ext.GetFile())
+	}
+	cs.extensions[typeName] = names
+	cs.save()
+	return exts, nil
+}
@@ -0,0 +1,237 @@
+package grpcurl_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto" //lint:ignore SA1019 we have to import this because it appears in exported API
+	"github.com/jhump/protoreflect/desc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/protobuf/types/descriptorpb"
+
+	. "github.com/tetrateio/grpcurl"
+)
+
+// countingSource counts the queries made of a DescriptorSource.
+type countingSource struct {
+	DescriptorSource
+	mu    sync.Mutex
+	count int
+}
+
+func (s *countingSource) queries() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.count
+}
+
+func (s *countingSource) inc() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.count++
+}
+
+func (s *countingSource) ListServices() ([]string, error) {
+	s.inc()
+	return s.DescriptorSource.ListServices()
+}
+
+func (s *countingSource) FindSymbol(fullyQualifiedName string) (desc.Descriptor, error) {
+	s.inc()
+	return s.DescriptorSource.FindSymbol(fullyQualifiedName)
+}
+
+func (s *countingSource) AllExtensionsForType(typeName string) ([]*desc.FieldDescriptor, error) {
+	s.inc()
+	return s.DescriptorSource.AllExtensionsForType(typeName)
+}
+
+func TestCachedDescriptorSource(t *testing.T) {
+	dir := t.TempDir()
+	path := DescriptorCacheFile(dir, "127.0.0.1:1234", "", true, nil)
+	if other := DescriptorCacheFile(dir, "127.0.0.1:1234", "example.com", true, nil); other == path {
+		t.Errorf("cache file should depend on authority")
+	}
+	if other := DescriptorCacheFile(dir, "127.0.0.1:1234", "", false, nil); other == path {
+		t.Errorf("cache file should depend on transport security")
+	}
+	headers := []string{"tenant: acme", "x-env: prod"}
+	withHeaders := DescriptorCacheFile(dir, "127.0.0.1:1234", "", true, headers)
+	if withHeaders == path {
+		t.Errorf("cache file should depend on reflection headers")
+	}
+	if other := DescriptorCacheFile(dir, "127.0.0.1:1234", "", true, []string{"x-env: prod", "tenant: acme"}); other != withHeaders {
+		t.Errorf("cache file should not depend on the order of reflection headers")
+	}
+
+	use := func(source DescriptorSource) {
+		t.Helper()
+		svcs, err := source.ListServices()
+		if err != nil {
+			t.Fatalf("failed to list services: %v", err)
+		}
+		sort.Strings(svcs)
+		if len(svcs) != 3 || svcs[2] != "testing.TestService" {
+			t.Errorf("wrong services: %v", svcs)
+		}
+		d, err := source.FindSymbol("testing.TestService.UnaryCall")
+		if err != nil {
+			t.Fatalf("failed to find symbol: %v", err)
+		}
+		if _, ok := d.(*desc.MethodDescriptor); !ok {
+			t.Errorf("wrong kind of descriptor: %T", d)
+		}
+		if _, err := source.FindSymbol("testing.NoSuchMessage"); err == nil {
+			t.Errorf("expected error for unknown symbol")
+		}
+		if _, err := source.AllExtensionsForType("testing.SimpleRequest"); err != nil {
+			t.Fatalf("failed to find extensions: %v", err)
+		}
+		h := &handler{reqMessages: []string{`{"payload": {"body": "AQID"}}`}}
+		if err := InvokeRpc(context.Background(), source, ccReflect, "testing.TestService/UnaryCall", nil, h, h.getRequestData); err != nil {
+			t.Fatalf("failed to invoke RPC: %v", err)
+		}
+		if h.respStatus.Code() != codes.OK || len(h.respMessages) != 1 {
+			t.Errorf("wrong result: %v, %v", h.respStatus, h.respMessages)
+		}
+	}
+
+	// first use populates the cache
+	inner := &countingSource{DescriptorSource: sourceReflect}
+	use(CachedDescriptorSource(inner, path, time.Hour, false))
+	populated := inner.queries()
+	if populated == 0 {
+		t.Fatalf("expected queries of underlying source")
+	}
+	if _, err := os.Stat(path); err != nil {
+		t.Fatalf("cache file was not written: %v", err)
+	}
+
+	// later uses only query for the list of services and unknown symbols
+	inner = &countingSource{DescriptorSource: sourceReflect}
+	use(CachedDescriptorSource(inner, path, time.Hour, false))
+	if n := inner.queries(); n != 2 {
+		t.Errorf("expected only queries for services and unknown symbol, got %d queries", n)
+	}
+
+	// refreshing or expiry discards the cache
+	inner = &countingSource{DescriptorSource: sourceReflect}
+	use(CachedDescriptorSource(inner, path, time.Hour, true))
+	if n := inner.queries(); n != populated {
+		t.Errorf("refresh: expected %d queries, got %d", populated, n)
+	}
+	time.Sleep(10 * time.Millisecond)
+	inner = &countingSource{DescriptorSource: sourceReflect}
+	use(CachedDescriptorSource(inner, path, time.Millisecond, false))
+	if n := inner.queries(); n != populated {
+		t.Errorf("expired: expected %d queries, got %d", populated, n)
+	}
+
+	// a corrupt cache is ignored
+	if err := os.WriteFile(path, []byte("{"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	inner = &countingSource{DescriptorSource: sourceReflect}
+	use(CachedDescriptorSource(inner, path, 0, false))
+	if n := inner.queries(); n != populated {
+		t.Errorf("corrupt: expected %d queries, got %d", populated, n)
+	}
+	if tmps, _ := filepath.Glob(filepath.Join(dir, "*.tmp")); len(tmps) > 0 {
+		t.Errorf("temporary files left behind: %v", tmps)
+	}
+}
+
+func TestCachedDescriptorSource_ServerChanges(t *testing.T) {
+	path := DescriptorCacheFile(t.TempDir(), "127.0.0.1:1234", "", true, nil)
+
+	// the cache is written while the server's service lacks a method that it
+	// has later
+	d, err := sourceReflect.FindSymbol("testing.TestService")
+	if err != nil {
+		t.Fatalf("failed to find service: %v", err)
+	}
+	fd := d.GetFile()
+	fdp := proto.Clone(fd.AsFileDescriptorProto()).(*descriptorpb.FileDescriptorProto)
+	for _, sd := range fdp.GetService() {
+		if sd.GetName() != "TestService" {
+			continue
+		}
+		var methods []*descriptorpb.MethodDescriptorProto
+		for _, m := range sd.GetMethod() {
+			if m.GetName() != "UnaryCall" {
+				methods = append(methods, m)
+			}
+		}
+		sd.Method = methods
+	}
+	oldFd, err := desc.CreateFileDescriptor(fdp, fd.GetDependencies()...)
+	if err != nil {
+		t.Fatalf("failed to create old version of file: %v", err)
+	}
+	oldSource, err := DescriptorSourceFromFileDescriptors(oldFd)
+	if err != nil {
+		t.Fatalf("failed to create descriptor source: %v", err)
+	}
+	if _, err := CachedDescriptorSource(oldSource, path, time.Hour, false).FindSymbol("testing.TestService"); err != nil {
+		t.Fatalf("failed to find service: %v", err)
+	}
+
+	// the new method is found, even though the cached service lacks it
+	source := CachedDescriptorSource(sourceReflect, path, time.Hour, false)
+	h := &handler{reqMessages: []string{`{"payload": {"body": "AQID"}}`}}
+	if err := InvokeRpc(context.Background(), source, ccReflect, "testing.TestService/UnaryCall", nil, h, h.getRequestData); err != nil {
+		t.Fatalf("failed to invoke RPC: %v", err)
+	}
+	if h.respStatus.Code() != codes.OK || len(h.respMessages) != 1 {
+		t.Errorf("wrong result: %v, %v", h.respStatus, h.respMessages)
+	}
+
+	// and the cached file is replaced with the new version
+	inner := &countingSource{DescriptorSource: sourceReflect}
+	d, err = CachedDescriptorSource(inner, path, time.Hour, false).FindSymbol("testing.TestService")
+	if err != nil {
+		t.Fatalf("failed to find service: %v", err)
+	}
+	if d.(*desc.ServiceDescriptor).FindMethodByName("UnaryCall") == nil {
+		t.Errorf("cached service does not have new method")
+	}
+	if n := inner.queries(); n != 0 {
+		t.Errorf("expected no queries, got %d", n)
+	}
+
+	// a change in the list of services discards the cache
+	lister := &servicesSource{DescriptorSource: sourceReflect, services: []string{"testing.TestService"}}
+	if _, err := CachedDescriptorSource(lister, path, time.Hour, false).ListServices(); err != nil {
+		t.Fatalf("failed to list services: %v", err)
+	}
+	inner = &countingSource{DescriptorSource: sourceReflect}
+	source = CachedDescriptorSource(inner, path, time.Hour, false)
+	svcs, err := source.ListServices()
+	if err != nil {
+		t.Fatalf("failed to list services: %v", err)
+	}
+	if len(svcs) != 3 {
+		t.Errorf("wrong services: %v", svcs)
+	}
+	if _, err := source.FindSymbol("testing.TestService"); err != nil {
+		t.Fatalf("failed to find service: %v", err)
+	}
+	if n := inner.queries(); n != 2 {
+		t.Errorf("expected queries for services and discarded symbol, got %d", n)
+	}
+}
+
+// servicesSource is a DescriptorSource that lists the given services.
+type servicesSource struct {
+	DescriptorSource
+	services []string
+}
+
+func (s *servicesSource) ListServices() ([]string, error) {
+	return s.services, nil
+}
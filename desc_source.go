@@ -13,8 +13,12 @@ import (
 	"github.com/jhump/protoreflect/desc/protoparse"
 	"github.com/jhump/protoreflect/dynamic"
 	"github.com/jhump/protoreflect/grpcreflect"
+	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
+	reflectv1pb "google.golang.org/grpc/reflection/grpc_reflection_v1"
+	reflectpb "google.golang.org/grpc/reflection/grpc_reflection_v1alpha"
 	"google.golang.org/grpc/status"
+	protov2 "google.golang.org/protobuf/proto"
 	"google.golang.org/protobuf/types/descriptorpb"
 )
 
@@ -201,7 +205,8 @@ func (fs *fileSource) AllExtensionsForType(typeName string) ([]*desc.FieldDescri
 
 // DescriptorSourceFromServer creates a DescriptorSource that uses the given gRPC reflection client
 // to interrogate a server for descriptor information. If the server does not support the reflection
-// API then the various DescriptorSource methods will return ErrReflectionNotSupported
+// API then the various DescriptorSource methods will return ErrReflectionNotSupported. Use
+// NewReflectionClient to create a client that negotiates the version of the reflection API.
 func DescriptorSourceFromServer(_ context.Context, refClient *grpcreflect.Client) DescriptorSource {
 	return serverSource{client: refClient}
 }
@@ -243,6 +248,94 @@ func (ss serverSource) AllExtensionsForType(typeName string) ([]*desc.FieldDescr
 	return exts, nil
 }
 
+// ReflectionVersion identifies the version of the server reflection service
+// that a reflection client uses. The allowed values are 'auto', 'v1' or
+// 'v1alpha'.
+type ReflectionVersion string
+
+const (
+	// ReflectionAuto tries the "grpc.reflection.v1" service first. If the server
+	// reports that it is unimplemented, the client falls back to the
+	// "grpc.reflection.v1alpha" service and remembers that choice for
+	// subsequent requests.
+	ReflectionAuto = ReflectionVersion("auto")
+
+	// ReflectionV1 uses only the stable "grpc.reflection.v1" service.
+	ReflectionV1 = ReflectionVersion("v1")
+
+	// ReflectionV1Alpha uses only the older "grpc.reflection.v1alpha" service.
+	ReflectionV1Alpha = ReflectionVersion("v1alpha")
+)
+
+// NewReflectionClient creates a gRPC reflection client that uses the given
+// connection and the given version of the server reflection service. The
+// returned client can be given to DescriptorSourceFromServer. An empty version
+// is treated the same as ReflectionAuto. Callers should call Reset on the
+// returned client when done with it, to release the underlying stream.
+func NewReflectionClient(ctx context.Context, cc grpc.ClientConnInterface, version ReflectionVersion) (*grpcreflect.Client, error) {
+	switch version {
+	case ReflectionAuto, "":
+		return grpcreflect.NewClientAuto(ctx, cc), nil
+	case ReflectionV1:
+		// The grpcreflect package only speaks the v1alpha messages, so we
+		// adapt the v1 stub to look like a v1alpha one. The two versions
+		// of the protocol are wire-compatible.
+		return grpcreflect.NewClientV1Alpha(ctx, v1ReflectionClient{stub: reflectv1pb.NewServerReflectionClient(cc)}), nil
+	case ReflectionV1Alpha:
+		return grpcreflect.NewClientV1Alpha(ctx, reflectpb.NewServerReflectionClient(cc)), nil
+	default:
+		return nil, fmt.Errorf("unknown reflection version: %s", version)
+	}
+}
+
+// v1ReflectionClient implements the v1alpha reflection client interface by
+// invoking the v1 reflection service.
+type v1ReflectionClient struct {
+	stub reflectv1pb.ServerReflectionClient
+}
+
+func (c v1ReflectionClient) ServerReflectionInfo(ctx context.Context, opts ...grpc.CallOption) (reflectpb.ServerReflection_ServerReflectionInfoClient, error) {
+	str, err := c.stub.ServerReflectionInfo(ctx, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return v1ReflectionStream{ServerReflection_ServerReflectionInfoClient: str}, nil
+}
+
+type v1ReflectionStream struct {
+	reflectv1pb.ServerReflection_ServerReflectionInfoClient
+}
+
+func (s v1ReflectionStream) Send(req *reflectpb.ServerReflectionRequest) error {
+	var v1req reflectv1pb.ServerReflectionRequest
+	if err := convertReflectionMessage(req, &v1req); err != nil {
+		return err
+	}
+	return s.ServerReflection_ServerReflectionInfoClient.Send(&v1req)
+}
+
+func (s v1ReflectionStream) Recv() (*reflectpb.ServerReflectionResponse, error) {
+	v1resp, err := s.ServerReflection_ServerReflectionInfoClient.Recv()
+	if err != nil {
+		return nil, err
+	}
+	var resp reflectpb.ServerReflectionResponse
+	if err := convertReflectionMessage(v1resp, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// convertReflectionMessage copies a reflection message from one version of
+// the protocol to the other by round-tripping it through the binary format.
+func convertReflectionMessage(from, to protov2.Message) error {
+	b, err := protov2.Marshal(from)
+	if err != nil {
+		return err
+	}
+	return protov2.Unmarshal(b, to)
+}
+
 func reflectionSupport(err error) error {
 	if err == nil {
 		return nil
@@ -0,0 +1,284 @@
+package grpcurl
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/golang/protobuf/jsonpb" //lint:ignore SA1019 we have to import this because it appears in exported API
+	"github.com/golang/protobuf/proto"  //lint:ignore SA1019 we have to import this because it appears in exported API
+	"github.com/jhump/protoreflect/desc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// EnvelopeVersion is the version of the JSON output written by
+// JSONEnvelopeHandler. Fields may be added to the output without changing the
+// version, but the version is incremented if any field is removed or its
+// meaning is changed.
+const EnvelopeVersion = 1
+
+// Envelope is the JSON document written by JSONEnvelopeHandler for a call
+// whose method does not have a stream of responses. It describes the whole
+// call. Values for binary headers and trailers (whose names end in "-bin") are
+// base64-encoded.
+type Envelope struct {
+	// Version is always EnvelopeVersion.
+	Version int `json:"version"`
+	// Method describes the method that was invoked. It is absent if the
+	// method could not be resolved.
+	Method *EnvelopeMethod `json:"method,omitempty"`
+	// RequestHeaders are the headers that were sent.
+	RequestHeaders map[string][]string `json:"requestHeaders,omitempty"`
+	// ResponseHeaders are the headers that were received.
+	ResponseHeaders map[string][]string `json:"responseHeaders,omitempty"`
+	// Responses are the JSON forms of all response messages received.
+	Responses []json.RawMessage `json:"responses"`
+	// Trailers are the trailers that were received.
+	Trailers map[string][]string `json:"trailers,omitempty"`
+	// Status is the outcome of the call.
+	Status *EnvelopeStatus `json:"status"`
+	// Error describes a failure that does not have a gRPC status, such as an
+	// unknown method or malformed request data. When set, the status code is
+	// "Unknown".
+	Error string `json:"error,omitempty"`
+}
+
+// EnvelopeEvent is a single line of JSON output written by JSONEnvelopeHandler
+// for a call whose method has a stream of responses. Each event is written as
+// soon as it happens. The Event field indicates which other fields are set:
+//
+//   - "method": Method describes the method that was invoked.
+//   - "requestHeaders": Headers are the headers that were sent.
+//   - "responseHeaders": Headers are the headers that were received.
+//   - "response": Message is the JSON form of a response message.
+//   - "end": Trailers and Status describe the outcome of the call, and Error
+//     may be set. This is always the last event.
+//
+// Values for binary headers and trailers (whose names end in "-bin") are
+// base64-encoded.
+type EnvelopeEvent struct {
+	// Version is always EnvelopeVersion.
+	Version  int                 `json:"version"`
+	Event    string              `json:"event"`
+	Method   *EnvelopeMethod     `json:"method,omitempty"`
+	Headers  map[string][]string `json:"headers,omitempty"`
+	Message  json.RawMessage     `json:"message,omitempty"`
+	Trailers map[string][]string `json:"trailers,omitempty"`
+	Status   *EnvelopeStatus     `json:"status,omitempty"`
+	Error    string              `json:"error,omitempty"`
+}
+
+// EnvelopeMethod describes a method in JSON output.
+type EnvelopeMethod struct {
+	// Name is the method's fully-qualified name, in 'service/method' format.
+	Name string `json:"name"`
+	// RequestType is the fully-qualified name of the request message type.
+	RequestType string `json:"requestType"`
+	// ResponseType is the fully-qualified name of the response message type.
+	ResponseType    string `json:"responseType"`
+	ClientStreaming bool   `json:"clientStreaming"`
+	ServerStreaming bool   `json:"serverStreaming"`
+}
+
+// EnvelopeStatus describes the status of a call in JSON output.
+type EnvelopeStatus struct {
+	// Code is the name of the status code, such as "OK" or "NotFound".
+	Code string `json:"code"`
+	// Number is the numeric value of the status code.
+	Number int `json:"number"`
+	// Message is the status message, if any.
+	Message string `json:"message,omitempty"`
+	// Details are the JSON forms of the status's detail messages. Each has an
+	// "@type" property, like the JSON form of a google.protobuf.Any message.
+	// If a detail's type is not known, it also has a "@value" property with
+	// the base64-encoded binary form of the message.
+	Details []json.RawMessage `json:"details,omitempty"`
+}
+
+// JSONEnvelopeHandler is an InvocationEventHandler that writes a description
+// of the whole call as JSON, to be consumed by other programs. If the method
+// has a stream of responses, each event is written as a line of JSON, as an
+// EnvelopeEvent, as soon as it happens. Otherwise, a single Envelope is
+// written when the call completes. Finish must be called after the call, to
+// ensure the output is complete.
+type JSONEnvelopeHandler struct {
+	Out io.Writer
+
+	// NumResponses is the number of responses that have been received.
+	NumResponses int
+	// Status is the status that was received at the end of an RPC. It is
+	// nil if the RPC is still in progress.
+	Status *status.Status
+
+	marshaler jsonpb.Marshaler
+	streaming bool
+	done      bool
+	envelope  Envelope
+	writeErr  error
+}
+
+var _ InvocationEventHandler = (*JSONEnvelopeHandler)(nil)
+
+// NewJSONEnvelopeHandler returns a handler that writes JSON to the given
+// output. The given descriptor source is used to format messages in
+// google.protobuf.Any fields and status details. If emitDefaults is true,
+// fields with default values are included in the JSON forms of messages.
+func NewJSONEnvelopeHandler(out io.Writer, source DescriptorSource, emitDefaults bool) *JSONEnvelopeHandler {
+	return &JSONEnvelopeHandler{
+		Out: out,
+		marshaler: jsonpb.Marshaler{
+			EmitDefaults: emitDefaults,
+			// messages of types the source doesn't know are shown in binary
+			// form, so they are not dropped
+			AnyResolver: AnyResolverFromDescriptorSourceWithFallback(source),
+		},
+		envelope: Envelope{
+			Version:   EnvelopeVersion,
+			Responses: []json.RawMessage{},
+		},
+	}
+}
+
+func (h *JSONEnvelopeHandler) OnResolveMethod(md *desc.MethodDescriptor) {
+	m := &EnvelopeMethod{
+		Name:            md.GetService().GetFullyQualifiedName() + "/" + md.GetName(),
+		RequestType:     md.GetInputType().GetFullyQualifiedName(),
+		ResponseType:    md.GetOutputType().GetFullyQualifiedName(),
+		ClientStreaming: md.IsClientStreaming(),
+		ServerStreaming: md.IsServerStreaming(),
+	}
+	h.streaming = md.IsServerStreaming()
+	if h.streaming {
+		h.writeEvent(&EnvelopeEvent{Event: "method", Method: m})
+	} else {
+		h.envelope.Method = m
+	}
+}
+
+func (h *JSONEnvelopeHandler) OnSendHeaders(md metadata.MD) {
+	if h.streaming {
+		h.writeEvent(&EnvelopeEvent{Event: "requestHeaders", Headers: metadataToJSON(md)})
+	} else {
+		h.envelope.RequestHeaders = metadataToJSON(md)
+	}
+}
+
+func (h *JSONEnvelopeHandler) OnReceiveHeaders(md metadata.MD) {
+	if h.streaming {
+		h.writeEvent(&EnvelopeEvent{Event: "responseHeaders", Headers: metadataToJSON(md)})
+	} else {
+		h.envelope.ResponseHeaders = metadataToJSON(md)
+	}
+}
+
+func (h *JSONEnvelopeHandler) OnReceiveResponse(resp proto.Message) {
+	h.NumResponses++
+	str, err := h.marshaler.MarshalToString(resp)
+	if err != nil {
+		h.setError(fmt.Sprintf("failed to format response message %d: %v", h.NumResponses, err))
+		return
+	}
+	if h.streaming {
+		h.writeEvent(&EnvelopeEvent{Event: "response", Message: json.RawMessage(str)})
+	} else {
+		h.envelope.Responses = append(h.envelope.Responses, json.RawMessage(str))
+	}
+}
+
+func (h *JSONEnvelopeHandler) OnReceiveTrailers(stat *status.Status, md metadata.MD) {
+	h.Status = stat
+	h.envelope.Status = statusToJSON(stat, h.marshaler)
+	h.envelope.Trailers = metadataToJSON(md)
+	if h.streaming {
+		h.writeEnd()
+	}
+}
+
+// Finish completes the output, given the error returned by the call, if
+// any. If the error was not already reported via OnReceiveTrailers, it is
+// included in the output. If the method does not have a stream of responses,
+// this is when the output is written. It returns an error if the output
+// could not be written.
+func (h *JSONEnvelopeHandler) Finish(err error) error {
+	if err != nil && h.Status == nil {
+		stat, ok := status.FromError(err)
+		if !ok {
+			stat = status.New(codes.Unknown, err.Error())
+			h.setError(err.Error())
+		}
+		h.Status = stat
+		h.envelope.Status = statusToJSON(stat, h.marshaler)
+	}
+	if h.envelope.Status == nil {
+		h.envelope.Status = statusToJSON(h.Status, h.marshaler)
+	}
+	if h.streaming {
+		h.writeEnd()
+	} else {
+		h.write(&h.envelope)
+	}
+	return h.writeErr
+}
+
+// setError records the given failure, unless one was already recorded, since
+// the first failure is usually the cause of any others.
+func (h *JSONEnvelopeHandler) setError(msg string) {
+	if h.envelope.Error == "" {
+		h.envelope.Error = msg
+	}
+}
+
+func (h *JSONEnvelopeHandler) writeEnd() {
+	if h.done {
+		return
+	}
+	h.done = true
+	h.writeEvent(&EnvelopeEvent{
+		Event:    "end",
+		Trailers: h.envelope.Trailers,
+		Status:   h.envelope.Status,
+		Error:    h.envelope.Error,
+	})
+}
+
+func (h *JSONEnvelopeHandler) writeEvent(ev *EnvelopeEvent) {
+	ev.Version = EnvelopeVersion
+	h.write(ev)
+}
+
+func (h *JSONEnvelopeHandler) write(v interface{}) {
+	if h.writeErr != nil {
+		return
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		h.writeErr = err
+		return
+	}
+	_, h.writeErr = h.Out.Write(append(b, '\n'))
+}
+
+// statusToJSON converts the given status to its JSON form, using the given
+// marshaler to format its details.
+func statusToJSON(stat *status.Status, marshaler jsonpb.Marshaler) *EnvelopeStatus {
+	s := &EnvelopeStatus{
+		Code:    stat.Code().String(),
+		Number:  int(stat.Code()),
+		Message: stat.Message(),
+	}
+	for i, det := range stat.Proto().GetDetails() {
+		str, err := marshaler.MarshalToString(det)
+		if err != nil {
+			// should not be possible with the fallback resolver
+			b, _ := json.Marshal(map[string]string{
+				"@type": det.GetTypeUrl(),
+				"error": fmt.Sprintf("failed to format detail %d: %v", i+1, err),
+			})
+			str = string(b)
+		}
+		s.Details = append(s.Details, json.RawMessage(str))
+	}
+	return s
+}
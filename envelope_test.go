@@ -0,0 +1,170 @@
+package grpcurl_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/jhump/protoreflect/desc"
+	"github.com/jhump/protoreflect/dynamic"
+	"google.golang.org/protobuf/types/known/anypb"
+
+	. "github.com/tetrateio/grpcurl"
+)
+
+func invokeWithEnvelope(t *testing.T, source DescriptorSource, method string, headers []string, data string) (*JSONEnvelopeHandler, string) {
+	rf, _, err := RequestParserAndFormatter(FormatJSON, source, strings.NewReader(data), FormatOptions{})
+	if err != nil {
+		t.Fatalf("failed to create request parser: %v", err)
+	}
+	var out bytes.Buffer
+	h := NewJSONEnvelopeHandler(&out, source, false)
+	err = InvokeRPC(context.Background(), source, ccReflect, method, headers, h, rf.Next)
+	if err := h.Finish(err); err != nil {
+		t.Fatalf("failed to write output: %v", err)
+	}
+	return h, out.String()
+}
+
+func TestJSONEnvelopeHandler(t *testing.T) {
+	for _, ds := range descSources {
+		t.Run(ds.name, func(t *testing.T) {
+			doTestJSONEnvelopeHandler(t, ds.source)
+		})
+	}
+}
+
+func doTestJSONEnvelopeHandler(t *testing.T, source DescriptorSource) {
+	// unary call: a single document
+	h, out := invokeWithEnvelope(t, source, "testing.TestService/UnaryCall",
+		[]string{"reply-with-headers: foo: bar", "reply-with-trailers: baz: 1"},
+		`{"payload": {"body": "AQID"}}`)
+	var env Envelope
+	if err := json.Unmarshal([]byte(out), &env); err != nil {
+		t.Fatalf("output is not a valid envelope: %v\n%s", err, out)
+	}
+	if strings.Count(out, "\n") != 1 {
+		t.Errorf("expected a single line of output, got:\n%s", out)
+	}
+	if env.Version != EnvelopeVersion {
+		t.Errorf("wrong version: %d", env.Version)
+	}
+	expectedMethod := EnvelopeMethod{
+		Name:         "testing.TestService/UnaryCall",
+		RequestType:  "testing.SimpleRequest",
+		ResponseType: "testing.SimpleResponse",
+	}
+	if env.Method == nil || *env.Method != expectedMethod {
+		t.Errorf("wrong method: %+v", env.Method)
+	}
+	if vals := env.RequestHeaders["reply-with-headers"]; len(vals) != 1 || vals[0] != "foo: bar" {
+		t.Errorf("wrong request headers: %v", env.RequestHeaders)
+	}
+	if vals := env.ResponseHeaders["foo"]; len(vals) != 1 || vals[0] != "bar" {
+		t.Errorf("wrong response headers: %v", env.ResponseHeaders)
+	}
+	if vals := env.Trailers["baz"]; len(vals) != 1 || vals[0] != "1" {
+		t.Errorf("wrong trailers: %v", env.Trailers)
+	}
+	if len(env.Responses) != 1 || string(env.Responses[0]) != `{"payload":{"body":"AQID"}}` {
+		t.Errorf("wrong responses: %s", env.Responses)
+	}
+	if env.Status == nil || env.Status.Code != "OK" || env.Status.Number != 0 || env.Error != "" {
+		t.Errorf("wrong status: %+v, %q", env.Status, env.Error)
+	}
+	if h.NumResponses != 1 {
+		t.Errorf("wrong number of responses: %d", h.NumResponses)
+	}
+
+	// failed call
+	_, out = invokeWithEnvelope(t, source, "testing.TestService/UnaryCall", []string{"fail-early: 5"}, `{}`)
+	env = Envelope{}
+	if err := json.Unmarshal([]byte(out), &env); err != nil {
+		t.Fatalf("output is not a valid envelope: %v\n%s", err, out)
+	}
+	if env.Status == nil || env.Status.Code != "NotFound" || env.Status.Number != 5 || env.Status.Message != "fail" {
+		t.Errorf("wrong status: %+v", env.Status)
+	}
+	if len(env.Responses) != 0 || !strings.Contains(out, `"responses":[]`) {
+		t.Errorf("wrong responses: %s", out)
+	}
+
+	// failure without a status
+	_, out = invokeWithEnvelope(t, source, "testing.TestService/NoSuchMethod", nil, `{}`)
+	env = Envelope{}
+	if err := json.Unmarshal([]byte(out), &env); err != nil {
+		t.Fatalf("output is not a valid envelope: %v\n%s", err, out)
+	}
+	if env.Method != nil || env.Status == nil || env.Status.Code != "Unknown" || !strings.Contains(env.Error, `"NoSuchMethod"`) {
+		t.Errorf("wrong method, status, or error: %+v, %+v, %q", env.Method, env.Status, env.Error)
+	}
+
+	// server-streaming call: one event per line
+	h, out = invokeWithEnvelope(t, source, "testing.TestService/StreamingOutputCall",
+		[]string{"fail-late: 9"},
+		`{"responseParameters": [{"size": 1}, {"size": 2}]}`)
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	expectedEvents := []string{"method", "requestHeaders", "responseHeaders", "response", "response", "end"}
+	if len(lines) != len(expectedEvents) {
+		t.Fatalf("wrong number of events: expected %d, got %d:\n%s", len(expectedEvents), len(lines), out)
+	}
+	var events []EnvelopeEvent
+	for i, line := range lines {
+		var ev EnvelopeEvent
+		if err := json.Unmarshal([]byte(line), &ev); err != nil {
+			t.Fatalf("event %d is not valid: %v", i+1, err)
+		}
+		if ev.Version != EnvelopeVersion || ev.Event != expectedEvents[i] {
+			t.Errorf("event %d: wrong version or kind: %d, %q", i+1, ev.Version, ev.Event)
+		}
+		events = append(events, ev)
+	}
+	if m := events[0].Method; m == nil || !m.ServerStreaming || m.ClientStreaming {
+		t.Errorf("wrong method: %+v", m)
+	}
+	if string(events[4].Message) != `{"payload":{"body":"AAE="}}` {
+		t.Errorf("wrong response: %s", events[4].Message)
+	}
+	if st := events[5].Status; st == nil || st.Code != "FailedPrecondition" {
+		t.Errorf("wrong status: %+v", st)
+	}
+	if h.Status == nil || h.NumResponses != 2 {
+		t.Errorf("wrong status or number of responses: %v, %d", h.Status, h.NumResponses)
+	}
+}
+
+func TestJSONEnvelopeHandler_UnknownAny(t *testing.T) {
+	source, err := DescriptorSourceFromProtoSets("internal/testing/example.protoset")
+	if err != nil {
+		t.Fatalf("failed to create descriptor source: %v", err)
+	}
+	d, err := source.FindSymbol("Extension")
+	if err != nil {
+		t.Fatalf("failed to find message 'Extension': %v", err)
+	}
+	resp := dynamic.NewMessage(d.(*desc.MessageDescriptor))
+	resp.SetFieldByName("id", uint64(42))
+	resp.SetFieldByName("data", &anypb.Any{TypeUrl: "type.googleapis.com/foo.Unknown", Value: []byte{8, 1}})
+
+	var out bytes.Buffer
+	h := NewJSONEnvelopeHandler(&out, source, false)
+	h.OnReceiveResponse(resp)
+	if err := h.Finish(nil); err != nil {
+		t.Fatalf("failed to write output: %v", err)
+	}
+	var env Envelope
+	if err := json.Unmarshal(out.Bytes(), &env); err != nil {
+		t.Fatalf("output is not a valid envelope: %v\n%s", err, out.String())
+	}
+	// a message of a type the source doesn't know is shown in binary form
+	// instead of being dropped
+	if env.Error != "" {
+		t.Errorf("unexpected error: %s", env.Error)
+	}
+	expected := `{"id":"42","data":{"@error":"foo.Unknown is not recognized; see @value for raw binary message data","@type":"type.googleapis.com/foo.Unknown","@value":"CAE="}}`
+	if len(env.Responses) != 1 || string(env.Responses[0]) != expected {
+		t.Errorf("wrong responses: expecting [%s], got %s", expected, env.Responses)
+	}
+}
@@ -0,0 +1,751 @@
+package grpcurl
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang/protobuf/jsonpb" //lint:ignore SA1019 we have to import this because it appears in exported API
+	"github.com/golang/protobuf/proto"  //lint:ignore SA1019 we have to import this because it appears in exported API
+	"github.com/jhump/protoreflect/desc"
+	"github.com/jhump/protoreflect/dynamic"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+// Expectations describe the expected outcome of an RPC. They are checked by
+// an ExpectationHandler as the RPC proceeds.
+type Expectations struct {
+	// Code is the expected status code. It may be given by name, in either
+	// "NotFound" or "NOT_FOUND" form, or by number. If empty, the RPC is
+	// expected to succeed with a status of OK.
+	Code string `json:"code,omitempty"`
+	// Headers are expected response headers, each in 'name: value' format.
+	// If a header has no value (e.g. does not contain a colon), it is only
+	// required to be present. Otherwise, one of the header's values must be
+	// equal to the given value.
+	Headers []string `json:"headers,omitempty"`
+	// Trailers are expected response trailers, in the same format as Headers.
+	Trailers []string `json:"trailers,omitempty"`
+	// Fields are expectations on the contents of response messages. See
+	// ParseFieldExpectation for their syntax.
+	Fields []string `json:"fields,omitempty"`
+}
+
+// ExpectationFailure describes an expectation that was not met.
+type ExpectationFailure struct {
+	// Subject is what was checked, such as "status code", a header name, or
+	// the path to a field in a response message.
+	Subject string `json:"subject"`
+	// Expected describes the expectation.
+	Expected string `json:"expected"`
+	// Actual describes the actual value, or what was found instead.
+	Actual string `json:"actual"`
+}
+
+func (f ExpectationFailure) String() string {
+	return fmt.Sprintf("%s: expected %s, got %s", f.Subject, f.Expected, f.Actual)
+}
+
+// FieldExpectation is an expectation on the value of a field in response
+// messages. It is created with ParseFieldExpectation.
+type FieldExpectation struct {
+	text     string
+	response int // -1 means all responses
+	path     []fieldPathElement
+	op       string
+	operand  interface{}
+	pattern  *regexp.Regexp
+	number   *big.Float
+	resolved []*desc.FieldDescriptor
+}
+
+type fieldPathElement struct {
+	name  string
+	index string // contents of [...] suffix, if present
+	// hasIndex is needed to distinguish "foo" from "foo[]"
+	hasIndex bool
+}
+
+const (
+	opPresent  = "present"
+	opAbsent   = "absent"
+	opEqual    = "=="
+	opNotEqual = "!="
+	opMatches  = "=~"
+	opGreater  = ">"
+	opGreaterE = ">="
+	opLess     = "<"
+	opLessE    = "<="
+)
+
+// the order matters: two-character operators must be matched first
+var fieldOperators = []string{opEqual, opNotEqual, opMatches, opGreaterE, opLessE, opGreater, opLess}
+
+var pathElementRegex = regexp.MustCompile(`^([A-Za-z_][A-Za-z0-9_]*)(?:\[([^\]]*)\])?$`)
+
+// ParseFieldExpectation parses an expectation on response message contents.
+// The expectation consists of a field path, optionally followed by an operator
+// and an operand:
+//
+//	path            the field must be present (set to a non-default value)
+//	!path           the field must be absent
+//	path == value   the field must equal the value
+//	path != value   the field must not equal the value
+//	path =~ regex   the field (formatted as a string) must match the regex
+//	path > number   the field must be numeric and greater than the number
+//
+// The operators >=, < and <= are also supported, which allows checking that a
+// field is within a numeric range using two expectations. A value is parsed as
+// JSON if possible and otherwise treated as a string, so both 'name == "abc"'
+// and 'name == abc' compare the field to the string "abc". Enum values compare
+// using their names, bytes values using their base64 encoding, and message
+// values using their JSON representation.
+//
+// A field path is a dot-separated list of field names (the names in the proto
+// source or their JSON names). A repeated field may be followed by an index in
+// brackets, like "items[0]", and a map field by a key, like "labels[env]".
+//
+// By default, the expectation must hold for every response message. The path
+// may be prefixed with an index in brackets to select just one response
+// message (counting from zero), like "[1].items[0].name == abc".
+func ParseFieldExpectation(expectation string) (*FieldExpectation, error) {
+	fe := FieldExpectation{text: expectation, response: -1}
+	str := strings.TrimSpace(expectation)
+
+	pos, op := -1, ""
+	for _, candidate := range fieldOperators {
+		if p := strings.Index(str, candidate); p >= 0 && (pos == -1 || p < pos) {
+			pos, op = p, candidate
+		}
+	}
+	var path string
+	if pos < 0 {
+		path = str
+		fe.op = opPresent
+		if strings.HasPrefix(path, "!") {
+			path = strings.TrimSpace(path[1:])
+			fe.op = opAbsent
+		}
+	} else {
+		path = strings.TrimSpace(str[:pos])
+		operand := strings.TrimSpace(str[pos+len(op):])
+		fe.op = op
+		switch op {
+		case opMatches:
+			var err error
+			if fe.pattern, err = regexp.Compile(operand); err != nil {
+				return nil, fmt.Errorf("invalid expectation %q: bad regular expression: %v", expectation, err)
+			}
+		case opGreater, opGreaterE, opLess, opLessE:
+			n, ok := new(big.Float).SetString(operand)
+			if !ok {
+				return nil, fmt.Errorf("invalid expectation %q: operand for %s must be a number", expectation, op)
+			}
+			fe.number = n
+		default:
+			fe.operand = parseExpectedValue(operand)
+		}
+	}
+
+	if strings.HasPrefix(path, "[") {
+		end := strings.Index(path, "]")
+		if end < 0 {
+			return nil, fmt.Errorf("invalid expectation %q: missing ']' after response index", expectation)
+		}
+		idx, err := strconv.Atoi(path[1:end])
+		if err != nil || idx < 0 {
+			return nil, fmt.Errorf("invalid expectation %q: response index must be a non-negative integer", expectation)
+		}
+		fe.response = idx
+		path = strings.TrimPrefix(path[end+1:], ".")
+	}
+	if path == "" {
+		return nil, fmt.Errorf("invalid expectation %q: missing field path", expectation)
+	}
+	for _, part := range strings.Split(path, ".") {
+		part = strings.TrimSpace(part)
+		m := pathElementRegex.FindStringSubmatch(part)
+		if m == nil {
+			return nil, fmt.Errorf("invalid expectation %q: malformed field path element %q", expectation, part)
+		}
+		fe.path = append(fe.path, fieldPathElement{name: m[1], index: m[2], hasIndex: strings.HasSuffix(part, "]")})
+	}
+	return &fe, nil
+}
+
+func (fe *FieldExpectation) String() string {
+	return fe.text
+}
+
+// Validate checks that the expectation's field path is valid for messages of
+// the given type. It must be called before the expectation is checked against
+// messages.
+func (fe *FieldExpectation) Validate(md *desc.MessageDescriptor) error {
+	resolved := make([]*desc.FieldDescriptor, len(fe.path))
+	for i, el := range fe.path {
+		if md == nil {
+			return fmt.Errorf("invalid expectation %q: %s is not a message, so it has no field named %q", fe.text, fe.pathString(i), el.name)
+		}
+		fd := findFieldByNameOrJSONName(md, el.name)
+		if fd == nil {
+			return fmt.Errorf("invalid expectation %q: %s", fe.text, noSuchFieldMessage(md, el.name))
+		}
+		resolved[i] = fd
+		msgType := fd.GetMessageType()
+		if el.hasIndex {
+			switch {
+			case fd.IsMap():
+				if _, err := parseMapKey(fd.GetMapKeyType(), el.index); err != nil {
+					return fmt.Errorf("invalid expectation %q: bad key for map field %s: %v", fe.text, fe.pathString(i+1), err)
+				}
+				msgType = fd.GetMapValueType().GetMessageType()
+			case fd.IsRepeated():
+				if idx, err := strconv.Atoi(el.index); err != nil || idx < 0 {
+					return fmt.Errorf("invalid expectation %q: index for repeated field %s must be a non-negative integer", fe.text, fe.pathString(i+1))
+				}
+			default:
+				return fmt.Errorf("invalid expectation %q: field %s is neither repeated nor a map, so cannot be indexed", fe.text, fe.pathString(i+1))
+			}
+		} else if fd.IsRepeated() && i < len(fe.path)-1 {
+			return fmt.Errorf("invalid expectation %q: repeated field %s must be indexed to refer to its fields", fe.text, fe.pathString(i+1))
+		}
+		if i < len(fe.path)-1 {
+			md = msgType
+		}
+	}
+	fe.resolved = resolved
+	return nil
+}
+
+func (fe *FieldExpectation) pathString(n int) string {
+	var buf strings.Builder
+	for i, el := range fe.path[:n] {
+		if i > 0 {
+			buf.WriteByte('.')
+		}
+		buf.WriteString(el.name)
+		if el.hasIndex {
+			buf.WriteString("[" + el.index + "]")
+		}
+	}
+	return buf.String()
+}
+
+func findFieldByNameOrJSONName(md *desc.MessageDescriptor, name string) *desc.FieldDescriptor {
+	if fd := md.FindFieldByName(name); fd != nil {
+		return fd
+	}
+	return md.FindFieldByJSONName(name)
+}
+
+func parseMapKey(fd *desc.FieldDescriptor, key string) (interface{}, error) {
+	switch fd.GetType() {
+	case descriptorpb.FieldDescriptorProto_TYPE_STRING:
+		return key, nil
+	case descriptorpb.FieldDescriptorProto_TYPE_BOOL:
+		return strconv.ParseBool(key)
+	case descriptorpb.FieldDescriptorProto_TYPE_INT32,
+		descriptorpb.FieldDescriptorProto_TYPE_SINT32,
+		descriptorpb.FieldDescriptorProto_TYPE_SFIXED32:
+		v, err := strconv.ParseInt(key, 10, 32)
+		return int32(v), err
+	case descriptorpb.FieldDescriptorProto_TYPE_INT64,
+		descriptorpb.FieldDescriptorProto_TYPE_SINT64,
+		descriptorpb.FieldDescriptorProto_TYPE_SFIXED64:
+		return strconv.ParseInt(key, 10, 64)
+	case descriptorpb.FieldDescriptorProto_TYPE_UINT32,
+		descriptorpb.FieldDescriptorProto_TYPE_FIXED32:
+		v, err := strconv.ParseUint(key, 10, 32)
+		return uint32(v), err
+	case descriptorpb.FieldDescriptorProto_TYPE_UINT64,
+		descriptorpb.FieldDescriptorProto_TYPE_FIXED64:
+		return strconv.ParseUint(key, 10, 64)
+	default:
+		return nil, fmt.Errorf("unsupported map key type %v", fd.GetType())
+	}
+}
+
+func parseExpectedValue(operand string) interface{} {
+	dec := json.NewDecoder(strings.NewReader(operand))
+	dec.UseNumber()
+	var v interface{}
+	if err := dec.Decode(&v); err != nil || dec.More() {
+		return operand
+	}
+	return v
+}
+
+// check tests the expectation against the given response message. It returns
+// nil if the expectation is met.
+func (fe *FieldExpectation) check(msg proto.Message, resolver jsonpb.AnyResolver) *ExpectationFailure {
+	present, val := fe.lookup(msg, resolver)
+	subject := fe.pathString(len(fe.path))
+	fail := func(expected, actual string) *ExpectationFailure {
+		return &ExpectationFailure{Subject: subject, Expected: expected, Actual: actual}
+	}
+	actual := "(absent)"
+	if present {
+		actual = formatActualValue(val)
+	}
+
+	switch fe.op {
+	case opPresent:
+		if !present {
+			return fail("present", actual)
+		}
+	case opAbsent:
+		if present {
+			return fail("absent", actual)
+		}
+	case opEqual:
+		if !valuesEqual(val, fe.operand) {
+			return fail("== "+formatActualValue(fe.operand), actual)
+		}
+	case opNotEqual:
+		if valuesEqual(val, fe.operand) {
+			return fail("!= "+formatActualValue(fe.operand), actual)
+		}
+	case opMatches:
+		str, ok := val.(string)
+		if !ok {
+			str = formatActualValue(val)
+		}
+		if !fe.pattern.MatchString(str) {
+			return fail("=~ "+fe.pattern.String(), actual)
+		}
+	default:
+		n, ok := toNumber(val)
+		if !ok {
+			return fail(fe.op+" "+fe.number.Text('g', -1), actual+" (not a number)")
+		}
+		c := n.Cmp(fe.number)
+		var met bool
+		switch fe.op {
+		case opGreater:
+			met = c > 0
+		case opGreaterE:
+			met = c >= 0
+		case opLess:
+			met = c < 0
+		case opLessE:
+			met = c <= 0
+		}
+		if !met {
+			return fail(fe.op+" "+fe.number.Text('g', -1), actual)
+		}
+	}
+	return nil
+}
+
+// lookup finds the value at the expectation's path in the given message. The
+// value is converted to a form that is comparable to operands parsed from JSON.
+// If the value is not present, it returns false and the field's default value.
+func (fe *FieldExpectation) lookup(msg proto.Message, resolver jsonpb.AnyResolver) (bool, interface{}) {
+	present := true
+	var val interface{} = msg
+	var fd *desc.FieldDescriptor
+	for i, el := range fe.path {
+		fd = fe.resolved[i]
+		dm, ok := asDynamicMessage(val)
+		if !ok || dm == nil {
+			// parent message is not set, so neither is this field
+			return false, nil
+		}
+		if !dm.HasField(fd) {
+			present = false
+		}
+		val = dm.GetField(fd)
+		if el.hasIndex {
+			if fd.IsMap() {
+				key, _ := parseMapKey(fd.GetMapKeyType(), el.index)
+				v, ok := val.(map[interface{}]interface{})[key]
+				if !ok {
+					return false, nil
+				}
+				val = v
+				fd = fd.GetMapValueType()
+			} else {
+				idx, _ := strconv.Atoi(el.index)
+				s := val.([]interface{})
+				if idx >= len(s) {
+					return false, nil
+				}
+				val = s[idx]
+			}
+			present = true
+		}
+	}
+	last := fe.path[len(fe.path)-1]
+	return present, toComparableValue(fd, val, !last.hasIndex, resolver)
+}
+
+func asDynamicMessage(v interface{}) (*dynamic.Message, bool) {
+	msg, ok := v.(proto.Message)
+	if !ok {
+		return nil, false
+	}
+	if dm, ok := msg.(*dynamic.Message); ok {
+		return dm, dm != nil
+	}
+	dm, err := dynamic.AsDynamicMessage(msg)
+	if err != nil {
+		return nil, false
+	}
+	return dm, true
+}
+
+// toComparableValue converts a field value, as returned from a dynamic message,
+// into the same kinds of values that result from decoding JSON: strings,
+// numbers, booleans, slices, and maps.
+func toComparableValue(fd *desc.FieldDescriptor, val interface{}, whole bool, resolver jsonpb.AnyResolver) interface{} {
+	if whole && fd.IsMap() {
+		m := map[string]interface{}{}
+		for k, v := range val.(map[interface{}]interface{}) {
+			m[fmt.Sprint(k)] = toComparableValue(fd.GetMapValueType(), v, true, resolver)
+		}
+		return m
+	}
+	if whole && fd.IsRepeated() {
+		s := val.([]interface{})
+		result := make([]interface{}, len(s))
+		for i, v := range s {
+			result[i] = toComparableValue(fd, v, false, resolver)
+		}
+		return result
+	}
+
+	switch v := val.(type) {
+	case proto.Message:
+		if dm, ok := v.(*dynamic.Message); ok && dm == nil {
+			return nil
+		}
+		marshaler := jsonpb.Marshaler{AnyResolver: resolver}
+		str, err := marshaler.MarshalToString(v)
+		if err != nil {
+			return nil
+		}
+		return parseExpectedValue(str)
+	case []byte:
+		return base64.StdEncoding.EncodeToString(v)
+	case int32:
+		if ed := fd.GetEnumType(); ed != nil {
+			if evd := ed.FindValueByNumber(v); evd != nil {
+				return evd.GetName()
+			}
+		}
+		return json.Number(strconv.FormatInt(int64(v), 10))
+	case int64:
+		return json.Number(strconv.FormatInt(v, 10))
+	case uint32:
+		return json.Number(strconv.FormatUint(uint64(v), 10))
+	case uint64:
+		return json.Number(strconv.FormatUint(v, 10))
+	case float32:
+		return json.Number(strconv.FormatFloat(float64(v), 'g', -1, 32))
+	case float64:
+		return json.Number(strconv.FormatFloat(v, 'g', -1, 64))
+	default:
+		// strings and bools
+		return v
+	}
+}
+
+func toNumber(v interface{}) (*big.Float, bool) {
+	switch v := v.(type) {
+	case json.Number:
+		return new(big.Float).SetString(string(v))
+	case string:
+		// 64-bit integers are formatted as strings in JSON
+		return new(big.Float).SetString(v)
+	default:
+		return nil, false
+	}
+}
+
+func valuesEqual(actual, expected interface{}) bool {
+	if a, ok := toNumber(actual); ok {
+		if e, ok := toNumber(expected); ok {
+			if _, isNum := actual.(json.Number); isNum {
+				return a.Cmp(e) == 0
+			}
+			if _, isNum := expected.(json.Number); isNum {
+				return a.Cmp(e) == 0
+			}
+		}
+	}
+	switch a := actual.(type) {
+	case []interface{}:
+		e, ok := expected.([]interface{})
+		if !ok || len(a) != len(e) {
+			return false
+		}
+		for i := range a {
+			if !valuesEqual(a[i], e[i]) {
+				return false
+			}
+		}
+		return true
+	case map[string]interface{}:
+		e, ok := expected.(map[string]interface{})
+		if !ok || len(a) != len(e) {
+			return false
+		}
+		for k, v := range a {
+			if ev, ok := e[k]; !ok || !valuesEqual(v, ev) {
+				return false
+			}
+		}
+		return true
+	default:
+		return actual == expected
+	}
+}
+
+func formatActualValue(v interface{}) string {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	return string(b)
+}
+
+// ExpectationHandler is an InvocationEventHandler that checks the events of an
+// RPC against a set of expectations. All events are also passed along to an
+// underlying handler. This is not thread-safe, but is safe for use with
+// InvokeRPC as long as Failures is not called until InvokeRPC completes.
+type ExpectationHandler struct {
+	InvocationEventHandler
+
+	resolver    jsonpb.AnyResolver
+	code        codes.Code
+	expHeaders  metadata.MD
+	expTrailers metadata.MD
+	fields      []*FieldExpectation
+	// validated is true if fields were already checked against the
+	// method's response type, when the handler was created
+	validated bool
+
+	respHeaders  metadata.MD
+	done         bool
+	status       *status.Status
+	numResponses int
+	failures     []ExpectationFailure
+}
+
+// NewExpectationHandler returns a handler that checks the given expectations
+// and delegates all events to the given handler. The given descriptor source is
+// used to resolve google.protobuf.Any messages when response fields are
+// compared. An error is returned if any of the expectations are malformed.
+//
+// The given method is the one that will be invoked. Field expectations are
+// checked against its response type, and an error is returned if any refers
+// to a field that the response type does not have, so that the RPC need not
+// be invoked at all. If the method is nil, they are instead checked when the
+// method is resolved, and any that are invalid are reported as failures.
+func NewExpectationHandler(handler InvocationEventHandler, source DescriptorSource, md *desc.MethodDescriptor, expectations *Expectations) (*ExpectationHandler, error) {
+	h := &ExpectationHandler{
+		InvocationEventHandler: handler,
+		resolver:               anyResolverWithFallback{AnyResolver: AnyResolverFromDescriptorSource(source)},
+		expHeaders:             MetadataFromHeaders(expectations.Headers),
+		expTrailers:            MetadataFromHeaders(expectations.Trailers),
+		validated:              md != nil,
+	}
+	if expectations.Code != "" {
+		c, err := ParseCode(expectations.Code)
+		if err != nil {
+			return nil, err
+		}
+		h.code = c
+	}
+	for _, f := range expectations.Fields {
+		fe, err := ParseFieldExpectation(f)
+		if err != nil {
+			return nil, err
+		}
+		if md != nil {
+			if err := fe.Validate(md.GetOutputType()); err != nil {
+				return nil, err
+			}
+		}
+		h.fields = append(h.fields, fe)
+	}
+	return h, nil
+}
+
+// ParseCode parses a status code from its name, in either "NotFound" or
+// "NOT_FOUND" form, or from its number.
+func ParseCode(s string) (codes.Code, error) {
+	if n, err := strconv.Atoi(s); err == nil {
+		if n >= int(codes.OK) && n <= int(codes.Unauthenticated) {
+			return codes.Code(n), nil
+		}
+	} else {
+		name := strings.ReplaceAll(s, "_", "")
+		for c := codes.OK; c <= codes.Unauthenticated; c++ {
+			if strings.EqualFold(c.String(), name) {
+				return c, nil
+			}
+		}
+		// handles the "CANCELLED" spelling
+		var c codes.Code
+		if err := c.UnmarshalJSON([]byte(strconv.Quote(strings.ToUpper(s)))); err == nil {
+			return c, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown status code: %s", s)
+}
+
+func (h *ExpectationHandler) OnResolveMethod(md *desc.MethodDescriptor) {
+	if !h.validated {
+		for _, fe := range h.fields {
+			if err := fe.Validate(md.GetOutputType()); err != nil {
+				h.failures = append(h.failures, ExpectationFailure{Subject: fe.String(), Expected: "valid expectation", Actual: err.Error()})
+			}
+		}
+	}
+	if h.InvocationEventHandler != nil {
+		h.InvocationEventHandler.OnResolveMethod(md)
+	}
+}
+
+func (h *ExpectationHandler) OnSendHeaders(md metadata.MD) {
+	if h.InvocationEventHandler != nil {
+		h.InvocationEventHandler.OnSendHeaders(md)
+	}
+}
+
+func (h *ExpectationHandler) OnSendRequest(req proto.Message, sentAt time.Time) {
+	notifySendRequest(h.InvocationEventHandler, req, sentAt)
+}
+
+func (h *ExpectationHandler) OnCloseSend(closedAt time.Time) {
+	notifyCloseSend(h.InvocationEventHandler, closedAt)
+}
+
+func (h *ExpectationHandler) OnReceivePeer(p *peer.Peer) {
+	notifyPeer(h.InvocationEventHandler, p)
+}
+
+func (h *ExpectationHandler) OnReceiveHeaders(md metadata.MD) {
+	h.respHeaders = md
+	if h.InvocationEventHandler != nil {
+		h.InvocationEventHandler.OnReceiveHeaders(md)
+	}
+}
+
+func (h *ExpectationHandler) OnReceiveResponse(resp proto.Message) {
+	for _, fe := range h.fields {
+		if fe.resolved == nil || (fe.response >= 0 && fe.response != h.numResponses) {
+			continue
+		}
+		if f := fe.check(resp, h.resolver); f != nil {
+			if fe.response < 0 {
+				f.Subject = fmt.Sprintf("response[%d].%s", h.numResponses, f.Subject)
+			} else {
+				f.Subject = fmt.Sprintf("[%d].%s", fe.response, f.Subject)
+			}
+			h.failures = append(h.failures, *f)
+		}
+	}
+	h.numResponses++
+	if h.InvocationEventHandler != nil {
+		h.InvocationEventHandler.OnReceiveResponse(resp)
+	}
+}
+
+func (h *ExpectationHandler) OnReceiveTrailers(stat *status.Status, md metadata.MD) {
+	// note that stat is nil when the RPC succeeds
+	h.done = true
+	h.status = stat
+	h.failures = append(h.failures, checkMetadata("trailer", h.expTrailers, md)...)
+	if h.InvocationEventHandler != nil {
+		h.InvocationEventHandler.OnReceiveTrailers(stat, md)
+	}
+}
+
+// Failures returns all expectations that were not met. It should be called
+// after the RPC completes, since some expectations (like the status code) can
+// only be checked at the end.
+func (h *ExpectationHandler) Failures() []ExpectationFailure {
+	failures := append([]ExpectationFailure(nil), h.failures...)
+
+	if !h.done {
+		failures = append(failures, ExpectationFailure{Subject: "status code", Expected: h.code.String(), Actual: "(no status)"})
+	} else if h.status.Code() != h.code {
+		actual := h.status.Code().String()
+		if h.status.Message() != "" {
+			actual += fmt.Sprintf(" (%s)", h.status.Message())
+		}
+		failures = append(failures, ExpectationFailure{Subject: "status code", Expected: h.code.String(), Actual: actual})
+	}
+
+	failures = append(failures, checkMetadata("header", h.expHeaders, h.respHeaders)...)
+
+	for _, fe := range h.fields {
+		if fe.resolved == nil {
+			continue
+		}
+		if fe.response >= h.numResponses {
+			failures = append(failures, ExpectationFailure{
+				Subject:  fmt.Sprintf("[%d].%s", fe.response, fe.pathString(len(fe.path))),
+				Expected: fmt.Sprintf("at least %d response(s)", fe.response+1),
+				Actual:   fmt.Sprintf("%d response(s)", h.numResponses),
+			})
+		} else if fe.response < 0 && h.numResponses == 0 && h.status.Code() == codes.OK {
+			failures = append(failures, ExpectationFailure{
+				Subject:  fe.pathString(len(fe.path)),
+				Expected: "at least 1 response",
+				Actual:   "0 responses",
+			})
+		}
+	}
+	return failures
+}
+
+func checkMetadata(kind string, expected, actual metadata.MD) []ExpectationFailure {
+	names := make([]string, 0, len(expected))
+	for name := range expected {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var failures []ExpectationFailure
+	for _, name := range names {
+		vals := expected[name]
+		got := actual.Get(name)
+		for _, want := range vals {
+			subject := fmt.Sprintf("%s %q", kind, name)
+			if len(got) == 0 {
+				exp := "present"
+				if want != "" {
+					exp = strconv.Quote(want)
+				}
+				failures = append(failures, ExpectationFailure{Subject: subject, Expected: exp, Actual: "(absent)"})
+				continue
+			}
+			if want == "" {
+				continue
+			}
+			found := false
+			for _, v := range got {
+				if v == want {
+					found = true
+					break
+				}
+			}
+			if !found {
+				failures = append(failures, ExpectationFailure{Subject: subject, Expected: strconv.Quote(want), Actual: formatActualValue(got)})
+			}
+		}
+	}
+	return failures
+}
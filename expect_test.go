@@ -0,0 +1,249 @@
+package grpcurl_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/jhump/protoreflect/desc"
+	"google.golang.org/grpc/codes"
+
+	. "github.com/tetrateio/grpcurl"
+)
+
+func TestParseFieldExpectation(t *testing.T) {
+	valid := []string{
+		"payload",
+		"!payload.type",
+		"payload.type == UNCOMPRESSABLE",
+		`payload.body == "AQID"`,
+		"payload.body =~ ^AQ",
+		"aggregated_payload_size >= 10",
+		"aggregatedPayloadSize < 100",
+		"[1].payload.body != AQID",
+		"items[0].labels[env] == prod",
+	}
+	for _, s := range valid {
+		if _, err := ParseFieldExpectation(s); err != nil {
+			t.Errorf("%q: unexpected error: %v", s, err)
+		}
+	}
+
+	invalid := []string{
+		"",
+		"== 1",
+		"payload..type",
+		"payload.body =~ (",
+		"size > abc",
+		"[x].payload",
+		"!payload == 1",
+	}
+	for _, s := range invalid {
+		if _, err := ParseFieldExpectation(s); err == nil {
+			t.Errorf("%q: expected error but got none", s)
+		}
+	}
+}
+
+func TestParseCode(t *testing.T) {
+	testCases := map[string]codes.Code{
+		"OK":                 codes.OK,
+		"0":                  codes.OK,
+		"NotFound":           codes.NotFound,
+		"NOT_FOUND":          codes.NotFound,
+		"not_found":          codes.NotFound,
+		"5":                  codes.NotFound,
+		"CANCELLED":          codes.Canceled,
+		"Canceled":           codes.Canceled,
+		"16":                 codes.Unauthenticated,
+		"RESOURCE_EXHAUSTED": codes.ResourceExhausted,
+	}
+	for s, expected := range testCases {
+		c, err := ParseCode(s)
+		if err != nil {
+			t.Errorf("%q: unexpected error: %v", s, err)
+		} else if c != expected {
+			t.Errorf("%q: expected %v, got %v", s, expected, c)
+		}
+	}
+	for _, s := range []string{"", "17", "-1", "Nope"} {
+		if _, err := ParseCode(s); err == nil {
+			t.Errorf("%q: expected error but got none", s)
+		}
+	}
+}
+
+func TestExpectationHandler(t *testing.T) {
+	testCases := []struct {
+		name     string
+		method   string
+		headers  []string
+		reqs     []string
+		exp      Expectations
+		failures []string
+	}{
+		{
+			name:   "all met",
+			method: "testing.TestService/UnaryCall",
+			headers: []string{
+				"reply-with-headers: foo: bar",
+				"reply-with-trailers: baz: buzz",
+			},
+			reqs: []string{payload3},
+			exp: Expectations{
+				Headers:  []string{"foo: bar"},
+				Trailers: []string{"baz"},
+				Fields: []string{
+					"payload",
+					"payload.type == UNCOMPRESSABLE",
+					"payload.type != 2",
+					"payload.body =~ ^SGlwaG9w",
+				},
+			},
+		},
+		{
+			name:   "fields not met",
+			method: "testing.TestService/UnaryCall",
+			reqs:   []string{payload1},
+			exp: Expectations{
+				Fields: []string{
+					"payload.type",
+					"payload.body == abc",
+					"payload.nope",
+				},
+			},
+			failures: []string{
+				`payload.nope: expected valid expectation, got invalid expectation "payload.nope": message testing.Payload has no field named "nope"; its fields are body, type`,
+				`response[0].payload.type: expected present, got (absent)`,
+				`response[0].payload.body: expected == "abc", got "SXQncyBCdXNpbmVzcyBUaW1l"`,
+			},
+		},
+		{
+			name:    "headers not met",
+			method:  "testing.TestService/UnaryCall",
+			headers: []string{"reply-with-headers: foo: bar"},
+			reqs:    []string{payload1},
+			exp: Expectations{
+				Headers:  []string{"foo: baz", "abc"},
+				Trailers: []string{"foo"},
+			},
+			failures: []string{
+				`trailer "foo": expected present, got (absent)`,
+				`header "abc": expected present, got (absent)`,
+				`header "foo": expected "baz", got ["bar"]`,
+			},
+		},
+		{
+			name:    "expected error code",
+			method:  "testing.TestService/UnaryCall",
+			headers: []string{"fail-early: 5"},
+			reqs:    []string{payload1},
+			exp: Expectations{
+				Code:   "NOT_FOUND",
+				Fields: []string{"payload"},
+			},
+		},
+		{
+			name:    "unexpected error code",
+			method:  "testing.TestService/UnaryCall",
+			headers: []string{"fail-early: 5"},
+			reqs:    []string{payload1},
+			failures: []string{
+				`status code: expected OK, got NotFound (fail)`,
+			},
+		},
+		{
+			name:   "numeric comparisons",
+			method: "testing.TestService/StreamingInputCall",
+			reqs:   []string{payload1, payload2, payload3},
+			exp: Expectations{
+				Fields: []string{
+					"aggregated_payload_size == 61",
+					"aggregatedPayloadSize > 60",
+					"aggregatedPayloadSize <= 61",
+					"[0].aggregatedPayloadSize < 61",
+					"[1].aggregatedPayloadSize",
+				},
+			},
+			failures: []string{
+				`[0].aggregatedPayloadSize: expected < 61, got 61`,
+				`[1].aggregatedPayloadSize: expected at least 2 response(s), got 1 response(s)`,
+			},
+		},
+	}
+
+	for _, ds := range descSources {
+		for _, tc := range testCases {
+			t.Run(ds.name+"/"+tc.name, func(t *testing.T) {
+				h := &handler{reqMessages: tc.reqs}
+				eh, err := NewExpectationHandler(h, ds.source, nil, &tc.exp)
+				if err != nil {
+					t.Fatalf("failed to create expectation handler: %v", err)
+				}
+				err = InvokeRpc(context.Background(), ds.source, getCC(ds.includeRefl), tc.method, tc.headers, eh, h.getRequestData)
+				if err != nil {
+					t.Fatalf("unexpected error during RPC: %v", err)
+				}
+				// the delegate handler must still see all events
+				if h.methodCount != 1 || h.respTrailersCount != 1 {
+					t.Errorf("events were not passed to delegate handler")
+				}
+				var actual []string
+				for _, f := range eh.Failures() {
+					actual = append(actual, f.String())
+				}
+				if strings.Join(actual, "\n") != strings.Join(tc.failures, "\n") {
+					t.Errorf("wrong failures:\nexpected:\n%s\nactual:\n%s", strings.Join(tc.failures, "\n"), strings.Join(actual, "\n"))
+				}
+			})
+		}
+	}
+}
+
+func TestNewExpectationHandler_InvalidField(t *testing.T) {
+	d, err := sourceProtoset.FindSymbol("testing.TestService.UnaryCall")
+	if err != nil {
+		t.Fatalf("failed to find method: %v", err)
+	}
+	md := d.(*desc.MethodDescriptor)
+	// checked before the RPC is invoked, so that it need not be invoked
+	_, err = NewExpectationHandler(&handler{}, sourceProtoset, md, &Expectations{Fields: []string{"payload.type", "payload.nope"}})
+	expected := `invalid expectation "payload.nope": message testing.Payload has no field named "nope"`
+	if err == nil || !strings.Contains(err.Error(), expected) {
+		t.Errorf("expected error %q, got %v", expected, err)
+	}
+	if _, err := NewExpectationHandler(&handler{}, sourceProtoset, md, &Expectations{Fields: []string{"payload.type"}}); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestReplayCallsWithExpectations(t *testing.T) {
+	log := `
+{"method": "testing.TestService/UnaryCall", "request": {"payload": {"body": "AQID"}}, "expect": {"fields": ["payload.body == AQID"]}}
+{"method": "testing.TestService/UnaryCall", "headers": ["fail-early: 5"], "expect": {"code": "NotFound"}}
+{"method": "testing.TestService/UnaryCall", "expect": {"code": "Internal"}}
+{"method": "testing.TestService/UnaryCall", "expect": {"fields": ["payload.nope["]}}
+`
+	var out strings.Builder
+	results, err := ReplayCalls(context.Background(), sourceProtoset, ccNoReflect, nil, strings.NewReader(log), &out)
+	if err != nil {
+		t.Fatalf("failed to replay calls: %v", err)
+	}
+	expectedPassed := []bool{true, true, false, false}
+	if len(results) != len(expectedPassed) {
+		t.Fatalf("wrong number of results: expected %d, got %d", len(expectedPassed), len(results))
+	}
+	for i, res := range results {
+		if res.Passed == nil {
+			t.Errorf("result %d: passed should be set", i+1)
+		} else if *res.Passed != expectedPassed[i] {
+			t.Errorf("result %d: expected passed to be %v; failures: %v", i+1, expectedPassed[i], res.Failures)
+		}
+	}
+	if len(results[2].Failures) != 1 || results[2].Failures[0].Subject != "status code" {
+		t.Errorf("result 3: wrong failures: %v", results[2].Failures)
+	}
+	if !strings.Contains(results[3].Error, "payload.nope[") {
+		t.Errorf("result 4: wrong error: %s", results[3].Error)
+	}
+}
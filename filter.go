@@ -0,0 +1,310 @@
+package grpcurl
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/golang/protobuf/jsonpb" //lint:ignore SA1019 we have to import this because it appears in exported API
+	"github.com/golang/protobuf/proto"  //lint:ignore SA1019 we have to import this because it appears in exported API
+	"github.com/jhump/protoreflect/desc"
+	"github.com/jhump/protoreflect/dynamic"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// FieldMask selects some of the fields of messages of one type, like a
+// google.protobuf.FieldMask. It is created with NewFieldMask.
+type FieldMask struct {
+	md   *desc.MessageDescriptor
+	root *fieldMaskNode
+}
+
+// fieldMaskNode is a field selected by a FieldMask. If children is nil, the
+// whole field is selected. Otherwise, only the given fields of its message
+// values are selected.
+type fieldMaskNode struct {
+	fd       *desc.FieldDescriptor
+	children map[int32]*fieldMaskNode
+}
+
+// NewFieldMask returns a field mask that selects the given paths of messages
+// of the given type. Each path is a dot-separated list of field names (the
+// names in the proto source or their JSON names), like "customer.name". Unlike
+// in a google.protobuf.FieldMask, a path may go through a repeated or map
+// field whose values are messages, in which case the rest of the path applies
+// to each of its values: "accounts.balance_cents" selects just the balance of
+// every account. An error is returned if any path does not refer to a field.
+func NewFieldMask(md *desc.MessageDescriptor, paths []string) (*FieldMask, error) {
+	if len(paths) == 0 {
+		return nil, fmt.Errorf("no field paths given")
+	}
+	root := &fieldMaskNode{children: map[int32]*fieldMaskNode{}}
+	for _, path := range paths {
+		node := root
+		msgType := md
+		names := strings.Split(strings.TrimSpace(path), ".")
+		for i, name := range names {
+			if msgType == nil {
+				return nil, fmt.Errorf("invalid field path %q: %s is not a message, so it has no field named %q", path, strings.Join(names[:i], "."), name)
+			}
+			fd := findFieldByNameOrJSONName(msgType, name)
+			if fd == nil {
+				return nil, fmt.Errorf("invalid field path %q: %s", path, noSuchFieldMessage(msgType, name))
+			}
+			if node.children == nil {
+				// a shorter path already selects the whole field
+				break
+			}
+			child := node.children[fd.GetNumber()]
+			if child == nil {
+				child = &fieldMaskNode{fd: fd, children: map[int32]*fieldMaskNode{}}
+				node.children[fd.GetNumber()] = child
+			}
+			if i == len(names)-1 {
+				child.children = nil
+			}
+			node = child
+			if fd.IsMap() {
+				msgType = fd.GetMapValueType().GetMessageType()
+			} else {
+				msgType = fd.GetMessageType()
+			}
+		}
+	}
+	return &FieldMask{md: md, root: root}, nil
+}
+
+// noSuchFieldMessage describes the lack of a field with the given name in the
+// given message type, suggesting the field that was probably meant.
+func noSuchFieldMessage(md *desc.MessageDescriptor, name string) string {
+	msg := fmt.Sprintf("message %s has no field named %q", md.GetFullyQualifiedName(), name)
+	best, bestDist := "", len(name)/3+1
+	var names []string
+	for _, fd := range md.GetFields() {
+		names = append(names, fd.GetName())
+		for _, candidate := range []string{fd.GetName(), fd.GetJSONName()} {
+			if d := editDistance(strings.ToLower(name), strings.ToLower(candidate)); d < bestDist {
+				best, bestDist = fd.GetName(), d
+			}
+		}
+	}
+	if best != "" {
+		return msg + fmt.Sprintf("; did you mean %q?", best)
+	}
+	if len(names) == 0 {
+		return msg + "; it has no fields"
+	}
+	sort.Strings(names)
+	return msg + fmt.Sprintf("; its fields are %s", strings.Join(names, ", "))
+}
+
+// editDistance returns the Levenshtein distance between the given strings.
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min3(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
+
+func min3(a, b, c int) int {
+	if b < a {
+		a = b
+	}
+	if c < a {
+		a = c
+	}
+	return a
+}
+
+// Apply returns a copy of the given message that has only the fields selected
+// by the mask. The message must be of the type given to NewFieldMask.
+func (m *FieldMask) Apply(msg proto.Message) (proto.Message, error) {
+	dm, ok := asDynamicMessage(msg)
+	if !ok {
+		return nil, fmt.Errorf("could not convert %T to a dynamic message", msg)
+	}
+	if dm.GetMessageDescriptor().GetFullyQualifiedName() != m.md.GetFullyQualifiedName() {
+		return nil, fmt.Errorf("field mask is for message %s, not %s", m.md.GetFullyQualifiedName(), dm.GetMessageDescriptor().GetFullyQualifiedName())
+	}
+	return m.root.apply(dm)
+}
+
+func (n *fieldMaskNode) apply(src *dynamic.Message) (*dynamic.Message, error) {
+	dst := dynamic.NewMessageFactoryWithDefaults().NewDynamicMessage(src.GetMessageDescriptor())
+	for _, child := range n.children {
+		fd := child.fd
+		if !src.HasField(fd) {
+			continue
+		}
+		val := src.GetField(fd)
+		if child.children != nil {
+			var err error
+			if val, err = child.applyToValue(val); err != nil {
+				return nil, err
+			}
+		}
+		if err := dst.TrySetField(fd, val); err != nil {
+			return nil, err
+		}
+	}
+	return dst, nil
+}
+
+// applyToValue applies the node to the value of its field, which holds
+// messages: each message in a map or repeated field, or the single message.
+func (n *fieldMaskNode) applyToValue(val interface{}) (interface{}, error) {
+	project := func(v interface{}) (interface{}, error) {
+		dm, ok := asDynamicMessage(v)
+		if !ok {
+			return nil, fmt.Errorf("could not convert %T to a dynamic message", v)
+		}
+		return n.apply(dm)
+	}
+	switch {
+	case n.fd.IsMap():
+		m := map[interface{}]interface{}{}
+		for k, v := range val.(map[interface{}]interface{}) {
+			projected, err := project(v)
+			if err != nil {
+				return nil, err
+			}
+			m[k] = projected
+		}
+		return m, nil
+	case n.fd.IsRepeated():
+		s := val.([]interface{})
+		result := make([]interface{}, len(s))
+		for i, v := range s {
+			projected, err := project(v)
+			if err != nil {
+				return nil, err
+			}
+			result[i] = projected
+		}
+		return result, nil
+	default:
+		return project(val)
+	}
+}
+
+// ResponseFilter selects response messages that match a set of expressions.
+// It is created with NewResponseFilter.
+type ResponseFilter struct {
+	exprs    []*FieldExpectation
+	resolver jsonpb.AnyResolver
+}
+
+// NewResponseFilter returns a filter that matches messages of the given type
+// for which all of the given expressions hold. The expressions have the same
+// syntax as field expectations (see ParseFieldExpectation), except that they
+// may not select a response by index. The given descriptor source is used to
+// resolve google.protobuf.Any messages when fields are compared. An error is
+// returned if any expression is malformed or refers to a field that the
+// message type does not have.
+func NewResponseFilter(md *desc.MessageDescriptor, source DescriptorSource, exprs []string) (*ResponseFilter, error) {
+	f := &ResponseFilter{resolver: anyResolverWithFallback{AnyResolver: AnyResolverFromDescriptorSource(source)}}
+	for _, expr := range exprs {
+		fe, err := ParseFieldExpectation(expr)
+		if err != nil {
+			return nil, err
+		}
+		if fe.response >= 0 {
+			return nil, fmt.Errorf("invalid filter %q: filters apply to each response, so they cannot select one by index", expr)
+		}
+		if err := fe.Validate(md); err != nil {
+			return nil, err
+		}
+		f.exprs = append(f.exprs, fe)
+	}
+	return f, nil
+}
+
+// Matches returns true if all of the filter's expressions hold for the given
+// message.
+func (f *ResponseFilter) Matches(msg proto.Message) bool {
+	for _, fe := range f.exprs {
+		if fe.check(msg, f.resolver) != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// ResponseFilterHandler is an InvocationEventHandler that passes only the
+// response messages that match a filter, with only the fields selected by a
+// field mask, to an underlying handler. All other events are passed along
+// unchanged. This is not thread-safe, but is safe for use with InvokeRPC as
+// long as NumFiltered is not read until InvokeRPC completes.
+type ResponseFilterHandler struct {
+	InvocationEventHandler
+
+	filter *ResponseFilter
+	fields *FieldMask
+
+	// NumFiltered is the number of response messages that did not match the
+	// filter, so were not passed along.
+	NumFiltered int
+}
+
+// NewResponseFilterHandler returns a handler that filters and projects
+// response messages and delegates all events to the given handler. Either of
+// the given filter and field mask may be nil, to pass along all messages or
+// all of their fields, respectively.
+func NewResponseFilterHandler(handler InvocationEventHandler, filter *ResponseFilter, fields *FieldMask) *ResponseFilterHandler {
+	return &ResponseFilterHandler{InvocationEventHandler: handler, filter: filter, fields: fields}
+}
+
+var _ PeerEventHandler = (*ResponseFilterHandler)(nil)
+var _ RequestEventHandler = (*ResponseFilterHandler)(nil)
+
+func (h *ResponseFilterHandler) OnSendRequest(req proto.Message, sentAt time.Time) {
+	notifySendRequest(h.InvocationEventHandler, req, sentAt)
+}
+
+func (h *ResponseFilterHandler) OnCloseSend(closedAt time.Time) {
+	notifyCloseSend(h.InvocationEventHandler, closedAt)
+}
+
+func (h *ResponseFilterHandler) OnReceivePeer(p *peer.Peer) {
+	notifyPeer(h.InvocationEventHandler, p)
+}
+
+func (h *ResponseFilterHandler) OnReceiveHeaders(md metadata.MD) {
+	h.InvocationEventHandler.OnReceiveHeaders(md)
+}
+
+func (h *ResponseFilterHandler) OnReceiveResponse(resp proto.Message) {
+	if h.filter != nil && !h.filter.Matches(resp) {
+		h.NumFiltered++
+		return
+	}
+	if h.fields != nil {
+		projected, err := h.fields.Apply(resp)
+		if err == nil {
+			// should not fail, since the mask was created for the method's
+			// output type; if it does, pass along the whole message
+			resp = projected
+		}
+	}
+	h.InvocationEventHandler.OnReceiveResponse(resp)
+}
+
+func (h *ResponseFilterHandler) OnReceiveTrailers(stat *status.Status, md metadata.MD) {
+	h.InvocationEventHandler.OnReceiveTrailers(stat, md)
+}
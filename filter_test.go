@@ -0,0 +1,152 @@
+package grpcurl_test
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/golang/protobuf/jsonpb" //lint:ignore SA1019 we have to import this because it appears in exported API
+	"github.com/jhump/protoreflect/desc"
+	"github.com/jhump/protoreflect/dynamic"
+
+	. "github.com/tetrateio/grpcurl"
+)
+
+func TestNewFieldMask_Errors(t *testing.T) {
+	md := findMessage(t, "testing.StreamingOutputCallResponse")
+	testCases := []struct {
+		paths []string
+		err   string
+	}{
+		{paths: nil, err: "no field paths given"},
+		{paths: []string{"payload.bdy"}, err: `invalid field path "payload.bdy": message testing.Payload has no field named "bdy"; did you mean "body"?`},
+		{paths: []string{"paylaod"}, err: `message testing.StreamingOutputCallResponse has no field named "paylaod"; did you mean "payload"?`},
+		{paths: []string{"payload", "nope"}, err: `message testing.StreamingOutputCallResponse has no field named "nope"; its fields are payload`},
+		{paths: []string{"payload.body.size"}, err: `payload.body is not a message, so it has no field named "size"`},
+	}
+	for _, tc := range testCases {
+		_, err := NewFieldMask(md, tc.paths)
+		if err == nil || !strings.Contains(err.Error(), tc.err) {
+			t.Errorf("%v: expecting error %q, got %v", tc.paths, tc.err, err)
+		}
+	}
+}
+
+func TestFieldMask_Apply(t *testing.T) {
+	md := findMessage(t, "testing.SimpleResponse")
+	msg := dynamic.NewMessage(md)
+	if err := jsonpb.UnmarshalString(`{"payload": {"type": "RANDOM", "body": "AQID"}, "username": "joe", "oauthScope": "all"}`, msg); err != nil {
+		t.Fatalf("failed to parse message: %v", err)
+	}
+
+	testCases := []struct {
+		paths []string
+		json  string
+		text  string
+	}{
+		{paths: []string{"payload.body"}, json: `{"payload":{"body":"AQID"}}`, text: `payload:<body:"\001\002\003">`},
+		{paths: []string{"username", "oauthScope"}, json: `{"username":"joe","oauthScope":"all"}`, text: `username:"joe"oauth_scope:"all"`},
+		// the shorter path selects the whole field
+		{paths: []string{"payload.type", "payload"}, json: `{"payload":{"type":"RANDOM","body":"AQID"}}`, text: `payload:<type:RANDOMbody:"\001\002\003">`},
+	}
+	jsonFormatter := NewJSONFormatter(false, nil)
+	textFormatter := NewTextFormatter(false)
+	for _, tc := range testCases {
+		mask, err := NewFieldMask(md, tc.paths)
+		if err != nil {
+			t.Fatalf("%v: failed to create field mask: %v", tc.paths, err)
+		}
+		projected, err := mask.Apply(msg)
+		if err != nil {
+			t.Fatalf("%v: failed to apply field mask: %v", tc.paths, err)
+		}
+		if js, err := jsonFormatter(projected); err != nil {
+			t.Errorf("%v: failed to format as JSON: %v", tc.paths, err)
+		} else if compact(js) != tc.json {
+			t.Errorf("%v: wrong JSON: expecting %s, got %s", tc.paths, tc.json, compact(js))
+		}
+		if txt, err := textFormatter(projected); err != nil {
+			t.Errorf("%v: failed to format as text: %v", tc.paths, err)
+		} else if compact(txt) != tc.text {
+			t.Errorf("%v: wrong text: expecting %s, got %s", tc.paths, tc.text, compact(txt))
+		}
+	}
+
+	mask, err := NewFieldMask(findMessage(t, "testing.Payload"), []string{"body"})
+	if err != nil {
+		t.Fatalf("failed to create field mask: %v", err)
+	}
+	if _, err := mask.Apply(msg); err == nil {
+		t.Errorf("expecting error when applying a mask to a message of another type")
+	}
+}
+
+func TestNewResponseFilter_Errors(t *testing.T) {
+	md := findMessage(t, "testing.StreamingOutputCallResponse")
+	testCases := []struct {
+		expr string
+		err  string
+	}{
+		{expr: "payload.bdy == AQID", err: `message testing.Payload has no field named "bdy"; did you mean "body"?`},
+		{expr: "[0].payload", err: "cannot select one by index"},
+		{expr: "payload.body =~ (", err: "invalid expectation"},
+	}
+	for _, tc := range testCases {
+		_, err := NewResponseFilter(md, sourceProtoset, []string{tc.expr})
+		if err == nil || !strings.Contains(err.Error(), tc.err) {
+			t.Errorf("%s: expecting error %q, got %v", tc.expr, tc.err, err)
+		}
+	}
+}
+
+func TestResponseFilterHandler(t *testing.T) {
+	req := `{"response_parameters": [{"size": 1}, {"size": 2}, {"size": 3}, {"size": 4}]}`
+	md := findMessage(t, "testing.StreamingOutputCallResponse")
+	for _, ds := range descSources {
+		t.Run(ds.name, func(t *testing.T) {
+			// the bodies of the responses are AA==, AAE=, AAEC, and AAECAw==
+			filter, err := NewResponseFilter(md, ds.source, []string{"payload.body != AAEC", "payload.body =~ ^AA"})
+			if err != nil {
+				t.Fatalf("failed to create filter: %v", err)
+			}
+			mask, err := NewFieldMask(md, []string{"payload.body"})
+			if err != nil {
+				t.Fatalf("failed to create field mask: %v", err)
+			}
+			rf, formatter, err := RequestParserAndFormatter(FormatJSON, ds.source, strings.NewReader(req), FormatOptions{})
+			if err != nil {
+				t.Fatalf("failed to create request parser: %v", err)
+			}
+			var out bytes.Buffer
+			h := &DefaultEventHandler{Out: &out, Formatter: formatter}
+			fh := NewResponseFilterHandler(h, filter, mask)
+			err = InvokeRPC(context.Background(), ds.source, getCC(ds.includeRefl), "testing.TestService/StreamingOutputCall", nil, fh, rf.Next)
+			if err != nil {
+				t.Fatalf("unexpected error during RPC: %v", err)
+			}
+			if h.NumResponses != 3 || fh.NumFiltered != 1 {
+				t.Errorf("expecting 3 responses and 1 filtered out, got %d and %d", h.NumResponses, fh.NumFiltered)
+			}
+			expected := `{"payload":{"body":"AA=="}}{"payload":{"body":"AAE="}}{"payload":{"body":"AAECAw=="}}`
+			if actual := compact(out.String()); actual != expected {
+				t.Errorf("wrong output:\nexpecting %s\ngot %s", expected, actual)
+			}
+		})
+	}
+}
+
+func findMessage(t *testing.T, name string) *desc.MessageDescriptor {
+	t.Helper()
+	d, err := sourceProtoset.FindSymbol(name)
+	if err != nil {
+		t.Fatalf("failed to find %s: %v", name, err)
+	}
+	return d.(*desc.MessageDescriptor)
+}
+
+// compact removes all whitespace from formatted messages, so they can be
+// compared regardless of indentation.
+func compact(s string) string {
+	return strings.Join(strings.Fields(s), "")
+}
@@ -4,12 +4,15 @@ import (
 	"bufio"
 	"bytes"
 	"encoding/base64"
+	"encoding/binary"
 	"encoding/json"
 	"fmt"
 	"io"
+	"math"
 	"reflect"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/golang/protobuf/jsonpb" //lint:ignore SA1019 we have to import this because it appears in exported API
 	"github.com/golang/protobuf/proto"  //lint:ignore SA1019 we have to import this because it appears in exported API
@@ -17,7 +20,9 @@ import (
 	"github.com/jhump/protoreflect/dynamic"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
 	"google.golang.org/grpc/status"
+	"gopkg.in/yaml.v3"
 )
 
 // RequestParser processes input into messages.
@@ -126,6 +131,163 @@ func (f *textRequestParser) NumRequests() int {
 	return f.requestCount
 }
 
+type yamlRequestParser struct {
+	dec          *yaml.Decoder
+	unmarshaler  jsonpb.Unmarshaler
+	requestCount int
+}
+
+// NewYAMLRequestParser returns a RequestParser that reads data in YAML format
+// from the given reader. The data is interpreted using the same mapping rules
+// as for JSON, so the given unmarshaler is used to decode each message after it
+// is converted to JSON.
+//
+// Input data that contains more than one message should include all messages
+// as separate YAML documents, separated by "---" lines. Each document is one
+// message. Empty documents are ignored, so an empty message must be written
+// as an empty mapping: "{}".
+//
+// If the given reader has no data, the returned parser will return io.EOF on
+// the very first call.
+func NewYAMLRequestParser(in io.Reader, unmarshaler jsonpb.Unmarshaler) RequestParser {
+	return &yamlRequestParser{
+		dec:         yaml.NewDecoder(in),
+		unmarshaler: unmarshaler,
+	}
+}
+
+func (f *yamlRequestParser) Next(m proto.Message) error {
+	var doc interface{}
+	for {
+		if err := f.dec.Decode(&doc); err != nil {
+			return err
+		}
+		// skip empty documents, like after a trailing "---"
+		if doc != nil {
+			break
+		}
+	}
+	f.requestCount++
+	val, err := yamlToJSONValue(doc)
+	if err != nil {
+		return err
+	}
+	js, err := json.Marshal(val)
+	if err != nil {
+		return err
+	}
+	return f.unmarshaler.Unmarshal(bytes.NewReader(js), m)
+}
+
+func (f *yamlRequestParser) NumRequests() int {
+	return f.requestCount
+}
+
+// yamlToJSONValue converts a value decoded from YAML into one that can be
+// encoded to JSON. The main difference is that YAML mappings may have keys that
+// are not strings, which JSON objects do not allow.
+func yamlToJSONValue(v interface{}) (interface{}, error) {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for k, e := range v {
+			var err error
+			if v[k], err = yamlToJSONValue(e); err != nil {
+				return nil, err
+			}
+		}
+		return v, nil
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for k, e := range v {
+			switch k.(type) {
+			case string, bool, int, int64, uint64, float64:
+			default:
+				return nil, fmt.Errorf("unsupported YAML mapping key: %v", k)
+			}
+			var err error
+			if m[fmt.Sprint(k)], err = yamlToJSONValue(e); err != nil {
+				return nil, err
+			}
+		}
+		return m, nil
+	case []interface{}:
+		for i, e := range v {
+			var err error
+			if v[i], err = yamlToJSONValue(e); err != nil {
+				return nil, err
+			}
+		}
+		return v, nil
+	case float64:
+		// JSON has no representation for these, but the proto3 JSON mapping
+		// represents them as strings
+		switch {
+		case math.IsNaN(v):
+			return "NaN", nil
+		case math.IsInf(v, 1):
+			return "Infinity", nil
+		case math.IsInf(v, -1):
+			return "-Infinity", nil
+		}
+		return v, nil
+	case time.Time:
+		return v.Format(time.RFC3339Nano), nil
+	case []byte:
+		return base64.StdEncoding.EncodeToString(v), nil
+	default:
+		return v, nil
+	}
+}
+
+type binaryRequestParser struct {
+	r            *bufio.Reader
+	requestCount int
+}
+
+// NewBinaryRequestParser returns a RequestParser that reads data in the
+// protobuf binary format from the given reader.
+//
+// Each message must be length-delimited: it is preceded by its size in bytes,
+// encoded as a varint. So input data that contains more than one message
+// should just include all such size-prefixed messages concatenated. This is
+// the same framing that is written by NewBinaryFormatter.
+//
+// If the given reader has no data, the returned parser will return io.EOF on
+// the very first call.
+func NewBinaryRequestParser(in io.Reader) RequestParser {
+	return &binaryRequestParser{r: bufio.NewReader(in)}
+}
+
+func (f *binaryRequestParser) Next(m proto.Message) error {
+	size, err := binary.ReadUvarint(f.r)
+	if err != nil {
+		if err == io.EOF {
+			return err
+		}
+		return fmt.Errorf("failed to read size of message %d: %v", f.requestCount+1, err)
+	}
+	// Protobuf messages can't be 2GB or larger, so a larger size means the
+	// input is corrupt.
+	if size > math.MaxInt32 {
+		return fmt.Errorf("failed to read message %d: message size %d too large", f.requestCount+1, size)
+	}
+	// We copy into a buffer instead of allocating a slice of the indicated
+	// size so that corrupt input doesn't cause a huge allocation.
+	var buf bytes.Buffer
+	if _, err := io.CopyN(&buf, f.r, int64(size)); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return fmt.Errorf("failed to read message %d: %v", f.requestCount+1, err)
+	}
+	f.requestCount++
+	return proto.Unmarshal(buf.Bytes(), m)
+}
+
+func (f *binaryRequestParser) NumRequests() int {
+	return f.requestCount
+}
+
 // Formatter translates messages into string representations.
 type Formatter func(proto.Message) (string, error)
 
@@ -151,6 +313,97 @@ func NewTextFormatter(includeSeparator bool) Formatter {
 	return tf.format
 }
 
+// NewYAMLFormatter returns a formatter that returns YAML strings. The messages
+// are first converted to JSON, so the YAML has the same structure as the JSON
+// produced by NewJSONFormatter, given the same emitDefaults and resolver. When
+// invoked to format multiple messages, all messages after the first one are
+// prefixed with a "---" document separator line, so that the output can be
+// parsed by NewYAMLRequestParser.
+func NewYAMLFormatter(emitDefaults bool, resolver jsonpb.AnyResolver) Formatter {
+	yf := yamlFormatter{
+		marshaler: jsonpb.Marshaler{
+			EmitDefaults: emitDefaults,
+			AnyResolver:  resolver,
+		},
+	}
+	return yf.format
+}
+
+type yamlFormatter struct {
+	marshaler    jsonpb.Marshaler
+	numFormatted int
+}
+
+func (yf *yamlFormatter) format(m proto.Message) (string, error) {
+	js, err := yf.marshaler.MarshalToString(m)
+	if err != nil {
+		return "", err
+	}
+	// JSON is also YAML, so we can parse it into a node tree. This preserves
+	// the order of fields, unlike decoding to a map.
+	var node yaml.Node
+	if err := yaml.Unmarshal([]byte(js), &node); err != nil {
+		return "", err
+	}
+	clearYAMLStyle(&node)
+
+	var buf bytes.Buffer
+	if yf.numFormatted > 0 {
+		buf.WriteString("---\n")
+	}
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(&node); err != nil {
+		return "", err
+	}
+	if err := enc.Close(); err != nil {
+		return "", err
+	}
+
+	// no trailing newline needed
+	str := buf.String()
+	if len(str) > 0 && str[len(str)-1] == '\n' {
+		str = str[:len(str)-1]
+	}
+
+	yf.numFormatted++
+
+	return str, nil
+}
+
+// clearYAMLStyle resets the style of the given node and all of its descendants,
+// so that JSON parsed as YAML is formatted in block style with plain scalars
+// (which are still quoted when necessary to preserve their type).
+func clearYAMLStyle(node *yaml.Node) {
+	node.Style = 0
+	for _, n := range node.Content {
+		clearYAMLStyle(n)
+	}
+}
+
+// NewBinaryFormatter returns a formatter that returns messages in the protobuf
+// binary format. Each message is prefixed with its size in bytes, encoded as a
+// varint, so the output for multiple messages can be concatenated and then
+// parsed by NewBinaryRequestParser. Since the strings are binary data, they
+// should be written as is, with nothing (like a newline) added between them.
+func NewBinaryFormatter() Formatter {
+	return formatBinary
+}
+
+func formatBinary(m proto.Message) (string, error) {
+	b, err := proto.Marshal(m)
+	if err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	var sizeBytes [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(sizeBytes[:], uint64(len(b)))
+	buf.Grow(n + len(b))
+	buf.Write(sizeBytes[:n])
+	buf.Write(b)
+	return buf.String(), nil
+}
+
 type textFormatter struct {
 	useSeparator bool
 	numFormatted int
@@ -197,7 +450,8 @@ func (tf *textFormatter) format(m proto.Message) (string, error) {
 	return str, nil
 }
 
-// Format of request data. The allowed values are 'json' or 'text'.
+// Format of request data. The allowed values are 'json', 'text', 'yaml' or
+// 'binary'.
 type Format string
 
 const (
@@ -212,6 +466,16 @@ const (
 	// If it does, it will be interpreted as a final, blank message after the
 	// separator.
 	FormatText = Format("text")
+
+	// FormatYAML specifies input data in YAML format. The data is interpreted
+	// using the same rules as for JSON. Multiple request values must be
+	// separate YAML documents, delimited by "---" lines.
+	FormatYAML = Format("yaml")
+
+	// FormatBinary specifies input data must be in the protobuf binary format.
+	// Each message must be preceded by its size in bytes, encoded as a varint.
+	// Multiple request values are simply concatenated.
+	FormatBinary = Format("binary")
 )
 
 // AnyResolverFromDescriptorSource returns an AnyResolver that will search for
@@ -365,13 +629,13 @@ var _ proto.Message = (*unknownAny)(nil)
 // FormatOptions is a set of flags that are passed to a JSON or text formatter.
 type FormatOptions struct {
 	// EmitJSONDefaultFields flag, when true, includes empty/default values in the output.
-	// FormatJSON only flag.
+	// FormatJSON and FormatYAML only flag.
 	EmitJSONDefaultFields bool
 
 	// AllowUnknownFields is an option for the parser. When true,
 	// it accepts input which includes unknown fields. These unknown fields
 	// are skipped instead of returning an error.
-	// FormatJSON only flag.
+	// FormatJSON and FormatYAML only flag.
 	AllowUnknownFields bool
 
 	// IncludeTextSeparator is true then, when invoked to format multiple messages,
@@ -397,6 +661,12 @@ func RequestParserAndFormatter(format Format, descSource DescriptorSource, in io
 		return NewJSONRequestParserWithUnmarshaler(in, unmarshaler), NewJSONFormatter(opts.EmitJSONDefaultFields, anyResolverWithFallback{AnyResolver: resolver}), nil
 	case FormatText:
 		return NewTextRequestParser(in), NewTextFormatter(opts.IncludeTextSeparator), nil
+	case FormatYAML:
+		resolver := AnyResolverFromDescriptorSource(descSource)
+		unmarshaler := jsonpb.Unmarshaler{AnyResolver: resolver, AllowUnknownFields: opts.AllowUnknownFields}
+		return NewYAMLRequestParser(in, unmarshaler), NewYAMLFormatter(opts.EmitJSONDefaultFields, anyResolverWithFallback{AnyResolver: resolver}), nil
+	case FormatBinary:
+		return NewBinaryRequestParser(in), NewBinaryFormatter(), nil
 	default:
 		return nil, nil, fmt.Errorf("unknown format: %s", format)
 	}
@@ -422,6 +692,13 @@ func RequestParserAndFormatterFor(format Format, descSource DescriptorSource, em
 type DefaultEventHandler struct {
 	Out       io.Writer
 	Formatter Formatter
+	// RequestFormatter, if not nil, is used to show request messages when
+	// VerbosityLevel is at least 1. It must not be the same as Formatter,
+	// since some formatters, like those for text and YAML, keep track of how
+	// many messages they have formatted, to separate them. It is not set by
+	// NewDefaultEventHandler, so callers that want request messages shown
+	// must set it themselves.
+	RequestFormatter Formatter
 	// 0 = default
 	// 1 = verbose
 	// 2 = very verbose
@@ -432,11 +709,18 @@ type DefaultEventHandler struct {
 	// Status is the status that was received at the end of an RPC. It is
 	// nil if the RPC is still in progress.
 	Status *status.Status
+
+	// RawOutput, when true, means formatted response messages are written to
+	// Out exactly as returned by Formatter, without a trailing newline. This
+	// should be used with binary formatters, like NewBinaryFormatter.
+	RawOutput bool
 }
 
 // NewDefaultEventHandler returns an InvocationEventHandler that logs events to
 // the given output. If verbose is true, all events are logged. Otherwise, only
-// response messages are logged.
+// response messages are logged. Request messages are not logged, even if
+// verbose is true, since they need a formatter of their own: set the returned
+// handler's RequestFormatter to log them.
 //
 // Deprecated: NewDefaultEventHandler exists for compatibility.
 // It doesn't allow fine control over the `VerbosityLevel`
@@ -455,6 +739,8 @@ func NewDefaultEventHandler(out io.Writer, descSource DescriptorSource, formatte
 }
 
 var _ InvocationEventHandler = (*DefaultEventHandler)(nil)
+var _ PeerEventHandler = (*DefaultEventHandler)(nil)
+var _ RequestEventHandler = (*DefaultEventHandler)(nil)
 
 func (h *DefaultEventHandler) OnResolveMethod(md *desc.MethodDescriptor) {
 	if h.VerbosityLevel > 0 {
@@ -471,6 +757,33 @@ func (h *DefaultEventHandler) OnSendHeaders(md metadata.MD) {
 	}
 }
 
+func (h *DefaultEventHandler) OnSendRequest(req proto.Message, _ time.Time) {
+	if h.VerbosityLevel > 1 {
+		fmt.Fprintf(h.Out, "\nEstimated request size: %d bytes\n", proto.Size(req))
+	}
+	// binary messages would garble the output, so they are not shown
+	if h.VerbosityLevel > 0 && h.RequestFormatter != nil && !h.RawOutput {
+		fmt.Fprint(h.Out, "\nRequest contents:\n")
+		if reqStr, err := h.RequestFormatter(req); err != nil {
+			fmt.Fprintf(h.Out, "Failed to format request message: %v\n", err)
+		} else {
+			fmt.Fprintln(h.Out, reqStr)
+		}
+	}
+}
+
+func (h *DefaultEventHandler) OnCloseSend(time.Time) {
+	if h.VerbosityLevel > 1 {
+		fmt.Fprint(h.Out, "\nRequest stream closed\n")
+	}
+}
+
+func (h *DefaultEventHandler) OnReceivePeer(p *peer.Peer) {
+	if h.VerbosityLevel > 0 {
+		fmt.Fprintf(h.Out, "\nConnected to peer:\n%s\n", PeerToString(p))
+	}
+}
+
 func (h *DefaultEventHandler) OnReceiveHeaders(md metadata.MD) {
 	if h.VerbosityLevel > 0 {
 		fmt.Fprintf(h.Out, "\nResponse headers received:\n%s\n", MetadataToString(md))
@@ -487,6 +800,8 @@ func (h *DefaultEventHandler) OnReceiveResponse(resp proto.Message) {
 	}
 	if respStr, err := h.Formatter(resp); err != nil {
 		fmt.Fprintf(h.Out, "Failed to format response message %d: %v\n", h.NumResponses, err)
+	} else if h.RawOutput {
+		fmt.Fprint(h.Out, respStr)
 	} else {
 		fmt.Fprintln(h.Out, respStr)
 	}
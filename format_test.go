@@ -6,10 +6,12 @@ import (
 	"io"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/golang/protobuf/jsonpb" //lint:ignore SA1019 we have to import this because it appears in exported API
 	"github.com/golang/protobuf/proto"  //lint:ignore SA1019 we have to import this because it appears in exported API
 	"github.com/jhump/protoreflect/desc"
+	"github.com/jhump/protoreflect/dynamic"
 	"google.golang.org/grpc/metadata"
 	"google.golang.org/protobuf/types/known/structpb"
 )
@@ -25,6 +27,11 @@ func TestRequestParser(t *testing.T) {
 		t.Fatalf("failed to create message: %v", err)
 	}
 
+	messageAsBinary, err := NewBinaryFormatter()(msg)
+	if err != nil {
+		t.Fatalf("failed to format message as binary: %v", err)
+	}
+
 	testCases := []struct {
 		format         Format
 		input          string
@@ -65,6 +72,46 @@ func TestRequestParser(t *testing.T) {
 			input:          messageAsText + string(textSeparatorChar) + messageAsText + string(textSeparatorChar) + messageAsText,
 			expectedOutput: []proto.Message{msg, msg, msg},
 		},
+		{
+			format: FormatYAML,
+			input:  "",
+		},
+		{
+			format:         FormatYAML,
+			input:          messageAsYAML,
+			expectedOutput: []proto.Message{msg},
+		},
+		{
+			// empty documents are skipped
+			format:         FormatYAML,
+			input:          "---\n" + messageAsYAML + "---\n" + messageAsYAML + "---\n---\n" + messageAsYAML + "---\n",
+			expectedOutput: []proto.Message{msg, msg, msg},
+		},
+		{
+			format:         FormatYAML,
+			input:          "{}\n---\n" + messageAsYAML,
+			expectedOutput: []proto.Message{&structpb.Value{Kind: &structpb.Value_StructValue{StructValue: &structpb.Struct{}}}, msg},
+		},
+		{
+			format: FormatBinary,
+			input:  "",
+		},
+		{
+			// a zero size is an empty message
+			format:         FormatBinary,
+			input:          "\x00",
+			expectedOutput: []proto.Message{&structpb.Value{}},
+		},
+		{
+			format:         FormatBinary,
+			input:          messageAsBinary,
+			expectedOutput: []proto.Message{msg},
+		},
+		{
+			format:         FormatBinary,
+			input:          messageAsBinary + messageAsBinary + messageAsBinary,
+			expectedOutput: []proto.Message{msg, msg, msg},
+		},
 	}
 
 	for i, tc := range testCases {
@@ -94,6 +141,169 @@ func TestRequestParser(t *testing.T) {
 	}
 }
 
+func TestYAMLFormat(t *testing.T) {
+	source, err := DescriptorSourceFromProtoSets("internal/testing/example.protoset")
+	if err != nil {
+		t.Fatalf("failed to create descriptor source: %v", err)
+	}
+	msg, err := makeProto()
+	if err != nil {
+		t.Fatalf("failed to create message: %v", err)
+	}
+
+	// multiple messages are separated into documents
+	formatter := NewYAMLFormatter(false, nil)
+	var out []string
+	for i := 0; i < 2; i++ {
+		str, err := formatter(msg)
+		if err != nil {
+			t.Fatalf("failed to format message: %v", err)
+		}
+		out = append(out, str)
+	}
+	expected := strings.TrimSuffix(messageAsYAML, "\n") + "\n---\n" + strings.TrimSuffix(messageAsYAML, "\n")
+	if actual := strings.Join(out, "\n"); actual != expected {
+		t.Errorf("incorrect output. Expected:\n%s\nGot:\n%s", expected, actual)
+	}
+
+	// strings that look like other types must be quoted to round-trip
+	str, err := NewYAMLFormatter(false, nil)(structpb.NewStringValue("123"))
+	if err != nil {
+		t.Fatalf("failed to format message: %v", err)
+	}
+	if str != `"123"` {
+		t.Errorf("incorrect output. Expected:\n%s\nGot:\n%s", `"123"`, str)
+	}
+
+	// google.protobuf.Any messages are resolved using the descriptor source
+	input := `
+fileNames: [a.proto, b.proto]
+extensions:
+  - id: 42
+    data:
+      "@type": type.googleapis.com/TestRequest
+      fileNames:
+        - c.proto
+`
+	d, err := source.FindSymbol("TestRequest")
+	if err != nil {
+		t.Fatalf("failed to find message 'TestRequest': %v", err)
+	}
+	rf, formatter, err := RequestParserAndFormatter(FormatYAML, source, strings.NewReader(input), FormatOptions{})
+	if err != nil {
+		t.Fatalf("failed to create parser and formatter: %v", err)
+	}
+	req := dynamic.NewMessage(d.(*desc.MessageDescriptor))
+	if err := rf.Next(req); err != nil {
+		t.Fatalf("failed to parse message: %v", err)
+	}
+	if err := rf.Next(req); err != io.EOF {
+		t.Errorf("expected EOF after one message, got %v", err)
+	}
+	str, err = formatter(req)
+	if err != nil {
+		t.Fatalf("failed to format message: %v", err)
+	}
+	expected = `fileNames:
+  - a.proto
+  - b.proto
+extensions:
+  - id: "42"
+    data:
+      '@type': type.googleapis.com/TestRequest
+      fileNames:
+        - c.proto`
+	if str != expected {
+		t.Errorf("incorrect output. Expected:\n%s\nGot:\n%s", expected, str)
+	}
+}
+
+func TestBinaryRequestParserTruncated(t *testing.T) {
+	msg, err := makeProto()
+	if err != nil {
+		t.Fatalf("failed to create message: %v", err)
+	}
+	messageAsBinary, err := NewBinaryFormatter()(msg)
+	if err != nil {
+		t.Fatalf("failed to format message as binary: %v", err)
+	}
+
+	testCases := []struct {
+		input string
+		err   string
+	}{
+		{
+			// size is a varint with no final byte
+			input: "\x80",
+			err:   "failed to read size of message 1: unexpected EOF",
+		},
+		{
+			input: messageAsBinary[:len(messageAsBinary)-1],
+			err:   "failed to read message 1: unexpected EOF",
+		},
+		{
+			input: messageAsBinary + "\x05abc",
+			err:   "failed to read message 2: unexpected EOF",
+		},
+		{
+			// size is 2^63, which must not be read as a negative number
+			input: messageAsBinary + "\x80\x80\x80\x80\x80\x80\x80\x80\x80\x01abc",
+			err:   "failed to read message 2: message size 9223372036854775808 too large",
+		},
+		{
+			input: "\x80\x80\x80\x80\x10abc",
+			err:   "failed to read message 1: message size 4294967296 too large",
+		},
+	}
+	for _, tc := range testCases {
+		rf := NewBinaryRequestParser(strings.NewReader(tc.input))
+		for {
+			var req structpb.Value
+			err = rf.Next(&req)
+			if err != nil {
+				break
+			}
+		}
+		if err == io.EOF || err.Error() != tc.err {
+			t.Errorf("expected error %q, got %v", tc.err, err)
+		}
+	}
+}
+
+func TestBinaryHandlerOutput(t *testing.T) {
+	msg, err := makeProto()
+	if err != nil {
+		t.Fatalf("failed to create message: %v", err)
+	}
+	var buf bytes.Buffer
+	h := &DefaultEventHandler{
+		Out:       &buf,
+		Formatter: NewBinaryFormatter(),
+		RawOutput: true,
+	}
+	for i := 0; i < 3; i++ {
+		h.OnReceiveResponse(msg)
+	}
+
+	// output can be parsed back into the same messages
+	rf := NewBinaryRequestParser(&buf)
+	for {
+		var req structpb.Value
+		err := rf.Next(&req)
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatalf("msg %d: unexpected error: %v", rf.NumRequests(), err)
+		}
+		if !proto.Equal(&req, msg) {
+			t.Errorf("msg %d: incorrect message;\nexpecting:\n%v\ngot:\n%v", rf.NumRequests(), msg, &req)
+		}
+	}
+	if rf.NumRequests() != 3 {
+		t.Errorf("wrong number of messages: expecting 3, got %d", rf.NumRequests())
+	}
+}
+
 // Handler prints response data (and headers/trailers in verbose mode).
 // This verifies that we get the right output in both JSON and proto text modes.
 func TestHandler(t *testing.T) {
@@ -118,7 +328,7 @@ func TestHandler(t *testing.T) {
 		t.Fatalf("failed to create response message: %v", err)
 	}
 
-	for _, format := range []Format{FormatJSON, FormatText} {
+	for _, format := range []Format{FormatJSON, FormatText, FormatYAML} {
 		for _, numMessages := range []int{1, 3} {
 			for verbosityLevel := 0; verbosityLevel <= 2; verbosityLevel++ {
 				name := fmt.Sprintf("%s, %d message(s)", format, numMessages)
@@ -128,7 +338,12 @@ func TestHandler(t *testing.T) {
 
 				verbose := verbosityLevel > 0
 
-				_, formatter, err := RequestParserAndFormatter(format, source, nil, FormatOptions{IncludeTextSeparator: !verbose})
+				_, formatter, err := RequestParserAndFormatter(format, source, strings.NewReader(""), FormatOptions{IncludeTextSeparator: !verbose})
+				if err != nil {
+					t.Errorf("Failed to create parser and formatter: %v", err)
+					continue
+				}
+				_, reqFormatter, err := RequestParserAndFormatter(format, source, strings.NewReader(""), FormatOptions{})
 				if err != nil {
 					t.Errorf("Failed to create parser and formatter: %v", err)
 					continue
@@ -136,13 +351,16 @@ func TestHandler(t *testing.T) {
 
 				var buf bytes.Buffer
 				h := &DefaultEventHandler{
-					Out:            &buf,
-					Formatter:      formatter,
-					VerbosityLevel: verbosityLevel,
+					Out:              &buf,
+					Formatter:        formatter,
+					RequestFormatter: reqFormatter,
+					VerbosityLevel:   verbosityLevel,
 				}
 
 				h.OnResolveMethod(md)
 				h.OnSendHeaders(reqHeaders)
+				h.OnSendRequest(rsp, time.Now())
+				h.OnCloseSend(time.Now())
 				h.OnReceiveHeaders(respHeaders)
 				for i := 0; i < numMessages; i++ {
 					h.OnReceiveResponse(rsp)
@@ -153,6 +371,26 @@ func TestHandler(t *testing.T) {
 				if verbose {
 					expectedOutput += verbosePrefix
 				}
+				if verbosityLevel > 1 {
+					expectedOutput += verboseRequestSize
+				}
+				if verbose {
+					expectedOutput += verboseRequestHeader
+					switch format {
+					case FormatJSON:
+						expectedOutput += messageAsJSON
+					case FormatYAML:
+						expectedOutput += messageAsYAML
+					default:
+						expectedOutput += messageAsText
+					}
+				}
+				if verbosityLevel > 1 {
+					expectedOutput += verboseRequestClosed
+				}
+				if verbose {
+					expectedOutput += verboseResponseHeaders
+				}
 				for i := 0; i < numMessages; i++ {
 					if verbosityLevel > 1 {
 						expectedOutput += verboseResponseSize
@@ -160,9 +398,16 @@ func TestHandler(t *testing.T) {
 					if verbose {
 						expectedOutput += verboseResponseHeader
 					}
-					if format == "json" {
+					switch format {
+					case FormatJSON:
 						expectedOutput += messageAsJSON
-					} else {
+					case FormatYAML:
+						// YAML documents are always separated
+						if i > 0 {
+							expectedOutput += "---\n"
+						}
+						expectedOutput += messageAsYAML
+					default:
 						if i > 0 && !verbose {
 							expectedOutput += string(textSeparatorChar)
 						}
@@ -224,7 +469,17 @@ rpc GetFiles ( .TestRequest ) returns ( .TestResponse );
 Request metadata to send:
 bar: 456
 foo: 123
-
+`
+	verboseRequestSize = `
+Estimated request size: 100 bytes
+`
+	verboseRequestHeader = `
+Request contents:
+`
+	verboseRequestClosed = `
+Request stream closed
+`
+	verboseResponseHeaders = `
 Response headers received:
 bar: def
 baz: xyz
@@ -255,6 +510,16 @@ Response contents:
   ],
   "null": null
 }
+`
+	messageAsYAML = `bar:
+  a: 1
+  b: 2
+baz: true
+foo:
+  - abc
+  - def
+  - ghi
+"null": null
 `
 	messageAsText = `struct_value: <
   fields: <
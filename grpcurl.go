@@ -17,7 +17,6 @@ import (
 	"io/ioutil"
 	"net"
 	"os"
-	"regexp"
 	"sort"
 	"strings"
 
@@ -165,30 +164,27 @@ func MetadataFromHeaders(headers []string) metadata.MD {
 	return md
 }
 
-var envVarRegex = regexp.MustCompile(`\${\w+}`)
-
 // ExpandHeaders expands environment variables contained in the header string.
-// If no corresponding environment variable is found an error is returned.
-// TODO: Add escaping for `${`
+// If no corresponding environment variable is found an error is returned. A
+// literal '${' is written as '$${'.
 func ExpandHeaders(headers []string) ([]string, error) {
 	expandedHeaders := make([]string, len(headers))
 	for idx, header := range headers {
 		if header == "" {
 			continue
 		}
-		results := envVarRegex.FindAllString(header, -1)
-		if len(results) == 0 {
-			expandedHeaders[idx] = headers[idx]
-			continue
-		}
-		expandedHeader := header
-		for _, result := range results {
-			envVarName := result[2 : len(result)-1] // strip leading `${` and trailing `}`
+		expandedHeader, err := expandPlaceholders(header, func(envVarName string) (string, bool, error) {
+			if !placeholderVarRegex.MatchString(envVarName) {
+				return "", false, nil
+			}
 			envVarValue, ok := os.LookupEnv(envVarName)
 			if !ok {
-				return nil, fmt.Errorf("header %q refers to missing environment variable %q", header, envVarName)
+				return "", true, fmt.Errorf("header %q refers to missing environment variable %q", header, envVarName)
 			}
-			expandedHeader = strings.Replace(expandedHeader, result, envVarValue, -1)
+			return envVarValue, true, nil
+		}, nil)
+		if err != nil {
+			return nil, err
 		}
 		expandedHeaders[idx] = expandedHeader
 	}
@@ -523,11 +519,122 @@ func ClientTransportCredentials(insecureSkipVerify bool, cacertFile, clientCertF
 	return credentials.NewTLS(tlsConf), nil
 }
 
+// TLSOptions is a set of additional settings for the TLS configs built by
+// ClientTLSConfigWithOptions and ServerTransportCredentialsWithOptions.
+type TLSOptions struct {
+	// CRLPath is a file or directory with certificate revocation lists (see
+	// LoadCRLs). If not blank, peer certificates that are revoked by any of
+	// them are rejected.
+	CRLPath string
+	// MinVersion and MaxVersion are the minimum and maximum TLS versions
+	// that are acceptable, such as tls.VersionTLS12. If zero, the defaults of
+	// the crypto/tls package are used, except that if only MaxVersion is set,
+	// and it is older than TLS 1.2, then the minimum is TLS 1.0.
+	MinVersion, MaxVersion uint16
+	// CipherSuites are the cipher suites that are acceptable, in TLS 1.2
+	// and older. The cipher suites of TLS 1.3 cannot be configured. If
+	// empty, the defaults of the crypto/tls package are used.
+	CipherSuites []uint16
+	// CurvePreferences are the elliptic curves that are acceptable for key
+	// exchange, in order of preference. If empty, the defaults of the
+	// crypto/tls package are used.
+	CurvePreferences []tls.CurveID
+}
+
+func (opts *TLSOptions) apply(tlsConf *tls.Config) error {
+	if opts.MinVersion != 0 && opts.MaxVersion != 0 && opts.MinVersion > opts.MaxVersion {
+		return fmt.Errorf("minimum TLS version %s is newer than maximum TLS version %s", TLSVersionName(opts.MinVersion), TLSVersionName(opts.MaxVersion))
+	}
+	tlsConf.MinVersion = opts.MinVersion
+	tlsConf.MaxVersion = opts.MaxVersion
+	if opts.MinVersion == 0 && opts.MaxVersion != 0 && opts.MaxVersion < tls.VersionTLS12 {
+		// otherwise, no versions would be enabled
+		tlsConf.MinVersion = tls.VersionTLS10
+	}
+	tlsConf.CipherSuites = opts.CipherSuites
+	tlsConf.CurvePreferences = opts.CurvePreferences
+	if opts.CRLPath != "" {
+		crls, err := LoadCRLs(opts.CRLPath)
+		if err != nil {
+			return err
+		}
+		tlsConf.VerifyPeerCertificate = verifyNotRevoked(crls)
+	}
+	return nil
+}
+
+// ParseTLSVersion parses a TLS version, such as "1.2" or "TLS1.2", for use
+// with TLSOptions.
+func ParseTLSVersion(s string) (uint16, error) {
+	switch strings.TrimPrefix(strings.ToLower(strings.ReplaceAll(s, " ", "")), "tls") {
+	case "1.0":
+		return tls.VersionTLS10, nil
+	case "1.1":
+		return tls.VersionTLS11, nil
+	case "1.2":
+		return tls.VersionTLS12, nil
+	case "1.3":
+		return tls.VersionTLS13, nil
+	}
+	return 0, fmt.Errorf("unknown TLS version %q; known versions are 1.0, 1.1, 1.2, and 1.3", s)
+}
+
+// ParseCipherSuites parses a comma-separated list of cipher suite names, such
+// as "TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256", for use with TLSOptions. Both
+// secure and insecure cipher suites that the crypto/tls package implements
+// are accepted.
+func ParseCipherSuites(s string) ([]uint16, error) {
+	known := map[string]uint16{}
+	for _, cs := range append(tls.CipherSuites(), tls.InsecureCipherSuites()...) {
+		known[cs.Name] = cs.ID
+	}
+	var ids []uint16
+	for _, name := range strings.Split(s, ",") {
+		name = strings.TrimSpace(name)
+		id, ok := known[strings.ToUpper(name)]
+		if !ok {
+			return nil, fmt.Errorf("unknown cipher suite %q", name)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// ParseCurves parses a comma-separated list of elliptic curve names, for use
+// with TLSOptions. The known curves are X25519, P256, P384, and P521.
+func ParseCurves(s string) ([]tls.CurveID, error) {
+	known := map[string]tls.CurveID{
+		"x25519": tls.X25519,
+		"p256":   tls.CurveP256,
+		"p384":   tls.CurveP384,
+		"p521":   tls.CurveP521,
+	}
+	var ids []tls.CurveID
+	for _, name := range strings.Split(s, ",") {
+		name = strings.TrimSpace(name)
+		// also accepts "P-256" and "CurveP256"
+		key := strings.TrimPrefix(strings.ReplaceAll(strings.ToLower(name), "-", ""), "curve")
+		id, ok := known[key]
+		if !ok {
+			return nil, fmt.Errorf("unknown curve %q; known curves are X25519, P256, P384, and P521", name)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
 // ClientTLSConfig builds transport-layer config for a gRPC client using the
 // given properties. If cacertFile is blank, only standard trusted certs are used to
 // verify the server certs. If clientCertFile is blank, the client will not use a client
 // certificate. If clientCertFile is not blank then clientKeyFile must not be blank.
 func ClientTLSConfig(insecureSkipVerify bool, cacertFile, clientCertFile, clientKeyFile string) (*tls.Config, error) {
+	return ClientTLSConfigWithOptions(insecureSkipVerify, cacertFile, clientCertFile, clientKeyFile, TLSOptions{})
+}
+
+// ClientTLSConfigWithOptions is like ClientTLSConfig, but also applies the
+// given options. Revocation is not checked if insecureSkipVerify is true,
+// since the server certs are not verified at all.
+func ClientTLSConfigWithOptions(insecureSkipVerify bool, cacertFile, clientCertFile, clientKeyFile string, opts TLSOptions) (*tls.Config, error) {
 	var tlsConf tls.Config
 
 	if clientCertFile != "" {
@@ -557,6 +664,14 @@ func ClientTLSConfig(insecureSkipVerify bool, cacertFile, clientCertFile, client
 		tlsConf.RootCAs = certPool
 	}
 
+	if insecureSkipVerify {
+		// the server certs are not verified at all, so neither is revocation
+		opts.CRLPath = ""
+	}
+	if err := opts.apply(&tlsConf); err != nil {
+		return nil, err
+	}
+
 	return &tlsConf, nil
 }
 
@@ -566,10 +681,13 @@ func ClientTLSConfig(insecureSkipVerify bool, cacertFile, clientCertFile, client
 // not blank, the server will verify client certs when presented, but will not require
 // client certs. The serverCertFile and serverKeyFile must both not be blank.
 func ServerTransportCredentials(cacertFile, serverCertFile, serverKeyFile string, requireClientCerts bool) (credentials.TransportCredentials, error) {
+	return ServerTransportCredentialsWithOptions(cacertFile, serverCertFile, serverKeyFile, requireClientCerts, TLSOptions{})
+}
+
+// ServerTransportCredentialsWithOptions is like ServerTransportCredentials,
+// but also applies the given options.
+func ServerTransportCredentialsWithOptions(cacertFile, serverCertFile, serverKeyFile string, requireClientCerts bool, opts TLSOptions) (credentials.TransportCredentials, error) {
 	var tlsConf tls.Config
-	// TODO(jh): Remove this line once https://github.com/golang/go/issues/28779 is fixed
-	// in Go tip. Until then, the recently merged TLS 1.3 support breaks the TLS tests.
-	tlsConf.MaxVersion = tls.VersionTLS12
 
 	// Load the server certificates from disk
 	certificate, err := tls.LoadX509KeyPair(serverCertFile, serverKeyFile)
@@ -602,17 +720,23 @@ func ServerTransportCredentials(cacertFile, serverCertFile, serverKeyFile string
 		tlsConf.ClientAuth = tls.NoClientCert
 	}
 
+	if err := opts.apply(&tlsConf); err != nil {
+		return nil, err
+	}
+
 	return credentials.NewTLS(&tlsConf), nil
 }
 
 // BlockingDial is a helper method to dial the given address, using optional TLS credentials,
 // and blocking until the returned connection is ready. If the given credentials are nil, the
-// connection will be insecure (plain-text).
+// connection will be insecure (plain-text). If the given context was created with
+// WithDialTiming, the time taken by each step of establishing the connection is recorded.
 func BlockingDial(ctx context.Context, network, address string, creds credentials.TransportCredentials, opts ...grpc.DialOption) (*grpc.ClientConn, error) {
 	// grpc.Dial doesn't provide any information on permanent connection errors (like
 	// TLS handshake failures). So in order to provide good error messages, we need a
 	// custom dialer that can provide that info. That means we manage the TLS handshake.
 	result := make(chan interface{}, 1)
+	timer := newDialTimer(ctx)
 
 	writeResult := func(res interface{}) {
 		// non-blocking write: we only need the first result
@@ -628,6 +752,7 @@ func BlockingDial(ctx context.Context, network, address string, creds credential
 		creds = &errSignalingCreds{
 			TransportCredentials: creds,
 			writeResult:          writeResult,
+			timer:                timer,
 		}
 	}
 	dialer := func(ctx context.Context, address string) (net.Conn, error) {
@@ -638,7 +763,7 @@ func BlockingDial(ctx context.Context, network, address string, creds credential
 		// handshake). And that would mean that the library would send the
 		// wrong ":scheme" metaheader to servers: it would send "http" instead
 		// of "https" because it is unaware that TLS is actually in use.
-		conn, err := (&net.Dialer{}).DialContext(ctx, network, address)
+		conn, err := timer.dial(ctx, network, address)
 		if err != nil {
 			writeResult(err)
 		}
@@ -675,6 +800,7 @@ func BlockingDial(ctx context.Context, network, address string, creds credential
 	select {
 	case res := <-result:
 		if conn, ok := res.(*grpc.ClientConn); ok {
+			timer.done()
 			return conn, nil
 		}
 		return nil, res.(error)
@@ -684,16 +810,47 @@ func BlockingDial(ctx context.Context, network, address string, creds credential
 }
 
 // errSignalingCreds is a wrapper around a TransportCredentials value, but
-// it will use the writeResult function to notify on error.
+// it will use the writeResult function to notify on error. It also times
+// the handshake, if the timer is not nil.
 type errSignalingCreds struct {
 	credentials.TransportCredentials
 	writeResult func(res interface{})
+	timer       *dialTimer
 }
 
 func (c *errSignalingCreds) ClientHandshake(ctx context.Context, addr string, rawConn net.Conn) (net.Conn, credentials.AuthInfo, error) {
-	conn, auth, err := c.TransportCredentials.ClientHandshake(ctx, addr, rawConn)
+	var conn net.Conn
+	var auth credentials.AuthInfo
+	err := c.timer.handshake(func() error {
+		var err error
+		conn, auth, err = c.TransportCredentials.ClientHandshake(ctx, addr, rawConn)
+		return err
+	})
 	if err != nil {
 		c.writeResult(err)
+		return conn, auth, err
+	}
+	if tlsInfo, ok := auth.(credentials.TLSInfo); ok && tlsInfo.State.Version == tls.VersionTLS13 {
+		conn = &alertSignalingConn{Conn: conn, writeResult: c.writeResult}
+	}
+	return conn, auth, nil
+}
+
+// alertSignalingConn is a wrapper around a TLS connection that uses the
+// writeResult function to notify of alerts received from the server. With
+// TLS 1.3, the server checks the client certificate after the client has
+// finished the handshake, so that is how the client learns that the server
+// rejected it.
+type alertSignalingConn struct {
+	net.Conn
+	writeResult func(res interface{})
+}
+
+func (c *alertSignalingConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	var opErr *net.OpError
+	if errors.As(err, &opErr) && opErr.Op == "remote error" {
+		c.writeResult(err)
 	}
-	return conn, auth, err
+	return n, err
 }
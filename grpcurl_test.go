@@ -140,6 +140,79 @@ func TestServerDoesNotSupportReflection(t *testing.T) {
 	}
 }
 
+func TestReflectionVersions(t *testing.T) {
+	// servers that expose only one version of the reflection service
+	svrV1 := grpc.NewServer()
+	grpcurl_testing.RegisterTestServiceServer(svrV1, grpcurl_testing.TestServer{})
+	reflection.RegisterV1(svrV1)
+	ccV1, err := serveAndDial(svrV1)
+	if err != nil {
+		t.Fatalf("failed to start v1 reflection server: %v", err)
+	}
+	defer svrV1.Stop()
+	defer ccV1.Close()
+
+	svrV1Alpha := grpc.NewServer()
+	grpcurl_testing.RegisterTestServiceServer(svrV1Alpha, grpcurl_testing.TestServer{})
+	reflectpb.RegisterServerReflectionServer(svrV1Alpha, reflection.NewServer(reflection.ServerOptions{Services: svrV1Alpha}))
+	ccV1Alpha, err := serveAndDial(svrV1Alpha)
+	if err != nil {
+		t.Fatalf("failed to start v1alpha reflection server: %v", err)
+	}
+	defer svrV1Alpha.Stop()
+	defer ccV1Alpha.Close()
+
+	testCases := []struct {
+		name      string
+		cc        *grpc.ClientConn
+		version   ReflectionVersion
+		supported bool
+	}{
+		{"auto/v1 server", ccV1, ReflectionAuto, true},
+		{"auto/v1alpha server", ccV1Alpha, ReflectionAuto, true},
+		{"v1/v1 server", ccV1, ReflectionV1, true},
+		{"v1/v1alpha server", ccV1Alpha, ReflectionV1, false},
+		{"v1alpha/v1 server", ccV1, ReflectionV1Alpha, false},
+		{"v1alpha/v1alpha server", ccV1Alpha, ReflectionV1Alpha, true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			refClient, err := NewReflectionClient(context.Background(), tc.cc, tc.version)
+			if err != nil {
+				t.Fatalf("failed to create reflection client: %v", err)
+			}
+			defer refClient.Reset()
+			source := DescriptorSourceFromServer(context.Background(), refClient)
+
+			// do it twice, to make sure negotiated version is remembered
+			for i := 0; i < 2; i++ {
+				_, err = source.FindSymbol("testing.TestService")
+				if tc.supported && err != nil {
+					t.Fatalf("failed to find service via reflection: %v", err)
+				} else if !tc.supported && err != ErrReflectionNotSupported {
+					t.Fatalf("FindSymbol should have returned ErrReflectionNotSupported; instead got %v", err)
+				}
+			}
+		})
+	}
+
+	if _, err := NewReflectionClient(context.Background(), ccV1, ReflectionVersion("v2")); err == nil {
+		t.Errorf("NewReflectionClient should have failed for unknown version")
+	}
+}
+
+func serveAndDial(svr *grpc.Server) (*grpc.ClientConn, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	go svr.Serve(l)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	return grpc.DialContext(ctx, l.Addr().String(),
+		grpc.WithTransportCredentials(insecure.NewCredentials()), grpc.WithBlock())
+}
+
 func TestProtosetWithImports(t *testing.T) {
 	sourceProtoset, err := DescriptorSourceFromProtoSets("internal/testing/example.protoset")
 	if err != nil {
@@ -177,8 +250,8 @@ func doTestListServices(t *testing.T, source DescriptorSource, includeReflection
 	}
 	var expected []string
 	if includeReflection {
-		// when using server reflection, we see the TestService as well as the ServerReflection service
-		expected = []string{"grpc.reflection.v1alpha.ServerReflection", "testing.TestService"}
+		// when using server reflection, we see the TestService as well as both versions of the ServerReflection service
+		expected = []string{"grpc.reflection.v1.ServerReflection", "grpc.reflection.v1alpha.ServerReflection", "testing.TestService"}
 	} else {
 		// without reflection, we see all services defined in the same test.proto file, which is the
 		// TestService as well as UnimplementedService
@@ -244,7 +317,7 @@ func doTestListMethods(t *testing.T, source DescriptorSource, includeReflection
 func TestGetAllFiles(t *testing.T) {
 	expectedFiles := []string{"test.proto"}
 	expectedFilesWithReflection := []string{
-		"grpc/reflection/v1alpha/reflection.proto", "test.proto",
+		"grpc/reflection/v1/reflection.proto", "grpc/reflection/v1alpha/reflection.proto", "test.proto",
 	}
 
 	for _, ds := range descSources {
@@ -306,13 +379,14 @@ func TestGetAllFiles(t *testing.T) {
 
 func TestExpandHeaders(t *testing.T) {
 	inHeaders := []string{"key1: ${value}", "key2: bar", "key3: ${woo", "key4: woo}", "key5: ${TEST}",
-		"key6: ${TEST_VAR}", "${TEST}: ${TEST_VAR}", "key8: ${EMPTY}"}
+		"key6: ${TEST_VAR}", "${TEST}: ${TEST_VAR}", "key8: ${EMPTY}", "key9: $${TEST}", "key10: ${a.b}"}
 	os.Setenv("value", "value")
 	os.Setenv("TEST", "value5")
 	os.Setenv("TEST_VAR", "value6")
 	os.Setenv("EMPTY", "")
 	expectedHeaders := map[string]bool{"key1: value": true, "key2: bar": true, "key3: ${woo": true, "key4: woo}": true,
-		"key5: value5": true, "key6: value6": true, "value5: value6": true, "key8: ": true}
+		"key5: value5": true, "key6: value6": true, "value5: value6": true, "key8: ": true,
+		"key9: ${TEST}": true, "key10: ${a.b}": true}
 
 	outHeaders, err := ExpandHeaders(inHeaders)
 	if err != nil {
@@ -735,6 +809,10 @@ type handler struct {
 	reqHeadersCount   int
 	reqMessages       []string
 	reqMessagesCount  int
+	reqSentCount      int
+	reqSentAt         time.Time
+	closeSendCount    int
+	closeSendAt       time.Time
 	respHeaders       metadata.MD
 	respHeadersCount  int
 	respMessages      []string
@@ -768,6 +846,22 @@ func (h *handler) OnSendHeaders(md metadata.MD) {
 	h.reqHeaders = md
 }
 
+func (h *handler) OnSendRequest(_ proto.Message, sentAt time.Time) {
+	if h.closeSendCount > 0 || sentAt.Before(h.reqSentAt) {
+		panic("request sent out of order")
+	}
+	h.reqSentCount++
+	h.reqSentAt = sentAt
+}
+
+func (h *handler) OnCloseSend(closedAt time.Time) {
+	if closedAt.Before(h.reqSentAt) {
+		panic("request stream closed before last request was sent")
+	}
+	h.closeSendCount++
+	h.closeSendAt = closedAt
+}
+
 func (h *handler) OnReceiveHeaders(md metadata.MD) {
 	h.respHeadersCount++
 	h.respHeaders = md
@@ -799,6 +893,9 @@ func (h *handler) check(t *testing.T, expectedMethod string, expectedCode codes.
 	if h.reqHeadersCount != 1 {
 		t.Errorf("expected grpcurl to invoke OnSendHeaders once; was %d", h.reqHeadersCount)
 	}
+	if h.closeSendCount > 1 {
+		t.Errorf("expected grpcurl to invoke OnCloseSend at most once; was %d", h.closeSendCount)
+	}
 	if h.respHeadersCount != 1 {
 		t.Errorf("expected grpcurl to invoke OnReceiveHeaders once; was %d", h.respHeadersCount)
 	}
@@ -819,11 +916,20 @@ func (h *handler) check(t *testing.T, expectedMethod string, expectedCode codes.
 			// the + 1 is because there will be an extra query that returns EOF
 			t.Errorf("wrong number of messages queried: expecting no more than %v, got %v", -expectedRequestQueries, h.reqMessagesCount-1)
 		}
+		if h.reqSentCount > -expectedRequestQueries {
+			t.Errorf("wrong number of messages sent: expecting no more than %v, got %v", -expectedRequestQueries, h.reqSentCount)
+		}
 	} else {
 		if h.reqMessagesCount != expectedRequestQueries+1 {
 			// the + 1 is because there will be an extra query that returns EOF
 			t.Errorf("wrong number of messages queried: expecting %v, got %v", expectedRequestQueries, h.reqMessagesCount-1)
 		}
+		if h.reqSentCount != expectedRequestQueries {
+			t.Errorf("wrong number of messages sent: expecting %v, got %v", expectedRequestQueries, h.reqSentCount)
+		}
+		if h.closeSendCount != 1 {
+			t.Errorf("expected grpcurl to invoke OnCloseSend once; was %d", h.closeSendCount)
+		}
 	}
 	if len(h.respMessages) != expectedResponses {
 		t.Errorf("wrong number of messages received: expecting %v, got %v", expectedResponses, len(h.respMessages))
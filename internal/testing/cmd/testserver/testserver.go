@@ -37,6 +37,9 @@ var (
 	requirecert = flag.Bool("requirecert", false,
 		`Require clients to authenticate via client certs. Must be using TLS (e.g. must
     	also provide -cert and -key options).`)
+	crl = flag.String("crl", "",
+		`File or directory containing certificate revocation lists for checking client
+    	certs. Ignored if TLS is not in use (e.g. no -cert or -key specified).`)
 	port      = flag.Int("p", 0, "Port on which to listen. Ephemeral port used if not specified.")
 	noreflect = flag.Bool("noreflect", false, "Indicates that server should not support server reflection.")
 	quiet     = flag.Bool("q", false, "Suppresses server request and stream logging.")
@@ -67,7 +70,7 @@ func main() {
 
 	var opts []grpc.ServerOption
 	if *cert != "" {
-		creds, err := grpcurl.ServerTransportCredentials(*cacert, *cert, *key, *requirecert)
+		creds, err := grpcurl.ServerTransportCredentialsWithOptions(*cacert, *cert, *key, *requirecert, grpcurl.TLSOptions{CRLPath: *crl})
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "Failed to configure transport credentials: %v\n", err)
 			os.Exit(1)
@@ -8,6 +8,7 @@ import (
 	"strings"
 	"sync"
 	"sync/atomic"
+	"time"
 
 	"github.com/golang/protobuf/jsonpb" //lint:ignore SA1019 we have to import this because it appears in exported API
 	"github.com/golang/protobuf/proto"  //lint:ignore SA1019 we have to import this because it appears in exported API
@@ -18,6 +19,7 @@ import (
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
 	"google.golang.org/grpc/status"
 )
 
@@ -37,6 +39,35 @@ type InvocationEventHandler interface {
 	OnReceiveTrailers(*status.Status, metadata.MD)
 }
 
+// PeerEventHandler is an optional extension of InvocationEventHandler. If the
+// handler given to InvokeRPC also implements this interface, it is told which
+// server the RPC was sent to.
+type PeerEventHandler interface {
+	// OnReceivePeer is called with the server that the RPC was sent to, just
+	// before OnReceiveHeaders. If the connection uses TLS, the peer's AuthInfo
+	// is a credentials.TLSInfo, which has the negotiated connection state,
+	// including the server's certificate chain. It is not called if the RPC
+	// failed before it reached a server.
+	OnReceivePeer(*peer.Peer)
+}
+
+// RequestEventHandler is an optional extension of InvocationEventHandler. If
+// the handler given to InvokeRPC also implements this interface, it is told
+// about each request message that is sent, and when the request stream is
+// closed. These are called in order with the other callbacks; for
+// bidi-streaming RPCs, they may be interleaved with the response callbacks,
+// but no two callbacks are ever called concurrently.
+type RequestEventHandler interface {
+	// OnSendRequest is called with each request message that is sent and the
+	// time it was sent. The message is re-used for the next request, so the
+	// handler must not retain it.
+	OnSendRequest(req proto.Message, sentAt time.Time)
+	// OnCloseSend is called with the time the request stream was closed,
+	// after the last request message was sent. It is not called if the RPC
+	// failed before all request messages were sent.
+	OnCloseSend(closedAt time.Time)
+}
+
 // RequestMessageSupplier is a function that is called to retrieve request
 // messages for a GRPC operation. This type is deprecated and will be removed in
 // a future release.
@@ -89,32 +120,9 @@ func InvokeRPC(ctx context.Context, source DescriptorSource, ch grpcdynamic.Chan
 
 	md := MetadataFromHeaders(headers)
 
-	svc, mth := parseSymbol(methodName)
-	if svc == "" || mth == "" {
-		return fmt.Errorf("given method name %q is not in expected format: 'service/method' or 'service.method'", methodName)
-	}
-
-	dsc, err := source.FindSymbol(svc)
+	mtd, err := resolveMethod(source, methodName)
 	if err != nil {
-		// return a gRPC status error if hasStatus is true
-		errStatus, hasStatus := status.FromError(err)
-		switch {
-		case hasStatus && isNotFoundError(err):
-			return status.Errorf(errStatus.Code(), "target server does not expose service %q: %s", svc, errStatus.Message())
-		case hasStatus:
-			return status.Errorf(errStatus.Code(), "failed to query for service descriptor %q: %s", svc, errStatus.Message())
-		case isNotFoundError(err):
-			return fmt.Errorf("target server does not expose service %q", svc)
-		}
-		return fmt.Errorf("failed to query for service descriptor %q: %v", svc, err)
-	}
-	sd, ok := dsc.(*desc.ServiceDescriptor)
-	if !ok {
-		return fmt.Errorf("target server does not expose service %q", svc)
-	}
-	mtd := sd.FindMethodByName(mth)
-	if mtd == nil {
-		return fmt.Errorf("service %q does not include a method named %q", svc, mth)
+		return err
 	}
 
 	handler.OnResolveMethod(mtd)
@@ -150,6 +158,70 @@ func InvokeRPC(ctx context.Context, source DescriptorSource, ch grpcdynamic.Chan
 	}
 }
 
+// resolveMethod uses the given descriptor source to find the method with the
+// given name, in 'service/method' or 'service.method' format.
+func resolveMethod(source DescriptorSource, methodName string) (*desc.MethodDescriptor, error) {
+	svc, mth := parseSymbol(methodName)
+	if svc == "" || mth == "" {
+		return nil, fmt.Errorf("given method name %q is not in expected format: 'service/method' or 'service.method'", methodName)
+	}
+
+	dsc, err := source.FindSymbol(svc)
+	if err != nil {
+		// return a gRPC status error if hasStatus is true
+		errStatus, hasStatus := status.FromError(err)
+		switch {
+		case hasStatus && isNotFoundError(err):
+			return nil, status.Errorf(errStatus.Code(), "target server does not expose service %q: %s", svc, errStatus.Message())
+		case hasStatus:
+			return nil, status.Errorf(errStatus.Code(), "failed to query for service descriptor %q: %s", svc, errStatus.Message())
+		case isNotFoundError(err):
+			return nil, fmt.Errorf("target server does not expose service %q", svc)
+		}
+		return nil, fmt.Errorf("failed to query for service descriptor %q: %v", svc, err)
+	}
+	sd, ok := dsc.(*desc.ServiceDescriptor)
+	if !ok {
+		return nil, fmt.Errorf("target server does not expose service %q", svc)
+	}
+	mtd := sd.FindMethodByName(mth)
+	if mtd == nil {
+		// the service's descriptor may be out of date, as when it is cached,
+		// so ask for the method itself before giving up
+		if d, err := source.FindSymbol(svc + "." + mth); err == nil {
+			mtd, _ = d.(*desc.MethodDescriptor)
+		}
+	}
+	if mtd == nil {
+		return nil, fmt.Errorf("service %q does not include a method named %q", svc, mth)
+	}
+	return mtd, nil
+}
+
+// notifyPeer tells the given handler about the given peer, if the handler
+// implements PeerEventHandler.
+func notifyPeer(handler InvocationEventHandler, p *peer.Peer) {
+	if ph, ok := handler.(PeerEventHandler); ok {
+		ph.OnReceivePeer(p)
+	}
+}
+
+// notifySendRequest tells the given handler about the given request message,
+// if the handler implements RequestEventHandler.
+func notifySendRequest(handler InvocationEventHandler, req proto.Message, sentAt time.Time) {
+	if rh, ok := handler.(RequestEventHandler); ok {
+		rh.OnSendRequest(req, sentAt)
+	}
+}
+
+// notifyCloseSend tells the given handler that the request stream was
+// closed, if the handler implements RequestEventHandler.
+func notifyCloseSend(handler InvocationEventHandler, closedAt time.Time) {
+	if rh, ok := handler.(RequestEventHandler); ok {
+		rh.OnCloseSend(closedAt)
+	}
+}
+
 func invokeUnary(ctx context.Context, stub grpcdynamic.Stub, md *desc.MethodDescriptor, handler InvocationEventHandler,
 	requestData RequestSupplier, req proto.Message) error {
 
@@ -170,7 +242,9 @@ func invokeUnary(ctx context.Context, stub grpcdynamic.Stub, md *desc.MethodDesc
 	// Now we can actually invoke the RPC!
 	var respHeaders metadata.MD
 	var respTrailers metadata.MD
-	resp, err := stub.InvokeRpc(ctx, md, req, grpc.Trailer(&respTrailers), grpc.Header(&respHeaders))
+	var p peer.Peer
+	sentAt := time.Now()
+	resp, err := stub.InvokeRpc(ctx, md, req, grpc.Trailer(&respTrailers), grpc.Header(&respHeaders), grpc.Peer(&p))
 
 	stat, ok := status.FromError(err)
 	if !ok {
@@ -179,6 +253,12 @@ func invokeUnary(ctx context.Context, stub grpcdynamic.Stub, md *desc.MethodDesc
 		return fmt.Errorf("grpc call for %q failed: %v", md.GetFullyQualifiedName(), err)
 	}
 
+	// the request is sent, and the stream closed, as part of the call
+	notifySendRequest(handler, req, sentAt)
+	notifyCloseSend(handler, sentAt)
+	if p.Addr != nil {
+		notifyPeer(handler, &p)
+	}
 	handler.OnReceiveHeaders(respHeaders)
 
 	if stat.Code() == codes.OK {
@@ -198,9 +278,11 @@ func invokeClientStream(ctx context.Context, stub grpcdynamic.Stub, md *desc.Met
 
 	// Upload each request message in the stream
 	var resp proto.Message
+	var closedAt time.Time
 	for err == nil {
 		err = requestData(req)
 		if err == io.EOF {
+			closedAt = time.Now()
 			resp, err = str.CloseAndReceive()
 			break
 		}
@@ -215,6 +297,9 @@ func invokeClientStream(ctx context.Context, stub grpcdynamic.Stub, md *desc.Met
 			resp, err = str.CloseAndReceive()
 			break
 		}
+		if err == nil {
+			notifySendRequest(handler, req, time.Now())
+		}
 
 		req.Reset()
 	}
@@ -227,8 +312,14 @@ func invokeClientStream(ctx context.Context, stub grpcdynamic.Stub, md *desc.Met
 		return fmt.Errorf("grpc call for %q failed: %v", md.GetFullyQualifiedName(), err)
 	}
 
+	if !closedAt.IsZero() {
+		notifyCloseSend(handler, closedAt)
+	}
 	if str != nil {
 		if respHeaders, err := str.Header(); err == nil {
+			if p, ok := peer.FromContext(str.Context()); ok {
+				notifyPeer(handler, p)
+			}
 			handler.OnReceiveHeaders(respHeaders)
 		}
 	}
@@ -261,11 +352,23 @@ func invokeServerStream(ctx context.Context, stub grpcdynamic.Stub, md *desc.Met
 		}
 	}
 
+	ctx, limiter := newStreamLimiter(ctx)
+	defer limiter.release()
+
 	// Now we can actually invoke the RPC!
+	sentAt := time.Now()
 	str, err := stub.InvokeRpcServerStream(ctx, md, req)
+	if err == nil {
+		// the request is sent, and the stream closed, when the call starts
+		notifySendRequest(handler, req, sentAt)
+		notifyCloseSend(handler, sentAt)
+	}
 
 	if str != nil {
 		if respHeaders, err := str.Header(); err == nil {
+			if p, ok := peer.FromContext(str.Context()); ok {
+				notifyPeer(handler, p)
+			}
 			handler.OnReceiveHeaders(respHeaders)
 		}
 	}
@@ -280,8 +383,15 @@ func invokeServerStream(ctx context.Context, stub grpcdynamic.Stub, md *desc.Met
 			}
 			break
 		}
+		if !limiter.onResponse() {
+			continue
+		}
 		handler.OnReceiveResponse(resp)
 	}
+	if err != nil && limiter.stopped() && status.Code(err) == codes.Canceled {
+		// the stream was stopped on purpose, so the call succeeded
+		err = nil
+	}
 
 	stat, ok := status.FromError(err)
 	if !ok {
@@ -300,6 +410,8 @@ func invokeServerStream(ctx context.Context, stub grpcdynamic.Stub, md *desc.Met
 func invokeBidi(ctx context.Context, stub grpcdynamic.Stub, md *desc.MethodDescriptor, handler InvocationEventHandler,
 	requestData RequestSupplier, req proto.Message) error {
 
+	ctx, limiter := newStreamLimiter(ctx)
+	defer limiter.release()
 	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
 
@@ -308,6 +420,14 @@ func invokeBidi(ctx context.Context, stub grpcdynamic.Stub, md *desc.MethodDescr
 
 	var wg sync.WaitGroup
 	var sendErr atomic.Value
+	// the request and response callbacks are called from different
+	// goroutines, so they must be serialized
+	var handlerMu sync.Mutex
+	withHandler := func(fn func()) {
+		handlerMu.Lock()
+		defer handlerMu.Unlock()
+		fn()
+	}
 
 	defer wg.Wait()
 
@@ -322,7 +442,11 @@ func invokeBidi(ctx context.Context, stub grpcdynamic.Stub, md *desc.MethodDescr
 				err = requestData(req)
 
 				if err == io.EOF {
+					closedAt := time.Now()
 					err = str.CloseSend()
+					if err == nil {
+						withHandler(func() { notifyCloseSend(handler, closedAt) })
+					}
 					break
 				}
 				if err != nil {
@@ -332,6 +456,10 @@ func invokeBidi(ctx context.Context, stub grpcdynamic.Stub, md *desc.MethodDescr
 				}
 
 				err = str.SendMsg(req)
+				if err == nil {
+					sentAt := time.Now()
+					withHandler(func() { notifySendRequest(handler, req, sentAt) })
+				}
 
 				req.Reset()
 			}
@@ -344,7 +472,12 @@ func invokeBidi(ctx context.Context, stub grpcdynamic.Stub, md *desc.MethodDescr
 
 	if str != nil {
 		if respHeaders, err := str.Header(); err == nil {
-			handler.OnReceiveHeaders(respHeaders)
+			withHandler(func() {
+				if p, ok := peer.FromContext(str.Context()); ok {
+					notifyPeer(handler, p)
+				}
+				handler.OnReceiveHeaders(respHeaders)
+			})
 		}
 	}
 
@@ -358,12 +491,19 @@ func invokeBidi(ctx context.Context, stub grpcdynamic.Stub, md *desc.MethodDescr
 			}
 			break
 		}
-		handler.OnReceiveResponse(resp)
+		if !limiter.onResponse() {
+			continue
+		}
+		withHandler(func() { handler.OnReceiveResponse(resp) })
 	}
 
 	if se, ok := sendErr.Load().(error); ok && se != io.EOF {
 		err = se
 	}
+	if err != nil && limiter.stopped() && status.Code(err) == codes.Canceled {
+		// the stream was stopped on purpose, so the call succeeded
+		err = nil
+	}
 
 	stat, ok := status.FromError(err)
 	if !ok {
@@ -373,7 +513,7 @@ func invokeBidi(ctx context.Context, stub grpcdynamic.Stub, md *desc.MethodDescr
 	}
 
 	if str != nil {
-		handler.OnReceiveTrailers(stat, str.Trailer())
+		withHandler(func() { handler.OnReceiveTrailers(stat, str.Trailer()) })
 	}
 
 	return nil
@@ -0,0 +1,147 @@
+package grpcurl
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// StreamStopReason says why InvokeRPC stopped a response stream before the
+// server ended it.
+type StreamStopReason string
+
+const (
+	// StreamStoppedAtMaxResponses means that the stream was stopped because
+	// the maximum number of response messages had been received.
+	StreamStoppedAtMaxResponses = StreamStopReason("max-responses")
+	// StreamStoppedWhenIdle means that the stream was stopped because no
+	// response message was received within the idle timeout.
+	StreamStoppedWhenIdle = StreamStopReason("idle-timeout")
+)
+
+// StreamLimits describes when to stop the response stream of a
+// server-streaming or bidi-streaming RPC, for methods whose streams never
+// end by themselves, such as ones that watch for changes. To apply them, use
+// WithStreamLimits with the context used to invoke the RPC.
+//
+// When a limit is reached, the RPC is cancelled, but InvokeRPC reports that it
+// completed with a status of OK instead of Canceled. Any response messages
+// received after that are discarded. Limits do not apply to unary or
+// client-streaming RPCs. For bidi-streaming RPCs, InvokeRPC still waits for
+// the request supplier to return before it returns, just as it does when the
+// server ends the stream.
+type StreamLimits struct {
+	// MaxResponses is the number of response messages after which to stop
+	// the stream. If zero, there is no limit.
+	MaxResponses int
+	// IdleTimeout is how long to wait for each response message, including
+	// the first, before stopping the stream. If zero, there is no limit.
+	IdleTimeout time.Duration
+
+	// Stopped is set by InvokeRPC to the reason the stream was stopped, if
+	// it was stopped because of one of the above limits. It is empty if the
+	// stream ended in any other way.
+	Stopped StreamStopReason
+}
+
+type streamLimitsKey struct{}
+
+// WithStreamLimits returns a context that, when used to invoke an RPC,
+// applies the given limits to the RPC's response stream. The context should
+// be used for only one RPC, since the reason the stream was stopped is
+// recorded in limits.
+func WithStreamLimits(ctx context.Context, limits *StreamLimits) context.Context {
+	return context.WithValue(ctx, streamLimitsKey{}, limits)
+}
+
+// streamLimiter applies StreamLimits to an RPC. Its idle timer runs on its
+// own goroutine, so it is guarded by a mutex. A nil *streamLimiter applies no
+// limits.
+type streamLimiter struct {
+	mu           sync.Mutex
+	limits       *StreamLimits
+	cancel       context.CancelFunc
+	idleTimer    *time.Timer
+	numResponses int
+}
+
+// newStreamLimiter returns a limiter for the limits in the given context, if
+// any, along with the context to use for the RPC, which the limiter cancels
+// when a limit is reached.
+func newStreamLimiter(ctx context.Context) (context.Context, *streamLimiter) {
+	limits, ok := ctx.Value(streamLimitsKey{}).(*StreamLimits)
+	if !ok || limits == nil || (limits.MaxResponses <= 0 && limits.IdleTimeout <= 0) {
+		return ctx, nil
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	l := &streamLimiter{limits: limits, cancel: cancel}
+	if limits.IdleTimeout > 0 {
+		l.idleTimer = time.AfterFunc(limits.IdleTimeout, func() {
+			l.stop(StreamStoppedWhenIdle)
+		})
+	}
+	return ctx, l
+}
+
+// onResponse is called when a response message is received. It returns false
+// if the stream has already been stopped, in which case the message should be
+// discarded. Otherwise, it stops the stream if that was the last message
+// allowed.
+func (l *streamLimiter) onResponse() bool {
+	if l == nil {
+		return true
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.limits.Stopped != "" {
+		return false
+	}
+	l.numResponses++
+	if l.limits.MaxResponses > 0 && l.numResponses >= l.limits.MaxResponses {
+		l.stopLocked(StreamStoppedAtMaxResponses)
+	} else if l.idleTimer != nil {
+		l.idleTimer.Reset(l.limits.IdleTimeout)
+	}
+	return true
+}
+
+func (l *streamLimiter) stop(reason StreamStopReason) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.stopLocked(reason)
+}
+
+func (l *streamLimiter) stopLocked(reason StreamStopReason) {
+	if l.limits.Stopped != "" {
+		return
+	}
+	l.limits.Stopped = reason
+	if l.idleTimer != nil {
+		l.idleTimer.Stop()
+	}
+	l.cancel()
+}
+
+// stopped returns true if the stream was stopped because of a limit.
+func (l *streamLimiter) stopped() bool {
+	if l == nil {
+		return false
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.limits.Stopped != ""
+}
+
+// release stops the idle timer and frees the limiter's context. It must be
+// called once the RPC completes.
+func (l *streamLimiter) release() {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.idleTimer != nil {
+		l.idleTimer.Stop()
+	}
+	l.cancel()
+}
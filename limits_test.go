@@ -0,0 +1,86 @@
+package grpcurl_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/codes"
+
+	. "github.com/tetrateio/grpcurl"
+)
+
+func TestStreamLimits(t *testing.T) {
+	testCases := []struct {
+		name         string
+		method       string
+		reqs         []string
+		limits       StreamLimits
+		numResponses int
+		stopped      StreamStopReason
+	}{
+		{
+			name:         "server stream, max responses",
+			method:       "testing.TestService/StreamingOutputCall",
+			reqs:         []string{`{"response_parameters": [{"size": 1}, {"size": 2}, {"size": 3}, {"size": 4}]}`},
+			limits:       StreamLimits{MaxResponses: 2},
+			numResponses: 2,
+			stopped:      StreamStoppedAtMaxResponses,
+		},
+		{
+			name:         "server stream, idle",
+			method:       "testing.TestService/StreamingOutputCall",
+			reqs:         []string{`{"response_parameters": [{"size": 1}, {"size": 2, "interval_us": 5000000}]}`},
+			limits:       StreamLimits{MaxResponses: 10, IdleTimeout: 200 * time.Millisecond},
+			numResponses: 1,
+			stopped:      StreamStoppedWhenIdle,
+		},
+		{
+			name:         "server stream, limits not reached",
+			method:       "testing.TestService/StreamingOutputCall",
+			reqs:         []string{`{"response_parameters": [{"size": 1}, {"size": 2}]}`},
+			limits:       StreamLimits{MaxResponses: 3, IdleTimeout: 5 * time.Second},
+			numResponses: 2,
+		},
+		{
+			name:         "bidi stream, max responses",
+			method:       "testing.TestService/FullDuplexCall",
+			reqs:         []string{`{"response_parameters": [{"size": 1}]}`, `{"response_parameters": [{"size": 2}]}`, `{"response_parameters": [{"size": 3}]}`},
+			limits:       StreamLimits{MaxResponses: 2},
+			numResponses: 2,
+			stopped:      StreamStoppedAtMaxResponses,
+		},
+		{
+			name:         "unary, not limited",
+			method:       "testing.TestService/UnaryCall",
+			reqs:         []string{payload1},
+			limits:       StreamLimits{MaxResponses: 1, IdleTimeout: time.Nanosecond},
+			numResponses: 1,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			limits := tc.limits
+			h := &handler{reqMessages: tc.reqs}
+			start := time.Now()
+			ctx := WithStreamLimits(context.Background(), &limits)
+			err := InvokeRpc(ctx, sourceProtoset, ccNoReflect, tc.method, nil, h, h.getRequestData)
+			if err != nil {
+				t.Fatalf("unexpected error during RPC: %v", err)
+			}
+			if time.Since(start) > 3*time.Second {
+				t.Errorf("stream was not stopped promptly: took %v", time.Since(start))
+			}
+			if h.respTrailersCount != 1 || h.respStatus.Code() != codes.OK {
+				t.Errorf("expecting status OK, got %v", h.respStatus)
+			}
+			if len(h.respMessages) != tc.numResponses {
+				t.Errorf("expecting %d responses, got %d", tc.numResponses, len(h.respMessages))
+			}
+			if limits.Stopped != tc.stopped {
+				t.Errorf("expecting stream stopped because of %q, got %q", tc.stopped, limits.Stopped)
+			}
+		})
+	}
+}
@@ -0,0 +1,109 @@
+package grpcurl
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+)
+
+// PeerToString returns a string representation of the given peer, for
+// displaying to users. It includes the peer's address and, if the connection
+// uses TLS, the negotiated TLS version, cipher suite, and ALPN protocol, and
+// the certificate chain that the peer presented.
+func PeerToString(p *peer.Peer) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Address: %v", p.Addr)
+	tlsInfo, ok := p.AuthInfo.(credentials.TLSInfo)
+	if !ok {
+		if p.AuthInfo != nil {
+			fmt.Fprintf(&b, "\nSecurity: %s", p.AuthInfo.AuthType())
+		} else {
+			b.WriteString("\nSecurity: none")
+		}
+		return b.String()
+	}
+	b.WriteString("\n")
+	b.WriteString(tlsStateToString(tlsInfo.State))
+	return b.String()
+}
+
+// tlsStateToString describes the given TLS connection state: the negotiated
+// TLS version, cipher suite, and ALPN protocol, and the peer's certificate
+// chain.
+func tlsStateToString(state tls.ConnectionState) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "TLS version: %s", TLSVersionName(state.Version))
+	fmt.Fprintf(&b, "\nCipher suite: %s", tls.CipherSuiteName(state.CipherSuite))
+	if state.NegotiatedProtocol != "" {
+		fmt.Fprintf(&b, "\nALPN protocol: %s", state.NegotiatedProtocol)
+	}
+	b.WriteString("\nCertificate chain:")
+	if len(state.PeerCertificates) == 0 {
+		b.WriteString(" (empty)")
+	}
+	now := time.Now()
+	for i, cert := range state.PeerCertificates {
+		prefix := fmt.Sprintf("  %d) ", i)
+		b.WriteString("\n")
+		b.WriteString(prefix)
+		b.WriteString(strings.ReplaceAll(certificateToString(cert, now), "\n", "\n"+strings.Repeat(" ", len(prefix))))
+	}
+	return b.String()
+}
+
+// certificateToString describes the given certificate: its subject, subject
+// alternative names, issuer, and expiry.
+func certificateToString(cert *x509.Certificate, now time.Time) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Subject: %s", cert.Subject)
+	var sans []string
+	sans = append(sans, cert.DNSNames...)
+	for _, ip := range cert.IPAddresses {
+		sans = append(sans, ip.String())
+	}
+	for _, uri := range cert.URIs {
+		sans = append(sans, uri.String())
+	}
+	sans = append(sans, cert.EmailAddresses...)
+	if len(sans) > 0 {
+		fmt.Fprintf(&b, "\nSANs: %s", strings.Join(sans, ", "))
+	}
+	fmt.Fprintf(&b, "\nIssuer: %s", cert.Issuer)
+	fmt.Fprintf(&b, "\nExpires: %s", cert.NotAfter.UTC().Format(time.RFC3339))
+	if days := daysUntil(cert.NotAfter, now); now.After(cert.NotAfter) {
+		fmt.Fprintf(&b, " (expired %d days ago)", -days)
+	} else {
+		fmt.Fprintf(&b, " (in %d days)", days)
+	}
+	if now.Before(cert.NotBefore) {
+		fmt.Fprintf(&b, "\nNot valid until: %s", cert.NotBefore.UTC().Format(time.RFC3339))
+	}
+	return b.String()
+}
+
+// daysUntil returns the number of whole days from now until t, which is
+// negative if t is in the past.
+func daysUntil(t, now time.Time) int {
+	return int(t.Sub(now) / (24 * time.Hour))
+}
+
+// TLSVersionName returns the name of the given TLS version, such as "TLS 1.3".
+func TLSVersionName(version uint16) string {
+	switch version {
+	case tls.VersionTLS10:
+		return "TLS 1.0"
+	case tls.VersionTLS11:
+		return "TLS 1.1"
+	case tls.VersionTLS12:
+		return "TLS 1.2"
+	case tls.VersionTLS13:
+		return "TLS 1.3"
+	default:
+		return fmt.Sprintf("0x%04X", version)
+	}
+}
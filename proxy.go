@@ -0,0 +1,318 @@
+package grpcurl
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/protobuf/jsonpb" //lint:ignore SA1019 we have to import this because it appears in exported API
+	"github.com/jhump/protoreflect/desc"
+	"github.com/jhump/protoreflect/dynamic"
+	"github.com/jhump/protoreflect/dynamic/grpcdynamic"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// ProxyRecord describes a call that was forwarded by a proxy server (see
+// NewProxyServer). It is a CallRecord, so a log of proxied calls can be
+// replayed with ReplayCalls, along with a description of the call's outcome.
+type ProxyRecord struct {
+	CallRecord
+	// ResponseHeaders are the response headers. Values for binary headers
+	// (whose names end in "-bin") are base64-encoded.
+	ResponseHeaders map[string][]string `json:"responseHeaders,omitempty"`
+	// Responses are the JSON forms of all response messages.
+	Responses []json.RawMessage `json:"responses,omitempty"`
+	// Trailers are the response trailers. Values for binary trailers (whose
+	// names end in "-bin") are base64-encoded.
+	Trailers map[string][]string `json:"trailers,omitempty"`
+	// Status is the status of the call.
+	Status *EnvelopeStatus `json:"status"`
+	// DurationMillis is how long the call took, in milliseconds.
+	DurationMillis float64 `json:"durationMs"`
+	// RawRequests are the request messages that could not be decoded, such
+	// as those of methods that are not in the descriptor source, in their
+	// binary form. In JSON, they are base64-encoded.
+	RawRequests [][]byte `json:"rawRequests,omitempty"`
+	// RawResponses are the response messages that could not be decoded, in
+	// their binary form. In JSON, they are base64-encoded.
+	RawResponses [][]byte `json:"rawResponses,omitempty"`
+}
+
+// NewProxyServer returns a gRPC server that forwards every call it receives to
+// the given channel. Messages are forwarded as bytes, without being decoded,
+// so calls to any method are forwarded, even those not in the descriptor
+// source. Request headers are forwarded, except for pseudo-headers, reserved
+// "grpc-" headers, and the content-type and user-agent headers. Response
+// headers, trailers, and the status, including its details, are returned to
+// the client unchanged.
+//
+// When a call completes, a ProxyRecord that describes it is written to the
+// given log as a line of JSON, so the log can later be replayed. Messages are
+// decoded to JSON using the given descriptor source; if the source is nil or
+// the method cannot be found, they are recorded in binary form instead. Calls
+// to the server reflection service are forwarded but not logged. If log is
+// nil, calls are forwarded without being logged.
+//
+// The given options are used to create the server.
+func NewProxyServer(ch grpcdynamic.Channel, source DescriptorSource, log io.Writer, opts ...grpc.ServerOption) *grpc.Server {
+	p := &proxy{ch: ch, source: source, log: log}
+	if source != nil {
+		p.marshaler = jsonpb.Marshaler{AnyResolver: AnyResolverFromDescriptorSource(source)}
+		p.detailMarshaler = jsonpb.Marshaler{AnyResolver: AnyResolverFromDescriptorSourceWithFallback(source)}
+	}
+	opts = append([]grpc.ServerOption{
+		grpc.ForceServerCodec(rawCodec{}),
+		grpc.UnknownServiceHandler(p.handle),
+	}, opts...)
+	return grpc.NewServer(opts...)
+}
+
+// rawCodec is a codec that leaves messages in their binary form. Messages must
+// be of type *[]byte. It is named "proto", since servers are expected to
+// support that content-subtype.
+type rawCodec struct{}
+
+func (rawCodec) Marshal(v interface{}) ([]byte, error) {
+	b, ok := v.(*[]byte)
+	if !ok {
+		return nil, fmt.Errorf("unexpected message type %T", v)
+	}
+	return *b, nil
+}
+
+func (rawCodec) Unmarshal(data []byte, v interface{}) error {
+	b, ok := v.(*[]byte)
+	if !ok {
+		return fmt.Errorf("unexpected message type %T", v)
+	}
+	*b = append((*b)[:0], data...)
+	return nil
+}
+
+func (rawCodec) Name() string {
+	return "proto"
+}
+
+type proxy struct {
+	ch              grpcdynamic.Channel
+	source          DescriptorSource
+	marshaler       jsonpb.Marshaler
+	detailMarshaler jsonpb.Marshaler
+
+	mu  sync.Mutex
+	log io.Writer
+}
+
+func (p *proxy) handle(_ interface{}, ss grpc.ServerStream) error {
+	fullMethod, ok := grpc.MethodFromServerStream(ss)
+	if !ok {
+		return status.Error(codes.Internal, "failed to determine method of call")
+	}
+	ctx, cancel := context.WithCancel(ss.Context())
+	defer cancel()
+
+	inMD, _ := metadata.FromIncomingContext(ctx)
+	outMD := metadata.MD{}
+	for k, vs := range inMD {
+		if strings.HasPrefix(k, ":") || strings.HasPrefix(k, "grpc-") || k == "content-type" || k == "user-agent" {
+			continue
+		}
+		outMD[k] = vs
+	}
+	ctx = metadata.NewOutgoingContext(ctx, outMD)
+	rec := p.newRecorder(strings.TrimPrefix(fullMethod, "/"), outMD)
+
+	streamDesc := &grpc.StreamDesc{ServerStreams: true, ClientStreams: true}
+	cs, err := p.ch.NewStream(ctx, streamDesc, fullMethod, grpc.ForceCodec(rawCodec{}))
+	if err != nil {
+		p.finish(rec, err, nil)
+		return err
+	}
+
+	go func() {
+		for {
+			var req []byte
+			if err := ss.RecvMsg(&req); err == io.EOF {
+				_ = cs.CloseSend()
+				return
+			} else if err != nil {
+				// client went away, so abandon the call
+				cancel()
+				return
+			}
+			rec.request(req)
+			if err := cs.SendMsg(&req); err != nil {
+				// the call failed; its status is returned by cs.RecvMsg
+				return
+			}
+		}
+	}()
+
+	if md, err := cs.Header(); err == nil {
+		rec.responseHeaders(md)
+		if err := ss.SendHeader(md); err != nil {
+			p.finish(rec, err, nil)
+			return err
+		}
+	}
+	for {
+		var resp []byte
+		if err := cs.RecvMsg(&resp); err != nil {
+			if err == io.EOF {
+				err = nil
+			}
+			trailers := cs.Trailer()
+			ss.SetTrailer(trailers)
+			p.finish(rec, err, trailers)
+			return err
+		}
+		rec.response(resp)
+		if err := ss.SendMsg(&resp); err != nil {
+			p.finish(rec, err, cs.Trailer())
+			return err
+		}
+	}
+}
+
+func (p *proxy) newRecorder(method string, md metadata.MD) *proxyRecorder {
+	rec := &proxyRecorder{
+		marshaler: p.marshaler,
+		start:     time.Now(),
+		skip:      p.log == nil || strings.HasPrefix(method, "grpc.reflection."),
+	}
+	if rec.skip {
+		return rec
+	}
+	rec.record.Method = method
+	rec.record.Headers = metadataToHeaders(md)
+	if p.source != nil {
+		if mtd, err := resolveMethod(p.source, method); err == nil {
+			rec.method = mtd
+		}
+	}
+	return rec
+}
+
+func (p *proxy) finish(rec *proxyRecorder, err error, trailers metadata.MD) {
+	if rec.skip {
+		return
+	}
+	rec.mu.Lock()
+	r := rec.record
+	rec.mu.Unlock()
+
+	r.Trailers = metadataToJSON(trailers)
+	r.Status = statusToJSON(status.Convert(err), p.detailMarshaler)
+	r.DurationMillis = float64(time.Since(rec.start)) / float64(time.Millisecond)
+	if rec.method != nil && !rec.method.IsClientStreaming() && len(r.Requests) == 1 {
+		r.Request, r.Requests = r.Requests[0], nil
+	}
+	b, err := json.Marshal(&r)
+	if err != nil {
+		// should not be possible, since all messages are valid JSON
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.log.Write(append(b, '\n'))
+}
+
+// proxyRecorder records the events of a single proxied call. Requests and
+// responses are recorded by different goroutines, so it is guarded by a mutex.
+type proxyRecorder struct {
+	method    *desc.MethodDescriptor
+	marshaler jsonpb.Marshaler
+	start     time.Time
+	skip      bool
+
+	mu     sync.Mutex
+	record ProxyRecord
+}
+
+func (r *proxyRecorder) request(data []byte) {
+	if r.skip {
+		return
+	}
+	js := r.decode(data, true)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if js != nil {
+		r.record.Requests = append(r.record.Requests, js)
+	} else {
+		r.record.RawRequests = append(r.record.RawRequests, append([]byte(nil), data...))
+	}
+}
+
+func (r *proxyRecorder) responseHeaders(md metadata.MD) {
+	if r.skip {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.record.ResponseHeaders = metadataToJSON(md)
+}
+
+func (r *proxyRecorder) response(data []byte) {
+	if r.skip {
+		return
+	}
+	js := r.decode(data, false)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if js != nil {
+		r.record.Responses = append(r.record.Responses, js)
+	} else {
+		r.record.RawResponses = append(r.record.RawResponses, append([]byte(nil), data...))
+	}
+}
+
+// decode returns the JSON form of the given request or response message data,
+// or nil if it cannot be decoded.
+func (r *proxyRecorder) decode(data []byte, isRequest bool) json.RawMessage {
+	if r.method == nil {
+		return nil
+	}
+	md := r.method.GetOutputType()
+	if isRequest {
+		md = r.method.GetInputType()
+	}
+	msg := dynamic.NewMessage(md)
+	if err := msg.Unmarshal(data); err != nil {
+		return nil
+	}
+	str, err := r.marshaler.MarshalToString(msg)
+	if err != nil {
+		return nil
+	}
+	return json.RawMessage(str)
+}
+
+// metadataToHeaders converts the given metadata to headers in 'name: value'
+// format, as accepted by MetadataFromHeaders. Values of binary headers are
+// base64-encoded.
+func metadataToHeaders(md metadata.MD) []string {
+	keys := make([]string, 0, len(md))
+	for k := range md {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var headers []string
+	for _, k := range keys {
+		for _, v := range md[k] {
+			if strings.HasSuffix(k, "-bin") {
+				v = base64.StdEncoding.EncodeToString([]byte(v))
+			}
+			headers = append(headers, k+": "+v)
+		}
+	}
+	return headers
+}
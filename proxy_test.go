@@ -0,0 +1,158 @@
+package grpcurl_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+
+	. "github.com/tetrateio/grpcurl"
+)
+
+// lockedBuffer is a buffer that can be written by a server's goroutines while
+// a test reads it.
+type lockedBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *lockedBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func startProxy(t *testing.T, source DescriptorSource, log *lockedBuffer) *grpc.ClientConn {
+	svr := NewProxyServer(ccReflect, source, log)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	go svr.Serve(l)
+	t.Cleanup(svr.Stop)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	cc, err := grpc.DialContext(ctx, l.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()), grpc.WithBlock())
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+	t.Cleanup(func() { cc.Close() })
+	return cc
+}
+
+func TestProxyServer(t *testing.T) {
+	var log lockedBuffer
+	cc := startProxy(t, sourceProtoset, &log)
+
+	// calls through the proxy behave like calls to the server
+	var out bytes.Buffer
+	results, err := ReplayCalls(context.Background(), sourceProtoset, cc, nil, strings.NewReader(callLog), &out)
+	if err != nil {
+		t.Fatalf("failed to replay calls through proxy: %v", err)
+	}
+	expectedCodes := []string{"OK", "OK", "OK", "NotFound", "Unknown"}
+	for i, res := range results {
+		if res.Code != expectedCodes[i] {
+			t.Errorf("result %d: wrong code: expected %s, got %s (%s)", i+1, expectedCodes[i], res.Code, res.Error)
+		}
+	}
+	if vals := results[1].Headers["foo"]; len(vals) != 1 || vals[0] != "bar" {
+		t.Errorf("result 2: wrong response headers: %v", results[1].Headers)
+	}
+	if results[3].Message != "fail" {
+		t.Errorf("result 4: wrong status message: %q", results[3].Message)
+	}
+
+	// the unknown method was never sent, so four calls are logged
+	lines := strings.Split(strings.TrimSpace(log.String()), "\n")
+	if len(lines) != 4 {
+		t.Fatalf("wrong number of logged calls: expected 4, got %d:\n%s", len(lines), log.String())
+	}
+	var recs []ProxyRecord
+	for i, line := range lines {
+		var rec ProxyRecord
+		if err := json.Unmarshal([]byte(line), &rec); err != nil {
+			t.Fatalf("logged call %d is not valid: %v", i+1, err)
+		}
+		recs = append(recs, rec)
+	}
+	if recs[1].Method != "testing.TestService/UnaryCall" || string(recs[1].Request) != `{"payload":{"body":"AQID"}}` {
+		t.Errorf("logged call 2: wrong method or request: %s %s", recs[1].Method, recs[1].Request)
+	}
+	if len(recs[1].Headers) != 1 || recs[1].Headers[0] != "reply-with-headers: foo: bar" {
+		t.Errorf("logged call 2: wrong headers: %v", recs[1].Headers)
+	}
+	if vals := recs[1].ResponseHeaders["foo"]; len(vals) != 1 || vals[0] != "bar" {
+		t.Errorf("logged call 2: wrong response headers: %v", recs[1].ResponseHeaders)
+	}
+	if len(recs[1].Responses) != 1 || string(recs[1].Responses[0]) != `{"payload":{"body":"AQID"}}` {
+		t.Errorf("logged call 2: wrong responses: %s", recs[1].Responses)
+	}
+	if len(recs[2].Requests) != 2 || len(recs[2].Responses) != 1 || string(recs[2].Responses[0]) != `{"aggregatedPayloadSize":5}` {
+		t.Errorf("logged call 3: wrong requests or responses: %s %s", recs[2].Requests, recs[2].Responses)
+	}
+	if recs[3].Status.Code != "NotFound" || recs[3].Status.Message != "fail" {
+		t.Errorf("logged call 4: wrong status: %+v", recs[3].Status)
+	}
+
+	// the log can be replayed
+	results, err = ReplayCalls(context.Background(), sourceProtoset, ccReflect, nil, strings.NewReader(log.String()), &out)
+	if err != nil {
+		t.Fatalf("failed to replay logged calls: %v", err)
+	}
+	for i, res := range results {
+		if res.Code != recs[i].Status.Code {
+			t.Errorf("replayed call %d: wrong code: expected %s, got %s", i+1, recs[i].Status.Code, res.Code)
+		}
+		if len(res.Responses) != len(recs[i].Responses) {
+			t.Errorf("replayed call %d: wrong number of responses: expected %d, got %d", i+1, len(recs[i].Responses), len(res.Responses))
+		}
+	}
+}
+
+func TestProxyServerWithoutDescriptors(t *testing.T) {
+	var log lockedBuffer
+	cc := startProxy(t, nil, &log)
+
+	h := &handler{}
+	rf, _, err := RequestParserAndFormatter(FormatJSON, sourceProtoset, strings.NewReader(`{"payload": {"body": "AQID"}}`), FormatOptions{})
+	if err != nil {
+		t.Fatalf("failed to create request parser: %v", err)
+	}
+	if err := InvokeRPC(context.Background(), sourceProtoset, cc, "testing.TestService/UnaryCall", nil, h, rf.Next); err != nil {
+		t.Fatalf("failed to invoke: %v", err)
+	}
+	if h.respStatus.Err() != nil || len(h.respMessages) != 1 {
+		t.Fatalf("call through proxy failed: %v, %d responses", h.respStatus.Err(), len(h.respMessages))
+	}
+
+	// messages are logged in binary form
+	var rec ProxyRecord
+	if err := json.Unmarshal([]byte(log.String()), &rec); err != nil {
+		t.Fatalf("logged call is not valid: %v", err)
+	}
+	if len(rec.Requests) != 0 || len(rec.Request) != 0 || len(rec.Responses) != 0 {
+		t.Errorf("messages should not be decoded: %s", log.String())
+	}
+	// the payload is field 3 of the request and field 1 of the response
+	if len(rec.RawRequests) != 1 || !bytes.Equal(rec.RawRequests[0], []byte{0x1a, 0x05, 0x12, 0x03, 0x01, 0x02, 0x03}) {
+		t.Errorf("wrong raw requests: %x", rec.RawRequests)
+	}
+	if len(rec.RawResponses) != 1 || !bytes.Equal(rec.RawResponses[0], []byte{0x0a, 0x05, 0x12, 0x03, 0x01, 0x02, 0x03}) {
+		t.Errorf("wrong raw responses: %x", rec.RawResponses)
+	}
+}
@@ -0,0 +1,269 @@
+package grpcurl
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/golang/protobuf/jsonpb" //lint:ignore SA1019 we have to import this because it appears in exported API
+	"github.com/golang/protobuf/proto"  //lint:ignore SA1019 we have to import this because it appears in exported API
+	"github.com/jhump/protoreflect/desc"
+	"github.com/jhump/protoreflect/dynamic/grpcdynamic"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// CallRecord describes a single RPC in a call log. A call log is a JSONL
+// stream: each line is a JSON object that describes one call.
+type CallRecord struct {
+	// Method is the fully-qualified name of the method to invoke, in
+	// 'service/method' or 'service.method' format.
+	Method string `json:"method"`
+	// Headers are request headers, each in 'name: value' format (just like
+	// the -H command-line option).
+	Headers []string `json:"headers,omitempty"`
+	// Request is the JSON form of the request message, for calls that send
+	// a single message.
+	Request json.RawMessage `json:"request,omitempty"`
+	// Requests are the JSON forms of all request messages, for calls that
+	// send a stream of messages. It is an error to set both Request and
+	// Requests.
+	Requests []json.RawMessage `json:"requests,omitempty"`
+	// Expect, if present, describes the expected outcome of the call.
+	Expect *Expectations `json:"expect,omitempty"`
+}
+
+// CallResult describes the outcome of invoking a CallRecord. Results are
+// written as JSONL, one line per call, in the same order as the call log.
+type CallResult struct {
+	// Method is the method that was invoked, as given in the call record.
+	Method string `json:"method"`
+	// Code is the name of the RPC's status code, such as "OK" or "NotFound".
+	Code string `json:"code"`
+	// Message is the status message, if any.
+	Message string `json:"message,omitempty"`
+	// Headers are the response headers. Values for binary headers (whose
+	// names end in "-bin") are base64-encoded.
+	Headers map[string][]string `json:"headers,omitempty"`
+	// Trailers are the response trailers. Values for binary trailers (whose
+	// names end in "-bin") are base64-encoded.
+	Trailers map[string][]string `json:"trailers,omitempty"`
+	// Responses are the JSON forms of all response messages received.
+	Responses []json.RawMessage `json:"responses,omitempty"`
+	// DurationMillis is how long the call took, in milliseconds.
+	DurationMillis float64 `json:"durationMs"`
+	// Error describes a failure that prevented the call from being made or
+	// from completing, such as an unknown method or malformed request data.
+	// When set, Code will be "Unknown" unless the failure had a gRPC status.
+	Error string `json:"error,omitempty"`
+	// Passed indicates whether all expectations were met. It is only set if
+	// the call record included expectations.
+	Passed *bool `json:"passed,omitempty"`
+	// Failures describe the expectations that were not met.
+	Failures []ExpectationFailure `json:"failures,omitempty"`
+}
+
+// StatusCode returns the status code of the call. If the code is not
+// recognized, codes.Unknown is returned.
+func (r *CallResult) StatusCode() codes.Code {
+	c, err := ParseCode(r.Code)
+	if err != nil {
+		return codes.Unknown
+	}
+	return c
+}
+
+// ReadCallRecords reads a call log from the given reader. Blank lines and lines
+// whose first non-space character is '#' are ignored.
+func ReadCallRecords(in io.Reader) ([]*CallRecord, error) {
+	var records []*CallRecord
+	scanner := bufio.NewScanner(in)
+	// allow for large request messages
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		var rec CallRecord
+		if err := json.Unmarshal([]byte(line), &rec); err != nil {
+			return nil, fmt.Errorf("line %d: could not parse call record: %v", lineNum, err)
+		}
+		if rec.Method == "" {
+			return nil, fmt.Errorf("line %d: call record does not specify a method", lineNum)
+		}
+		if len(rec.Request) > 0 && len(rec.Requests) > 0 {
+			return nil, fmt.Errorf("line %d: call record may have 'request' or 'requests', but not both", lineNum)
+		}
+		records = append(records, &rec)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return records, nil
+}
+
+// InvokeCallRecord invokes the RPC described by the given call record, using
+// the given descriptor source and channel. The given headers are sent in
+// addition to those in the record. The returned result is never nil: problems
+// invoking the RPC are described by the result's Error field.
+func InvokeCallRecord(ctx context.Context, source DescriptorSource, ch grpcdynamic.Channel, headers []string, rec *CallRecord) *CallResult {
+	return invokeCallRecord(ctx, source, ch, headers, rec, nil)
+}
+
+// invokeCallRecord is like InvokeCallRecord, but also calls the given function,
+// if not nil, with each response message.
+func invokeCallRecord(ctx context.Context, source DescriptorSource, ch grpcdynamic.Channel, headers []string, rec *CallRecord, onResponse func(proto.Message)) *CallResult {
+	resolver := AnyResolverFromDescriptorSource(source)
+	h := &callResultHandler{
+		marshaler:  jsonpb.Marshaler{AnyResolver: anyResolverWithFallback{AnyResolver: resolver}},
+		result:     CallResult{Method: rec.Method},
+		onResponse: onResponse,
+	}
+	var handler InvocationEventHandler = h
+	var eh *ExpectationHandler
+	if rec.Expect != nil {
+		// check field expectations before invoking the RPC; if the method
+		// can't be resolved, InvokeRPC reports that instead
+		md, _ := resolveMethod(source, rec.Method)
+		var err error
+		if eh, err = NewExpectationHandler(h, source, md, rec.Expect); err != nil {
+			passed := false
+			h.result.Code = codes.Unknown.String()
+			h.result.Error = err.Error()
+			h.result.Passed = &passed
+			return &h.result
+		}
+		handler = eh
+	}
+
+	reqs := rec.Requests
+	if len(rec.Request) > 0 {
+		reqs = []json.RawMessage{rec.Request}
+	}
+	unmarshaler := jsonpb.Unmarshaler{AnyResolver: resolver}
+	supplier := func(m proto.Message) error {
+		if len(reqs) == 0 {
+			return io.EOF
+		}
+		data := reqs[0]
+		reqs = reqs[1:]
+		return unmarshaler.Unmarshal(bytes.NewReader(data), m)
+	}
+
+	allHeaders := make([]string, 0, len(headers)+len(rec.Headers))
+	allHeaders = append(allHeaders, headers...)
+	allHeaders = append(allHeaders, rec.Headers...)
+
+	start := time.Now()
+	err := InvokeRPC(ctx, source, ch, rec.Method, allHeaders, handler, supplier)
+	h.result.DurationMillis = float64(time.Since(start)) / float64(time.Millisecond)
+	if err != nil {
+		stat, ok := status.FromError(err)
+		if !ok {
+			stat = status.New(codes.Unknown, "")
+		}
+		h.result.Code = stat.Code().String()
+		h.result.Message = stat.Message()
+		h.result.Error = err.Error()
+	}
+	if eh != nil {
+		h.result.Failures = eh.Failures()
+		passed := err == nil && len(h.result.Failures) == 0
+		h.result.Passed = &passed
+	}
+	return &h.result
+}
+
+// ReplayCalls reads a call log from the given reader, invokes each call in
+// order, and writes a JSONL result for each one to the given writer. All calls
+// share the given descriptor source and channel, and the given headers are
+// sent with every call. It returns the results, in the same order as the call
+// log. An error is returned if the call log cannot be read or if results
+// cannot be written. Calls that fail do not stop the replay.
+func ReplayCalls(ctx context.Context, source DescriptorSource, ch grpcdynamic.Channel, headers []string, in io.Reader, out io.Writer) ([]*CallResult, error) {
+	records, err := ReadCallRecords(in)
+	if err != nil {
+		return nil, err
+	}
+	results := make([]*CallResult, 0, len(records))
+	enc := json.NewEncoder(out)
+	for _, rec := range records {
+		if err := ctx.Err(); err != nil {
+			return results, err
+		}
+		res := InvokeCallRecord(ctx, source, ch, headers, rec)
+		results = append(results, res)
+		if err := enc.Encode(res); err != nil {
+			return results, fmt.Errorf("failed to write result: %v", err)
+		}
+	}
+	return results, nil
+}
+
+// callResultHandler is an InvocationEventHandler that records the events of
+// an RPC into a CallResult.
+type callResultHandler struct {
+	marshaler  jsonpb.Marshaler
+	result     CallResult
+	onResponse func(proto.Message)
+}
+
+var _ InvocationEventHandler = (*callResultHandler)(nil)
+
+func (h *callResultHandler) OnResolveMethod(*desc.MethodDescriptor) {}
+
+func (h *callResultHandler) OnSendHeaders(metadata.MD) {}
+
+func (h *callResultHandler) OnReceiveHeaders(md metadata.MD) {
+	h.result.Headers = metadataToJSON(md)
+}
+
+func (h *callResultHandler) OnReceiveResponse(resp proto.Message) {
+	if h.onResponse != nil {
+		h.onResponse(resp)
+	}
+	str, err := h.marshaler.MarshalToString(resp)
+	if err != nil {
+		// should not be possible, but make sure the failure is visible
+		h.result.Error = fmt.Sprintf("failed to format response message %d: %v", len(h.result.Responses)+1, err)
+		return
+	}
+	h.result.Responses = append(h.result.Responses, json.RawMessage(str))
+}
+
+func (h *callResultHandler) OnReceiveTrailers(stat *status.Status, md metadata.MD) {
+	h.result.Code = stat.Code().String()
+	h.result.Message = stat.Message()
+	h.result.Trailers = metadataToJSON(md)
+}
+
+// metadataToJSON converts the given metadata to a map that is suitable for
+// encoding to JSON. Values of binary headers are base64-encoded, so that the
+// output is valid UTF-8. If the metadata is empty, nil is returned.
+func metadataToJSON(md metadata.MD) map[string][]string {
+	if len(md) == 0 {
+		return nil
+	}
+	m := make(map[string][]string, len(md))
+	for k, vs := range md {
+		if strings.HasSuffix(k, "-bin") {
+			encoded := make([]string, len(vs))
+			for i, v := range vs {
+				encoded[i] = base64.StdEncoding.EncodeToString([]byte(v))
+			}
+			vs = encoded
+		}
+		m[k] = vs
+	}
+	return m
+}
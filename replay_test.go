@@ -0,0 +1,91 @@
+package grpcurl_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	. "github.com/tetrateio/grpcurl"
+)
+
+const callLog = `
+# comments and blank lines are ignored
+
+{"method": "testing.TestService/EmptyCall"}
+{"method": "testing.TestService/UnaryCall", "headers": ["reply-with-headers: foo: bar"], "request": {"payload": {"body": "AQID"}}}
+{"method": "testing.TestService/StreamingInputCall", "requests": [{"payload": {"body": "AQID"}}, {"payload": {"body": "BAU="}}]}
+{"method": "testing.TestService/UnaryCall", "headers": ["fail-early: 5"]}
+{"method": "testing.TestService/NoSuchMethod"}
+`
+
+func TestReplayCalls(t *testing.T) {
+	for _, ds := range descSources {
+		t.Run(ds.name, func(t *testing.T) {
+			var out bytes.Buffer
+			results, err := ReplayCalls(context.Background(), ds.source, ccReflect, nil, strings.NewReader(callLog), &out)
+			if err != nil {
+				t.Fatalf("failed to replay calls: %v", err)
+			}
+			if len(results) != 5 {
+				t.Fatalf("wrong number of results: expected 5, got %d", len(results))
+			}
+
+			expectedCodes := []string{"OK", "OK", "OK", "NotFound", "Unknown"}
+			for i, res := range results {
+				if res.Code != expectedCodes[i] {
+					t.Errorf("result %d: wrong code: expected %s, got %s (%s)", i+1, expectedCodes[i], res.Code, res.Error)
+				}
+			}
+			if len(results[0].Responses) != 1 || string(results[0].Responses[0]) != "{}" {
+				t.Errorf("result 1: wrong responses: %s", results[0].Responses)
+			}
+			if vals := results[1].Headers["foo"]; len(vals) != 1 || vals[0] != "bar" {
+				t.Errorf("result 2: wrong response headers: %v", results[1].Headers)
+			}
+			if len(results[1].Responses) != 1 || string(results[1].Responses[0]) != `{"payload":{"body":"AQID"}}` {
+				t.Errorf("result 2: wrong responses: %s", results[1].Responses)
+			}
+			if len(results[2].Responses) != 1 || string(results[2].Responses[0]) != `{"aggregatedPayloadSize":5}` {
+				t.Errorf("result 3: wrong responses: %s", results[2].Responses)
+			}
+			if results[3].Message != "fail" || len(results[3].Responses) != 0 {
+				t.Errorf("result 4: wrong status message or responses: %q, %s", results[3].Message, results[3].Responses)
+			}
+			if !strings.Contains(results[4].Error, `does not include a method named "NoSuchMethod"`) {
+				t.Errorf("result 5: wrong error: %s", results[4].Error)
+			}
+
+			// written output should have one line per result
+			lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+			if len(lines) != len(results) {
+				t.Fatalf("wrong number of output lines: expected %d, got %d", len(results), len(lines))
+			}
+			for i, line := range lines {
+				var res CallResult
+				if err := json.Unmarshal([]byte(line), &res); err != nil {
+					t.Errorf("output line %d is not a valid result: %v", i+1, err)
+				} else if res.Code != expectedCodes[i] {
+					t.Errorf("output line %d: wrong code: expected %s, got %s", i+1, expectedCodes[i], res.Code)
+				}
+			}
+		})
+	}
+}
+
+func TestReadCallRecordsErrors(t *testing.T) {
+	testCases := []struct {
+		input, err string
+	}{
+		{`{"method": "a/b"`, "line 1: could not parse call record"},
+		{"\n{\"headers\": []}", "line 2: call record does not specify a method"},
+		{`{"method": "a/b", "request": {}, "requests": [{}]}`, "line 1: call record may have 'request' or 'requests', but not both"},
+	}
+	for _, tc := range testCases {
+		_, err := ReadCallRecords(strings.NewReader(tc.input))
+		if err == nil || !strings.Contains(err.Error(), tc.err) {
+			t.Errorf("expected error containing %q, got %v", tc.err, err)
+		}
+	}
+}
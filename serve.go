@@ -0,0 +1,430 @@
+package grpcurl
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/golang/protobuf/jsonpb" //lint:ignore SA1019 we have to import this because it appears in exported API
+	"github.com/golang/protobuf/proto"  //lint:ignore SA1019 we have to import this because it appears in exported API
+	"github.com/jhump/protoreflect/desc"
+	"github.com/jhump/protoreflect/dynamic"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/reflection"
+	reflectv1pb "google.golang.org/grpc/reflection/grpc_reflection_v1"
+	reflectpb "google.golang.org/grpc/reflection/grpc_reflection_v1alpha"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/dynamicpb"
+	"google.golang.org/protobuf/types/known/anypb"
+	"gopkg.in/yaml.v3"
+
+	// Register the standard error details, so they can be used in stub
+	// statuses even if the descriptor source does not include them
+	_ "google.golang.org/genproto/googleapis/rpc/errdetails"
+)
+
+// StubFile is the contents of a stub file, which describes how a stub server
+// answers calls. For example:
+//
+//	{
+//	  "stubs": [
+//	    {
+//	      "method": "my.custom.server.Service/GetCustomer",
+//	      "match": ["id == 1234"],
+//	      "headers": ["x-request-id: abc"],
+//	      "response": {"id": 1234, "name": "Alice"}
+//	    },
+//	    {
+//	      "method": "my.custom.server.Service/GetCustomer",
+//	      "status": {"code": "NotFound", "message": "no such customer"}
+//	    }
+//	  ]
+//	}
+type StubFile struct {
+	Stubs []*Stub `json:"stubs"`
+}
+
+// Stub describes the response to calls of a method. When a call is received,
+// the first stub for the method whose Match expressions all hold for the
+// request is used to answer it.
+type Stub struct {
+	// Method is the fully-qualified name of the method, in 'service/method'
+	// or 'service.method' format.
+	Method string `json:"method"`
+	// Match are expressions that must all hold for the request message, in
+	// the same syntax as field expectations (see ParseFieldExpectation). For
+	// client-streaming methods, they are checked against the first request
+	// message. If empty, the stub matches any request.
+	Match []string `json:"match,omitempty"`
+	// Headers are response headers, each in 'name: value' format.
+	Headers []string `json:"headers,omitempty"`
+	// Trailers are response trailers, each in 'name: value' format.
+	Trailers []string `json:"trailers,omitempty"`
+	// Response is the response message, for methods that return a single
+	// message. A JSON object is parsed as the JSON form of the message, and a
+	// JSON string is parsed as the protobuf text format.
+	Response json.RawMessage `json:"response,omitempty"`
+	// Responses are the response messages, for methods that return a stream
+	// of messages. It is an error to set both Response and Responses.
+	Responses []json.RawMessage `json:"responses,omitempty"`
+	// Status is the status of the call. If absent, the call succeeds.
+	Status *StubStatus `json:"status,omitempty"`
+}
+
+// StubStatus is the status with which a stub answers a call.
+type StubStatus struct {
+	// Code is the status code. It may be given by name, in either "NotFound"
+	// or "NOT_FOUND" form, or by number.
+	Code string `json:"code"`
+	// Message is the status message.
+	Message string `json:"message,omitempty"`
+	// Details are the JSON forms of the status's detail messages, each with
+	// an "@type" property, like the JSON form of a google.protobuf.Any
+	// message. Standard error details, such as google.rpc.ErrorInfo, can be
+	// used even if they are not in the descriptor source.
+	Details []json.RawMessage `json:"details,omitempty"`
+}
+
+// ReadStubFile reads a stub file from the given reader. The file may be in
+// JSON or YAML format.
+func ReadStubFile(in io.Reader) (*StubFile, error) {
+	// YAML is a superset of JSON, so this handles both
+	var doc interface{}
+	if err := yaml.NewDecoder(in).Decode(&doc); err != nil && err != io.EOF {
+		return nil, fmt.Errorf("failed to parse stub file: %v", err)
+	}
+	val, err := yamlToJSONValue(doc)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse stub file: %v", err)
+	}
+	js, err := json.Marshal(val)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse stub file: %v", err)
+	}
+	var sf StubFile
+	dec := json.NewDecoder(bytes.NewReader(js))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&sf); err != nil {
+		return nil, fmt.Errorf("failed to parse stub file: %v", err)
+	}
+	return &sf, nil
+}
+
+// NewStubServer returns a gRPC server that implements every service in the
+// given descriptor source, answering calls using the given stubs. The server
+// also exposes the server reflection service, so that the services can be
+// listed and described by clients such as grpcurl. Calls for which no stub
+// matches fail with a status of Unimplemented.
+//
+// Unary, client-streaming, and server-streaming calls are answered once all
+// request messages have been received. For bidi-streaming calls, each request
+// message is answered with the responses of the stub that matches it, and the
+// call ends when the client closes its stream, with the status and trailers of
+// the last stub used.
+//
+// An error is returned if any stub is invalid, such as if its method is not
+// in the descriptor source or if its messages cannot be parsed. The given
+// options are used to create the server.
+func NewStubServer(source DescriptorSource, stubs []*Stub, opts ...grpc.ServerOption) (*grpc.Server, error) {
+	resolver := AnyResolverFromDescriptorSourceWithFallback(source)
+	byMethod := map[string][]*parsedStub{}
+	for i, stub := range stubs {
+		ps, err := parseStub(source, resolver, stub)
+		if err != nil {
+			return nil, fmt.Errorf("stub %d: %v", i+1, err)
+		}
+		name := ps.method.GetFullyQualifiedName()
+		byMethod[name] = append(byMethod[name], ps)
+	}
+
+	files, err := GetAllFiles(source)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get files from descriptor source: %v", err)
+	}
+	svr := grpc.NewServer(opts...)
+	reg := &stubRegistry{files: &protoregistry.Files{}, types: &protoregistry.Types{}}
+	for _, fd := range files {
+		if err := reg.addFile(fd.UnwrapFile()); err != nil {
+			return nil, err
+		}
+		for _, sd := range fd.GetServices() {
+			if strings.HasPrefix(sd.GetFullyQualifiedName(), "grpc.reflection.") {
+				// registered below
+				continue
+			}
+			svcDesc := grpc.ServiceDesc{
+				ServiceName: sd.GetFullyQualifiedName(),
+				HandlerType: (*interface{})(nil),
+				Metadata:    fd.GetName(),
+			}
+			for _, md := range sd.GetMethods() {
+				h := &stubHandler{method: md, stubs: byMethod[md.GetFullyQualifiedName()], resolver: resolver}
+				svcDesc.Streams = append(svcDesc.Streams, grpc.StreamDesc{
+					StreamName:    md.GetName(),
+					Handler:       h.handle,
+					ServerStreams: md.IsServerStreaming(),
+					ClientStreams: md.IsClientStreaming(),
+				})
+			}
+			svr.RegisterService(&svcDesc, struct{}{})
+		}
+	}
+
+	reflOpts := reflection.ServerOptions{
+		Services:           svr,
+		DescriptorResolver: reg,
+		ExtensionResolver:  reg.types,
+	}
+	reflectpb.RegisterServerReflectionServer(svr, reflection.NewServer(reflOpts))
+	reflectv1pb.RegisterServerReflectionServer(svr, reflection.NewServerV1(reflOpts))
+	return svr, nil
+}
+
+// stubRegistry is used by the reflection service to find descriptors. Files
+// that are not in the descriptor source, like those of the reflection service
+// itself, are found in the files that are linked into the program.
+type stubRegistry struct {
+	files *protoregistry.Files
+	types *protoregistry.Types
+}
+
+func (r *stubRegistry) addFile(fd protoreflect.FileDescriptor) error {
+	if _, err := r.files.FindFileByPath(fd.Path()); err == nil {
+		return nil
+	}
+	imports := fd.Imports()
+	for i := 0; i < imports.Len(); i++ {
+		if err := r.addFile(imports.Get(i).FileDescriptor); err != nil {
+			return err
+		}
+	}
+	if err := r.files.RegisterFile(fd); err != nil {
+		return fmt.Errorf("failed to register %s: %v", fd.Path(), err)
+	}
+	return r.addExtensions(fd.Extensions(), fd.Messages())
+}
+
+func (r *stubRegistry) addExtensions(exts protoreflect.ExtensionDescriptors, msgs protoreflect.MessageDescriptors) error {
+	for i := 0; i < exts.Len(); i++ {
+		if err := r.types.RegisterExtension(dynamicpb.NewExtensionType(exts.Get(i))); err != nil {
+			return fmt.Errorf("failed to register extension %s: %v", exts.Get(i).FullName(), err)
+		}
+	}
+	for i := 0; i < msgs.Len(); i++ {
+		if err := r.addExtensions(msgs.Get(i).Extensions(), msgs.Get(i).Messages()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *stubRegistry) FindFileByPath(path string) (protoreflect.FileDescriptor, error) {
+	if fd, err := r.files.FindFileByPath(path); err == nil {
+		return fd, nil
+	}
+	return protoregistry.GlobalFiles.FindFileByPath(path)
+}
+
+func (r *stubRegistry) FindDescriptorByName(name protoreflect.FullName) (protoreflect.Descriptor, error) {
+	if d, err := r.files.FindDescriptorByName(name); err == nil {
+		return d, nil
+	}
+	return protoregistry.GlobalFiles.FindDescriptorByName(name)
+}
+
+// parsedStub is a stub whose messages and match expressions have been parsed.
+type parsedStub struct {
+	method    *desc.MethodDescriptor
+	match     []*FieldExpectation
+	headers   metadata.MD
+	trailers  metadata.MD
+	responses []proto.Message
+	status    *status.Status
+}
+
+func parseStub(source DescriptorSource, resolver jsonpb.AnyResolver, stub *Stub) (*parsedStub, error) {
+	md, err := resolveMethod(source, stub.Method)
+	if err != nil {
+		return nil, err
+	}
+	ps := &parsedStub{
+		method:   md,
+		headers:  MetadataFromHeaders(stub.Headers),
+		trailers: MetadataFromHeaders(stub.Trailers),
+	}
+	for _, m := range stub.Match {
+		fe, err := ParseFieldExpectation(m)
+		if err != nil {
+			return nil, err
+		}
+		if err := fe.Validate(md.GetInputType()); err != nil {
+			return nil, err
+		}
+		ps.match = append(ps.match, fe)
+	}
+
+	if len(stub.Response) > 0 && len(stub.Responses) > 0 {
+		return nil, fmt.Errorf("only one of response and responses may be set")
+	}
+	resps := stub.Responses
+	if len(stub.Response) > 0 {
+		resps = []json.RawMessage{stub.Response}
+	}
+	if !md.IsServerStreaming() && len(resps) > 1 {
+		return nil, fmt.Errorf("method %s returns a single message, but stub has %d responses", md.GetFullyQualifiedName(), len(resps))
+	}
+	unmarshaler := jsonpb.Unmarshaler{AnyResolver: resolver}
+	for i, data := range resps {
+		msg := dynamic.NewMessage(md.GetOutputType())
+		var parser RequestParser
+		var text string
+		if err := json.Unmarshal(data, &text); err == nil {
+			parser = NewTextRequestParser(strings.NewReader(text))
+		} else {
+			parser = NewJSONRequestParserWithUnmarshaler(bytes.NewReader(data), unmarshaler)
+		}
+		if err := parser.Next(msg); err != nil {
+			return nil, fmt.Errorf("failed to parse response %d: %v", i+1, err)
+		}
+		ps.responses = append(ps.responses, msg)
+	}
+
+	if stub.Status != nil {
+		code, err := ParseCode(stub.Status.Code)
+		if err != nil {
+			return nil, err
+		}
+		stat := status.New(code, stub.Status.Message)
+		if len(stub.Status.Details) > 0 {
+			if code == codes.OK {
+				return nil, fmt.Errorf("status with code OK cannot have details")
+			}
+			statProto := stat.Proto()
+			for i, data := range stub.Status.Details {
+				var detail anypb.Any
+				if err := unmarshaler.Unmarshal(bytes.NewReader(data), &detail); err != nil {
+					return nil, fmt.Errorf("failed to parse status detail %d: %v", i+1, err)
+				}
+				statProto.Details = append(statProto.Details, &detail)
+			}
+			stat = status.FromProto(statProto)
+		}
+		ps.status = stat
+	}
+	return ps, nil
+}
+
+// matches returns true if the stub's match expressions all hold for the given
+// request.
+func (ps *parsedStub) matches(req proto.Message, resolver jsonpb.AnyResolver) bool {
+	for _, fe := range ps.match {
+		if fe.check(req, resolver) != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// stubHandler answers the calls of a single method.
+type stubHandler struct {
+	method   *desc.MethodDescriptor
+	stubs    []*parsedStub
+	resolver jsonpb.AnyResolver
+}
+
+func (h *stubHandler) find(req proto.Message) (*parsedStub, error) {
+	for _, ps := range h.stubs {
+		if ps.matches(req, h.resolver) {
+			return ps, nil
+		}
+	}
+	return nil, status.Errorf(codes.Unimplemented, "no stub for method %s matches the request", h.method.GetFullyQualifiedName())
+}
+
+func (h *stubHandler) handle(_ interface{}, ss grpc.ServerStream) error {
+	if h.method.IsClientStreaming() && h.method.IsServerStreaming() {
+		return h.handleBidi(ss)
+	}
+
+	// read all requests, and match against the first
+	var first proto.Message
+	for {
+		req := dynamic.NewMessage(h.method.GetInputType())
+		if err := ss.RecvMsg(req); err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+		if first == nil {
+			first = req
+		}
+		if !h.method.IsClientStreaming() {
+			break
+		}
+	}
+	if first == nil {
+		first = dynamic.NewMessage(h.method.GetInputType())
+	}
+	ps, err := h.find(first)
+	if err != nil {
+		return err
+	}
+	if err := ss.SetHeader(ps.headers); err != nil {
+		return err
+	}
+	if ps.status.Code() == codes.OK || h.method.IsServerStreaming() {
+		resps := ps.responses
+		if len(resps) == 0 && !h.method.IsServerStreaming() {
+			resps = []proto.Message{dynamic.NewMessage(h.method.GetOutputType())}
+		}
+		for _, resp := range resps {
+			if err := ss.SendMsg(resp); err != nil {
+				return err
+			}
+		}
+	}
+	ss.SetTrailer(ps.trailers)
+	return ps.status.Err()
+}
+
+func (h *stubHandler) handleBidi(ss grpc.ServerStream) error {
+	var last *parsedStub
+	for {
+		req := dynamic.NewMessage(h.method.GetInputType())
+		if err := ss.RecvMsg(req); err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+		ps, err := h.find(req)
+		if err != nil {
+			return err
+		}
+		if last == nil {
+			if err := ss.SetHeader(ps.headers); err != nil {
+				return err
+			}
+		}
+		last = ps
+		for _, resp := range ps.responses {
+			if err := ss.SendMsg(resp); err != nil {
+				return err
+			}
+		}
+		if ps.status.Code() != codes.OK {
+			ss.SetTrailer(ps.trailers)
+			return ps.status.Err()
+		}
+	}
+	if last == nil {
+		return nil
+	}
+	ss.SetTrailer(last.trailers)
+	return last.status.Err()
+}
@@ -0,0 +1,198 @@
+package grpcurl_test
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jhump/protoreflect/grpcreflect"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+
+	. "github.com/tetrateio/grpcurl"
+)
+
+const testStubs = `
+stubs:
+  - method: testing.TestService/UnaryCall
+    match: ['payload.body == "AQID"']
+    headers: ["x-stub: first"]
+    trailers: ["x-stub-trailer: done"]
+    response: {payload: {body: "BAUG"}}
+  - method: testing.TestService/UnaryCall
+    status:
+      code: NOT_FOUND
+      message: no such payload
+      details:
+        - {"@type": "type.googleapis.com/google.rpc.ErrorInfo", reason: "MISSING"}
+  - method: testing.TestService/StreamingOutputCall
+    responses:
+      - {payload: {body: "AQ=="}}
+      - 'payload: {body: "\x02"}'
+    status: {code: "8"}
+  - method: testing.TestService/StreamingInputCall
+    match: ["payload.body"]
+    response: {aggregatedPayloadSize: 42}
+  - method: testing.TestService/FullDuplexCall
+    match: ['payload.type == RANDOM']
+    responses: [{payload: {type: RANDOM}}]
+  - method: testing.TestService/FullDuplexCall
+    responses: [{payload: {body: "AA=="}}, {payload: {body: "AA=="}}]
+`
+
+func TestStubServer(t *testing.T) {
+	sf, err := ReadStubFile(strings.NewReader(testStubs))
+	if err != nil {
+		t.Fatalf("failed to read stubs: %v", err)
+	}
+	svr, err := NewStubServer(sourceProtoset, sf.Stubs)
+	if err != nil {
+		t.Fatalf("failed to create server: %v", err)
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	go svr.Serve(l)
+	defer svr.Stop()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	cc, err := grpc.DialContext(ctx, l.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()), grpc.WithBlock())
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+	defer cc.Close()
+
+	// the server exposes reflection for the stubbed services
+	refClient := grpcreflect.NewClientAuto(ctx, cc)
+	defer refClient.Reset()
+	refSource := DescriptorSourceFromServer(ctx, refClient)
+	svcs, err := ListServices(refSource)
+	if err != nil {
+		t.Fatalf("failed to list services: %v", err)
+	}
+	expectedSvcs := []string{"grpc.reflection.v1.ServerReflection", "grpc.reflection.v1alpha.ServerReflection", "testing.TestService", "testing.UnimplementedService"}
+	if strings.Join(svcs, ",") != strings.Join(expectedSvcs, ",") {
+		t.Errorf("wrong services: expected %v, got %v", expectedSvcs, svcs)
+	}
+
+	testCases := []struct {
+		name              string
+		method            string
+		reqs              []string
+		expectedCode      codes.Code
+		expectedResponses []string
+	}{
+		{
+			name:              "unary matched",
+			method:            "testing.TestService/UnaryCall",
+			reqs:              []string{`{"payload": {"body": "AQID"}}`},
+			expectedResponses: []string{`{"payload":{"body":"BAUG"}}`},
+		},
+		{
+			name:         "unary error",
+			method:       "testing.TestService/UnaryCall",
+			reqs:         []string{`{"payload": {"body": "AAAA"}}`},
+			expectedCode: codes.NotFound,
+		},
+		{
+			name:              "server stream",
+			method:            "testing.TestService/StreamingOutputCall",
+			expectedCode:      codes.ResourceExhausted,
+			expectedResponses: []string{`{"payload":{"body":"AQ=="}}`, `{"payload":{"body":"Ag=="}}`},
+		},
+		{
+			name:              "client stream",
+			method:            "testing.TestService/StreamingInputCall",
+			reqs:              []string{`{"payload": {"body": "AQID"}}`, `{}`},
+			expectedResponses: []string{`{"aggregatedPayloadSize":42}`},
+		},
+		{
+			name:         "client stream unmatched",
+			method:       "testing.TestService/StreamingInputCall",
+			reqs:         []string{`{}`},
+			expectedCode: codes.Unimplemented,
+		},
+		{
+			name:              "bidi stream",
+			method:            "testing.TestService/FullDuplexCall",
+			reqs:              []string{`{"payload": {"type": "RANDOM"}}`, `{}`},
+			expectedResponses: []string{`{"payload":{"type":"RANDOM"}}`, `{"payload":{"body":"AA=="}}`, `{"payload":{"body":"AA=="}}`},
+		},
+		{
+			name:         "no stubs",
+			method:       "testing.TestService/EmptyCall",
+			expectedCode: codes.Unimplemented,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			rf, _, err := RequestParserAndFormatter(FormatJSON, sourceProtoset, strings.NewReader(strings.Join(tc.reqs, "\n")), FormatOptions{})
+			if err != nil {
+				t.Fatalf("failed to create request parser: %v", err)
+			}
+			h := &handler{}
+			if err := InvokeRPC(ctx, sourceProtoset, cc, tc.method, nil, h, rf.Next); err != nil {
+				t.Fatalf("failed to invoke: %v", err)
+			}
+			if h.respStatus.Code() != tc.expectedCode {
+				t.Errorf("wrong code: expected %v, got %v (%s)", tc.expectedCode, h.respStatus.Code(), h.respStatus.Message())
+			}
+			if len(h.respMessages) != len(tc.expectedResponses) {
+				t.Fatalf("wrong number of responses: expected %d, got %d", len(tc.expectedResponses), len(h.respMessages))
+			}
+			for i, resp := range h.respMessages {
+				if compact := strings.Join(strings.Fields(resp), ""); compact != tc.expectedResponses[i] {
+					t.Errorf("wrong response %d: expected %s, got %s", i, tc.expectedResponses[i], compact)
+				}
+			}
+			if tc.name == "unary matched" {
+				if v := h.respHeaders.Get("x-stub"); len(v) != 1 || v[0] != "first" {
+					t.Errorf("wrong headers: %v", h.respHeaders)
+				}
+				if v := h.respTrailers.Get("x-stub-trailer"); len(v) != 1 || v[0] != "done" {
+					t.Errorf("wrong trailers: %v", h.respTrailers)
+				}
+			}
+			if tc.name == "unary error" {
+				details := h.respStatus.Details()
+				if len(details) != 1 {
+					t.Fatalf("wrong number of details: %v", details)
+				}
+				if info, ok := details[0].(*errdetails.ErrorInfo); !ok || info.Reason != "MISSING" {
+					t.Errorf("wrong detail: %v", details[0])
+				}
+			}
+		})
+	}
+}
+
+func TestStubServerInvalidStubs(t *testing.T) {
+	testCases := []struct {
+		name string
+		stub Stub
+	}{
+		{"unknown method", Stub{Method: "testing.TestService/NoSuchMethod"}},
+		{"bad match", Stub{Method: "testing.TestService/UnaryCall", Match: []string{"no_such_field == 1"}}},
+		{"bad response", Stub{Method: "testing.TestService/UnaryCall", Response: []byte(`{"foo": 1}`)}},
+		{"too many responses", Stub{Method: "testing.TestService/UnaryCall", Responses: []json.RawMessage{[]byte(`{}`), []byte(`{}`)}}},
+		{"bad code", Stub{Method: "testing.TestService/UnaryCall", Status: &StubStatus{Code: "NotACode"}}},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := NewStubServer(sourceProtoset, []*Stub{&tc.stub}); err == nil {
+				t.Errorf("expected error")
+			}
+		})
+	}
+
+	if _, err := ReadStubFile(strings.NewReader(`{"stubs": [{"method": "x", "unknown": 1}]}`)); err == nil {
+		t.Errorf("expected error for unknown property")
+	}
+}
@@ -0,0 +1,490 @@
+package grpcurl
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// RequestTemplate expands placeholders in request data. A placeholder is
+// either a variable reference, like '${NAME}', or a call to a generator, like
+// '${uuid()}'. A literal '${' is written as '$${'. Anything else that starts
+// with '${' but does not look like a placeholder is left as is.
+//
+// A variable refers to one of the variables given to NewRequestTemplate or
+// SetVar or, if there is none with that name, to an environment variable. The
+// supported generators are:
+//
+//	uuid()             a random (version 4) UUID
+//	now()              the current time in RFC 3339 format
+//	now(DURATION)      the current time plus the given duration, like "-1h"
+//	randint()          a random non-negative 32-bit integer
+//	randint(MAX)       a random integer from 0 to MAX, inclusive
+//	randint(MIN, MAX)  a random integer from MIN to MAX, inclusive
+//	randstr()          a random alphanumeric string of 16 characters
+//	randstr(N)         a random alphanumeric string of N characters
+//	seq()              the next value of a counter that starts at 1
+//	seq(NAME)          the next value of the named counter
+//	file(PATH)         the contents of the file, encoded in base64, which is
+//	                   the JSON representation of a bytes field
+//
+// Each placeholder is expanded separately, so generators produce a new value
+// for every message in a stream. A RequestTemplate is safe for concurrent use.
+//
+// Expand expands placeholders in any text, with their values pasted in as is.
+// NewReader and ExpandData instead expand them in request data, where a value
+// is escaped as needed so that it cannot change the structure of the data:
+// in JSON and protobuf text data, a value is escaped if the placeholder is
+// inside a quoted string, and is otherwise pasted in as is, so a placeholder
+// can also stand for a number or a boolean. YAML data is parsed before
+// placeholders in its scalars are expanded, so placeholders in YAML flow
+// collections, like '{id: "${uuid()}"}', must be quoted.
+type RequestTemplate struct {
+	mu       sync.Mutex
+	vars     map[string]string
+	counters map[string]int64
+}
+
+// NewRequestTemplate returns a template that expands placeholders using the
+// given variables, which take precedence over environment variables.
+func NewRequestTemplate(vars map[string]string) *RequestTemplate {
+	t := &RequestTemplate{vars: map[string]string{}, counters: map[string]int64{}}
+	for name, val := range vars {
+		t.vars[name] = val
+	}
+	return t
+}
+
+// SetVar defines a variable, replacing any existing variable with the same
+// name.
+func (t *RequestTemplate) SetVar(name, val string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.vars[name] = val
+}
+
+func (t *RequestTemplate) lookupVar(name string) (string, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if val, ok := t.vars[name]; ok {
+		return val, true
+	}
+	return os.LookupEnv(name)
+}
+
+var (
+	placeholderVarRegex  = regexp.MustCompile(`^\w+$`)
+	placeholderCallRegex = regexp.MustCompile(`^(\w+)\((.*)\)$`)
+)
+
+// IsValidVarName returns true if the given name can be referenced by a
+// placeholder, as '${NAME}'. Such names have only letters, digits, and
+// underscores.
+func IsValidVarName(name string) bool {
+	return placeholderVarRegex.MatchString(name)
+}
+
+// Expand returns the given string with all placeholders expanded.
+func (t *RequestTemplate) Expand(s string) (string, error) {
+	return expandPlaceholders(s, t.expandPlaceholder, nil)
+}
+
+// expandPlaceholder returns the value of the placeholder '${EXPR}', or false
+// if it does not look like a placeholder.
+func (t *RequestTemplate) expandPlaceholder(expr string) (string, bool, error) {
+	if placeholderVarRegex.MatchString(expr) {
+		if val, ok := t.lookupVar(expr); ok {
+			return val, true, nil
+		}
+		return "", true, fmt.Errorf("variable %q is not defined and there is no environment variable with that name", expr)
+	}
+	if m := placeholderCallRegex.FindStringSubmatch(expr); m != nil {
+		val, err := t.generate(m[1], m[2])
+		if err != nil {
+			return "", true, fmt.Errorf("could not expand %q: %v", "${"+expr+"}", err)
+		}
+		return val, true, nil
+	}
+	return "", false, nil
+}
+
+// NewReader returns a reader of the request data in the given reader, which
+// is in the given format, with all placeholders expanded. JSON and text data
+// is read and expanded one line at a time, and YAML data one document at a
+// time, so each message in a stream of messages is expanded as it is read.
+// Binary data cannot have placeholders, so it is returned unchanged.
+func (t *RequestTemplate) NewReader(in io.Reader, format Format) io.Reader {
+	switch format {
+	case FormatJSON, FormatText:
+		return &templateReader{t: t, r: bufio.NewReader(in), format: format}
+	case FormatYAML:
+		return &yamlTemplateReader{t: t, dec: yaml.NewDecoder(in)}
+	default:
+		return in
+	}
+}
+
+// ExpandData returns the given request data, which is in the given format,
+// with all placeholders expanded, like the data read from NewReader.
+func (t *RequestTemplate) ExpandData(data string, format Format) (string, error) {
+	b, err := io.ReadAll(t.NewReader(strings.NewReader(data), format))
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+type templateReader struct {
+	t      *RequestTemplate
+	r      *bufio.Reader
+	format Format
+	buf    string
+	err    error
+}
+
+func (r *templateReader) Read(p []byte) (int, error) {
+	for r.buf == "" {
+		if r.err != nil {
+			return 0, r.err
+		}
+		var line string
+		line, r.err = r.r.ReadString('\n')
+		if line == "" {
+			continue
+		}
+		expanded, err := r.t.expandLine(line, r.format)
+		if err != nil {
+			r.err = err
+			return 0, err
+		}
+		r.buf = expanded
+	}
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
+}
+
+// expandLine expands the placeholders in a line of JSON or text data,
+// escaping the values of those inside strings. Strings in these formats can't
+// span lines, so each line starts outside of one.
+func (t *RequestTemplate) expandLine(line string, format Format) (string, error) {
+	var quote byte // the quote that started the current string, if any
+	var escaped, comment bool
+	scan := func(written string) {
+		for i := 0; i < len(written); i++ {
+			c := written[i]
+			switch {
+			case comment:
+			case escaped:
+				escaped = false
+			case quote != 0 && c == '\\':
+				escaped = true
+			case quote != 0 && c == quote:
+				quote = 0
+			case quote != 0:
+			case c == '"' || (c == '\'' && format == FormatText):
+				quote = c
+			case c == '#' && format == FormatText:
+				comment = true
+			}
+		}
+	}
+	return expandPlaceholders(line, func(expr string) (string, bool, error) {
+		val, ok, err := t.expandPlaceholder(expr)
+		if ok && err == nil && quote != 0 && !comment {
+			if format == FormatJSON {
+				val = escapeJSONString(val)
+			} else {
+				val = escapeTextString(val, quote)
+			}
+		}
+		return val, ok, err
+	}, scan)
+}
+
+// escapeJSONString returns the given string as it would appear inside a
+// quoted JSON string.
+func escapeJSONString(s string) string {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	// encoding a string can't fail
+	_ = enc.Encode(s)
+	js := strings.TrimSuffix(buf.String(), "\n")
+	return js[1 : len(js)-1]
+}
+
+// escapeTextString returns the given string as it would appear inside a
+// string in the protobuf text format that is quoted with the given quote.
+func escapeTextString(s string, quote byte) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == '\\' || c == quote:
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		case c == '\n':
+			b.WriteString(`\n`)
+		case c == '\r':
+			b.WriteString(`\r`)
+		case c == '\t':
+			b.WriteString(`\t`)
+		case c < 0x20 || c == 0x7f:
+			fmt.Fprintf(&b, "\\%03o", c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+type yamlTemplateReader struct {
+	t   *RequestTemplate
+	dec *yaml.Decoder
+	buf string
+	err error
+}
+
+func (r *yamlTemplateReader) Read(p []byte) (int, error) {
+	for r.buf == "" {
+		if r.err != nil {
+			return 0, r.err
+		}
+		var doc yaml.Node
+		if r.err = r.dec.Decode(&doc); r.err != nil {
+			continue
+		}
+		if len(doc.Content) == 0 {
+			// skip empty documents, like after a trailing "---"
+			continue
+		}
+		if r.err = r.t.expandYAML(&doc); r.err != nil {
+			continue
+		}
+		var buf bytes.Buffer
+		buf.WriteString("---\n")
+		enc := yaml.NewEncoder(&buf)
+		if r.err = enc.Encode(&doc); r.err == nil {
+			r.err = enc.Close()
+		}
+		if r.err != nil {
+			continue
+		}
+		r.buf = buf.String()
+	}
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
+}
+
+// expandYAML expands the placeholders in all scalars in the given node and
+// its descendants.
+func (t *RequestTemplate) expandYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode && strings.Contains(node.Value, "${") {
+		val, err := t.Expand(node.Value)
+		if err != nil {
+			return err
+		}
+		node.Value = val
+		if node.Style == 0 {
+			// like any unquoted scalar, its type depends on what it looks like
+			node.Tag = ""
+		}
+	}
+	for _, n := range node.Content {
+		if err := t.expandYAML(n); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (t *RequestTemplate) generate(name, args string) (string, error) {
+	var argList []string
+	if strings.TrimSpace(args) != "" {
+		argList = strings.Split(args, ",")
+		for i := range argList {
+			argList[i] = strings.TrimSpace(argList[i])
+		}
+	}
+	switch name {
+	case "uuid":
+		if len(argList) != 0 {
+			return "", fmt.Errorf("uuid takes no arguments")
+		}
+		return newUUID()
+	case "now":
+		now := time.Now()
+		switch len(argList) {
+		case 0:
+		case 1:
+			d, err := time.ParseDuration(argList[0])
+			if err != nil {
+				return "", err
+			}
+			now = now.Add(d)
+		default:
+			return "", fmt.Errorf("now takes at most one argument")
+		}
+		return now.UTC().Format(time.RFC3339), nil
+	case "randint":
+		min, max := int64(0), int64(1<<31-1)
+		var err error
+		switch len(argList) {
+		case 0:
+		case 1:
+			max, err = strconv.ParseInt(argList[0], 10, 64)
+		case 2:
+			min, err = strconv.ParseInt(argList[0], 10, 64)
+			if err == nil {
+				max, err = strconv.ParseInt(argList[1], 10, 64)
+			}
+		default:
+			return "", fmt.Errorf("randint takes at most two arguments")
+		}
+		if err != nil {
+			return "", err
+		}
+		if min > max {
+			return "", fmt.Errorf("minimum %d is greater than maximum %d", min, max)
+		}
+		n, err := rand.Int(rand.Reader, new(big.Int).Add(big.NewInt(max-min), big.NewInt(1)))
+		if err != nil {
+			return "", err
+		}
+		return strconv.FormatInt(min+n.Int64(), 10), nil
+	case "randstr":
+		length := 16
+		switch len(argList) {
+		case 0:
+		case 1:
+			var err error
+			length, err = strconv.Atoi(argList[0])
+			if err != nil {
+				return "", err
+			}
+			if length < 0 {
+				return "", fmt.Errorf("length must not be negative")
+			}
+		default:
+			return "", fmt.Errorf("randstr takes at most one argument")
+		}
+		return randomString(length)
+	case "seq":
+		if len(argList) > 1 {
+			return "", fmt.Errorf("seq takes at most one argument")
+		}
+		counter := ""
+		if len(argList) == 1 {
+			counter = argList[0]
+		}
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		t.counters[counter]++
+		return strconv.FormatInt(t.counters[counter], 10), nil
+	case "file":
+		// the path is not split on commas, since file names may contain them
+		if strings.TrimSpace(args) == "" {
+			return "", fmt.Errorf("file requires a path")
+		}
+		b, err := os.ReadFile(strings.TrimSpace(args))
+		if err != nil {
+			return "", err
+		}
+		return base64.StdEncoding.EncodeToString(b), nil
+	default:
+		return "", fmt.Errorf("unknown generator %q", name)
+	}
+}
+
+func newUUID() (string, error) {
+	var u [16]byte
+	if _, err := io.ReadFull(rand.Reader, u[:]); err != nil {
+		return "", err
+	}
+	u[6] = (u[6] & 0x0f) | 0x40 // version 4
+	u[8] = (u[8] & 0x3f) | 0x80 // RFC 4122 variant
+	return fmt.Sprintf("%x-%x-%x-%x-%x", u[0:4], u[4:6], u[6:8], u[8:10], u[10:]), nil
+}
+
+const randomStringChars = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789"
+
+func randomString(length int) (string, error) {
+	b := make([]byte, length)
+	limit := big.NewInt(int64(len(randomStringChars)))
+	for i := range b {
+		n, err := rand.Int(rand.Reader, limit)
+		if err != nil {
+			return "", err
+		}
+		b[i] = randomStringChars[n.Int64()]
+	}
+	return string(b), nil
+}
+
+// expandPlaceholders replaces each placeholder in s, of the form '${EXPR}',
+// with the result of calling expand with EXPR. If expand reports that EXPR is
+// not a placeholder, it is left as is. The escape sequence '$${' is replaced
+// with a literal '${'. If written is not nil, it is called with each part of
+// the result as it is written, before expand is called for the next
+// placeholder.
+func expandPlaceholders(s string, expand func(expr string) (val string, ok bool, err error), written func(string)) (string, error) {
+	if !strings.Contains(s, "${") {
+		if written != nil {
+			written(s)
+		}
+		return s, nil
+	}
+	var b strings.Builder
+	write := func(part string) {
+		b.WriteString(part)
+		if written != nil {
+			written(part)
+		}
+	}
+	for {
+		i := strings.Index(s, "${")
+		if i < 0 {
+			write(s)
+			return b.String(), nil
+		}
+		if i > 0 && s[i-1] == '$' {
+			// escaped
+			write(s[:i-1])
+			write("${")
+			s = s[i+2:]
+			continue
+		}
+		write(s[:i])
+		end := strings.IndexByte(s[i+2:], '}')
+		if end < 0 {
+			write(s[i:])
+			return b.String(), nil
+		}
+		expr := s[i+2 : i+2+end]
+		val, ok, err := expand(expr)
+		if err != nil {
+			return "", err
+		}
+		if ok {
+			write(val)
+			s = s[i+3+end:]
+		} else {
+			write("${")
+			s = s[i+2:]
+		}
+	}
+}
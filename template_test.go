@@ -0,0 +1,188 @@
+package grpcurl_test
+
+import (
+	"encoding/base64"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/protobuf/types/known/structpb"
+
+	. "github.com/tetrateio/grpcurl"
+)
+
+func TestRequestTemplate_Vars(t *testing.T) {
+	os.Setenv("TMPL_ENV", "from-env")
+	os.Setenv("TMPL_BOTH", "from-env")
+	defer os.Unsetenv("TMPL_ENV")
+	defer os.Unsetenv("TMPL_BOTH")
+	tmpl := NewRequestTemplate(map[string]string{"TMPL_BOTH": "from-var", "name": "Alice"})
+
+	testCases := []struct {
+		in, out string
+	}{
+		{in: `{"name": "${name}"}`, out: `{"name": "Alice"}`},
+		{in: `${TMPL_ENV}/${TMPL_BOTH}`, out: `from-env/from-var`},
+		{in: `$${name} is ${name}`, out: `${name} is Alice`},
+		{in: `${not a placeholder} ${name`, out: `${not a placeholder} ${name`},
+		{in: `no placeholders`, out: `no placeholders`},
+	}
+	for _, tc := range testCases {
+		out, err := tmpl.Expand(tc.in)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", tc.in, err)
+		} else if out != tc.out {
+			t.Errorf("%s: expecting %q, got %q", tc.in, tc.out, out)
+		}
+	}
+
+	if _, err := tmpl.Expand(`${TMPL_UNDEFINED}`); err == nil || !strings.Contains(err.Error(), `"TMPL_UNDEFINED" is not defined`) {
+		t.Errorf("expecting error for undefined variable, got %v", err)
+	}
+}
+
+func TestRequestTemplate_Generators(t *testing.T) {
+	tmpl := NewRequestTemplate(nil)
+	expand := func(s string) string {
+		out, err := tmpl.Expand(s)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", s, err)
+		}
+		return out
+	}
+
+	uuidRegex := regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+	if u1, u2 := expand("${uuid()}"), expand("${uuid()}"); !uuidRegex.MatchString(u1) || u1 == u2 {
+		t.Errorf("wrong UUIDs: %s, %s", u1, u2)
+	}
+
+	now, err := time.Parse(time.RFC3339, expand("${now()}"))
+	if err != nil || time.Since(now) > time.Minute {
+		t.Errorf("wrong time: %v, %v", now, err)
+	}
+	hourAgo, err := time.Parse(time.RFC3339, expand("${now(-1h)}"))
+	if err != nil || now.Sub(hourAgo) < 59*time.Minute {
+		t.Errorf("wrong time: %v, %v", hourAgo, err)
+	}
+
+	for i := 0; i < 20; i++ {
+		n, err := strconv.Atoi(expand("${randint(5, 7)}"))
+		if err != nil || n < 5 || n > 7 {
+			t.Errorf("wrong random int: %d, %v", n, err)
+		}
+	}
+	if s := expand("${randstr(24)}"); len(s) != 24 || !regexp.MustCompile(`^[A-Za-z0-9]+$`).MatchString(s) {
+		t.Errorf("wrong random string: %q", s)
+	}
+
+	if s := expand("${seq()} ${seq()} ${seq(other)} ${seq()}"); s != "1 2 1 3" {
+		t.Errorf("wrong sequence: %q", s)
+	}
+
+	file := filepath.Join(t.TempDir(), "data, with comma.bin")
+	if err := os.WriteFile(file, []byte{0, 1, 2, 0xff}, 0644); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+	if s := expand("${file(" + file + ")}"); s != base64.StdEncoding.EncodeToString([]byte{0, 1, 2, 0xff}) {
+		t.Errorf("wrong file contents: %q", s)
+	}
+
+	for _, bad := range []string{"${nope()}", "${randint(9, 1)}", "${randstr(x)}", "${now(soon)}", "${uuid(1)}", "${file()}"} {
+		if _, err := tmpl.Expand(bad); err == nil {
+			t.Errorf("%s: expecting an error", bad)
+		}
+	}
+}
+
+func TestRequestTemplate_Reader(t *testing.T) {
+	tmpl := NewRequestTemplate(map[string]string{"who": "world"})
+	in := strings.NewReader(`{"n": ${seq()}, "who": "${who}", "id": "${uuid()}"}
+{"n": ${seq()}, "who": "$${who}", "id": "${uuid()}"}`)
+	rf := NewJSONRequestParser(tmpl.NewReader(in, FormatJSON), nil)
+
+	var msgs []*structpb.Struct
+	for {
+		var msg structpb.Struct
+		err := rf.Next(&msg)
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatalf("failed to parse request: %v", err)
+		}
+		msgs = append(msgs, &msg)
+	}
+	if len(msgs) != 2 {
+		t.Fatalf("expecting 2 messages, got %d", len(msgs))
+	}
+	for i, msg := range msgs {
+		if n := msg.Fields["n"].GetNumberValue(); n != float64(i+1) {
+			t.Errorf("message %d: wrong sequence number %v", i, n)
+		}
+	}
+	if msgs[0].Fields["who"].GetStringValue() != "world" || msgs[1].Fields["who"].GetStringValue() != "${who}" {
+		t.Errorf("wrong variable expansion: %v", msgs)
+	}
+	if msgs[0].Fields["id"].GetStringValue() == msgs[1].Fields["id"].GetStringValue() {
+		t.Errorf("each message should have a distinct UUID: %v", msgs)
+	}
+
+	rf = NewJSONRequestParser(tmpl.NewReader(strings.NewReader(`{"who": "${nobody}"}`), FormatJSON), nil)
+	var msg structpb.Struct
+	if err := rf.Next(&msg); err == nil || !strings.Contains(err.Error(), `"nobody" is not defined`) {
+		t.Errorf("expecting error for undefined variable, got %v", err)
+	}
+}
+
+func TestRequestTemplate_Escaping(t *testing.T) {
+	value := "say \"hi\"\\\n', \"extra\": \"injected\": # not a comment"
+	tmpl := NewRequestTemplate(map[string]string{"v": value, "n": "42"})
+
+	testCases := []struct {
+		format Format
+		data   string
+	}{
+		{format: FormatJSON, data: `{"s": "${v}", "t": "<${v}>", "n": ${n}}`},
+		{format: FormatText, data: `fields: <key: "s" value: <string_value: "${v}">> fields: <key: "t" value: <string_value: '<${v}>'>> fields: <key: "n" value: <number_value: ${n}>>`},
+		{format: FormatYAML, data: "s: ${v}\nt: \"<${v}>\"\nn: ${n}"},
+		{format: FormatYAML, data: `{s: "${v}", t: '<${v}>', n: "${n}"}`},
+	}
+	for _, tc := range testCases {
+		expanded, err := tmpl.ExpandData(tc.data, tc.format)
+		if err != nil {
+			t.Errorf("%s: failed to expand %s: %v", tc.format, tc.data, err)
+			continue
+		}
+		rf, _, err := RequestParserAndFormatter(tc.format, nil, strings.NewReader(expanded), FormatOptions{})
+		if err != nil {
+			t.Fatalf("failed to create request parser: %v", err)
+		}
+		var msg structpb.Struct
+		if err := rf.Next(&msg); err != nil {
+			t.Errorf("%s: failed to parse expanded data %s: %v", tc.format, expanded, err)
+			continue
+		}
+		if len(msg.Fields) != 3 {
+			t.Errorf("%s: expecting 3 fields, got %v", tc.format, &msg)
+		}
+		if s := msg.Fields["s"].GetStringValue(); s != value {
+			t.Errorf("%s: expecting %q, got %q", tc.format, value, s)
+		}
+		if s := msg.Fields["t"].GetStringValue(); s != "<"+value+">" {
+			t.Errorf("%s: expecting %q, got %q", tc.format, "<"+value+">", s)
+		}
+		if n := msg.Fields["n"].GetNumberValue(); n != 42 && msg.Fields["n"].GetStringValue() != "42" {
+			t.Errorf("%s: expecting 42, got %v", tc.format, msg.Fields["n"])
+		}
+	}
+
+	// a quote in a text comment does not start a string
+	expanded, err := tmpl.ExpandData(`fields: <key: "n" value: <number_value: ${n}>> # it's ${n}`, FormatText)
+	if err != nil || expanded != `fields: <key: "n" value: <number_value: 42>> # it's 42` {
+		t.Errorf("wrong expansion with comment: %q, %v", expanded, err)
+	}
+}
@@ -0,0 +1,212 @@
+package grpcurl
+
+import (
+	"context"
+	"net"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc/stats"
+)
+
+// DialTiming describes how long each step of establishing a connection took.
+// To record it, use WithDialTiming with the context given to BlockingDial. If
+// the connection is re-established later, only the first connection is
+// recorded.
+type DialTiming struct {
+	// NameLookup is how long it took to resolve the server's host name to
+	// IP addresses. It is zero if the address was already an IP address,
+	// such as when it is resolved by the gRPC library, or if it is a Unix
+	// domain socket.
+	NameLookup time.Duration
+	// Connect is how long it took to establish the TCP connection (or to
+	// connect to the Unix domain socket).
+	Connect time.Duration
+	// TLSHandshake is how long the TLS handshake took. It is zero if the
+	// connection does not use TLS.
+	TLSHandshake time.Duration
+	// Total is how long it took for the connection to be ready for use,
+	// including all of the above as well as setting up HTTP/2.
+	Total time.Duration
+}
+
+// CallTiming describes how long each phase of an RPC took. To record it,
+// use WithCallTiming with the context used to invoke the RPC, on a connection
+// that was created with the grpc.WithStatsHandler(TimingStatsHandler{})
+// dial option. All durations are measured from the start of the RPC.
+type CallTiming struct {
+	// Headers is how long it took to receive the response headers.
+	Headers time.Duration
+	// FirstResponse is how long it took to receive the first response
+	// message. It is zero if there were no response messages.
+	FirstResponse time.Duration
+	// ResponseGaps are the times between receiving each response message and
+	// the one before it. It is empty unless there were at least two response
+	// messages.
+	ResponseGaps []time.Duration
+	// Total is how long the whole RPC took.
+	Total time.Duration
+}
+
+type dialTimingKey struct{}
+
+type callTimingKey struct{}
+
+// WithDialTiming returns a context that, when given to BlockingDial, records
+// how long each step of establishing the connection takes in t.
+func WithDialTiming(ctx context.Context, t *DialTiming) context.Context {
+	return context.WithValue(ctx, dialTimingKey{}, t)
+}
+
+// WithCallTiming returns a context that, when used to invoke an RPC on a
+// connection that has a TimingStatsHandler, records how long each phase of
+// the RPC takes in t. The context should be used for only one RPC. The timing
+// is complete once the RPC completes.
+func WithCallTiming(ctx context.Context, t *CallTiming) context.Context {
+	return context.WithValue(ctx, callTimingKey{}, &callTimer{timing: t})
+}
+
+// TimingStatsHandler is a stats.Handler that records the timing of RPCs
+// whose contexts were created with WithCallTiming. Other RPCs are ignored.
+type TimingStatsHandler struct{}
+
+var _ stats.Handler = TimingStatsHandler{}
+
+// TagRPC implements stats.Handler.
+func (TimingStatsHandler) TagRPC(ctx context.Context, _ *stats.RPCTagInfo) context.Context {
+	return ctx
+}
+
+// HandleRPC implements stats.Handler.
+func (TimingStatsHandler) HandleRPC(ctx context.Context, s stats.RPCStats) {
+	t, ok := ctx.Value(callTimingKey{}).(*callTimer)
+	if !ok || !s.IsClient() {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	switch s := s.(type) {
+	case *stats.Begin:
+		t.start = s.BeginTime
+	case *stats.InHeader:
+		t.timing.Headers = time.Since(t.start)
+	case *stats.InPayload:
+		if t.lastResponse.IsZero() {
+			t.timing.FirstResponse = s.RecvTime.Sub(t.start)
+		} else {
+			t.timing.ResponseGaps = append(t.timing.ResponseGaps, s.RecvTime.Sub(t.lastResponse))
+		}
+		t.lastResponse = s.RecvTime
+	case *stats.End:
+		t.timing.Total = s.EndTime.Sub(t.start)
+	}
+}
+
+// TagConn implements stats.Handler.
+func (TimingStatsHandler) TagConn(ctx context.Context, _ *stats.ConnTagInfo) context.Context {
+	return ctx
+}
+
+// HandleConn implements stats.Handler.
+func (TimingStatsHandler) HandleConn(context.Context, stats.ConnStats) {}
+
+// callTimer records the timing of an RPC. Events for an RPC may be handled by
+// different goroutines, so it is guarded by a mutex.
+type callTimer struct {
+	mu           sync.Mutex
+	timing       *CallTiming
+	start        time.Time
+	lastResponse time.Time
+}
+
+// dialTimer records the timing of the first connection made by BlockingDial.
+// A nil *dialTimer records nothing.
+type dialTimer struct {
+	mu         sync.Mutex
+	timing     *DialTiming
+	start      time.Time
+	dialed     bool
+	handshaken bool
+}
+
+func newDialTimer(ctx context.Context) *dialTimer {
+	t, ok := ctx.Value(dialTimingKey{}).(*DialTiming)
+	if !ok || t == nil {
+		return nil
+	}
+	return &dialTimer{timing: t, start: time.Now()}
+}
+
+// dial connects to the given address. For the first connection, the name
+// lookup and connection are done separately, so that each can be timed.
+func (t *dialTimer) dial(ctx context.Context, network, address string) (net.Conn, error) {
+	var d net.Dialer
+	if t == nil || !t.claim(&t.dialed) {
+		return d.DialContext(ctx, network, address)
+	}
+	host, port, err := net.SplitHostPort(address)
+	if network == "unix" || err != nil || net.ParseIP(host) != nil {
+		start := time.Now()
+		conn, err := d.DialContext(ctx, network, address)
+		t.record(func(timing *DialTiming) { timing.Connect = time.Since(start) })
+		return conn, err
+	}
+
+	start := time.Now()
+	addrs, err := net.DefaultResolver.LookupHost(ctx, host)
+	t.record(func(timing *DialTiming) { timing.NameLookup = time.Since(start) })
+	if err != nil {
+		return nil, err
+	}
+	start = time.Now()
+	defer func() {
+		t.record(func(timing *DialTiming) { timing.Connect = time.Since(start) })
+	}()
+	// like net.Dialer, try each address in turn and report the first error
+	var firstErr error
+	for _, addr := range addrs {
+		conn, err := d.DialContext(ctx, network, net.JoinHostPort(addr, port))
+		if err == nil {
+			return conn, nil
+		}
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+	return nil, firstErr
+}
+
+// handshake runs the given TLS handshake, timing it if it is the first.
+func (t *dialTimer) handshake(fn func() error) error {
+	if t == nil || !t.claim(&t.handshaken) {
+		return fn()
+	}
+	start := time.Now()
+	err := fn()
+	t.record(func(timing *DialTiming) { timing.TLSHandshake = time.Since(start) })
+	return err
+}
+
+// done records the total time taken to establish the connection.
+func (t *dialTimer) done() {
+	if t == nil {
+		return
+	}
+	t.record(func(timing *DialTiming) { timing.Total = time.Since(t.start) })
+}
+
+func (t *dialTimer) claim(flag *bool) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if *flag {
+		return false
+	}
+	*flag = true
+	return true
+}
+
+func (t *dialTimer) record(fn func(*DialTiming)) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	fn(t.timing)
+}
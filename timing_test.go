@@ -0,0 +1,86 @@
+package grpcurl_test
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto" //lint:ignore SA1019 we have to import this because it appears in exported API
+	"google.golang.org/grpc"
+
+	. "github.com/tetrateio/grpcurl"
+	grpcurl_testing "github.com/tetrateio/grpcurl/internal/testing"
+)
+
+func TestTiming(t *testing.T) {
+	serverCreds, err := ServerTransportCredentials("", "internal/testing/tls/server.crt", "internal/testing/tls/server.key", false)
+	if err != nil {
+		t.Fatalf("failed to create server creds: %v", err)
+	}
+	clientCreds, err := ClientTransportCredentials(false, "internal/testing/tls/ca.crt", "", "")
+	if err != nil {
+		t.Fatalf("failed to create client creds: %v", err)
+	}
+	svr := grpc.NewServer(grpc.Creds(serverCreds))
+	grpcurl_testing.RegisterTestServiceServer(svr, grpcurl_testing.TestServer{})
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	go svr.Serve(l)
+	defer svr.Stop()
+
+	// use a host name, so that the name lookup is timed
+	var dt DialTiming
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	addr := fmt.Sprintf("localhost:%d", l.Addr().(*net.TCPAddr).Port)
+	cc, err := BlockingDial(WithDialTiming(ctx, &dt), "tcp", addr, clientCreds, grpc.WithStatsHandler(TimingStatsHandler{}))
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+	defer cc.Close()
+	if dt.NameLookup <= 0 || dt.Connect <= 0 || dt.TLSHandshake <= 0 {
+		t.Errorf("dial steps should all be timed: %+v", dt)
+	}
+	if dt.Total < dt.NameLookup+dt.Connect+dt.TLSHandshake {
+		t.Errorf("total dial time should include all steps: %+v", dt)
+	}
+
+	var ct CallTiming
+	h := &handler{}
+	rf, _, err := RequestParserAndFormatter(FormatJSON, sourceProtoset, strings.NewReader(`{"responseParameters": [{"size": 1}, {"size": 1, "intervalUs": 20000}, {"size": 1, "intervalUs": 20000}]}`), FormatOptions{})
+	if err != nil {
+		t.Fatalf("failed to create request parser: %v", err)
+	}
+	if err := InvokeRPC(WithCallTiming(ctx, &ct), sourceProtoset, cc, "testing.TestService/StreamingOutputCall", nil, h, rf.Next); err != nil {
+		t.Fatalf("failed to invoke: %v", err)
+	}
+	if len(h.respMessages) != 3 {
+		t.Fatalf("wrong number of responses: %d", len(h.respMessages))
+	}
+	if ct.Headers <= 0 || ct.FirstResponse < ct.Headers || ct.Total < ct.FirstResponse {
+		t.Errorf("call phases should be timed in order: %+v", ct)
+	}
+	if len(ct.ResponseGaps) != 2 {
+		t.Fatalf("wrong number of gaps between responses: %v", ct.ResponseGaps)
+	}
+	for _, gap := range ct.ResponseGaps {
+		if gap < 15*time.Millisecond {
+			t.Errorf("gaps between responses should be about 20ms: %v", ct.ResponseGaps)
+		}
+	}
+
+	// calls without a timing context are not recorded
+	h = &handler{}
+	if err := InvokeRPC(ctx, sourceProtoset, cc, "testing.TestService/EmptyCall", nil, h, func(proto.Message) error { return io.EOF }); err != nil {
+		t.Fatalf("failed to invoke: %v", err)
+	}
+	if h.respStatus.Err() != nil {
+		t.Errorf("call without timing failed: %v", h.respStatus.Err())
+	}
+}
@@ -2,12 +2,15 @@ package grpcurl_test
 
 import (
 	"context"
+	"crypto/tls"
 	"fmt"
+	"io"
 	"net"
 	"strings"
 	"testing"
 	"time"
 
+	"github.com/golang/protobuf/proto" //lint:ignore SA1019 we have to import this because it appears in exported API
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials"
 
@@ -44,6 +47,47 @@ func TestBasicTLS(t *testing.T) {
 	simpleTest(t, e.cc)
 }
 
+func TestPeerDetails(t *testing.T) {
+	serverCreds, err := ServerTransportCredentials("", "internal/testing/tls/server.crt", "internal/testing/tls/server.key", false)
+	if err != nil {
+		t.Fatalf("failed to create server creds: %v", err)
+	}
+	clientCreds, err := ClientTransportCredentials(false, "internal/testing/tls/ca.crt", "", "")
+	if err != nil {
+		t.Fatalf("failed to create server creds: %v", err)
+	}
+
+	e, err := createTestServerAndClient(serverCreds, clientCreds)
+	if err != nil {
+		t.Fatalf("failed to setup server and client: %v", err)
+	}
+	defer e.Close()
+
+	for _, method := range []string{"testing.TestService/UnaryCall", "testing.TestService/StreamingOutputCall"} {
+		var out strings.Builder
+		h := &DefaultEventHandler{Out: &out, Formatter: NewJSONFormatter(false, nil), VerbosityLevel: 1}
+		// the peer is passed along by handlers that wrap others
+		eh, err := NewExpectationHandler(h, sourceProtoset, nil, &Expectations{})
+		if err != nil {
+			t.Fatalf("failed to create expectation handler: %v", err)
+		}
+		err = InvokeRPC(context.Background(), sourceProtoset, e.cc, method, nil, eh, func(proto.Message) error { return io.EOF })
+		if err != nil {
+			t.Fatalf("%s: failed to invoke: %v", method, err)
+		}
+		for _, expected := range []string{
+			"\nConnected to peer:\nAddress: 127.0.0.1:",
+			"\nTLS version: TLS 1.",
+			"\nALPN protocol: h2\n",
+			"\n  0) Subject: CN=server\n     SANs: localhost, 127.0.0.1\n     Issuer: CN=ca\n     Expires: ",
+		} {
+			if !strings.Contains(out.String(), expected) {
+				t.Errorf("%s: output should contain %q:\n%s", method, expected, out.String())
+			}
+		}
+	}
+}
+
 func TestInsecureClientTLS(t *testing.T) {
 	serverCreds, err := ServerTransportCredentials("", "internal/testing/tls/server.crt", "internal/testing/tls/server.key", false)
 	if err != nil {
@@ -213,7 +257,8 @@ func TestBrokenTLS_ClientHasExpiredCert(t *testing.T) {
 		e.Close()
 		t.Fatal("expecting TLS failure setting up server and client")
 	}
-	if !strings.Contains(err.Error(), "bad certificate") {
+	// TLS 1.2 reports a bad certificate, but TLS 1.3 is more specific
+	if !strings.Contains(err.Error(), "bad certificate") && !strings.Contains(err.Error(), "expired certificate") {
 		t.Fatalf("expecting TLS certificate error, got: %v", err)
 	}
 }
@@ -253,7 +298,8 @@ func TestBrokenTLS_ClientNotTrusted(t *testing.T) {
 		e.Close()
 		t.Fatal("expecting TLS failure setting up server and client")
 	}
-	if !strings.Contains(err.Error(), "bad certificate") {
+	// with TLS 1.3, the client doesn't send a cert that the server won't accept
+	if !strings.Contains(err.Error(), "bad certificate") && !strings.Contains(err.Error(), "certificate required") {
 		t.Fatalf("expecting TLS certificate error, got: %v", err)
 	}
 }
@@ -293,7 +339,7 @@ func TestBrokenTLS_RequireClientCertButNonePresented(t *testing.T) {
 		e.Close()
 		t.Fatal("expecting TLS failure setting up server and client")
 	}
-	if !strings.Contains(err.Error(), "bad certificate") {
+	if !strings.Contains(err.Error(), "bad certificate") && !strings.Contains(err.Error(), "certificate required") {
 		t.Fatalf("expecting TLS certificate error, got: %v", err)
 	}
 }
@@ -359,3 +405,110 @@ func (e *testEnv) Close() {
 		e.svr = nil
 	}
 }
+
+func TestTLSOptions(t *testing.T) {
+	// the server negotiates TLS 1.3 by default
+	serverCreds, err := ServerTransportCredentials("", "internal/testing/tls/server.crt", "internal/testing/tls/server.key", false)
+	if err != nil {
+		t.Fatalf("failed to create server creds: %v", err)
+	}
+	conf, err := ClientTLSConfig(false, "internal/testing/tls/ca.crt", "", "")
+	if err != nil {
+		t.Fatalf("failed to create client TLS config: %v", err)
+	}
+	e, err := createTestServerAndClient(serverCreds, credentials.NewTLS(conf))
+	if err != nil {
+		t.Fatalf("failed to setup server and client: %v", err)
+	}
+	defer e.Close()
+	report, err := CheckTLS(context.Background(), "tcp", e.cc.Target(), conf)
+	if err != nil {
+		t.Fatalf("failed to check TLS: %v", err)
+	}
+	if report.State.Version != tls.VersionTLS13 {
+		t.Errorf("expecting TLS 1.3, got %s", TLSVersionName(report.State.Version))
+	}
+
+	// the client can limit the version, cipher suite, and curve
+	conf, err = ClientTLSConfigWithOptions(false, "internal/testing/tls/ca.crt", "", "", TLSOptions{
+		MaxVersion:       tls.VersionTLS12,
+		CipherSuites:     []uint16{tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384},
+		CurvePreferences: []tls.CurveID{tls.CurveP384},
+	})
+	if err != nil {
+		t.Fatalf("failed to create client TLS config: %v", err)
+	}
+	report, err = CheckTLS(context.Background(), "tcp", e.cc.Target(), conf)
+	if err != nil {
+		t.Fatalf("failed to check TLS: %v", err)
+	}
+	if report.State.Version != tls.VersionTLS12 || report.State.CipherSuite != tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384 {
+		t.Errorf("expecting TLS 1.2 with chosen cipher suite, got %s with %s", TLSVersionName(report.State.Version), tls.CipherSuiteName(report.State.CipherSuite))
+	}
+
+	// the server rejects versions older than TLS 1.2
+	conf, err = ClientTLSConfigWithOptions(false, "internal/testing/tls/ca.crt", "", "", TLSOptions{MaxVersion: tls.VersionTLS11})
+	if err != nil {
+		t.Fatalf("failed to create client TLS config: %v", err)
+	}
+	if conf.MinVersion != tls.VersionTLS10 {
+		t.Errorf("minimum version should default to TLS 1.0, got %s", TLSVersionName(conf.MinVersion))
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	cc, err := BlockingDial(ctx, "tcp", e.cc.Target(), credentials.NewTLS(conf))
+	if err == nil {
+		cc.Close()
+		t.Fatal("expecting TLS failure with TLS 1.1")
+	}
+	if !strings.Contains(err.Error(), "protocol version not supported") {
+		t.Errorf("expecting TLS version error, got: %v", err)
+	}
+
+	// and the server can also be limited
+	serverCreds, err = ServerTransportCredentialsWithOptions("", "internal/testing/tls/server.crt", "internal/testing/tls/server.key", false, TLSOptions{MaxVersion: tls.VersionTLS12})
+	if err != nil {
+		t.Fatalf("failed to create server creds: %v", err)
+	}
+	conf, err = ClientTLSConfigWithOptions(false, "internal/testing/tls/ca.crt", "", "", TLSOptions{MinVersion: tls.VersionTLS13})
+	if err != nil {
+		t.Fatalf("failed to create client TLS config: %v", err)
+	}
+	e2, err := createTestServerAndClient(serverCreds, credentials.NewTLS(conf))
+	if err == nil {
+		e2.Close()
+		t.Fatal("expecting TLS failure with TLS 1.3")
+	}
+
+	_, err = ClientTLSConfigWithOptions(false, "", "", "", TLSOptions{MinVersion: tls.VersionTLS13, MaxVersion: tls.VersionTLS12})
+	if err == nil {
+		t.Error("expecting error when minimum version is newer than maximum")
+	}
+}
+
+func TestParseTLSOptions(t *testing.T) {
+	for s, expected := range map[string]uint16{"1.0": tls.VersionTLS10, "TLS1.1": tls.VersionTLS11, "tls 1.2": tls.VersionTLS12, "1.3": tls.VersionTLS13} {
+		if v, err := ParseTLSVersion(s); err != nil || v != expected {
+			t.Errorf("%q: expecting %s, got %s, %v", s, TLSVersionName(expected), TLSVersionName(v), err)
+		}
+	}
+	if _, err := ParseTLSVersion("1.4"); err == nil {
+		t.Error("expecting error for unknown TLS version")
+	}
+
+	ciphers, err := ParseCipherSuites("TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256, tls_rsa_with_aes_128_cbc_sha")
+	if err != nil || len(ciphers) != 2 || ciphers[0] != tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256 || ciphers[1] != tls.TLS_RSA_WITH_AES_128_CBC_SHA {
+		t.Errorf("wrong cipher suites: %v, %v", ciphers, err)
+	}
+	if _, err := ParseCipherSuites("TLS_NOPE"); err == nil {
+		t.Error("expecting error for unknown cipher suite")
+	}
+
+	curves, err := ParseCurves("X25519,P-256,CurveP384,p521")
+	if err != nil || len(curves) != 4 || curves[0] != tls.X25519 || curves[1] != tls.CurveP256 || curves[2] != tls.CurveP384 || curves[3] != tls.CurveP521 {
+		t.Errorf("wrong curves: %v, %v", curves, err)
+	}
+	if _, err := ParseCurves("P999"); err == nil {
+		t.Error("expecting error for unknown curve")
+	}
+}
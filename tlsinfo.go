@@ -0,0 +1,221 @@
+package grpcurl
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+// TLSReport describes a TLS handshake with a server, as performed by
+// CheckTLS.
+type TLSReport struct {
+	// Address is the address of the server that was connected to.
+	Address net.Addr
+	// ServerName is the name that the server's certificate was verified
+	// against, which is also sent to the server via SNI.
+	ServerName string
+	// State is the state of the connection after the handshake, which
+	// includes the negotiated TLS version, cipher suite, and ALPN protocol,
+	// and the certificate chain that the server presented. If the server
+	// rejected the client certificate, it describes the handshake up to that
+	// point.
+	State tls.ConnectionState
+	// VerifySkipped is true if the server's certificate chain was not verified,
+	// because the TLS config has InsecureSkipVerify set.
+	VerifySkipped bool
+	// VerifyError is why the server's certificate chain could not be verified,
+	// or nil if it was verified (or verification was skipped).
+	VerifyError error
+	// ClientCertRequested is true if the server asked for a client
+	// certificate.
+	ClientCertRequested bool
+	// ClientCertSent is true if a client certificate was sent to the server.
+	ClientCertSent bool
+	// ClientCertError is the error reported by the server if it rejected the
+	// client certificate, or the lack of one.
+	ClientCertError error
+	// AcceptableCAs are the names of the certificate authorities that the
+	// server advertised as acceptable issuers of client certificates.
+	AcceptableCAs []string
+}
+
+// CheckTLS connects to the given address and performs only a TLS handshake,
+// using the given config, and reports the outcome. Unlike a normal handshake,
+// it is completed even if the server's certificate chain can't be verified,
+// so that the chain can be inspected. An error is returned only if the server
+// could not be reached or the handshake failed for some other reason than the
+// server rejecting the client certificate.
+func CheckTLS(ctx context.Context, network, address string, config *tls.Config) (*TLSReport, error) {
+	var report TLSReport
+	report.ServerName = config.ServerName
+	if report.ServerName == "" {
+		report.ServerName = "localhost"
+		if network != "unix" {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return nil, err
+			}
+			report.ServerName = host
+		}
+	}
+	report.VerifySkipped = config.InsecureSkipVerify
+
+	config = config.Clone()
+	config.ServerName = report.ServerName
+	// the chain is verified below, so that the handshake can complete anyway
+	config.InsecureSkipVerify = true
+	if len(config.NextProtos) == 0 {
+		// like the gRPC library
+		config.NextProtos = []string{"h2"}
+	}
+	clientCerts := config.Certificates
+	config.Certificates = nil
+	config.GetClientCertificate = func(cri *tls.CertificateRequestInfo) (*tls.Certificate, error) {
+		report.ClientCertRequested = true
+		for _, name := range cri.AcceptableCAs {
+			report.AcceptableCAs = append(report.AcceptableCAs, distinguishedNameToString(name))
+		}
+		if len(clientCerts) == 0 {
+			return &tls.Certificate{}, nil
+		}
+		report.ClientCertSent = true
+		return &clientCerts[0], nil
+	}
+
+	var d net.Dialer
+	rawConn, err := d.DialContext(ctx, network, address)
+	if err != nil {
+		return nil, err
+	}
+	defer rawConn.Close()
+	report.Address = rawConn.RemoteAddr()
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = rawConn.SetDeadline(deadline)
+	}
+	conn := tls.Client(rawConn, config)
+	err = conn.HandshakeContext(ctx)
+	if err == nil && report.ClientCertRequested {
+		// With TLS 1.3, the server checks the client certificate after the
+		// client thinks the handshake is done, so the server only reports a
+		// problem with it once the client tries to use the connection.
+		err = checkAccepted(conn)
+	}
+	report.State = conn.ConnectionState()
+	if err != nil {
+		if !report.ClientCertRequested || ctx.Err() != nil {
+			return nil, err
+		}
+		report.ClientCertError = err
+	}
+
+	if !report.VerifySkipped {
+		report.VerifyError = verifyChain(report.State, config)
+	}
+	return &report, nil
+}
+
+// checkAccepted sends the HTTP/2 connection preface and waits for the server
+// to respond, which it will not do if it rejected the client certificate.
+func checkAccepted(conn *tls.Conn) error {
+	// the preface, followed by an empty SETTINGS frame
+	preface := "PRI * HTTP/2.0\r\n\r\nSM\r\n\r\n" + "\x00\x00\x00\x04\x00\x00\x00\x00\x00"
+	if _, err := conn.Write([]byte(preface)); err != nil {
+		return err
+	}
+	var b [1]byte
+	_, err := conn.Read(b[:])
+	return err
+}
+
+// verifyChain verifies the peer's certificate chain like a normal handshake
+// would, including any additional checks done by the config's
+// VerifyPeerCertificate, such as for revocation.
+func verifyChain(state tls.ConnectionState, config *tls.Config) error {
+	certs := state.PeerCertificates
+	if len(certs) == 0 {
+		return fmt.Errorf("server presented no certificates")
+	}
+	intermediates := x509.NewCertPool()
+	for _, cert := range certs[1:] {
+		intermediates.AddCert(cert)
+	}
+	chains, err := certs[0].Verify(x509.VerifyOptions{
+		Roots:         config.RootCAs,
+		DNSName:       config.ServerName,
+		Intermediates: intermediates,
+	})
+	if err != nil || config.VerifyPeerCertificate == nil {
+		return err
+	}
+	rawCerts := make([][]byte, len(certs))
+	for i, cert := range certs {
+		rawCerts[i] = cert.Raw
+	}
+	return config.VerifyPeerCertificate(rawCerts, chains)
+}
+
+func distinguishedNameToString(der []byte) string {
+	var rdns pkix.RDNSequence
+	if _, err := asn1.Unmarshal(der, &rdns); err != nil {
+		return fmt.Sprintf("(malformed name: %v)", err)
+	}
+	var name pkix.Name
+	name.FillFromRDNSequence(&rdns)
+	return name.String()
+}
+
+// String returns a description of the report, for displaying to users.
+func (r *TLSReport) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Address: %v", r.Address)
+	fmt.Fprintf(&b, "\nServer name: %s", r.ServerName)
+	b.WriteString("\n")
+	b.WriteString(tlsStateToString(r.State))
+	switch {
+	case r.VerifySkipped:
+		b.WriteString("\nVerification: skipped")
+	case r.VerifyError != nil:
+		fmt.Fprintf(&b, "\nVerification: FAILED: %v", r.VerifyError)
+	default:
+		b.WriteString("\nVerification: OK")
+	}
+	if !r.ClientCertRequested {
+		b.WriteString("\nClient certificate: not requested")
+		return b.String()
+	}
+	b.WriteString("\nClient certificate: requested")
+	if r.ClientCertSent {
+		b.WriteString(", sent")
+	} else {
+		b.WriteString(", none sent")
+	}
+	if r.ClientCertError != nil {
+		fmt.Fprintf(&b, ", REJECTED: %v", r.ClientCertError)
+	} else {
+		b.WriteString(", accepted")
+	}
+	b.WriteString("\nAcceptable CAs:")
+	if len(r.AcceptableCAs) == 0 {
+		b.WriteString(" (any)")
+	}
+	for _, ca := range r.AcceptableCAs {
+		fmt.Fprintf(&b, "\n  %s", ca)
+	}
+	return b.String()
+}
+
+// DaysUntilExpiry returns the number of whole days until the server's
+// certificate expires, which is negative if it has already expired. It is
+// zero if the server presented no certificates.
+func (r *TLSReport) DaysUntilExpiry() int {
+	if len(r.State.PeerCertificates) == 0 {
+		return 0
+	}
+	return daysUntil(r.State.PeerCertificates[0].NotAfter, time.Now())
+}
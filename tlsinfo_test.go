@@ -0,0 +1,96 @@
+package grpcurl_test
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+
+	. "github.com/tetrateio/grpcurl"
+	grpcurl_testing "github.com/tetrateio/grpcurl/internal/testing"
+)
+
+func TestCheckTLS(t *testing.T) {
+	serverCreds, err := ServerTransportCredentials("internal/testing/tls/ca.crt", "internal/testing/tls/server.crt", "internal/testing/tls/server.key", true)
+	if err != nil {
+		t.Fatalf("failed to create server creds: %v", err)
+	}
+	svr := grpc.NewServer(grpc.Creds(serverCreds))
+	grpcurl_testing.RegisterTestServiceServer(svr, grpcurl_testing.TestServer{})
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	go svr.Serve(l)
+	defer svr.Stop()
+
+	testCases := []struct {
+		name                 string
+		cacert, cert, key    string
+		serverName           string
+		expectVerifyError    bool
+		expectClientCertSent bool
+		expectRejected       bool
+	}{
+		{name: "trusted", cacert: "ca.crt", cert: "client.crt", key: "client.key", expectClientCertSent: true},
+		{name: "untrusted", cacert: "wrong-ca.crt", cert: "client.crt", key: "client.key", expectVerifyError: true, expectClientCertSent: true},
+		{name: "wrong server name", cacert: "ca.crt", cert: "client.crt", key: "client.key", serverName: "foo.example", expectVerifyError: true, expectClientCertSent: true},
+		{name: "no client cert", cacert: "ca.crt", expectRejected: true},
+		{name: "expired client cert", cacert: "ca.crt", cert: "expired.crt", key: "expired.key", expectClientCertSent: true, expectRejected: true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			cert, key := tc.cert, tc.key
+			if cert != "" {
+				cert, key = "internal/testing/tls/"+cert, "internal/testing/tls/"+key
+			}
+			conf, err := ClientTLSConfig(false, "internal/testing/tls/"+tc.cacert, cert, key)
+			if err != nil {
+				t.Fatalf("failed to create TLS config: %v", err)
+			}
+			conf.ServerName = tc.serverName
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			report, err := CheckTLS(ctx, "tcp", l.Addr().String(), conf)
+			if err != nil {
+				t.Fatalf("failed to check TLS: %v", err)
+			}
+			if (report.VerifyError != nil) != tc.expectVerifyError {
+				t.Errorf("wrong verification result: %v", report.VerifyError)
+			}
+			if !report.ClientCertRequested || report.ClientCertSent != tc.expectClientCertSent {
+				t.Errorf("wrong client cert result: requested %v, sent %v", report.ClientCertRequested, report.ClientCertSent)
+			}
+			if (report.ClientCertError != nil) != tc.expectRejected {
+				t.Errorf("wrong client cert acceptance: %v", report.ClientCertError)
+			}
+			if len(report.AcceptableCAs) != 1 || report.AcceptableCAs[0] != "CN=ca" {
+				t.Errorf("wrong acceptable CAs: %v", report.AcceptableCAs)
+			}
+			if len(report.State.PeerCertificates) != 1 || report.State.PeerCertificates[0].Subject.CommonName != "server" {
+				t.Errorf("wrong certificate chain: %v", report.State.PeerCertificates)
+			}
+			if days := report.DaysUntilExpiry(); days <= 0 {
+				t.Errorf("server certificate should not be expired: %d", days)
+			}
+		})
+	}
+
+	// with InsecureSkipVerify, the chain is not verified
+	conf, err := ClientTLSConfig(true, "", "", "")
+	if err != nil {
+		t.Fatalf("failed to create TLS config: %v", err)
+	}
+	report, err := CheckTLS(context.Background(), "tcp", l.Addr().String(), conf)
+	if err != nil {
+		t.Fatalf("failed to check TLS: %v", err)
+	}
+	if !report.VerifySkipped || report.VerifyError != nil {
+		t.Errorf("verification should be skipped: %v, %v", report.VerifySkipped, report.VerifyError)
+	}
+	if report.ServerName != "127.0.0.1" {
+		t.Errorf("wrong server name: %q", report.ServerName)
+	}
+}
@@ -0,0 +1,210 @@
+package grpcurl
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"time"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/clientcredentials"
+	"google.golang.org/grpc/credentials"
+)
+
+// TokenScope indicates which calls are sent a bearer token by the credentials
+// returned from TokenCredentials.
+type TokenScope string
+
+const (
+	// TokenForAll sends the token with both reflection requests and RPCs,
+	// like headers given with the -H flag of the command-line tool.
+	TokenForAll = TokenScope("all")
+	// TokenForRPC sends the token only with RPCs, and not with reflection
+	// requests, like headers given with the -rpc-header flag.
+	TokenForRPC = TokenScope("rpc")
+	// TokenForReflection sends the token only with reflection requests, like
+	// headers given with the -reflect-header flag.
+	TokenForReflection = TokenScope("reflection")
+)
+
+// TokenCredentials returns per-RPC credentials that send a bearer token from
+// the given source, in the "authorization" header, with calls in the given
+// scope. A token is re-used until it expires, at which point a new token is
+// fetched from the source. If requireTransportSecurity is true, the
+// credentials can only be used on connections that use TLS.
+func TokenCredentials(ts oauth2.TokenSource, scope TokenScope, requireTransportSecurity bool) credentials.PerRPCCredentials {
+	return &tokenCredentials{
+		ts:              oauth2.ReuseTokenSource(nil, ts),
+		scope:           scope,
+		requireSecurity: requireTransportSecurity,
+	}
+}
+
+type tokenCredentials struct {
+	ts              oauth2.TokenSource
+	scope           TokenScope
+	requireSecurity bool
+}
+
+func (c *tokenCredentials) GetRequestMetadata(ctx context.Context, _ ...string) (map[string]string, error) {
+	if c.scope != TokenForAll {
+		ri, _ := credentials.RequestInfoFromContext(ctx)
+		isReflection := strings.HasPrefix(ri.Method, "/grpc.reflection.")
+		if isReflection != (c.scope == TokenForReflection) {
+			return nil, nil
+		}
+	}
+	tok, err := c.ts.Token()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get token: %v", err)
+	}
+	return map[string]string{"authorization": tok.Type() + " " + tok.AccessToken}, nil
+}
+
+func (c *tokenCredentials) RequireTransportSecurity() bool {
+	return c.requireSecurity
+}
+
+// ClientCredentialsTokenSource returns a token source that uses the OAuth2
+// client credentials flow to get tokens from the given token endpoint. The
+// given params, which may be nil, are included in token requests; for example,
+// some OpenID Connect providers require an "audience" parameter.
+func ClientCredentialsTokenSource(ctx context.Context, tokenURL, clientID, clientSecret string, scopes []string, params url.Values) oauth2.TokenSource {
+	conf := clientcredentials.Config{
+		ClientID:       clientID,
+		ClientSecret:   clientSecret,
+		TokenURL:       tokenURL,
+		Scopes:         scopes,
+		EndpointParams: params,
+	}
+	return conf.TokenSource(ctx)
+}
+
+// TokenSourceFromFile returns a token source that reads tokens from the given
+// file. The file may contain just the token, or it may contain a JSON token
+// response (see TokenSourceFromCommand). The file is read again each time the
+// token expires, so the file may be updated by another process. A token whose
+// expiry is not known, because it is neither in a JSON response nor in the
+// token itself (as the "exp" claim of a JWT), never expires.
+func TokenSourceFromFile(path string) oauth2.TokenSource {
+	return tokenFileSource(path)
+}
+
+type tokenFileSource string
+
+func (s tokenFileSource) Token() (*oauth2.Token, error) {
+	data, err := os.ReadFile(string(s))
+	if err != nil {
+		return nil, err
+	}
+	data = bytes.TrimSpace(data)
+	if len(data) > 0 && data[0] == '{' {
+		tok, err := parseTokenResponse(data)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse token file %s: %v", s, err)
+		}
+		return tok, nil
+	}
+	if len(data) == 0 {
+		return nil, fmt.Errorf("token file %s is empty", s)
+	}
+	tok := &oauth2.Token{AccessToken: string(data)}
+	tok.Expiry = jwtExpiry(tok.AccessToken)
+	return tok, nil
+}
+
+// TokenSourceFromCommand returns a token source that runs the given command,
+// using the system shell, to get tokens. The command is run again each time
+// the token expires. The command must write a JSON token response to stdout,
+// in the same form as an OAuth2 token endpoint: an object with an
+// "access_token" (or "token") and, optionally, a "token_type" and either an
+// "expires_in" duration in seconds or an "expiry" time in RFC 3339 format. If
+// no expiry is given, it is taken from the token if it is a JWT.
+func TokenSourceFromCommand(command string) oauth2.TokenSource {
+	return tokenCommandSource(command)
+}
+
+type tokenCommandSource string
+
+func (s tokenCommandSource) Token() (*oauth2.Token, error) {
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.Command("cmd", "/C", string(s))
+	} else {
+		cmd = exec.Command("sh", "-c", string(s))
+	}
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, fmt.Errorf("token command failed: %v: %s", err, msg)
+		}
+		return nil, fmt.Errorf("token command failed: %v", err)
+	}
+	tok, err := parseTokenResponse(out)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse output of token command: %v", err)
+	}
+	return tok, nil
+}
+
+type tokenResponse struct {
+	AccessToken string    `json:"access_token"`
+	Token       string    `json:"token"`
+	TokenType   string    `json:"token_type"`
+	ExpiresIn   float64   `json:"expires_in"`
+	Expiry      time.Time `json:"expiry"`
+}
+
+func parseTokenResponse(data []byte) (*oauth2.Token, error) {
+	var resp tokenResponse
+	if err := json.Unmarshal(data, &resp); err != nil {
+		return nil, err
+	}
+	tok := &oauth2.Token{
+		AccessToken: resp.AccessToken,
+		TokenType:   resp.TokenType,
+		Expiry:      resp.Expiry,
+	}
+	if tok.AccessToken == "" {
+		tok.AccessToken = resp.Token
+	}
+	if tok.AccessToken == "" {
+		return nil, errors.New("response has no access_token")
+	}
+	if resp.ExpiresIn > 0 {
+		tok.Expiry = time.Now().Add(time.Duration(resp.ExpiresIn * float64(time.Second)))
+	} else if tok.Expiry.IsZero() {
+		tok.Expiry = jwtExpiry(tok.AccessToken)
+	}
+	return tok, nil
+}
+
+// jwtExpiry returns the expiry of the given token if it is a JWT with an "exp"
+// claim. Otherwise, it returns the zero time.
+func jwtExpiry(token string) time.Time {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return time.Time{}
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(parts[1], "="))
+	if err != nil {
+		return time.Time{}
+	}
+	var claims struct {
+		Exp float64 `json:"exp"`
+	}
+	if err := json.Unmarshal(payload, &claims); err != nil || claims.Exp <= 0 {
+		return time.Time{}
+	}
+	return time.Unix(int64(claims.Exp), 0)
+}
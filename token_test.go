@@ -0,0 +1,262 @@
+package grpcurl_test
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jhump/protoreflect/grpcreflect"
+	"golang.org/x/oauth2"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/reflection"
+
+	. "github.com/tetrateio/grpcurl"
+	grpcurl_testing "github.com/tetrateio/grpcurl/internal/testing"
+)
+
+// authRecorder records the "authorization" header of each call to a server.
+type authRecorder struct {
+	mu   sync.Mutex
+	auth map[string][]string
+}
+
+func (r *authRecorder) record(ctx context.Context, method string) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.auth[method] = append(r.auth[method], md.Get("authorization")...)
+}
+
+func (r *authRecorder) get(method string) []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.auth[method]
+}
+
+func TestTokenCredentials(t *testing.T) {
+	rec := &authRecorder{auth: map[string][]string{}}
+	svr := grpc.NewServer(
+		grpc.UnaryInterceptor(func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+			rec.record(ctx, info.FullMethod)
+			return handler(ctx, req)
+		}),
+		grpc.StreamInterceptor(func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+			rec.record(ss.Context(), info.FullMethod)
+			return handler(srv, ss)
+		}),
+	)
+	grpcurl_testing.RegisterTestServiceServer(svr, grpcurl_testing.TestServer{})
+	reflection.Register(svr)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	go svr.Serve(l)
+	defer svr.Stop()
+
+	const reflectV1Method = "/grpc.reflection.v1.ServerReflection/ServerReflectionInfo"
+	const reflectV1AlphaMethod = "/grpc.reflection.v1alpha.ServerReflection/ServerReflectionInfo"
+	const rpcMethod = "/testing.TestService/EmptyCall"
+	testCases := []struct {
+		scope                TokenScope
+		reflectAuth, rpcAuth bool
+	}{
+		{TokenForAll, true, true},
+		{TokenForRPC, false, true},
+		{TokenForReflection, true, false},
+	}
+	for _, tc := range testCases {
+		t.Run(string(tc.scope), func(t *testing.T) {
+			rec.mu.Lock()
+			rec.auth = map[string][]string{}
+			rec.mu.Unlock()
+
+			ts := oauth2.StaticTokenSource(&oauth2.Token{AccessToken: "abc"})
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+			cc, err := grpc.DialContext(ctx, l.Addr().String(),
+				grpc.WithTransportCredentials(insecure.NewCredentials()),
+				grpc.WithPerRPCCredentials(TokenCredentials(ts, tc.scope, false)))
+			if err != nil {
+				t.Fatalf("failed to dial: %v", err)
+			}
+			defer cc.Close()
+
+			refClient := grpcreflect.NewClientAuto(ctx, cc)
+			defer refClient.Reset()
+			source := DescriptorSourceFromServer(ctx, refClient)
+			h := &handler{}
+			if err := InvokeRpc(ctx, source, cc, "testing.TestService/EmptyCall", nil, h, h.getRequestData); err != nil {
+				t.Fatalf("failed to invoke RPC: %v", err)
+			}
+
+			reflectAuth := append(rec.get(reflectV1Method), rec.get(reflectV1AlphaMethod)...)
+			checkAuth(t, "reflection", reflectAuth, tc.reflectAuth)
+			checkAuth(t, "RPC", rec.get(rpcMethod), tc.rpcAuth)
+		})
+	}
+}
+
+func checkAuth(t *testing.T, kind string, auth []string, expected bool) {
+	if expected {
+		if len(auth) != 1 || auth[0] != "Bearer abc" {
+			t.Errorf("%s: expected authorization %q, got %q", kind, "Bearer abc", auth)
+		}
+	} else if len(auth) != 0 {
+		t.Errorf("%s: expected no authorization, got %q", kind, auth)
+	}
+}
+
+// getAuth returns the authorization header sent by the given credentials.
+func getAuth(t *testing.T, ts oauth2.TokenSource) string {
+	md, err := TokenCredentials(ts, TokenForAll, false).GetRequestMetadata(context.Background())
+	if err != nil {
+		t.Fatalf("failed to get request metadata: %v", err)
+	}
+	return md["authorization"]
+}
+
+func TestTokenSourceFromFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "token")
+	write := func(contents string) {
+		if err := os.WriteFile(path, []byte(contents), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// tokens that are about to expire are read again for each call
+	write(`{"access_token": "abc", "token_type": "MAC", "expires_in": 1}`)
+	ts := TokenSourceFromFile(path)
+	creds := TokenCredentials(ts, TokenForAll, false)
+	md, err := creds.GetRequestMetadata(context.Background())
+	if err != nil || md["authorization"] != "MAC abc" {
+		t.Errorf("wrong authorization: %v, %v", md, err)
+	}
+	write(`{"access_token": "def", "expires_in": 1}`)
+	md, err = creds.GetRequestMetadata(context.Background())
+	if err != nil || md["authorization"] != "Bearer def" {
+		t.Errorf("wrong authorization: %v, %v", md, err)
+	}
+
+	// tokens without an expiry are never read again
+	write("xyz\n")
+	creds = TokenCredentials(ts, TokenForAll, false)
+	for _, contents := range []string{"xyz", "uvw"} {
+		write(contents)
+		if md, err := creds.GetRequestMetadata(context.Background()); err != nil || md["authorization"] != "Bearer xyz" {
+			t.Errorf("wrong authorization: %v, %v", md, err)
+		}
+	}
+
+	// expiry is taken from the "exp" claim of a JWT
+	exp := time.Now().Add(time.Hour).Truncate(time.Second)
+	claims := base64.RawURLEncoding.EncodeToString([]byte(fmt.Sprintf(`{"sub": "me", "exp": %d}`, exp.Unix())))
+	write("e30." + claims + ".sig")
+	tok, err := ts.Token()
+	if err != nil {
+		t.Fatalf("failed to read token: %v", err)
+	}
+	if !tok.Expiry.Equal(exp) {
+		t.Errorf("wrong expiry: expected %v, got %v", exp, tok.Expiry)
+	}
+
+	for _, contents := range []string{"", "{", `{"token_type": "Bearer"}`} {
+		write(contents)
+		if _, err := ts.Token(); err == nil {
+			t.Errorf("%q: expected error but got none", contents)
+		}
+	}
+}
+
+func TestTokenSourceFromCommand(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("test commands require a unix shell")
+	}
+	ts := TokenSourceFromCommand(`echo '{"token": "abc", "expiry": "2100-01-01T00:00:00Z"}'`)
+	tok, err := ts.Token()
+	if err != nil {
+		t.Fatalf("failed to get token: %v", err)
+	}
+	if tok.AccessToken != "abc" || tok.Expiry.Year() != 2100 {
+		t.Errorf("wrong token: %+v", tok)
+	}
+	if auth := getAuth(t, ts); auth != "Bearer abc" {
+		t.Errorf("wrong authorization: %q", auth)
+	}
+
+	_, err = TokenSourceFromCommand("echo oops >&2; exit 3").Token()
+	if err == nil || !strings.Contains(err.Error(), "oops") {
+		t.Errorf("expected error with command's output, got %v", err)
+	}
+	_, err = TokenSourceFromCommand("echo abc").Token()
+	if err == nil || !strings.Contains(err.Error(), "failed to parse") {
+		t.Errorf("expected parse error, got %v", err)
+	}
+}
+
+func TestClientCredentialsTokenSource(t *testing.T) {
+	var requests int
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		id, secret, _ := r.BasicAuth()
+		if id == "" {
+			id, secret = r.PostForm.Get("client_id"), r.PostForm.Get("client_secret")
+		}
+		expected := url.Values{
+			"grant_type": []string{"client_credentials"},
+			"scope":      []string{"read write"},
+			"audience":   []string{"api"},
+		}
+		for k := range expected {
+			if r.PostForm.Get(k) != expected.Get(k) {
+				http.Error(w, fmt.Sprintf("wrong %s: %q", k, r.PostForm.Get(k)), http.StatusBadRequest)
+				return
+			}
+		}
+		if id != "client" || secret != "s3cret" {
+			http.Error(w, "bad client credentials", http.StatusUnauthorized)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"access_token": "abc", "token_type": "bearer", "expires_in": 3600}`)
+	}))
+	defer svr.Close()
+
+	ts := ClientCredentialsTokenSource(context.Background(), svr.URL, "client", "s3cret", []string{"read", "write"}, url.Values{"audience": []string{"api"}})
+	creds := TokenCredentials(ts, TokenForAll, false)
+	for i := 0; i < 2; i++ {
+		md, err := creds.GetRequestMetadata(context.Background())
+		if err != nil {
+			t.Fatalf("failed to get request metadata: %v", err)
+		}
+		if md["authorization"] != "Bearer abc" {
+			t.Errorf("wrong authorization: %q", md["authorization"])
+		}
+	}
+	// the token is re-used until it expires
+	if requests != 1 {
+		t.Errorf("expected 1 token request, got %d", requests)
+	}
+
+	ts = ClientCredentialsTokenSource(context.Background(), svr.URL, "client", "wrong", nil, nil)
+	if _, err := TokenCredentials(ts, TokenForAll, false).GetRequestMetadata(context.Background()); err == nil {
+		t.Errorf("expected error with wrong client secret")
+	}
+}
@@ -0,0 +1,357 @@
+package grpcurl
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/golang/protobuf/proto" //lint:ignore SA1019 we have to import this because it appears in exported API
+	"github.com/jhump/protoreflect/dynamic/grpcdynamic"
+	"google.golang.org/grpc/codes"
+	"gopkg.in/yaml.v3"
+)
+
+// Workflow is the contents of a workflow file, which describes a sequence of
+// calls in which later calls can use values from the results of earlier ones.
+// For example:
+//
+//	vars:
+//	  owner: joe
+//	steps:
+//	  - name: open
+//	    method: Bank/OpenAccount
+//	    headers: ["authorization: token ${owner}"]
+//	    request: {initial_deposit_cents: 1000, type: CHECKING}
+//	    capture: {account: account_number}
+//	  - name: deposit
+//	    method: Bank/Deposit
+//	    headers: ["authorization: token ${owner}"]
+//	    request: {account_number: "${account}", amount_cents: 500}
+//	    expect: {fields: ["balance_cents == 1500"]}
+type Workflow struct {
+	// Vars are variables that steps can refer to, in addition to captured
+	// values and environment variables.
+	Vars map[string]string `json:"vars,omitempty"`
+	// Steps are the calls to make, in order.
+	Steps []*WorkflowStep `json:"steps"`
+}
+
+// WorkflowStep describes one call in a workflow. Placeholders in its headers
+// and in string values in its request messages are expanded like those in a
+// RequestTemplate, and may also refer to values captured by earlier steps.
+type WorkflowStep struct {
+	// Name identifies the step in results. It is optional.
+	Name string `json:"name,omitempty"`
+	// Method is the fully-qualified name of the method to invoke, in
+	// 'service/method' or 'service.method' format.
+	Method string `json:"method"`
+	// Headers are request headers, each in 'name: value' format.
+	Headers []string `json:"headers,omitempty"`
+	// Request is the JSON form of the request message, for calls that send
+	// a single message.
+	Request json.RawMessage `json:"request,omitempty"`
+	// Requests are the JSON forms of all request messages, for calls that
+	// send a stream of messages. It is an error to set both Request and
+	// Requests.
+	Requests []json.RawMessage `json:"requests,omitempty"`
+	// Expect describes the expected outcome of the call. If absent, the call
+	// is expected to succeed with a status of OK.
+	Expect *Expectations `json:"expect,omitempty"`
+	// Capture defines variables, for use by later steps, from the results of
+	// the call. Each key is a variable name, and each value says where the
+	// variable's value comes from: 'header:NAME' or 'trailer:NAME' for the
+	// first value of a response header or trailer, or otherwise the path to
+	// a field in the response messages. Paths are in the same syntax as for
+	// field expectations (see ParseFieldExpectation), and refer to the last
+	// response message unless prefixed with an index, like "[0].id". Message
+	// and list values are captured in their JSON form.
+	Capture map[string]string `json:"capture,omitempty"`
+}
+
+// WorkflowStepResult describes the outcome of one step of a workflow.
+type WorkflowStepResult struct {
+	// Step is the number of the step, counting from one.
+	Step int `json:"step"`
+	// Name is the name of the step, if it has one.
+	Name string `json:"name,omitempty"`
+	// CallResult describes the call. Its Passed field is always set: it is
+	// true if the call met its expectations and all values were captured.
+	*CallResult
+	// Captured are the variables captured by the step.
+	Captured map[string]string `json:"captured,omitempty"`
+}
+
+// ReadWorkflow reads a workflow file from the given reader. The file may be in
+// JSON or YAML format.
+func ReadWorkflow(in io.Reader) (*Workflow, error) {
+	// YAML is a superset of JSON, so this handles both
+	var doc interface{}
+	if err := yaml.NewDecoder(in).Decode(&doc); err != nil && err != io.EOF {
+		return nil, fmt.Errorf("failed to parse workflow: %v", err)
+	}
+	val, err := yamlToJSONValue(doc)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse workflow: %v", err)
+	}
+	js, err := json.Marshal(val)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse workflow: %v", err)
+	}
+	var wf Workflow
+	dec := json.NewDecoder(bytes.NewReader(js))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&wf); err != nil {
+		return nil, fmt.Errorf("failed to parse workflow: %v", err)
+	}
+	if len(wf.Steps) == 0 {
+		return nil, fmt.Errorf("workflow has no steps")
+	}
+	for i, step := range wf.Steps {
+		if step == nil || step.Method == "" {
+			return nil, fmt.Errorf("step %d: no method specified", i+1)
+		}
+		if len(step.Request) > 0 && len(step.Requests) > 0 {
+			return nil, fmt.Errorf("step %d: may have 'request' or 'requests', but not both", i+1)
+		}
+		for name, src := range step.Capture {
+			if _, err := parseCapture(name, src); err != nil {
+				return nil, fmt.Errorf("step %d: %v", i+1, err)
+			}
+		}
+	}
+	return &wf, nil
+}
+
+// RunWorkflow runs the steps of the given workflow in order, until one fails.
+// All calls share the given descriptor source and channel, and the given
+// headers are sent with every call. The given variables take precedence over
+// those defined in the workflow. If onStep is not nil, it is called with the
+// result of each step as soon as the step completes.
+//
+// A step fails if its call does not meet its expectations (by default, that
+// it succeeds), or if any of its values cannot be captured. The results of
+// all steps that were run, including the one that failed, are returned. An
+// error is returned only if the given context is done before all steps are
+// run.
+func RunWorkflow(ctx context.Context, source DescriptorSource, ch grpcdynamic.Channel, headers []string, wf *Workflow, vars map[string]string, onStep func(*WorkflowStepResult)) ([]*WorkflowStepResult, error) {
+	tmpl := NewRequestTemplate(wf.Vars)
+	for name, val := range vars {
+		tmpl.SetVar(name, val)
+	}
+	results := make([]*WorkflowStepResult, 0, len(wf.Steps))
+	for i, step := range wf.Steps {
+		if err := ctx.Err(); err != nil {
+			return results, err
+		}
+		res := runWorkflowStep(ctx, source, ch, headers, step, tmpl)
+		res.Step = i + 1
+		results = append(results, res)
+		if onStep != nil {
+			onStep(res)
+		}
+		if !*res.Passed {
+			break
+		}
+	}
+	return results, nil
+}
+
+func runWorkflowStep(ctx context.Context, source DescriptorSource, ch grpcdynamic.Channel, headers []string, step *WorkflowStep, tmpl *RequestTemplate) *WorkflowStepResult {
+	res := &WorkflowStepResult{Name: step.Name}
+	failed := func(err error) *WorkflowStepResult {
+		passed := false
+		if res.CallResult == nil {
+			res.CallResult = &CallResult{Method: step.Method, Code: codes.Unknown.String()}
+		}
+		res.Error = err.Error()
+		res.Passed = &passed
+		return res
+	}
+
+	rec := &CallRecord{Method: step.Method, Expect: step.Expect}
+	if rec.Expect == nil {
+		rec.Expect = &Expectations{}
+	}
+	for _, h := range step.Headers {
+		expanded, err := tmpl.Expand(h)
+		if err != nil {
+			return failed(fmt.Errorf("could not expand header %q: %v", h, err))
+		}
+		rec.Headers = append(rec.Headers, expanded)
+	}
+	reqs := step.Requests
+	if len(step.Request) > 0 {
+		reqs = []json.RawMessage{step.Request}
+	}
+	for i, req := range reqs {
+		expanded, err := expandJSON(req, tmpl)
+		if err != nil {
+			return failed(fmt.Errorf("could not expand request %d: %v", i+1, err))
+		}
+		rec.Requests = append(rec.Requests, expanded)
+	}
+
+	names := make([]string, 0, len(step.Capture))
+	for name := range step.Capture {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var captures []*workflowCapture
+	for _, name := range names {
+		c, err := parseCapture(name, step.Capture[name])
+		if err != nil {
+			return failed(err)
+		}
+		captures = append(captures, c)
+	}
+	var onResponse func(proto.Message)
+	if len(captures) > 0 {
+		// if the method can't be resolved, the call fails below and reports why
+		if md, err := resolveMethod(source, step.Method); err == nil {
+			for _, c := range captures {
+				if c.field == nil {
+					continue
+				}
+				if err := c.field.Validate(md.GetOutputType()); err != nil {
+					return failed(fmt.Errorf("invalid capture of %q: %v", c.name, err))
+				}
+			}
+		}
+		resolver := anyResolverWithFallback{AnyResolver: AnyResolverFromDescriptorSource(source)}
+		numResponses := 0
+		onResponse = func(resp proto.Message) {
+			for _, c := range captures {
+				if c.field != nil && c.field.resolved != nil && (c.field.response < 0 || c.field.response == numResponses) {
+					_, c.value = c.field.lookup(resp, resolver)
+					c.found = c.value != nil
+				}
+			}
+			numResponses++
+		}
+	}
+
+	res.CallResult = invokeCallRecord(ctx, source, ch, headers, rec, onResponse)
+	if !*res.Passed {
+		return res
+	}
+	for _, c := range captures {
+		val, err := c.result(res.CallResult)
+		if err != nil {
+			return failed(err)
+		}
+		if res.Captured == nil {
+			res.Captured = map[string]string{}
+		}
+		res.Captured[c.name] = val
+		tmpl.SetVar(c.name, val)
+	}
+	return res
+}
+
+// expandJSON expands the placeholders in all strings in the given JSON value.
+func expandJSON(js json.RawMessage, tmpl *RequestTemplate) (json.RawMessage, error) {
+	dec := json.NewDecoder(bytes.NewReader(js))
+	dec.UseNumber()
+	var val interface{}
+	if err := dec.Decode(&val); err != nil {
+		return nil, err
+	}
+	val, err := expandJSONValue(val, tmpl)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(val)
+}
+
+func expandJSONValue(val interface{}, tmpl *RequestTemplate) (interface{}, error) {
+	var err error
+	switch val := val.(type) {
+	case string:
+		return tmpl.Expand(val)
+	case map[string]interface{}:
+		for k, v := range val {
+			if val[k], err = expandJSONValue(v, tmpl); err != nil {
+				return nil, err
+			}
+		}
+	case []interface{}:
+		for i, v := range val {
+			if val[i], err = expandJSONValue(v, tmpl); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return val, nil
+}
+
+// workflowCapture is a parsed entry of a step's Capture.
+type workflowCapture struct {
+	name            string
+	src             string
+	header, trailer string
+	field           *FieldExpectation
+
+	// set when a response message is received
+	found bool
+	value interface{}
+}
+
+func parseCapture(name, src string) (*workflowCapture, error) {
+	if !placeholderVarRegex.MatchString(name) {
+		return nil, fmt.Errorf("invalid capture variable name %q: must have only letters, digits, and underscores", name)
+	}
+	c := &workflowCapture{name: name, src: src}
+	switch {
+	case strings.HasPrefix(src, "header:"):
+		c.header = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(src, "header:")))
+	case strings.HasPrefix(src, "trailer:"):
+		c.trailer = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(src, "trailer:")))
+	default:
+		fe, err := ParseFieldExpectation(src)
+		if err != nil {
+			return nil, fmt.Errorf("invalid capture of %q: %v", name, err)
+		}
+		if fe.op != opPresent {
+			return nil, fmt.Errorf("invalid capture of %q: %q is not a field path", name, src)
+		}
+		c.field = fe
+	}
+	if c.field == nil && c.header == "" && c.trailer == "" {
+		return nil, fmt.Errorf("invalid capture of %q: no name given in %q", name, src)
+	}
+	return c, nil
+}
+
+// result returns the captured value, after the call has completed with the
+// given result.
+func (c *workflowCapture) result(res *CallResult) (string, error) {
+	var md map[string][]string
+	var kind, key string
+	switch {
+	case c.header != "":
+		md, kind, key = res.Headers, "header", c.header
+	case c.trailer != "":
+		md, kind, key = res.Trailers, "trailer", c.trailer
+	default:
+		if !c.found {
+			return "", fmt.Errorf("could not capture %q: no response has a value for %s", c.name, c.src)
+		}
+		switch v := c.value.(type) {
+		case string:
+			return v, nil
+		case json.Number:
+			return string(v), nil
+		default:
+			return formatActualValue(v), nil
+		}
+	}
+	vals := md[key]
+	if len(vals) == 0 {
+		return "", fmt.Errorf("could not capture %q: no response %s named %q", c.name, kind, key)
+	}
+	return vals[0], nil
+}
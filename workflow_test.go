@@ -0,0 +1,142 @@
+package grpcurl_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	. "github.com/tetrateio/grpcurl"
+)
+
+const workflow = `
+vars:
+  body: AQID
+steps:
+  - name: echo
+    method: testing.TestService/UnaryCall
+    headers: ["reply-with-trailers: x-token: tok-${body}"]
+    request: {payload: {body: "${body}"}}
+    capture:
+      echoed: payload.body
+      token: "trailer:x-token"
+  - name: aggregate
+    method: testing.TestService/StreamingInputCall
+    headers: ["x-token: ${token}"]
+    requests:
+      - {payload: {body: "${echoed}"}}
+      - {payload: {body: "${echoed}"}}
+    capture: {size: aggregatedPayloadSize}
+  - method: testing.TestService/UnaryCall
+    headers: ["fail-early: ${size}"]
+    expect: {code: AlreadyExists}
+  - name: unexpected failure
+    method: testing.TestService/UnaryCall
+    headers: ["fail-early: 5"]
+  - name: never run
+    method: testing.TestService/EmptyCall
+`
+
+func TestRunWorkflow(t *testing.T) {
+	for _, ds := range descSources {
+		t.Run(ds.name, func(t *testing.T) {
+			wf, err := ReadWorkflow(strings.NewReader(workflow))
+			if err != nil {
+				t.Fatalf("failed to read workflow: %v", err)
+			}
+			var reported int
+			results, err := RunWorkflow(context.Background(), ds.source, ccReflect, nil, wf, nil, func(*WorkflowStepResult) {
+				reported++
+			})
+			if err != nil {
+				t.Fatalf("failed to run workflow: %v", err)
+			}
+			if len(results) != 4 || reported != 4 {
+				t.Fatalf("wrong number of results: expected 4, got %d (%d reported)", len(results), reported)
+			}
+
+			expectedCodes := []string{"OK", "OK", "AlreadyExists", "NotFound"}
+			expectedPassed := []bool{true, true, true, false}
+			for i, res := range results {
+				if res.Step != i+1 || res.Code != expectedCodes[i] || *res.Passed != expectedPassed[i] {
+					t.Errorf("step %d: wrong result: step %d, code %s, passed %v (%s)", i+1, res.Step, res.Code, *res.Passed, res.Error)
+				}
+			}
+			if results[0].Captured["echoed"] != "AQID" || results[0].Captured["token"] != "tok-AQID" {
+				t.Errorf("step 1: wrong captured values: %v", results[0].Captured)
+			}
+			// two messages with three bytes each
+			if results[1].Captured["size"] != "6" {
+				t.Errorf("step 2: wrong captured values: %v", results[1].Captured)
+			}
+			if len(results[3].Failures) != 1 || results[3].Failures[0].Subject != "status code" {
+				t.Errorf("step 4: wrong failures: %v", results[3].Failures)
+			}
+		})
+	}
+}
+
+func TestRunWorkflow_Errors(t *testing.T) {
+	testCases := []struct {
+		name, workflow, err string
+	}{
+		{
+			name:     "undefined variable",
+			workflow: `{"steps": [{"method": "testing.TestService/UnaryCall", "request": {"payload": {"body": "${nope}"}}}]}`,
+			err:      `could not expand request 1: variable "nope" is not defined`,
+		},
+		{
+			name:     "missing header",
+			workflow: `{"steps": [{"method": "testing.TestService/EmptyCall", "capture": {"id": "header:x-id"}}]}`,
+			err:      `could not capture "id": no response header named "x-id"`,
+		},
+		{
+			name:     "bad path",
+			workflow: `{"steps": [{"method": "testing.TestService/EmptyCall", "capture": {"id": "nope.id"}}]}`,
+			err:      `invalid capture of "id"`,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			wf, err := ReadWorkflow(strings.NewReader(tc.workflow))
+			if err != nil {
+				t.Fatalf("failed to read workflow: %v", err)
+			}
+			results, err := RunWorkflow(context.Background(), sourceProtoset, ccReflect, nil, wf, nil, nil)
+			if err != nil {
+				t.Fatalf("failed to run workflow: %v", err)
+			}
+			if len(results) != 1 || *results[0].Passed {
+				t.Fatalf("expecting one failed step, got %d", len(results))
+			}
+			if !strings.Contains(results[0].Error, tc.err) {
+				t.Errorf("wrong error: expecting %q, got %q", tc.err, results[0].Error)
+			}
+		})
+	}
+
+	// variables given to RunWorkflow take precedence over the workflow's
+	wf, err := ReadWorkflow(strings.NewReader(`{"vars": {"code": "0"}, "steps": [{"method": "testing.TestService/EmptyCall", "headers": ["fail-early: ${code}"]}]}`))
+	if err != nil {
+		t.Fatalf("failed to read workflow: %v", err)
+	}
+	results, err := RunWorkflow(context.Background(), sourceProtoset, ccReflect, nil, wf, map[string]string{"code": "9"}, nil)
+	if err != nil {
+		t.Fatalf("failed to run workflow: %v", err)
+	}
+	if len(results) != 1 || results[0].Code != "FailedPrecondition" {
+		t.Errorf("wrong result: %+v", results[0].CallResult)
+	}
+
+	for _, bad := range []string{
+		`{"steps": []}`,
+		`{"steps": [{"request": {}}]}`,
+		`{"steps": [{"method": "a/b", "request": {}, "requests": [{}]}]}`,
+		`{"steps": [{"method": "a/b", "capture": {"bad name": "id"}}]}`,
+		`{"steps": [{"method": "a/b", "capture": {"id": "id == 1"}}]}`,
+		`{"steps": [{"method": "a/b", "unknown": 1}]}`,
+	} {
+		if _, err := ReadWorkflow(strings.NewReader(bad)); err == nil {
+			t.Errorf("expecting error reading workflow %s", bad)
+		}
+	}
+}